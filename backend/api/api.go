@@ -0,0 +1,26 @@
+// Package api holds the OpenAPI 3 documents of the three routers, they're the reference of the HTTP contracts:
+// the routers serve them on /openapi.json, the clients of the gateways are generated from them
+// and the contract tests check the handlers against them
+package api
+
+import (
+	_ "embed"
+)
+
+//go:generate go run ./internal/clientgen -spec server.json -package serverclient -out serverclient/client.gen.go
+//go:generate go run ./internal/clientgen -spec p2p.json -package p2pclient -out p2pclient/client.gen.go
+
+// ServerSpec describes the central server router
+//
+//go:embed server.json
+var ServerSpec []byte
+
+// FrontSpec describes the client front router
+//
+//go:embed front.json
+var FrontSpec []byte
+
+// P2PSpec describes the client p2p router
+//
+//go:embed p2p.json
+var P2PSpec []byte
@@ -0,0 +1,402 @@
+// Command clientgen generates a typed Go client from one of the OpenAPI documents of the api package,
+// it only supports what these documents use: component schemas of scalars and arrays (or free-form objects),
+// path and header parameters,
+// JSON request bodies and JSON or empty responses
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+func main() {
+	spec := flag.String("spec", "", "the OpenAPI document")
+	pkg := flag.String("package", "", "the package of the generated client")
+	out := flag.String("out", "", "the generated file")
+	flag.Parse()
+
+	doc, err := openapi3.NewLoader().LoadFromFile(*spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := doc.Validate(openapi3.NewLoader().Context); err != nil {
+		log.Fatal(err)
+	}
+
+	data, err := newTemplateData(doc, *pkg, filepath.Base(*spec))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	src := &bytes.Buffer{}
+	if err := clientTemplate.Execute(src, data); err != nil {
+		log.Fatal(err)
+	}
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		log.Fatalf("%v\n%s", err, src.String())
+	}
+
+	if err := os.WriteFile(*out, formatted, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type templateData struct {
+	Package    string
+	Spec       string
+	Models     []model
+	Operations []operation
+}
+
+type model struct {
+	Name        string
+	Description string
+	Fields      []field
+}
+
+type field struct {
+	Name     string
+	Type     string
+	JSONName string
+	Required bool
+}
+
+type operation struct {
+	Name        string
+	Description string
+	Method      string
+	Path        string
+	PathParams  []param
+	Headers     []param
+	Body        string
+	Responses   []response
+}
+
+type param struct {
+	Name     string
+	GoName   string
+	Required bool
+}
+
+type response struct {
+	Status int
+	Type   string
+}
+
+func newTemplateData(doc *openapi3.T, pkg, spec string) (templateData, error) {
+	data := templateData{Package: pkg, Spec: spec}
+
+	for _, name := range sortedKeys(doc.Components.Schemas) {
+		s := doc.Components.Schemas[name].Value
+		m := model{Name: name, Description: s.Description}
+		for _, prop := range sortedKeys(s.Properties) {
+			t, err := goType(s.Properties[prop])
+			if err != nil {
+				return data, fmt.Errorf("%s.%s: %v", name, prop, err)
+			}
+			m.Fields = append(m.Fields, field{
+				Name:     exported(prop),
+				Type:     t,
+				JSONName: prop,
+				Required: contains(s.Required, prop),
+			})
+		}
+		data.Models = append(data.Models, m)
+	}
+
+	for _, path := range doc.Paths.InMatchingOrder() {
+		item := doc.Paths.Find(path)
+		for method, op := range item.Operations() {
+			o, err := newOperation(path, method, op)
+			if err != nil {
+				return data, fmt.Errorf("%s %s: %v", method, path, err)
+			}
+			data.Operations = append(data.Operations, o)
+		}
+	}
+	sort.Slice(data.Operations, func(i, j int) bool { return data.Operations[i].Name < data.Operations[j].Name })
+
+	return data, nil
+}
+
+func newOperation(path, method string, op *openapi3.Operation) (operation, error) {
+	if op.OperationID == "" {
+		return operation{}, fmt.Errorf("missing operationId")
+	}
+
+	o := operation{
+		Name:        exported(op.OperationID),
+		Description: op.Description,
+		Method:      method,
+		Path:        path,
+	}
+
+	for _, ref := range op.Parameters {
+		p := ref.Value
+		gp := param{Name: p.Name, GoName: unexported(p.Name), Required: p.Required}
+		switch p.In {
+		case openapi3.ParameterInPath:
+			o.PathParams = append(o.PathParams, gp)
+		case openapi3.ParameterInHeader:
+			gp.GoName = exported(p.Name)
+			o.Headers = append(o.Headers, gp)
+		default:
+			return o, fmt.Errorf("parameters in %s are not supported", p.In)
+		}
+	}
+
+	if op.RequestBody != nil {
+		media := op.RequestBody.Value.Content.Get("application/json")
+		if media == nil {
+			return o, fmt.Errorf("only JSON bodies are supported")
+		}
+		t, err := goType(media.Schema)
+		if err != nil {
+			return o, err
+		}
+		o.Body = t
+	}
+
+	for status, ref := range op.Responses {
+		code := 0
+		if _, err := fmt.Sscanf(status, "%d", &code); err != nil {
+			return o, fmt.Errorf("only explicit statuses are supported, got %s", status)
+		}
+		media := ref.Value.Content.Get("application/json")
+		if media == nil {
+			continue
+		}
+		t, err := goType(media.Schema)
+		if err != nil {
+			return o, err
+		}
+		o.Responses = append(o.Responses, response{Status: code, Type: t})
+	}
+	sort.Slice(o.Responses, func(i, j int) bool { return o.Responses[i].Status < o.Responses[j].Status })
+
+	return o, nil
+}
+
+func goType(ref *openapi3.SchemaRef) (string, error) {
+	if ref.Ref != "" {
+		return strings.TrimPrefix(ref.Ref, "#/components/schemas/"), nil
+	}
+
+	s := ref.Value
+	switch {
+	case s.Type == openapi3.TypeString && s.Format == "byte":
+		return "[]byte", nil
+	case s.Type == openapi3.TypeString:
+		return "string", nil
+	case s.Type == openapi3.TypeBoolean:
+		return "bool", nil
+	case s.Type == openapi3.TypeInteger && s.Format == "int64":
+		return "int64", nil
+	case s.Type == openapi3.TypeInteger:
+		return "int", nil
+	case s.Type == openapi3.TypeArray:
+		item, err := goType(s.Items)
+		return "[]" + item, err
+	case s.Type == openapi3.TypeObject && len(s.Properties) == 0 && s.AdditionalProperties.Schema != nil:
+		value, err := goType(s.AdditionalProperties.Schema)
+		return "map[string]" + value, err
+	case s.Type == openapi3.TypeObject && len(s.Properties) == 0:
+		return "map[string]interface{}", nil
+	default:
+		return "", fmt.Errorf("inline %s schemas are not supported, use a component", s.Type)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(l []string, s string) bool {
+	for _, item := range l {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// exported converts snake_case, kebab-case and camelCase names
+func exported(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' })
+	for i, p := range parts {
+		parts[i] = strings.ToUpper(p[:1]) + p[1:]
+	}
+	return strings.Join(parts, "")
+}
+
+func unexported(name string) string {
+	e := exported(name)
+	return strings.ToLower(e[:1]) + e[1:]
+}
+
+var clientTemplate = template.Must(template.New("client").Funcs(template.FuncMap{
+	"comment": func(s string) string {
+		return strings.Replace(strings.TrimSpace(s), "\n", "\n// ", -1)
+	},
+}).Parse(`// Code generated by clientgen from {{.Spec}}. DO NOT EDIT.
+
+// Package {{.Package}} is the typed client of {{.Spec}}
+package {{.Package}}
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+{{range .Models}}
+{{if .Description}}// {{comment .Description}}
+{{end}}type {{.Name}} struct {
+{{- range .Fields}}
+	{{.Name}} {{if and (not .Required) (ne (printf "%.2s" .Type) "[]")}}*{{end}}{{.Type}} ` + "`" + `json:"{{.JSONName}}{{if not .Required}},omitempty{{end}}"` + "`" + `
+{{- end}}
+}
+{{end}}
+// Doer sends the requests, *http.Client is one
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// RequestEditor is called on every request before it's sent (eg. to add a header)
+type RequestEditor func(ctx context.Context, req *http.Request) error
+
+// Client calls the API at Server (scheme://host:port)
+type Client struct {
+	Server  string
+	Doer    Doer
+	Editors []RequestEditor
+}
+
+// New returns a client of the API at server, http.DefaultClient is used if doer is nil
+func New(server string, doer Doer, editors ...RequestEditor) *Client {
+	if doer == nil {
+		doer = http.DefaultClient
+	}
+	return &Client{Server: strings.TrimSuffix(server, "/"), Doer: doer, Editors: editors}
+}
+
+// Response is what every operation returns, the body is decoded in a typed field when documented
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body interface{}) (*Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.Server+path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, edit := range c.Editors {
+		if err := edit(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.Doer.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: respBody}, nil
+}
+{{range $op := .Operations}}
+{{if .Headers}}// {{.Name}}Params are the headers of {{.Name}}
+type {{.Name}}Params struct {
+{{- range .Headers}}
+	{{.GoName}} string
+{{- end}}
+}
+{{end}}
+// {{.Name}}Response is the response of {{.Name}}
+type {{.Name}}Response struct {
+	*Response
+{{- range .Responses}}
+	JSON{{.Status}} *{{.Type}}
+{{- end}}
+}
+
+// {{.Name}} calls {{.Method}} {{.Path}}{{if .Description}}
+// {{comment .Description}}{{end}}
+func (c *Client) {{.Name}}(ctx context.Context{{range .PathParams}}, {{.GoName}} string{{end}}{{if .Headers}}, params {{.Name}}Params{{end}}{{if .Body}}, body {{.Body}}{{end}}) (*{{.Name}}Response, error) {
+	path := "{{.Path}}"
+{{- range .PathParams}}
+	path = strings.Replace(path, "{{"{"}}{{.Name}}{{"}"}}", escapePath({{.GoName}}), 1)
+{{- end}}
+
+	header := http.Header{}
+{{- range .Headers}}
+{{- if .Required}}
+	header.Set("{{.Name}}", params.{{.GoName}})
+{{- else}}
+	if params.{{.GoName}} != "" {
+		header.Set("{{.Name}}", params.{{.GoName}})
+	}
+{{- end}}
+{{- end}}
+
+	resp, err := c.do(ctx, "{{.Method}}", path, header, {{if .Body}}body{{else}}nil{{end}})
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &{{.Name}}Response{Response: resp}
+{{- range .Responses}}
+	if resp.StatusCode == {{.Status}} {
+		typed.JSON{{.Status}} = new({{.Type}})
+		if err := json.Unmarshal(resp.Body, typed.JSON{{.Status}}); err != nil {
+			return typed, err
+		}
+	}
+{{- end}}
+	return typed, nil
+}
+{{end}}
+func escapePath(s string) string {
+	return strings.Replace(strings.Replace(s, "%", "%25", -1), "/", "%2F", -1)
+}
+`))
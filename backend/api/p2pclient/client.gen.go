@@ -0,0 +1,352 @@
+// Code generated by clientgen from p2p.json. DO NOT EDIT.
+
+// Package p2pclient is the typed client of p2p.json
+package p2pclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type Attachment struct {
+	Hash string `json:"hash"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// What a node supports, the nodes of previous versions don't advertise anything (no versions) and only speak the legacy protocol (version 0) on the unversioned routes.
+type Capabilities struct {
+	Features []string `json:"features,omitempty"`
+	Versions []int    `json:"versions,omitempty"`
+}
+
+type History struct {
+	Messages []Message `json:"messages"`
+	With     string    `json:"with"`
+}
+
+// A message as stored by the client
+type Message struct {
+	Attachments []Attachment         `json:"Attachments,omitempty"`
+	Author      string               `json:"Author"`
+	Content     string               `json:"Content"`
+	Date        *string              `json:"Date,omitempty"`
+	Deleted     bool                 `json:"Deleted"`
+	Edits       int                  `json:"Edits"`
+	ID          int64                `json:"ID"`
+	Reactions   *map[string][]string `json:"Reactions,omitempty"`
+	Ref         string               `json:"Ref"`
+	Status      *string              `json:"Status,omitempty"`
+}
+
+// A new message when there's no type (the peers of previous versions only send the message), an operation on the message with the ref otherwise. The operations are only sent to the peers advertising the events feature.
+type PostMessageBody struct {
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Edits       *int         `json:"edits,omitempty"`
+	Emoji       *string      `json:"emoji,omitempty"`
+	Message     *string      `json:"message,omitempty"`
+	Ref         *string      `json:"ref,omitempty"`
+	Removed     *bool        `json:"removed,omitempty"`
+	Ttl         *int64       `json:"ttl,omitempty"`
+	Type        *string      `json:"type,omitempty"`
+	With        *string      `json:"with,omitempty"`
+}
+
+// An ephemeral signal on the conversation with the sender.
+type PostSignalBody struct {
+	Ref  *string `json:"ref,omitempty"`
+	Type string  `json:"type"`
+}
+
+// Doer sends the requests, *http.Client is one
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// RequestEditor is called on every request before it's sent (eg. to add a header)
+type RequestEditor func(ctx context.Context, req *http.Request) error
+
+// Client calls the API at Server (scheme://host:port)
+type Client struct {
+	Server  string
+	Doer    Doer
+	Editors []RequestEditor
+}
+
+// New returns a client of the API at server, http.DefaultClient is used if doer is nil
+func New(server string, doer Doer, editors ...RequestEditor) *Client {
+	if doer == nil {
+		doer = http.DefaultClient
+	}
+	return &Client{Server: strings.TrimSuffix(server, "/"), Doer: doer, Editors: editors}
+}
+
+// Response is what every operation returns, the body is decoded in a typed field when documented
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body interface{}) (*Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.Server+path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, edit := range c.Editors {
+		if err := edit(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.Doer.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: respBody}, nil
+}
+
+// GetAttachmentChunkParams are the headers of GetAttachmentChunk
+type GetAttachmentChunkParams struct {
+	User  string
+	Range string
+}
+
+// GetAttachmentChunkResponse is the response of GetAttachmentChunk
+type GetAttachmentChunkResponse struct {
+	*Response
+}
+
+// GetAttachmentChunk calls GET /v1/attachments/{hash}
+// A chunk of an attachment of the conversation with the user given in the header, at most 256 KiB from the start of the range. It's only served once the attachment is complete.
+func (c *Client) GetAttachmentChunk(ctx context.Context, hash string, params GetAttachmentChunkParams) (*GetAttachmentChunkResponse, error) {
+	path := "/v1/attachments/{hash}"
+	path = strings.Replace(path, "{hash}", escapePath(hash), 1)
+
+	header := http.Header{}
+	header.Set("user", params.User)
+	if params.Range != "" {
+		header.Set("Range", params.Range)
+	}
+
+	resp, err := c.do(ctx, "GET", path, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &GetAttachmentChunkResponse{Response: resp}
+	return typed, nil
+}
+
+// GetCapabilitiesResponse is the response of GetCapabilities
+type GetCapabilitiesResponse struct {
+	*Response
+	JSON200 *Capabilities
+}
+
+// GetCapabilities calls GET /v1/capabilities
+// The handshake: the protocol versions and the features of the node, the nodes of previous versions answer 404.
+func (c *Client) GetCapabilities(ctx context.Context) (*GetCapabilitiesResponse, error) {
+	path := "/v1/capabilities"
+
+	header := http.Header{}
+
+	resp, err := c.do(ctx, "GET", path, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &GetCapabilitiesResponse{Response: resp}
+	if resp.StatusCode == 200 {
+		typed.JSON200 = new(Capabilities)
+		if err := json.Unmarshal(resp.Body, typed.JSON200); err != nil {
+			return typed, err
+		}
+	}
+	return typed, nil
+}
+
+// GetHistoryParams are the headers of GetHistory
+type GetHistoryParams struct {
+	User string
+}
+
+// GetHistoryResponse is the response of GetHistory
+type GetHistoryResponse struct {
+	*Response
+	JSON200 *[]History
+}
+
+// GetHistory calls GET /v1/history
+// Every conversation of the client, only served to the other devices of its user so that they sync with it.
+func (c *Client) GetHistory(ctx context.Context, params GetHistoryParams) (*GetHistoryResponse, error) {
+	path := "/v1/history"
+
+	header := http.Header{}
+	header.Set("user", params.User)
+
+	resp, err := c.do(ctx, "GET", path, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &GetHistoryResponse{Response: resp}
+	if resp.StatusCode == 200 {
+		typed.JSON200 = new([]History)
+		if err := json.Unmarshal(resp.Body, typed.JSON200); err != nil {
+			return typed, err
+		}
+	}
+	return typed, nil
+}
+
+// HealthResponse is the response of Health
+type HealthResponse struct {
+	*Response
+}
+
+// Health calls GET /healthz
+func (c *Client) Health(ctx context.Context) (*HealthResponse, error) {
+	path := "/healthz"
+
+	header := http.Header{}
+
+	resp, err := c.do(ctx, "GET", path, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &HealthResponse{Response: resp}
+	return typed, nil
+}
+
+// MetricsResponse is the response of Metrics
+type MetricsResponse struct {
+	*Response
+}
+
+// Metrics calls GET /metrics
+func (c *Client) Metrics(ctx context.Context) (*MetricsResponse, error) {
+	path := "/metrics"
+
+	header := http.Header{}
+
+	resp, err := c.do(ctx, "GET", path, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &MetricsResponse{Response: resp}
+	return typed, nil
+}
+
+// OpenapiResponse is the response of Openapi
+type OpenapiResponse struct {
+	*Response
+	JSON200 *map[string]interface{}
+}
+
+// Openapi calls GET /openapi.json
+func (c *Client) Openapi(ctx context.Context) (*OpenapiResponse, error) {
+	path := "/openapi.json"
+
+	header := http.Header{}
+
+	resp, err := c.do(ctx, "GET", path, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &OpenapiResponse{Response: resp}
+	if resp.StatusCode == 200 {
+		typed.JSON200 = new(map[string]interface{})
+		if err := json.Unmarshal(resp.Body, typed.JSON200); err != nil {
+			return typed, err
+		}
+	}
+	return typed, nil
+}
+
+// PostMessageParams are the headers of PostMessage
+type PostMessageParams struct {
+	User string
+}
+
+// PostMessageResponse is the response of PostMessage
+type PostMessageResponse struct {
+	*Response
+}
+
+// PostMessage calls POST /v1/messages/
+// Delivers a new message, or an operation on one of the messages (edit, delete or reaction), from the user given in the header. Receiving the same event again changes nothing.
+func (c *Client) PostMessage(ctx context.Context, params PostMessageParams, body PostMessageBody) (*PostMessageResponse, error) {
+	path := "/v1/messages/"
+
+	header := http.Header{}
+	header.Set("user", params.User)
+
+	resp, err := c.do(ctx, "POST", path, header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &PostMessageResponse{Response: resp}
+	return typed, nil
+}
+
+// PostSignalParams are the headers of PostSignal
+type PostSignalParams struct {
+	User string
+}
+
+// PostSignalResponse is the response of PostSignal
+type PostSignalResponse struct {
+	*Response
+}
+
+// PostSignal calls POST /v1/signals/
+// Tells the user the sender is typing or has seen their conversation, nothing is stored. Only sent to the peers advertising the signals feature, at most 2 per second and per sender.
+func (c *Client) PostSignal(ctx context.Context, params PostSignalParams, body PostSignalBody) (*PostSignalResponse, error) {
+	path := "/v1/signals/"
+
+	header := http.Header{}
+	header.Set("user", params.User)
+
+	resp, err := c.do(ctx, "POST", path, header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &PostSignalResponse{Response: resp}
+	return typed, nil
+}
+
+func escapePath(s string) string {
+	return strings.Replace(strings.Replace(s, "%", "%25", -1), "/", "%2F", -1)
+}
@@ -0,0 +1,432 @@
+// Code generated by clientgen from server.json. DO NOT EDIT.
+
+// Package serverclient is the typed client of server.json
+package serverclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type AdminSession struct {
+	Address string  `json:"address"`
+	Device  *string `json:"device,omitempty"`
+	Login   string  `json:"login"`
+}
+
+type AdminUser struct {
+	Disabled bool   `json:"disabled"`
+	Login    string `json:"login"`
+}
+
+// What a node supports, the nodes of previous versions don't advertise anything (no versions) and only speak the legacy protocol (version 0) on the unversioned routes.
+type Capabilities struct {
+	Features []string `json:"features,omitempty"`
+	Versions []int    `json:"versions,omitempty"`
+}
+
+type CreateNewSessionBody struct {
+	Address      *string       `json:"address,omitempty"`
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+	Device       *string       `json:"device,omitempty"`
+	Login        string        `json:"login"`
+	Password     string        `json:"password"`
+}
+
+// The session of a device
+type DeviceSession struct {
+	Address      string        `json:"address"`
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+	Device       *string       `json:"device,omitempty"`
+	ExpiresAt    *int64        `json:"expires_at,omitempty"`
+	Online       bool          `json:"online"`
+	PublicKey    []byte        `json:"public_key,omitempty"`
+}
+
+type ResetPasswordBody struct {
+	Password string `json:"password"`
+}
+
+// The session of the device which started last, for the clients of previous versions, and the sessions of every device.
+type Session struct {
+	Address      string          `json:"address"`
+	Capabilities *Capabilities   `json:"capabilities,omitempty"`
+	Device       *string         `json:"device,omitempty"`
+	Devices      []DeviceSession `json:"devices,omitempty"`
+	ExpiresAt    *int64          `json:"expires_at,omitempty"`
+	Online       bool            `json:"online"`
+	PublicKey    []byte          `json:"public_key,omitempty"`
+}
+
+type SetUserDisabledBody struct {
+	Disabled bool `json:"disabled"`
+}
+
+// Doer sends the requests, *http.Client is one
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// RequestEditor is called on every request before it's sent (eg. to add a header)
+type RequestEditor func(ctx context.Context, req *http.Request) error
+
+// Client calls the API at Server (scheme://host:port)
+type Client struct {
+	Server  string
+	Doer    Doer
+	Editors []RequestEditor
+}
+
+// New returns a client of the API at server, http.DefaultClient is used if doer is nil
+func New(server string, doer Doer, editors ...RequestEditor) *Client {
+	if doer == nil {
+		doer = http.DefaultClient
+	}
+	return &Client{Server: strings.TrimSuffix(server, "/"), Doer: doer, Editors: editors}
+}
+
+// Response is what every operation returns, the body is decoded in a typed field when documented
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body interface{}) (*Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.Server+path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, edit := range c.Editors {
+		if err := edit(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.Doer.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: respBody}, nil
+}
+
+// AdminDeleteUserResponse is the response of AdminDeleteUser
+type AdminDeleteUserResponse struct {
+	*Response
+}
+
+// AdminDeleteUser calls DELETE /v1/admin/users/{login}
+// Deletes the user and its session.
+func (c *Client) AdminDeleteUser(ctx context.Context, login string) (*AdminDeleteUserResponse, error) {
+	path := "/v1/admin/users/{login}"
+	path = strings.Replace(path, "{login}", escapePath(login), 1)
+
+	header := http.Header{}
+
+	resp, err := c.do(ctx, "DELETE", path, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &AdminDeleteUserResponse{Response: resp}
+	return typed, nil
+}
+
+// AdminListSessionsResponse is the response of AdminListSessions
+type AdminListSessionsResponse struct {
+	*Response
+	JSON200 *[]AdminSession
+}
+
+// AdminListSessions calls GET /v1/admin/sessions/
+func (c *Client) AdminListSessions(ctx context.Context) (*AdminListSessionsResponse, error) {
+	path := "/v1/admin/sessions/"
+
+	header := http.Header{}
+
+	resp, err := c.do(ctx, "GET", path, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &AdminListSessionsResponse{Response: resp}
+	if resp.StatusCode == 200 {
+		typed.JSON200 = new([]AdminSession)
+		if err := json.Unmarshal(resp.Body, typed.JSON200); err != nil {
+			return typed, err
+		}
+	}
+	return typed, nil
+}
+
+// AdminListUsersResponse is the response of AdminListUsers
+type AdminListUsersResponse struct {
+	*Response
+	JSON200 *[]AdminUser
+}
+
+// AdminListUsers calls GET /v1/admin/users/
+func (c *Client) AdminListUsers(ctx context.Context) (*AdminListUsersResponse, error) {
+	path := "/v1/admin/users/"
+
+	header := http.Header{}
+
+	resp, err := c.do(ctx, "GET", path, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &AdminListUsersResponse{Response: resp}
+	if resp.StatusCode == 200 {
+		typed.JSON200 = new([]AdminUser)
+		if err := json.Unmarshal(resp.Body, typed.JSON200); err != nil {
+			return typed, err
+		}
+	}
+	return typed, nil
+}
+
+// AdminResetPasswordResponse is the response of AdminResetPassword
+type AdminResetPasswordResponse struct {
+	*Response
+}
+
+// AdminResetPassword calls PUT /v1/admin/users/{login}/password
+func (c *Client) AdminResetPassword(ctx context.Context, login string, body ResetPasswordBody) (*AdminResetPasswordResponse, error) {
+	path := "/v1/admin/users/{login}/password"
+	path = strings.Replace(path, "{login}", escapePath(login), 1)
+
+	header := http.Header{}
+
+	resp, err := c.do(ctx, "PUT", path, header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &AdminResetPasswordResponse{Response: resp}
+	return typed, nil
+}
+
+// AdminRevokeSessionResponse is the response of AdminRevokeSession
+type AdminRevokeSessionResponse struct {
+	*Response
+}
+
+// AdminRevokeSession calls DELETE /v1/admin/sessions/{login}
+func (c *Client) AdminRevokeSession(ctx context.Context, login string) (*AdminRevokeSessionResponse, error) {
+	path := "/v1/admin/sessions/{login}"
+	path = strings.Replace(path, "{login}", escapePath(login), 1)
+
+	header := http.Header{}
+
+	resp, err := c.do(ctx, "DELETE", path, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &AdminRevokeSessionResponse{Response: resp}
+	return typed, nil
+}
+
+// AdminSetUserDisabledResponse is the response of AdminSetUserDisabled
+type AdminSetUserDisabledResponse struct {
+	*Response
+}
+
+// AdminSetUserDisabled calls PUT /v1/admin/users/{login}/disabled
+// A disabled user can't start a session and its current session is revoked.
+func (c *Client) AdminSetUserDisabled(ctx context.Context, login string, body SetUserDisabledBody) (*AdminSetUserDisabledResponse, error) {
+	path := "/v1/admin/users/{login}/disabled"
+	path = strings.Replace(path, "{login}", escapePath(login), 1)
+
+	header := http.Header{}
+
+	resp, err := c.do(ctx, "PUT", path, header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &AdminSetUserDisabledResponse{Response: resp}
+	return typed, nil
+}
+
+// GetCapabilitiesResponse is the response of GetCapabilities
+type GetCapabilitiesResponse struct {
+	*Response
+	JSON200 *Capabilities
+}
+
+// GetCapabilities calls GET /v1/capabilities
+// The handshake: the protocol versions and the features of the node, the nodes of previous versions answer 404.
+func (c *Client) GetCapabilities(ctx context.Context) (*GetCapabilitiesResponse, error) {
+	path := "/v1/capabilities"
+
+	header := http.Header{}
+
+	resp, err := c.do(ctx, "GET", path, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &GetCapabilitiesResponse{Response: resp}
+	if resp.StatusCode == 200 {
+		typed.JSON200 = new(Capabilities)
+		if err := json.Unmarshal(resp.Body, typed.JSON200); err != nil {
+			return typed, err
+		}
+	}
+	return typed, nil
+}
+
+// GetSessionParams are the headers of GetSession
+type GetSessionParams struct {
+	User string
+}
+
+// GetSessionResponse is the response of GetSession
+type GetSessionResponse struct {
+	*Response
+	JSON200 *Session
+}
+
+// GetSession calls GET /v1/sessions/{login}
+// Provides the sessions of every device of another user (or of the caller), only to users with a session.
+func (c *Client) GetSession(ctx context.Context, login string, params GetSessionParams) (*GetSessionResponse, error) {
+	path := "/v1/sessions/{login}"
+	path = strings.Replace(path, "{login}", escapePath(login), 1)
+
+	header := http.Header{}
+	header.Set("user", params.User)
+
+	resp, err := c.do(ctx, "GET", path, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &GetSessionResponse{Response: resp}
+	if resp.StatusCode == 200 {
+		typed.JSON200 = new(Session)
+		if err := json.Unmarshal(resp.Body, typed.JSON200); err != nil {
+			return typed, err
+		}
+	}
+	return typed, nil
+}
+
+// HealthResponse is the response of Health
+type HealthResponse struct {
+	*Response
+}
+
+// Health calls GET /healthz
+func (c *Client) Health(ctx context.Context) (*HealthResponse, error) {
+	path := "/healthz"
+
+	header := http.Header{}
+
+	resp, err := c.do(ctx, "GET", path, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &HealthResponse{Response: resp}
+	return typed, nil
+}
+
+// MetricsResponse is the response of Metrics
+type MetricsResponse struct {
+	*Response
+}
+
+// Metrics calls GET /metrics
+func (c *Client) Metrics(ctx context.Context) (*MetricsResponse, error) {
+	path := "/metrics"
+
+	header := http.Header{}
+
+	resp, err := c.do(ctx, "GET", path, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &MetricsResponse{Response: resp}
+	return typed, nil
+}
+
+// OpenapiResponse is the response of Openapi
+type OpenapiResponse struct {
+	*Response
+	JSON200 *map[string]interface{}
+}
+
+// Openapi calls GET /openapi.json
+func (c *Client) Openapi(ctx context.Context) (*OpenapiResponse, error) {
+	path := "/openapi.json"
+
+	header := http.Header{}
+
+	resp, err := c.do(ctx, "GET", path, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &OpenapiResponse{Response: resp}
+	if resp.StatusCode == 200 {
+		typed.JSON200 = new(map[string]interface{})
+		if err := json.Unmarshal(resp.Body, typed.JSON200); err != nil {
+			return typed, err
+		}
+	}
+	return typed, nil
+}
+
+// StartSessionResponse is the response of StartSession
+type StartSessionResponse struct {
+	*Response
+}
+
+// StartSession calls POST /v1/sessions/
+// Authenticates the user and registers the address where its client can be reached, the address of the caller is used if none is provided. The user has a session per device.
+func (c *Client) StartSession(ctx context.Context, body CreateNewSessionBody) (*StartSessionResponse, error) {
+	path := "/v1/sessions/"
+
+	header := http.Header{}
+
+	resp, err := c.do(ctx, "POST", path, header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &StartSessionResponse{Response: resp}
+	return typed, nil
+}
+
+func escapePath(s string) string {
+	return strings.Replace(strings.Replace(s, "%", "%25", -1), "/", "%2F", -1)
+}
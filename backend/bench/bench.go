@@ -0,0 +1,250 @@
+// Package bench simulates clients against a central server, to measure how many sessions the server and how many
+// messages per second a client can sustain
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mux "gop2p/driving/api.mux"
+)
+
+// the operations measured, in the order of the report
+const (
+	// OpRegister starts the session of a client, through its front API
+	OpRegister = "register"
+	// OpResolve asks the server the sessions of the peer, like a client does before sending
+	OpResolve = "resolve"
+	// OpSend sends a message to every device of the peer, through the front API of the client
+	OpSend = "send"
+)
+
+// Credentials of a user known by the server
+type Credentials struct {
+	Login    string
+	Password string
+}
+
+// Config of a run
+type Config struct {
+	ServerAddress string
+	Transport     mux.Transport
+
+	// Users the clients log in with, round robin: the clients sharing a user are its devices, so the messages sent
+	// to it fan out to all of them. Each client talks to the user following its own
+	Users   []Credentials
+	Clients int
+	// Host the clients listen on, the server and the other clients must reach them there
+	Host string
+
+	// Duration of the exchanges, once every client is registered
+	Duration time.Duration
+	// MessageRate and ResolveRate are per client and per second, 0 disables the operation. A client waits for a
+	// call to return before the next one, so the rates are upper bounds
+	MessageRate float64
+	ResolveRate float64
+	// MessageSize is the length of the content of the messages
+	MessageSize int
+}
+
+func (c Config) validate() error {
+	switch {
+	case c.ServerAddress == "":
+		return errors.New("server address is mandatory")
+	case c.Clients < 1:
+		return errors.New("at least one client is needed")
+	case len(c.Users) < 2:
+		return errors.New("at least two users are needed to exchange messages")
+	case c.Duration <= 0:
+		return errors.New("the duration must be positive")
+	case c.MessageRate < 0 || c.ResolveRate < 0:
+		return errors.New("the rates can't be negative")
+	}
+	return nil
+}
+
+// Stats of an operation, the throughput only counts the calls which succeeded
+type Stats struct {
+	Operation  string
+	Count      int
+	Errors     int
+	Throughput float64
+	P50        time.Duration
+	P90        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+}
+
+// Report of a run, the operations which were never called are left out
+type Report struct {
+	Clients    int
+	Elapsed    time.Duration
+	Operations []Stats
+}
+
+// Run starts the clients, registers them all at once and makes them exchange during the duration of the config.
+// It fails if the clients can't be started, the errors of the calls are only counted
+func Run(ctx context.Context, c Config) (Report, error) {
+	if err := c.validate(); err != nil {
+		return Report{}, err
+	}
+
+	clients := make([]*client, 0, c.Clients)
+	defer func() {
+		for _, cl := range clients {
+			cl.stop()
+		}
+	}()
+	for i := 0; i < c.Clients; i++ {
+		user := i % len(c.Users)
+		cl, err := startClient(c, fmt.Sprintf("bench-%d", i), c.Users[user], c.Users[(user+1)%len(c.Users)].Login)
+		if err != nil {
+			return Report{}, err
+		}
+		clients = append(clients, cl)
+	}
+
+	registration := newRecorder()
+	start := time.Now()
+	forEach(clients, func(cl *client) {
+		registration.measure(OpRegister, cl.register)
+	})
+	report := Report{Clients: c.Clients, Operations: registration.stats(time.Since(start))}
+
+	exchanges := newRecorder()
+	ctx, cancel := context.WithTimeout(ctx, c.Duration)
+	defer cancel()
+	start = time.Now()
+	content := strings.Repeat("x", c.MessageSize)
+	forEach(clients, func(cl *client) {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			every(ctx, c.ResolveRate, func() { exchanges.measure(OpResolve, cl.resolve) })
+		}()
+		go func() {
+			defer wg.Done()
+			every(ctx, c.MessageRate, func() { exchanges.measure(OpSend, func() error { return cl.send(content) }) })
+		}()
+		wg.Wait()
+	})
+	report.Elapsed = time.Since(start)
+	report.Operations = append(report.Operations, exchanges.stats(report.Elapsed)...)
+
+	return report, nil
+}
+
+// forEach calls f for every client at once, and waits for them
+func forEach(clients []*client, f func(cl *client)) {
+	var wg sync.WaitGroup
+	for _, cl := range clients {
+		wg.Add(1)
+		go func(cl *client) {
+			defer wg.Done()
+			f(cl)
+		}(cl)
+	}
+	wg.Wait()
+}
+
+// every calls f at rate per second until ctx is done, the first call is delayed at random so that the clients
+// don't all call at the same time
+func every(ctx context.Context, rate float64, f func()) {
+	if rate <= 0 {
+		return
+	}
+
+	interval := time.Duration(float64(time.Second) / rate)
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(time.Duration(rand.Int63n(int64(interval) + 1))):
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		f()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recorder keeps the latency of every call by operation
+type recorder struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]int
+}
+
+func newRecorder() *recorder {
+	return &recorder{latencies: map[string][]time.Duration{}, errors: map[string]int{}}
+}
+
+func (r *recorder) measure(op string, call func() error) {
+	start := time.Now()
+	err := call()
+	latency := time.Since(start)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		r.errors[op]++
+		return
+	}
+	r.latencies[op] = append(r.latencies[op], latency)
+}
+
+// stats of the operations recorded, elapsed is the time they were called during
+func (r *recorder) stats(elapsed time.Duration) []Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := []Stats{}
+	for _, op := range []string{OpRegister, OpResolve, OpSend} {
+		latencies, errs := r.latencies[op], r.errors[op]
+		if len(latencies) == 0 && errs == 0 {
+			continue
+		}
+
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		s := Stats{
+			Operation:  op,
+			Count:      len(latencies) + errs,
+			Errors:     errs,
+			Throughput: float64(len(latencies)) / elapsed.Seconds(),
+			P50:        percentile(latencies, 0.5),
+			P90:        percentile(latencies, 0.9),
+			P99:        percentile(latencies, 0.99),
+		}
+		if len(latencies) > 0 {
+			s.Max = latencies[len(latencies)-1]
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// percentile of sorted latencies, by nearest rank
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
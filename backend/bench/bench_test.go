@@ -0,0 +1,90 @@
+package bench_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gop2p/bench"
+	sessionmanager "gop2p/driven/inMem.sessionManager"
+	userstore "gop2p/driven/inMem.userStore"
+	mux "gop2p/driving/api.mux"
+	"gop2p/uc"
+)
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	us := userstore.New()
+	us.InsertUser(ctx, "alice", "pass")
+	us.InsertUser(ctx, "bob", "pass")
+	server := httptest.NewServer(mux.ServerRouter{Logic: uc.NewServerLogic(us, sessionmanager.New())}.Handler())
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	config := func() bench.Config {
+		return bench.Config{
+			ServerAddress: u.Host,
+			Transport:     mux.DefaultTransport(),
+			Users:         []bench.Credentials{{Login: "alice", Password: "pass"}, {Login: "bob", Password: "pass"}},
+			Clients:       4,
+			Host:          "127.0.0.1",
+			Duration:      300 * time.Millisecond,
+			MessageRate:   20,
+			ResolveRate:   20,
+			MessageSize:   16,
+		}
+	}
+
+	Convey("given 4 clients of alice and bob", t, func() {
+		Convey("when they exchange for a while", func() {
+			report, err := bench.Run(ctx, config())
+			So(err, ShouldBeNil)
+
+			Convey("every operation is measured without error", func() {
+				So(report.Clients, ShouldEqual, 4)
+				So(report.Elapsed, ShouldBeGreaterThanOrEqualTo, 300*time.Millisecond)
+				So(report.Operations, ShouldHaveLength, 3)
+
+				register := report.Operations[0]
+				So(register.Operation, ShouldEqual, bench.OpRegister)
+				So(register.Count, ShouldEqual, 4)
+
+				for _, s := range report.Operations {
+					So(s.Errors, ShouldEqual, 0)
+					So(s.Count, ShouldBeGreaterThan, 0)
+					So(s.Throughput, ShouldBeGreaterThan, 0)
+					So(s.P50, ShouldBeLessThanOrEqualTo, s.P90)
+					So(s.P90, ShouldBeLessThanOrEqualTo, s.P99)
+					So(s.P99, ShouldBeLessThanOrEqualTo, s.Max)
+				}
+			})
+		})
+
+		Convey("when the messages are disabled, only the resolutions are measured", func() {
+			c := config()
+			c.MessageRate = 0
+			report, err := bench.Run(ctx, c)
+			So(err, ShouldBeNil)
+			So(report.Operations, ShouldHaveLength, 2)
+			So(report.Operations[1].Operation, ShouldEqual, bench.OpResolve)
+		})
+
+		Convey("when a user doesn't exist, its calls are counted as errors", func() {
+			c := config()
+			c.Users[1].Login = "carol"
+			report, err := bench.Run(ctx, c)
+			So(err, ShouldBeNil)
+			So(report.Operations[0].Errors, ShouldEqual, 2)
+		})
+
+		Convey("a single user isn't enough", func() {
+			c := config()
+			c.Users = c.Users[:1]
+			_, err := bench.Run(ctx, c)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,161 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+
+	"gop2p/domain"
+	blobstore "gop2p/driven/fs.blobStore"
+	clientgateway "gop2p/driven/http.clientGateway"
+	servergateway "gop2p/driven/http.serverGateway"
+	conversationmanager "gop2p/driven/inMem.conversationManager"
+	signalbroker "gop2p/driven/inMem.signalBroker"
+	mux "gop2p/driving/api.mux"
+	"gop2p/uc"
+)
+
+// frontClient calls the front API of every client, it keeps enough connections open for all of them
+var frontClient = &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 16}}
+
+// client is a client wired the way the start command does, on ephemeral ports, and driven like a front end would
+type client struct {
+	user Credentials
+	// peer is the login of the user it talks to
+	peer string
+
+	front, p2p *http.Server
+	// frontAddress and p2pAddress are the host:port the routers listen on
+	frontAddress, p2pAddress string
+
+	sg  uc.ServerGateway
+	dir string
+}
+
+func startClient(c Config, device string, user Credentials, peer string) (*client, error) {
+	dir, err := os.MkdirTemp("", "gop2p-bench-")
+	if err != nil {
+		return nil, err
+	}
+	cl := &client{user: user, peer: peer, dir: dir, sg: servergateway.New(c.ServerAddress, c.Transport, domain.Supported())}
+
+	bs, err := blobstore.New(dir)
+	if err != nil {
+		cl.stop()
+		return nil, err
+	}
+	cm := conversationmanager.New()
+	sb := signalbroker.New()
+	account := uc.NewAccount(device)
+
+	cl.p2p, cl.p2pAddress, err = serve(c.Host, mux.ClientP2pRouter{
+		Logic:        uc.NewClientP2pLogic(cm, bs, sb, account),
+		Capabilities: domain.Supported(),
+		Senders:      uc.NewSenderVerifier(cl.sg, account),
+	}.Handler())
+	if err != nil {
+		cl.stop()
+		return nil, err
+	}
+
+	cl.front, cl.frontAddress, err = serve(c.Host, mux.ClientFrontRouter{
+		Logic:         uc.NewClientFrontLogic(cm, cl.sg, clientgateway.New(c.Transport, domain.Supported()), bs, sb, account),
+		ServerAddress: &url.URL{Host: c.ServerAddress},
+		Transport:     c.Transport,
+		Capabilities:  domain.Supported(),
+		Device:        device,
+	}.Handler())
+	if err != nil {
+		cl.stop()
+		return nil, err
+	}
+
+	return cl, nil
+}
+
+// serve h on an ephemeral port of host
+func serve(host string, h http.Handler) (*http.Server, string, error) {
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return nil, "", err
+	}
+
+	s := &http.Server{Handler: h}
+	go func() { _ = s.Serve(l) }()
+	return s, l.Addr().String(), nil
+}
+
+func (cl *client) stop() {
+	for _, s := range []*http.Server{cl.front, cl.p2p} {
+		if s != nil {
+			_ = s.Close()
+		}
+	}
+	_ = os.RemoveAll(cl.dir)
+}
+
+// register starts the session of the client on the server
+func (cl *client) register() error {
+	// the front API takes the login of the session from the header, like the terminal client sends it
+	header := http.Header{}
+	header.Set("user", cl.user.Login)
+	return cl.call(http.MethodPost, mux.V1+"/sessions/", header, mux.CreateNewSessionBody{
+		Login:    cl.user.Login,
+		Password: cl.user.Password,
+		Address:  cl.p2pAddress,
+	})
+}
+
+// resolve the sessions of the peer, it fails if it has none
+func (cl *client) resolve() error {
+	sessions, ok := cl.sg.AskSessionsToServer(context.Background(), cl.user.Login, cl.peer)
+	switch {
+	case !ok:
+		return errors.New("the server can't be asked")
+	case len(sessions) == 0:
+		return fmt.Errorf("%s has no session", cl.peer)
+	}
+	return nil
+}
+
+// send a message to the peer
+func (cl *client) send(content string) error {
+	return cl.call(http.MethodPost, mux.V1+"/messages/", nil, mux.SendNewMessageBody{Message: content, To: cl.peer})
+}
+
+// call sends body as JSON to the front API of the client
+func (cl *client) call(method, path string, header http.Header, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, "http://"+cl.frontAddress+path, bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+	for k := range header {
+		req.Header.Set(k, header.Get(k))
+	}
+	req.Header.Set("Content-Type", mux.ApplicationJSON)
+
+	r, err := frontClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	// the body is read so that the connection is reused
+	_, _ = io.Copy(io.Discard, r.Body)
+
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s responded %s", method, path, r.Status)
+	}
+	return nil
+}
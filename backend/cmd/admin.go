@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	mux "gop2p/driving/api.mux"
+)
+
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "manage the users and sessions of a central server",
+	Long:  `admin calls the admin API of the central server found at --server_address using --admin_token`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// once the args are valid, errors come from the server and the usage doesn't help,
+		// they're printed by Execute
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+	},
+}
+
+var adminUsersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "manage the users",
+}
+
+var adminSessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "manage the active sessions",
+}
+
+func init() {
+	adminUsersCmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "list the users",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				users := []mux.AdminUser{}
+				if err := adminCall(http.MethodGet, mux.V1+"/admin/users/", nil, &users); err != nil {
+					return err
+				}
+
+				w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "LOGIN\tDISABLED")
+				for _, u := range users {
+					fmt.Fprintf(w, "%s\t%t\n", u.Login, u.Disabled)
+				}
+				return w.Flush()
+			},
+		},
+		&cobra.Command{
+			Use:   "reset-password <login>",
+			Short: "set a new password, prompted for or read from stdin",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				// not an arg, it would end up in the shell history and the process list
+				password, err := promptPassword()
+				if err != nil {
+					return err
+				}
+				return adminCall(http.MethodPut, mux.V1+"/admin/users/"+args[0]+"/password", mux.ResetPasswordBody{Password: password}, nil)
+			},
+		},
+		&cobra.Command{
+			Use:   "disable <login>",
+			Short: "disable a user and revoke their session",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return adminCall(http.MethodPut, mux.V1+"/admin/users/"+args[0]+"/disabled", mux.SetUserDisabledBody{Disabled: true}, nil)
+			},
+		},
+		&cobra.Command{
+			Use:   "enable <login>",
+			Short: "enable a disabled user",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return adminCall(http.MethodPut, mux.V1+"/admin/users/"+args[0]+"/disabled", mux.SetUserDisabledBody{Disabled: false}, nil)
+			},
+		},
+		&cobra.Command{
+			Use:   "delete <login>",
+			Short: "delete a user and their session",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return adminCall(http.MethodDelete, mux.V1+"/admin/users/"+args[0], nil, nil)
+			},
+		},
+	)
+
+	adminSessionsCmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "list the active sessions",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				sessions := []mux.AdminSession{}
+				if err := adminCall(http.MethodGet, mux.V1+"/admin/sessions/", nil, &sessions); err != nil {
+					return err
+				}
+
+				w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "LOGIN\tADDRESS")
+				for _, s := range sessions {
+					fmt.Fprintf(w, "%s\t%s\n", s.Login, s.Address)
+				}
+				return w.Flush()
+			},
+		},
+		&cobra.Command{
+			Use:   "revoke <login>",
+			Short: "kick a user out",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return adminCall(http.MethodDelete, mux.V1+"/admin/sessions/"+args[0], nil, nil)
+			},
+		},
+	)
+
+	adminCmd.AddCommand(adminUsersCmd, adminSessionsCmd)
+	rootCmd.AddCommand(adminCmd)
+}
+
+// adminCall calls the admin API of the central server
+func adminCall(method, path string, body, resp interface{}) error {
+	serverAddress := viper.GetString(serverAddressKey)
+	if serverAddress == "" {
+		return errors.New("server address is mandatory")
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+viper.GetString(adminTokenKey))
+	return apiCall(serverAddress, method, path, header, body, resp)
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gop2p/bench"
+	mux "gop2p/driving/api.mux"
+	"gop2p/logging"
+)
+
+const (
+	benchClientsKey     = "clients"
+	benchUsersKey       = "users"
+	benchHostKey        = "host"
+	benchDurationKey    = "duration"
+	benchMessageRateKey = "message_rate"
+	benchResolveRateKey = "resolve_rate"
+	benchMessageSizeKey = "message_size"
+)
+
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "measure a central server under the load of simulated clients",
+	Long: `bench starts clients in this process, registers their sessions on the central server found at --server_address
+and makes them resolve each other and exchange messages for a while, then prints the throughput and latency of
+each operation. The clients serve plain http on --host, the server must reach them there`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// once the args are valid, errors come from the run and the usage doesn't help
+		cmd.SilenceUsage = true
+
+		flags := cmd.Flags()
+		c := bench.Config{ServerAddress: viper.GetString(serverAddressKey), Transport: mux.DefaultTransport()}
+		c.Clients, _ = flags.GetInt(benchClientsKey)
+		c.Host, _ = flags.GetString(benchHostKey)
+		c.Duration, _ = flags.GetDuration(benchDurationKey)
+		c.MessageRate, _ = flags.GetFloat64(benchMessageRateKey)
+		c.ResolveRate, _ = flags.GetFloat64(benchResolveRateKey)
+		c.MessageSize, _ = flags.GetInt(benchMessageSizeKey)
+
+		users, _ := flags.GetStringSlice(benchUsersKey)
+		for _, u := range users {
+			parts := strings.SplitN(u, ":", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid user %q, expected login:password", u)
+			}
+			c.Users = append(c.Users, bench.Credentials{Login: parts[0], Password: parts[1]})
+		}
+
+		// every client logs its session and its messages, only the problems are kept
+		logging.SetLevel(slog.LevelWarn)
+
+		report, err := bench.Run(context.Background(), c)
+		if err != nil {
+			return err
+		}
+		printReport(report)
+
+		if failed(report) {
+			return errors.New("some calls failed")
+		}
+		return nil
+	},
+}
+
+func init() {
+	benchCmd.Flags().Int(benchClientsKey, 10, "How many clients are simulated")
+	benchCmd.Flags().StringSlice(benchUsersKey, []string{"alice:pass", "bob:pass"},
+		"Comma separated login:password of existing users, the clients take them round robin and each one talks to the next user")
+	benchCmd.Flags().String(benchHostKey, "127.0.0.1", "The host the clients listen on")
+	benchCmd.Flags().Duration(benchDurationKey, 10*time.Second, "How long the clients exchange once registered")
+	benchCmd.Flags().Float64(benchMessageRateKey, 1, "The messages sent per second by each client, 0 sends none")
+	benchCmd.Flags().Float64(benchResolveRateKey, 1, "The sessions resolved per second by each client, 0 resolves none")
+	benchCmd.Flags().Int(benchMessageSizeKey, 64, "The length of the messages")
+
+	rootCmd.AddCommand(benchCmd)
+}
+
+func printReport(r bench.Report) {
+	fmt.Printf("%d clients, exchanged for %s\n\n", r.Clients, r.Elapsed.Round(time.Millisecond))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "OPERATION\tCOUNT\tERRORS\tPER SECOND\tP50\tP90\tP99\tMAX")
+	for _, s := range r.Operations {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\n", s.Operation, s.Count, s.Errors, s.Throughput,
+			latency(s.P50), latency(s.P90), latency(s.P99), latency(s.Max))
+	}
+	_ = w.Flush()
+}
+
+func latency(d time.Duration) string {
+	return d.Round(10 * time.Microsecond).String()
+}
+
+func failed(r bench.Report) bool {
+	for _, s := range r.Operations {
+		if s.Errors > 0 {
+			return true
+		}
+	}
+	return false
+}
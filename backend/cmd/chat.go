@@ -0,0 +1,492 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+	"gop2p/domain"
+	mux "gop2p/driving/api.mux"
+)
+
+// the chat subcommands talk to the front API of a running client, like a frontend would
+const (
+	clientAddressKey = "client_address"
+	passwordKey      = "password"
+
+	chatRefreshInterval = time.Second
+)
+
+var loginCmd = &cobra.Command{
+	Use:   "login <login>",
+	Short: "start a session on the running client",
+	Long:  `login registers the running client to the central server, the password is prompted if not provided`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		login := args[0]
+
+		password, _ := cmd.Flags().GetString(passwordKey)
+		if password == "" {
+			p, err := promptPassword()
+			if err != nil {
+				return err
+			}
+			password = p
+		}
+
+		header := http.Header{}
+		header.Set("user", login)
+		if err := clientCall(http.MethodPost, mux.V1+"/sessions/", header, mux.CreateNewSessionBody{
+			Login:    login,
+			Password: password,
+			Address:  viper.GetString(p2pAddressKey),
+		}, nil); err != nil {
+			return err
+		}
+
+		fmt.Println("logged in as", login)
+		return nil
+	},
+}
+
+var sendCmd = &cobra.Command{
+	Use:   "send <to> <message>",
+	Short: "send a message to another user",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return sendMessage(args[0], strings.Join(args[1:], " "))
+	},
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history <user>",
+	Short: "print the conversation with a user",
+	Long:  `history prints the ref of every message, it's what the edit, delete and react subcommands expect`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		messages, err := conversationWith(args[0], 0)
+		if err != nil {
+			return err
+		}
+		for _, m := range messages {
+			fmt.Printf("[%s] %s\n", m.Ref, formatMessage(m))
+		}
+		return nil
+	},
+}
+
+var editCmd = &cobra.Command{
+	Use:   "edit <user> <ref> <message>",
+	Short: "edit one of our messages, for both users",
+	Args:  cobra.MinimumNArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return clientCall(http.MethodPut, messagePath(args[0], args[1]), nil,
+			mux.EditMessageBody{Message: strings.Join(args[2:], " ")}, nil)
+	},
+}
+
+var deleteCmd = &cobra.Command{
+	Use:   "delete <user> <ref>",
+	Short: "delete one of our messages, for both users",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return clientCall(http.MethodDelete, messagePath(args[0], args[1]), nil, nil, nil)
+	},
+}
+
+var resendCmd = &cobra.Command{
+	Use:   "resend <user> <ref>",
+	Short: "send again one of our messages that wasn't delivered",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return clientCall(http.MethodPost, messagePath(args[0], args[1])+"/resend", nil, nil, nil)
+	},
+}
+
+const removeKey = "remove"
+
+var reactCmd = &cobra.Command{
+	Use:   "react <user> <ref> <emoji>",
+	Short: "react to a message of a conversation",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		method := http.MethodPut
+		if remove, _ := cmd.Flags().GetBool(removeKey); remove {
+			method = http.MethodDelete
+		}
+		return clientCall(method, messagePath(args[0], args[1])+"/reactions/"+url.PathEscape(args[2]), nil, nil, nil)
+	},
+}
+
+var attachCmd = &cobra.Command{
+	Use:   "attach <to> <file> [message]",
+	Short: "send a file to another user",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a, err := uploadAttachment(args[1])
+		if err != nil {
+			return err
+		}
+		return clientCall(http.MethodPost, mux.V1+"/messages/", nil, mux.SendNewMessageBody{
+			To:          args[0],
+			Message:     strings.Join(args[2:], " "),
+			Attachments: []domain.Attachment{*a},
+		}, nil)
+	},
+}
+
+const outputKey = "output"
+
+var downloadCmd = &cobra.Command{
+	Use:   "download <user> <hash>",
+	Short: "download an attachment of a conversation",
+	Long:  `download writes the attachment in the current dir under its name, the hashes are printed by history`,
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, _ := cmd.Flags().GetString(outputKey)
+		return downloadAttachment(args[0], args[1], output)
+	},
+}
+
+var contactsCmd = &cobra.Command{
+	Use:   "contacts",
+	Short: "list the users we have a conversation with, the most recent first",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conversations := []domain.Conversation{}
+		if err := clientCall(http.MethodGet, mux.V1+"/conversations/", nil, nil, &conversations); err != nil {
+			return err
+		}
+		for _, c := range conversations {
+			fmt.Printf("%s (%d unread) %s\n", c.With, c.Unread, formatMessage(c.LastMessage))
+		}
+		return nil
+	},
+}
+
+var searchCmd = &cobra.Command{
+	Use:   "search <words>",
+	Short: "search the messages holding every word",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		results := []domain.SearchResult{}
+		path := mux.V1 + "/search?q=" + url.QueryEscape(strings.Join(args, " "))
+		if err := clientCall(http.MethodGet, path, nil, nil, &results); err != nil {
+			return err
+		}
+		for _, r := range results {
+			fmt.Printf("[%s] %s\n", r.With, formatMessage(r.Message))
+		}
+		return nil
+	},
+}
+
+var chatCmd = &cobra.Command{
+	Use:   "chat <user>",
+	Short: "chat interactively with a user",
+	Long:  `chat prints the conversation as it goes and sends every line typed, /quit (or EOF) leaves`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return chat(args[0])
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().String(clientAddressKey, "localhost:3000", "The front API address of the running client used by the chat subcommands")
+	_ = viper.BindPFlag(clientAddressKey, rootCmd.PersistentFlags().Lookup(clientAddressKey))
+
+	loginCmd.Flags().String(passwordKey, "", "The password, prompted if empty")
+	reactCmd.Flags().Bool(removeKey, false, "Remove the reaction")
+	downloadCmd.Flags().StringP(outputKey, "o", "", "The file written, the name of the attachment if empty")
+
+	for _, c := range []*cobra.Command{loginCmd, sendCmd, historyCmd, editCmd, deleteCmd, resendCmd, reactCmd, attachCmd, downloadCmd, contactsCmd, searchCmd, chatCmd} {
+		c.PreRun = func(cmd *cobra.Command, args []string) {
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+		}
+		rootCmd.AddCommand(c)
+	}
+}
+
+// promptPassword reads the password without echoing it on a terminal, or a line of the piped stdin
+func promptPassword() (string, error) {
+	fmt.Print("password: ")
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		b, err := term.ReadPassword(fd)
+		fmt.Println()
+		return string(b), err
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// clientCall calls the front API of the running client
+func clientCall(method, path string, header http.Header, body, resp interface{}) error {
+	clientAddress := viper.GetString(clientAddressKey)
+	if clientAddress == "" {
+		return errors.New("client address is mandatory")
+	}
+	return apiCall(clientAddress, method, path, header, body, resp)
+}
+
+func sendMessage(to, msg string) error {
+	return clientCall(http.MethodPost, mux.V1+"/messages/", nil, mux.SendNewMessageBody{To: to, Message: msg}, nil)
+}
+
+// conversationWith returns the latest messages, or the ones after the given ID
+func conversationWith(user string, after int64) ([]domain.Message, error) {
+	path := mux.V1 + "/conversations/" + url.PathEscape(user)
+	if after > 0 {
+		path += "?after=" + strconv.FormatInt(after, 10)
+	}
+
+	messages := []domain.Message{}
+	if err := clientCall(http.MethodGet, path, nil, nil, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// uploadAttachment sends the file to the running client so that it can be attached to a message
+func uploadAttachment(file string) (*domain.Attachment, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	path := mux.V1 + "/attachments/?name=" + url.QueryEscape(filepath.Base(file))
+	r, err := http.Post("http://"+viper.GetString(clientAddressKey)+path, mux.ApplicationOctetStream, f)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded %s", viper.GetString(clientAddressKey), r.Status)
+	}
+
+	a := &domain.Attachment{}
+	return a, json.NewDecoder(r.Body).Decode(a)
+}
+
+// downloadAttachment writes the attachment in output, or under its name if empty
+func downloadAttachment(user, hash, output string) error {
+	path := mux.V1 + "/conversations/" + url.PathEscape(user) + "/attachments/" + url.PathEscape(hash)
+	r, err := http.Get("http://" + viper.GetString(clientAddressKey) + path)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded %s", viper.GetString(clientAddressKey), r.Status)
+	}
+
+	if output == "" {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition"))
+		if err != nil || filepath.Base(params["filename"]) != params["filename"] {
+			return fmt.Errorf("invalid attachment name, use --%s", outputKey)
+		}
+		output = params["filename"]
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r.Body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Println("written to", output)
+	return nil
+}
+
+func messagePath(user, ref string) string {
+	return mux.V1 + "/conversations/" + url.PathEscape(user) + "/messages/" + url.PathEscape(ref)
+}
+
+// formatMessage shows the deleted messages as tombstones and marks the edited ones and the ones not delivered
+func formatMessage(m domain.Message) string {
+	if m.Deleted {
+		return m.Author + "> (deleted)"
+	}
+
+	line := m.Author + "> " + m.Content
+	if m.Edits > 0 {
+		line += " (edited)"
+	}
+	if m.Status == domain.MessagePending || m.Status == domain.MessageFailed {
+		line += " (" + m.Status + ")"
+	}
+	for _, a := range m.Attachments {
+		line += fmt.Sprintf(" [%s, %d bytes, %s]", a.Name, a.Size, a.Hash)
+	}
+
+	emojis := make([]string, 0, len(m.Reactions))
+	for emoji := range m.Reactions {
+		emojis = append(emojis, emoji)
+	}
+	sort.Strings(emojis)
+	for _, emoji := range emojis {
+		line += fmt.Sprintf(" %s%d", emoji, len(m.Reactions[emoji]))
+	}
+	return line
+}
+
+func printMessages(messages []domain.Message) {
+	for _, m := range messages {
+		fmt.Println(formatMessage(m))
+	}
+}
+
+// sendSignal tells the other user of the conversation, a client of a previous version can't
+func sendSignal(with, signalType, ref string) error {
+	path := mux.V1 + "/conversations/" + url.PathEscape(with) + "/signals"
+	return clientCall(http.MethodPost, path, nil, mux.SignalBody{Type: signalType, Ref: ref}, nil)
+}
+
+// signals streams the server-sent events of the client, the channel is closed when the stream ends
+func signals() <-chan domain.Signal {
+	stream := make(chan domain.Signal)
+
+	go func() {
+		defer close(stream)
+
+		r, err := http.Get("http://" + viper.GetString(clientAddressKey) + mux.V1 + "/events")
+		if err != nil {
+			return
+		}
+		defer r.Body.Close()
+		if r.StatusCode != http.StatusOK {
+			return
+		}
+
+		// only the data lines matter, the event is the type of the signal
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			data := strings.TrimPrefix(scanner.Text(), "data: ")
+			if data == scanner.Text() {
+				continue
+			}
+			s := domain.Signal{}
+			if err := json.Unmarshal([]byte(data), &s); err == nil {
+				stream <- s
+			}
+		}
+	}()
+
+	return stream
+}
+
+// seen tells the other user the messages were read, when they wrote the last one
+func seen(with string, messages []domain.Message) {
+	if len(messages) == 0 {
+		return
+	}
+	if m := messages[len(messages)-1]; m.Author == with {
+		_ = sendSignal(with, domain.SignalSeen, m.Ref)
+	}
+}
+
+func chat(with string) error {
+	messages, err := conversationWith(with, 0)
+	if err != nil {
+		return err
+	}
+	printMessages(messages)
+	seen(with, messages)
+
+	// the ID of the last message printed
+	var last int64
+	if len(messages) > 0 {
+		last = messages[len(messages)-1].ID
+	}
+
+	// stdin is read in background so incoming messages are printed while the user types
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	refresh := func() {
+		messages, err := conversationWith(with, last)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		if len(messages) > 0 {
+			printMessages(messages)
+			last = messages[len(messages)-1].ID
+			seen(with, messages)
+		}
+	}
+
+	// the signals refresh the conversation as soon as it changes, polling is kept for the clients without them
+	ticker := time.NewTicker(chatRefreshInterval)
+	defer ticker.Stop()
+	events := signals()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			line = strings.TrimSpace(line)
+			if !ok || line == "/quit" {
+				return nil
+			}
+			if line == "" {
+				continue
+			}
+			if err := sendMessage(with, line); err != nil {
+				fmt.Fprintln(os.Stderr, "not sent:", err)
+				continue
+			}
+			refresh()
+
+		case <-ticker.C:
+			refresh()
+
+		case s, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if s.With != with {
+				continue
+			}
+			switch s.Type {
+			case domain.SignalTyping:
+				fmt.Printf("(%s is typing)\n", with)
+			case domain.SignalSeen:
+				fmt.Printf("(seen by %s)\n", with)
+			case domain.SignalUpdated:
+				refresh()
+			}
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+	clusterstore "gop2p/driven/raft.clusterStore"
+	mux "gop2p/driving/api.mux"
+	"gop2p/logging"
+	"gop2p/tracing"
+)
+
+// config is everything the daemon can be configured with, from the config file, the env or the flags
+// (the flags win over the env which wins over the file)
+type config struct {
+	Server        bool              `mapstructure:"server"`
+	APIPort       int               `mapstructure:"api_port"`
+	P2PPort       int               `mapstructure:"p2p_port"`
+	ServerAddress string            `mapstructure:"server_address"`
+	P2PAddress    string            `mapstructure:"p2p_address"`
+	Device        string            `mapstructure:"device"`
+	VerifySenders bool              `mapstructure:"verify_senders"`
+	MaxBodySize   int64             `mapstructure:"max_body_size"`
+	AdminToken    string            `mapstructure:"admin_token"`
+	DHT           dhtConfig         `mapstructure:"dht"`
+	Storage       string            `mapstructure:"storage"`
+	Cluster       clusterConfig     `mapstructure:"cluster"`
+	TLS           tlsConfig         `mapstructure:"tls"`
+	RateLimit     rateLimitConfig   `mapstructure:"rate_limit"`
+	Tracing       tracingConfig     `mapstructure:"tracing"`
+	Log           logConfig         `mapstructure:"log"`
+	Attachments   attachmentsConfig `mapstructure:"attachments"`
+	Retention     retentionConfig   `mapstructure:"retention"`
+	Outbox        outboxConfig      `mapstructure:"outbox"`
+	Encryption    encryptionConfig  `mapstructure:"encryption"`
+}
+
+type dhtConfig struct {
+	Enabled   bool     `mapstructure:"enabled"`
+	Bootstrap []string `mapstructure:"bootstrap"`
+	KeyFile   string   `mapstructure:"key_file"`
+}
+
+type clusterConfig struct {
+	NodeID  string   `mapstructure:"node_id"`
+	Peers   []string `mapstructure:"peers"`
+	DataDir string   `mapstructure:"data_dir"`
+	Secret  string   `mapstructure:"secret"`
+}
+
+// tlsConfig enables TLS on every router, the other nodes are then called with https and trusted with the CA
+type tlsConfig struct {
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	CAFile   string `mapstructure:"ca_file"`
+}
+
+// rateLimitConfig is applied per client IP on every router, it's reloaded on SIGHUP
+type rateLimitConfig struct {
+	RequestsPerSecond float64 `mapstructure:"requests_per_second"`
+	Burst             int     `mapstructure:"burst"`
+}
+
+// tracingConfig selects where the spans are exported (none, otlp, stdout or file)
+type tracingConfig struct {
+	Exporter    string  `mapstructure:"exporter"`
+	ServiceName string  `mapstructure:"service_name"`
+	Endpoint    string  `mapstructure:"endpoint"`
+	Insecure    bool    `mapstructure:"insecure"`
+	File        string  `mapstructure:"file"`
+	SampleRatio float64 `mapstructure:"sample_ratio"`
+}
+
+// logConfig level is reloaded on SIGHUP, the format (text or json) needs a restart
+type logConfig struct {
+	Level  string `mapstructure:"level"`
+	Format string `mapstructure:"format"`
+}
+
+// attachmentsConfig is where a client keeps the files sent and received, a new temporary dir if empty
+type attachmentsConfig struct {
+	Dir string `mapstructure:"dir"`
+}
+
+// retentionConfig is the default retention of the conversations of a client, they can set their own
+type retentionConfig struct {
+	Days          int           `mapstructure:"days"`
+	Messages      int           `mapstructure:"messages"`
+	SweepInterval time.Duration `mapstructure:"sweep_interval"`
+}
+
+// outboxConfig is how often a client sends again the messages its peers couldn't store yet
+type outboxConfig struct {
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// encryptionConfig encrypts the history of a client at rest, it's unlocked with the keyfile or else a passphrase
+type encryptionConfig struct {
+	Keyring string `mapstructure:"keyring"`
+	Keyfile string `mapstructure:"keyfile"`
+}
+
+const (
+	storageMemory = "memory"
+	storageRaft   = "raft"
+)
+
+// loadConfig reads the config file again (if any) and validates the whole config
+func loadConfig() (config, error) {
+	if viper.ConfigFileUsed() != "" {
+		if err := viper.ReadInConfig(); err != nil {
+			return config{}, fmt.Errorf("can't read the config file: %v", err)
+		}
+	}
+
+	c := config{}
+	if err := viper.Unmarshal(&c); err != nil {
+		return config{}, fmt.Errorf("can't parse the config: %v", err)
+	}
+	c.DHT.Bootstrap = trimList(c.DHT.Bootstrap)
+	c.Cluster.Peers = trimList(c.Cluster.Peers)
+
+	return c, c.validate()
+}
+
+// validate reports all the problems at once
+func (c config) validate() error {
+	errs := []string{}
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	if !validPort(c.APIPort) {
+		fail("api_port must be between 1 and 65535, got %d", c.APIPort)
+	}
+
+	if c.Server {
+		switch c.Storage {
+		case storageMemory:
+		case storageRaft:
+			if c.Cluster.Secret == "" {
+				fail("cluster.secret is mandatory with raft storage")
+			}
+			peers, err := c.clusterPeers()
+			if err != nil {
+				fail("%v", err)
+			} else if _, ok := peerIDs(peers)[c.Cluster.NodeID]; !ok {
+				fail("cluster.node_id %q must be one of cluster.peers", c.Cluster.NodeID)
+			}
+		default:
+			fail("storage must be %s or %s, got %q", storageMemory, storageRaft, c.Storage)
+		}
+	} else {
+		if !validPort(c.P2PPort) {
+			fail("p2p_port must be between 1 and 65535, got %d", c.P2PPort)
+		} else if c.P2PPort == c.APIPort {
+			fail("api_port and p2p_port must be different")
+		}
+
+		if c.DHT.Enabled && c.P2PAddress == "" {
+			fail("p2p_address is mandatory in dht mode")
+		}
+		// the first key publishing a login is trusted, so the other devices of the user can't be found
+		if c.DHT.Enabled && c.Device != "" {
+			fail("device can't be set in dht mode, a user has one device there")
+		}
+		if !c.DHT.Enabled && c.ServerAddress == "" {
+			fail("server_address is mandatory in client mode")
+		}
+		if c.MaxBodySize <= 0 {
+			fail("max_body_size must be positive, got %d", c.MaxBodySize)
+		}
+	}
+
+	if c.Retention.Days < 0 || c.Retention.Messages < 0 {
+		fail("retention.days and retention.messages can't be negative")
+	}
+	if c.Retention.SweepInterval <= 0 {
+		fail("retention.sweep_interval must be positive, got %s", c.Retention.SweepInterval)
+	}
+	if c.Outbox.Interval <= 0 {
+		fail("outbox.interval must be positive, got %s", c.Outbox.Interval)
+	}
+
+	if c.Encryption.Keyfile != "" {
+		if c.Encryption.Keyring == "" {
+			fail("encryption.keyfile needs encryption.keyring")
+		}
+		if _, err := os.Stat(c.Encryption.Keyfile); err != nil {
+			fail("%v", err)
+		}
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		fail("tls.cert_file and tls.key_file go together")
+	}
+	for _, f := range []string{c.TLS.CertFile, c.TLS.KeyFile, c.TLS.CAFile} {
+		if _, err := os.Stat(f); f != "" && err != nil {
+			fail("%v", err)
+		}
+	}
+
+	if c.RateLimit.RequestsPerSecond < 0 {
+		fail("rate_limit.requests_per_second can't be negative")
+	}
+	if c.RateLimit.RequestsPerSecond > 0 && c.RateLimit.Burst < 1 {
+		fail("rate_limit.burst must be at least 1 when rate limiting is enabled")
+	}
+
+	if err := (tracing.Config{Exporter: c.Tracing.Exporter, File: c.Tracing.File, SampleRatio: c.Tracing.SampleRatio}).Validate(); err != nil {
+		fail("tracing: %v", err)
+	}
+
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		fail("log.level: %v", err)
+	}
+	if c.Log.Format != logging.FormatText && c.Log.Format != logging.FormatJSON {
+		fail("log.format must be %s or %s, got %q", logging.FormatText, logging.FormatJSON, c.Log.Format)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(errs, "\n  - "))
+	}
+	return nil
+}
+
+func validPort(p int) bool {
+	return p > 0 && p < 65536
+}
+
+// trimList trims the items of a list and drops the empty ones
+func trimList(l []string) []string {
+	trimmed := []string{}
+	for _, item := range l {
+		if item = strings.TrimSpace(item); item != "" {
+			trimmed = append(trimmed, item)
+		}
+	}
+	return trimmed
+}
+
+// clusterPeers parses the list of id@raftAddress@apiAddress
+func (c config) clusterPeers() ([]clusterstore.Peer, error) {
+	peers := []clusterstore.Peer{}
+	for _, item := range c.Cluster.Peers {
+		parts := strings.Split(item, "@")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid cluster peer %q, expected id@raftHost:port@apiHost:port", item)
+		}
+		peers = append(peers, clusterstore.Peer{ID: parts[0], RaftAddress: parts[1], APIAddress: parts[2]})
+	}
+	return peers, nil
+}
+
+func peerIDs(peers []clusterstore.Peer) map[string]struct{} {
+	ids := map[string]struct{}{}
+	for _, p := range peers {
+		ids[p.ID] = struct{}{}
+	}
+	return ids
+}
+
+// transport is how this node calls the others, https with TLS enabled
+func (c config) transport() (mux.Transport, error) {
+	if c.TLS.CertFile == "" {
+		return mux.DefaultTransport(), nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if c.TLS.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.TLS.CAFile)
+		if err != nil {
+			return mux.Transport{}, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return mux.Transport{}, fmt.Errorf("no certificate found in %s", c.TLS.CAFile)
+		}
+	}
+
+	return mux.Transport{
+		Scheme:  "https",
+		Client:  &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+		Retries: mux.DefaultRetries,
+	}, nil
+}
+
+func (c config) listenOptions(port int, limiter *mux.RateLimiter) mux.ListenOptions {
+	return mux.ListenOptions{
+		Port:     port,
+		CertFile: c.TLS.CertFile,
+		KeyFile:  c.TLS.KeyFile,
+		Limiter:  limiter,
+	}
+}
+
+// device is stable across restarts so that a client restarted replaces its own session
+func (c config) device() string {
+	if c.Device != "" {
+		return c.Device
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%s-%d", host, c.P2PPort)
+}
+
+// dhtKeyFile is stable across restarts so that a client restarted can publish its session again
+func (c config) dhtKeyFile() (string, error) {
+	if c.DHT.KeyFile != "" {
+		return c.DHT.KeyFile, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gop2p", "dht.key"), nil
+}
+
+// runtime holds what can be changed without restarting
+type runtime struct {
+	limiter *mux.RateLimiter
+
+	// stops are called before the process stops
+	stops *stops
+}
+
+type stops struct {
+	mu sync.Mutex
+	fs []func()
+}
+
+func newRuntime(c config) runtime {
+	// already validated with the config
+	_ = logging.Setup(os.Stderr, c.Log.Format)
+
+	rt := runtime{limiter: mux.NewRateLimiter(c.RateLimit.RequestsPerSecond, c.RateLimit.Burst), stops: &stops{}}
+	rt.apply(c)
+	return rt
+}
+
+// onExit registers what must be stopped before the process stops
+func (rt runtime) onExit(stop func()) {
+	rt.stops.mu.Lock()
+	defer rt.stops.mu.Unlock()
+	rt.stops.fs = append(rt.stops.fs, stop)
+}
+
+// stop calls what was registered, the latest first
+func (rt runtime) stop() {
+	rt.stops.mu.Lock()
+	defer rt.stops.mu.Unlock()
+	for i := len(rt.stops.fs) - 1; i >= 0; i-- {
+		rt.stops.fs[i]()
+	}
+}
+
+func (rt runtime) apply(c config) {
+	rt.limiter.SetLimits(c.RateLimit.RequestsPerSecond, c.RateLimit.Burst)
+
+	level, _ := logging.ParseLevel(c.Log.Level)
+	logging.SetLevel(level)
+}
+
+// reloadOnSIGHUP applies the safe settings (rate limits and log level) of the new config,
+// an invalid config is ignored and the other changes need a restart
+func (rt runtime) reloadOnSIGHUP(current config) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+
+	for range sig {
+		current = rt.reload(current)
+	}
+}
+
+// reload returns the config now applied, the current one if the new one is invalid
+func (rt runtime) reload(current config) config {
+	next, err := loadConfig()
+	if err != nil {
+		logging.Error(context.Background(), "config not reloaded", "err", err)
+		return current
+	}
+
+	rt.apply(next)
+	logging.Info(context.Background(), "config reloaded", "log_level", next.Log.Level, "rate_limit", next.RateLimit.RequestsPerSecond)
+
+	if !reflect.DeepEqual(withoutReloadable(current), withoutReloadable(next)) {
+		logging.Warn(context.Background(), "only rate_limit and log.level are reloaded, the other changes need a restart")
+	}
+	return next
+}
+
+func withoutReloadable(c config) config {
+	c.RateLimit = rateLimitConfig{}
+	c.Log.Level = ""
+	return c
+}
@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// load reads the config like the daemon does from the flags, the env and a config file (empty if no content),
+// the flags set by the previous tests are reset to their default first
+func load(t *testing.T, args []string, env map[string]string, file string) (config, error) {
+	t.Helper()
+
+	if err := rootCmd.ParseFlags([]string{}); err != nil {
+		t.Fatal(err)
+	}
+	rootCmd.Flags().VisitAll(func(f *pflag.Flag) {
+		_ = f.Value.Set(f.DefValue)
+		f.Changed = false
+	})
+	if err := rootCmd.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+
+	path := filepath.Join(t.TempDir(), "gop2p.yaml")
+	writeFile(t, path, file)
+	viper.SetConfigFile(path)
+
+	return loadConfig()
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	existing := filepath.Join(t.TempDir(), "existing")
+	writeFile(t, existing, "")
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	client := []string{"--server_address", "central:3000"}
+	raft := []string{"-s", "--storage", "raft", "--cluster_secret", "s3cr3t", "--cluster_node_id", "n1"}
+
+	cases := []struct {
+		name string
+		args []string
+		file string
+		// every message expected, the config is valid if there's none
+		errs []string
+	}{
+		{name: "client", args: client},
+		{name: "server", args: []string{"-s"}},
+		{name: "dht client", args: []string{"--dht", "--p2p_address", "alice:4000"}},
+		{name: "raft server", args: append(raft, "--cluster_peers", " n1@a:7000@a:3000, ,n2@b:7000@b:3000")},
+		{name: "invalid api port", args: append(client, "--api_port", "0"), errs: []string{"api_port must be between 1 and 65535, got 0"}},
+		{name: "invalid p2p port", args: append(client, "--p2p_port", "70000"), errs: []string{"p2p_port must be between 1 and 65535, got 70000"}},
+		{name: "same ports", args: append(client, "--p2p_port", "3000"), errs: []string{"api_port and p2p_port must be different"}},
+		{name: "client without server", errs: []string{"server_address is mandatory in client mode"}},
+		{name: "no max body size", args: append(client, "--max_body_size", "0"), errs: []string{"max_body_size must be positive, got 0"}},
+		{name: "dht without p2p address", args: []string{"--dht"}, errs: []string{"p2p_address is mandatory in dht mode"}},
+		{name: "dht with a device", args: []string{"--dht", "--p2p_address", "alice:4000", "--device", "phone"}, errs: []string{"device can't be set in dht mode, a user has one device there"}},
+		{name: "unknown storage", args: []string{"-s", "--storage", "disk"}, errs: []string{`storage must be memory or raft, got "disk"`}},
+		{name: "raft without secret", args: []string{"-s", "--storage", "raft", "--cluster_node_id", "n1", "--cluster_peers", "n1@a:7000@a:3000"}, errs: []string{"cluster.secret is mandatory with raft storage"}},
+		{name: "invalid peer", args: append(raft, "--cluster_peers", "n1@a:7000"), errs: []string{`invalid cluster peer "n1@a:7000", expected id@raftHost:port@apiHost:port`}},
+		{name: "node not a peer", args: append(raft, "--cluster_peers", "n2@b:7000@b:3000"), errs: []string{`cluster.node_id "n1" must be one of cluster.peers`}},
+		{name: "negative retention", args: append(client, "--retention_days", "-1"), errs: []string{"retention.days and retention.messages can't be negative"}},
+		{name: "no sweep interval", args: append(client, "--retention_sweep_interval", "0s"), errs: []string{"retention.sweep_interval must be positive, got 0s"}},
+		{name: "no outbox interval", args: append(client, "--outbox_interval", "-1s"), errs: []string{"outbox.interval must be positive, got -1s"}},
+		{name: "keyfile without keyring", args: append(client, "--encryption_keyfile", existing), errs: []string{"encryption.keyfile needs encryption.keyring"}},
+		{name: "missing keyfile", args: append(client, "--encryption_keyring", existing, "--encryption_keyfile", missing), errs: []string{missing + ": no such file or directory"}},
+		{name: "certificate without key", args: append(client, "--tls_cert_file", existing), errs: []string{"tls.cert_file and tls.key_file go together"}},
+		{name: "missing CA", args: append(client, "--tls_ca_file", missing), errs: []string{missing + ": no such file or directory"}},
+		{name: "negative rate limit", args: append(client, "--rate_limit_requests_per_second", "-1"), errs: []string{"rate_limit.requests_per_second can't be negative"}},
+		{name: "no burst", args: append(client, "--rate_limit_requests_per_second", "5", "--rate_limit_burst", "0"), errs: []string{"rate_limit.burst must be at least 1 when rate limiting is enabled"}},
+		{name: "unknown exporter", args: append(client, "--tracing_exporter", "zipkin"), errs: []string{`tracing: unknown exporter "zipkin"`}},
+		{name: "file exporter without file", args: append(client, "--tracing_exporter", "file"), errs: []string{"tracing: a file is mandatory with the file exporter"}},
+		{name: "unknown log level", args: append(client, "--log_level", "loud"), errs: []string{"log.level: "}},
+		{name: "unknown log format", args: append(client, "--log_format", "xml"), errs: []string{`log.format must be text or json, got "xml"`}},
+		{name: "from the file", file: "api_port: 0\n", errs: []string{"api_port must be between 1 and 65535, got 0", "server_address is mandatory in client mode"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := load(t, c.args, nil, c.file)
+
+			if len(c.errs) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected %q", c.errs)
+			}
+			if !strings.HasPrefix(err.Error(), "invalid configuration:\n  - ") {
+				t.Errorf("the errors aren't listed: %v", err)
+			}
+			for _, msg := range c.errs {
+				if !strings.Contains(err.Error(), msg) {
+					t.Errorf("%q is missing from %v", msg, err)
+				}
+			}
+			if listed := strings.Count(err.Error(), "\n  - "); listed != len(c.errs) {
+				t.Errorf("%d errors listed instead of %d: %v", listed, len(c.errs), err)
+			}
+		})
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	file := "server_address: file:3000\napi_port: 3001\ncluster:\n  peers: [n1@a:7000@a:3000]\n"
+
+	cases := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		port    int
+		address string
+		peers   []string
+	}{
+		{name: "the file wins over the defaults", port: 3001, address: "file:3000", peers: []string{"n1@a:7000@a:3000"}},
+		{name: "the env wins over the file", env: map[string]string{"API_PORT": "3002"}, port: 3002, address: "file:3000", peers: []string{"n1@a:7000@a:3000"}},
+		{name: "the flags win over the env", args: []string{"--api_port", "3003"}, env: map[string]string{"API_PORT": "3002", "SERVER_ADDRESS": "env:3000"}, port: 3003, address: "env:3000", peers: []string{"n1@a:7000@a:3000"}},
+		{name: "the nested keys are read from the env", env: map[string]string{"CLUSTER_PEERS": "n2@b:7000@b:3000,n3@c:7000@c:3000"}, port: 3001, address: "file:3000", peers: []string{"n2@b:7000@b:3000", "n3@c:7000@c:3000"}},
+		{name: "the nested keys are set by the flags", args: []string{"--cluster_peers", "n4@d:7000@d:3000"}, env: map[string]string{"CLUSTER_PEERS": "n2@b:7000@b:3000"}, port: 3001, address: "file:3000", peers: []string{"n4@d:7000@d:3000"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conf, err := load(t, c.args, c.env, file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if conf.APIPort != c.port || conf.ServerAddress != c.address || !reflect.DeepEqual(conf.Cluster.Peers, c.peers) {
+				t.Errorf("got api_port %d, server_address %s and cluster.peers %v instead of %d, %s and %v",
+					conf.APIPort, conf.ServerAddress, conf.Cluster.Peers, c.port, c.address, c.peers)
+			}
+		})
+	}
+
+	t.Run("the p2p port keeps its default", func(t *testing.T) {
+		conf, err := load(t, nil, nil, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conf.P2PPort != 4000 {
+			t.Errorf("got p2p_port %d", conf.P2PPort)
+		}
+	})
+}
+
+func TestReload(t *testing.T) {
+	current, err := load(t, nil, nil, "server_address: central:3000\nrate_limit:\n  requests_per_second: 1\n  burst: 1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := newRuntime(current)
+
+	// the rate limit would be disabled if this file was applied
+	writeFile(t, viper.ConfigFileUsed(), "server_address: central:3000\nrate_limit:\n  requests_per_second: 0\nlog:\n  level: loud\n")
+	applied := rt.reload(current)
+
+	if applied.RateLimit.RequestsPerSecond != 1 || applied.Log.Level != "info" {
+		t.Errorf("the invalid config replaced the previous one: %+v", applied)
+	}
+
+	h := rt.limiter.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	codes := []int{}
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		codes = append(codes, w.Code)
+	}
+	if codes[1] != http.StatusTooManyRequests {
+		t.Errorf("the previous rate limit isn't applied anymore, got %v", codes)
+	}
+
+	writeFile(t, viper.ConfigFileUsed(), "server_address: central:3000\nlog:\n  level: debug\n")
+	if applied = rt.reload(applied); applied.RateLimit.RequestsPerSecond != 0 || applied.Log.Level != "debug" {
+		t.Errorf("the valid config isn't applied: %+v", applied)
+	}
+}
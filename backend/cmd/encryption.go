@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gop2p/domain"
+	encrypted "gop2p/driven/aes.conversationManager"
+	mux "gop2p/driving/api.mux"
+)
+
+const (
+	keyfileKey    = "keyfile"
+	newKeyfileKey = "new_keyfile"
+)
+
+var encryptionCmd = &cobra.Command{
+	Use:   "encryption",
+	Short: "manage the encryption of the history of the running client",
+	Long:  `encryption calls the front API of the running client, it's only available when started with --encryption_keyring`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+	},
+}
+
+func init() {
+	unlockCmd := &cobra.Command{
+		Use:   "unlock",
+		Short: "open the history, the first unlock sets the secret",
+		Long:  `unlock prompts the passphrase, or sends the content of --keyfile`,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			in := bufio.NewReader(os.Stdin)
+			secret, err := readSecret(cmd, in, keyfileKey, "passphrase: ")
+			if err != nil {
+				return err
+			}
+			return clientCall(http.MethodPost, mux.V1+"/encryption/unlock", nil, mux.UnlockHistoryBody{Secret: secret}, nil)
+		},
+	}
+	unlockCmd.Flags().String(keyfileKey, "", "The keyfile, the passphrase is prompted if empty")
+
+	changeSecretCmd := &cobra.Command{
+		Use:   "change-secret",
+		Short: "replace the passphrase or the keyfile of the history",
+		Long:  `change-secret prompts the current and the new passphrases, or sends the content of the keyfiles`,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			in := bufio.NewReader(os.Stdin)
+			current, err := readSecret(cmd, in, keyfileKey, "current passphrase: ")
+			if err != nil {
+				return err
+			}
+			secret, err := readSecret(cmd, in, newKeyfileKey, "new passphrase: ")
+			if err != nil {
+				return err
+			}
+			return clientCall(http.MethodPut, mux.V1+"/encryption/secret", nil, mux.ChangeHistorySecretBody{Current: current, Secret: secret}, nil)
+		},
+	}
+	changeSecretCmd.Flags().String(keyfileKey, "", "The current keyfile, the current passphrase is prompted if empty")
+	changeSecretCmd.Flags().String(newKeyfileKey, "", "The new keyfile, the new passphrase is prompted if empty")
+
+	encryptionCmd.AddCommand(
+		&cobra.Command{
+			Use:   "status",
+			Short: "tell if the history is encrypted and locked",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				e := domain.Encryption{}
+				if err := clientCall(http.MethodGet, mux.V1+"/encryption", nil, nil, &e); err != nil {
+					return err
+				}
+
+				switch {
+				case !e.Encrypted:
+					fmt.Println("the history isn't encrypted")
+				case e.Locked:
+					fmt.Println("the history is encrypted and locked")
+				default:
+					fmt.Println("the history is encrypted and unlocked")
+				}
+				return nil
+			},
+		},
+		unlockCmd,
+		&cobra.Command{
+			Use:   "lock",
+			Short: "forget the keys of the history until it's unlocked again",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return clientCall(http.MethodPost, mux.V1+"/encryption/lock", nil, nil, nil)
+			},
+		},
+		changeSecretCmd,
+		&cobra.Command{
+			Use:   "rotate-key",
+			Short: "encrypt the next messages with a new key",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return clientCall(http.MethodPost, mux.V1+"/encryption/rotate", nil, nil, nil)
+			},
+		},
+		&cobra.Command{
+			Use:   "new-keyfile <file>",
+			Short: "write a new random keyfile, to start a client with --encryption_keyfile",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if _, err := os.Stat(args[0]); err == nil {
+					return fmt.Errorf("%s already exists", args[0])
+				}
+				return encrypted.NewKeyfile(args[0])
+			},
+		},
+	)
+
+	rootCmd.AddCommand(encryptionCmd)
+}
+
+// readSecret reads the keyfile given with the flag, or else prompts a passphrase
+func readSecret(cmd *cobra.Command, in *bufio.Reader, flag, prompt string) (string, error) {
+	if keyfile, _ := cmd.Flags().GetString(flag); keyfile != "" {
+		return encrypted.ReadKeyfile(keyfile)
+	}
+
+	fmt.Print(prompt)
+	line, err := in.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	mux "gop2p/driving/api.mux"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export [user]",
+	Short: "export the conversation with a user, or all of them, as JSON Lines",
+	Long:  `export writes the history of the running client to stdout or --output, import restores it on another client`,
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := mux.V1 + "/export"
+		if len(args) == 1 {
+			path = mux.V1 + "/conversations/" + url.PathEscape(args[0]) + "/export"
+		}
+
+		r, err := http.Get("http://" + viper.GetString(clientAddressKey) + path)
+		if err != nil {
+			return err
+		}
+		defer r.Body.Close()
+		if r.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s responded %s", viper.GetString(clientAddressKey), r.Status)
+		}
+
+		output, _ := cmd.Flags().GetString(outputKey)
+		if output == "" {
+			_, err := io.Copy(os.Stdout, r.Body)
+			return err
+		}
+
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r.Body); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "import an export in the running client",
+	Long:  `import reads the export from the file, or stdin if it's -, the messages already known are skipped`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		in := os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+
+		r, err := http.Post("http://"+viper.GetString(clientAddressKey)+mux.V1+"/import", mux.ApplicationJSONLines, in)
+		if err != nil {
+			return err
+		}
+		defer r.Body.Close()
+		if r.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s responded %s", viper.GetString(clientAddressKey), r.Status)
+		}
+
+		result := mux.ImportResult{}
+		if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+			return err
+		}
+		fmt.Println(result.Imported, "messages imported")
+		return nil
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringP(outputKey, "o", "", "The file written, stdout if empty")
+
+	for _, c := range []*cobra.Command{exportCmd, importCmd} {
+		c.PreRun = func(cmd *cobra.Command, args []string) {
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+		}
+		rootCmd.AddCommand(c)
+	}
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gop2p/domain"
+	mux "gop2p/driving/api.mux"
+)
+
+const (
+	daysKey     = "days"
+	messagesKey = "messages"
+	ttlKey      = "ttl"
+)
+
+var retentionCmd = &cobra.Command{
+	Use:   "retention <user>",
+	Short: "print or change how long the conversation with a user is kept",
+	Long: `retention prints the retention of the conversation, or changes the settings given. The days and messages are
+only applied by this client, the ttl is sent to the other user so that the messages disappear for both`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := mux.V1 + "/conversations/" + url.PathEscape(args[0]) + "/retention"
+
+		r := domain.Retention{}
+		if err := clientCall(http.MethodGet, path, nil, nil, &r); err != nil {
+			return err
+		}
+
+		flags := cmd.Flags()
+		if !flags.Changed(daysKey) && !flags.Changed(messagesKey) && !flags.Changed(ttlKey) {
+			fmt.Printf("days: %d\nmessages: %d\nttl: %s\n", r.Days, r.Messages, time.Duration(r.TTL)*time.Second)
+			return nil
+		}
+
+		if flags.Changed(daysKey) {
+			r.Days, _ = flags.GetInt(daysKey)
+		}
+		if flags.Changed(messagesKey) {
+			r.Messages, _ = flags.GetInt(messagesKey)
+		}
+		if flags.Changed(ttlKey) {
+			ttl, _ := flags.GetDuration(ttlKey)
+			r.TTL = int64(ttl / time.Second)
+		}
+		return clientCall(http.MethodPut, path, nil, r, nil)
+	},
+}
+
+func init() {
+	retentionCmd.Flags().Int(daysKey, 0, "Purge the messages older than this many days, 0 uses the default of the client")
+	retentionCmd.Flags().Int(messagesKey, 0, "Only keep the latest messages, 0 uses the default of the client")
+	retentionCmd.Flags().Duration(ttlKey, 0, "Make the messages disappear after this long for both users (eg. 24h), 0 stops it")
+
+	retentionCmd.PreRun = func(cmd *cobra.Command, args []string) {
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+	}
+	rootCmd.AddCommand(retentionCmd)
+}
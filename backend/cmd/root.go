@@ -4,17 +4,65 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
 	"github.com/spf13/viper"
+	mux "gop2p/driving/api.mux"
+	"gop2p/logging"
 )
 
 const (
+	configFileKey    = "config"
 	serverModeKey    = "server"
 	apiPortKey       = "api_port"
 	p2pPortKey       = "p2p_port"
 	serverAddressKey = "server_address"
+	dhtModeKey       = "dht.enabled"
+	dhtBootstrapKey  = "dht.bootstrap"
+	dhtKeyFileKey    = "dht.key_file"
+	p2pAddressKey    = "p2p_address"
+	deviceKey        = "device"
+	verifySendersKey = "verify_senders"
+	maxBodySizeKey   = "max_body_size"
+	adminTokenKey    = "admin_token"
+
+	storageKey        = "storage"
+	clusterNodeIDKey  = "cluster.node_id"
+	clusterPeersKey   = "cluster.peers"
+	clusterDataDirKey = "cluster.data_dir"
+	clusterSecretKey  = "cluster.secret"
+
+	tlsCertFileKey = "tls.cert_file"
+	tlsKeyFileKey  = "tls.key_file"
+	tlsCAFileKey   = "tls.ca_file"
+
+	rateLimitKey      = "rate_limit.requests_per_second"
+	rateLimitBurstKey = "rate_limit.burst"
+
+	tracingExporterKey    = "tracing.exporter"
+	tracingServiceNameKey = "tracing.service_name"
+	tracingEndpointKey    = "tracing.endpoint"
+	tracingInsecureKey    = "tracing.insecure"
+	tracingFileKey        = "tracing.file"
+	tracingSampleRatioKey = "tracing.sample_ratio"
+
+	logLevelKey  = "log.level"
+	logFormatKey = "log.format"
+
+	attachmentsDirKey = "attachments.dir"
+
+	retentionDaysKey          = "retention.days"
+	retentionMessagesKey      = "retention.messages"
+	retentionSweepIntervalKey = "retention.sweep_interval"
+
+	outboxIntervalKey = "outbox.interval"
+
+	encryptionKeyringKey = "encryption.keyring"
+	encryptionKeyfileKey = "encryption.keyfile"
 )
 
 var rootCmd = &cobra.Command{
@@ -22,23 +70,22 @@ var rootCmd = &cobra.Command{
 	Short: "a p2p messaging system",
 	Long:  `gop2p is a peer-to-peer messaging system`,
 	Run: func(cmd *cobra.Command, args []string) {
+		c, err := loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
 
-		// when every flag / env var is parsed, we start the app
-		// in server or client mode according to the "server" flag
-		if viper.GetBool(serverModeKey) {
-			startInServerMode(viper.GetInt(apiPortKey))
-		} else {
-			serverAddress := viper.GetString(serverAddressKey)
-			if serverAddress == "" {
-				log.Fatal("server address is mandatory in client mode")
-				return
-			}
-
-			startInClientMode(
-				viper.GetInt(apiPortKey),
-				viper.GetInt(p2pPortKey),
-				serverAddress,
-			)
+		// when every flag / env var / config value is parsed, we start the app
+		// in server or client mode according to the "server" setting
+		switch {
+		case c.Server && c.Storage == storageRaft:
+			startInClusteredServerMode(c)
+		case c.Server:
+			startInServerMode(c)
+		case c.DHT.Enabled:
+			startInDHTClientMode(c)
+		default:
+			startInClientMode(c)
 		}
 	},
 }
@@ -54,20 +101,152 @@ func Execute() {
 
 // we set here the available flags
 func init() {
+	cobra.OnInitialize(func() {
+		if f := viper.GetString(configFileKey); f != "" {
+			viper.SetConfigFile(f)
+		}
+	})
+
+	// the nested keys (eg. cluster.node_id) are read from env vars like CLUSTER_NODE_ID
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
 
+	// every setting below can also be set in a yaml or toml file
+	rootCmd.PersistentFlags().String(configFileKey, "", "The config file (yaml or toml), the flags and env vars override it")
+	_ = viper.BindPFlag(configFileKey, rootCmd.PersistentFlags().Lookup(configFileKey))
+
 	// we select if the app runs in client or server mode, defaults to client mode
 	rootCmd.Flags().BoolP(serverModeKey, "s", false, "Run gop2p in server mode, default: false")
-	_ = viper.BindPFlag(serverModeKey, rootCmd.Flags().Lookup(serverModeKey))
+	bindFlag(serverModeKey, rootCmd.Flags())
 
 	// we select the port on which the API server will listen on, defaults to 3000
 	rootCmd.Flags().IntP(apiPortKey, "p", 3000, "The API port to listen on")
-	_ = viper.BindPFlag(apiPortKey, rootCmd.Flags().Lookup(apiPortKey))
+	bindFlag(apiPortKey, rootCmd.Flags())
 
 	// we select the port on which the P2P server will listen on, defaults to 4000
 	rootCmd.Flags().Int(p2pPortKey, 4000, "The port used for p2p communication between clients")
-	_ = viper.BindPFlag(p2pPortKey, rootCmd.Flags().Lookup(p2pPortKey))
+	bindFlag(p2pPortKey, rootCmd.Flags())
+
+	// persistent since the subcommands also talk to the central server
+	rootCmd.PersistentFlags().String(serverAddressKey, "", "The address where the client can reach the central server")
+	bindFlag(serverAddressKey, rootCmd.PersistentFlags())
+
+	// the server only serves the admin API when a token is set, the admin subcommands use the same one
+	rootCmd.PersistentFlags().String(adminTokenKey, "", "The token required by the admin API")
+	bindFlag(adminTokenKey, rootCmd.PersistentFlags())
+
+	// in dht mode, the clients find each other without central server
+	rootCmd.Flags().Bool("dht", false, "Run the client without central server, using a DHT to find the other clients")
+	_ = viper.BindPFlag(dhtModeKey, rootCmd.Flags().Lookup("dht"))
+
+	rootCmd.Flags().String(flagName(dhtBootstrapKey), "", "Comma separated p2p addresses of nodes already in the DHT")
+	bindFlag(dhtBootstrapKey, rootCmd.Flags())
+
+	rootCmd.Flags().String(flagName(dhtKeyFileKey), "", "Where the key signing the session is kept across restarts, <user config dir>/gop2p/dht.key if empty")
+	bindFlag(dhtKeyFileKey, rootCmd.Flags())
+
+	// persistent since the login subcommand also announces it
+	rootCmd.PersistentFlags().String(p2pAddressKey, "", "The address where the other clients can reach this one")
+	bindFlag(p2pAddressKey, rootCmd.PersistentFlags())
+
+	rootCmd.Flags().String(deviceKey, "", "The name of this client among the devices of the user, <hostname>-<p2p port> if empty")
+	bindFlag(deviceKey, rootCmd.Flags())
+
+	// the peers are only known by their address, it may not be the one of their session behind a proxy
+	rootCmd.Flags().Bool(verifySendersKey, true, "Reject the peers whose address isn't the one of a session of the user they claim to be")
+	bindFlag(verifySendersKey, rootCmd.Flags())
+
+	// the bodies are limited once decompressed, a small gzipped body could be huge otherwise
+	rootCmd.Flags().Int64(maxBodySizeKey, mux.DefaultMaxBodySize, "The size in bytes the requests of the peers are refused past (413)")
+	bindFlag(maxBodySizeKey, rootCmd.Flags())
+
+	// in server mode, users and sessions are either kept in memory or replicated between several servers
+	rootCmd.Flags().String(storageKey, storageMemory, "The server storage: memory or raft (clustered)")
+	bindFlag(storageKey, rootCmd.Flags())
+
+	rootCmd.Flags().String(flagName(clusterNodeIDKey), "", "The id of this server in the cluster")
+	bindFlag(clusterNodeIDKey, rootCmd.Flags())
+
+	rootCmd.Flags().String(flagName(clusterPeersKey), "", "Comma separated cluster members as id@raftHost:port@apiHost:port, this node included. The raft port isn't authenticated, keep it on a trusted network")
+	bindFlag(clusterPeersKey, rootCmd.Flags())
+
+	rootCmd.Flags().String(flagName(clusterDataDirKey), "", "Where the raft log is persisted, kept in memory if empty")
+	bindFlag(clusterDataDirKey, rootCmd.Flags())
+
+	rootCmd.Flags().String(flagName(clusterSecretKey), "", "The secret shared by the cluster members")
+	bindFlag(clusterSecretKey, rootCmd.Flags())
+
+	// TLS is used both to serve and to call the other nodes
+	rootCmd.Flags().String(flagName(tlsCertFileKey), "", "The certificate used to serve with TLS")
+	bindFlag(tlsCertFileKey, rootCmd.Flags())
+
+	rootCmd.Flags().String(flagName(tlsKeyFileKey), "", "The private key of the certificate")
+	bindFlag(tlsKeyFileKey, rootCmd.Flags())
+
+	rootCmd.Flags().String(flagName(tlsCAFileKey), "", "The CA used to trust the other nodes, the system ones if empty")
+	bindFlag(tlsCAFileKey, rootCmd.Flags())
+
+	// the rate limit and the log level are reloaded on SIGHUP
+	rootCmd.Flags().Float64(flagName(rateLimitKey), 0, "The requests per second allowed per client IP, 0 disables the limit")
+	bindFlag(rateLimitKey, rootCmd.Flags())
+
+	rootCmd.Flags().Int(flagName(rateLimitBurstKey), 20, "The requests a client IP can burst above the rate limit")
+	bindFlag(rateLimitBurstKey, rootCmd.Flags())
+
+	// the traces are propagated between the nodes with the W3C traceparent header
+	rootCmd.Flags().String(flagName(tracingExporterKey), "none", "Where the spans are exported: none, otlp, stdout or file")
+	bindFlag(tracingExporterKey, rootCmd.Flags())
+
+	rootCmd.Flags().String(flagName(tracingServiceNameKey), "gop2p", "The service name of the spans")
+	bindFlag(tracingServiceNameKey, rootCmd.Flags())
+
+	rootCmd.Flags().String(flagName(tracingEndpointKey), "", "The host:port of the OTLP/HTTP collector, the OTEL_EXPORTER_OTLP_* env vars are used if empty")
+	bindFlag(tracingEndpointKey, rootCmd.Flags())
+
+	rootCmd.Flags().Bool(flagName(tracingInsecureKey), false, "Call the OTLP collector with plain http")
+	bindFlag(tracingInsecureKey, rootCmd.Flags())
+
+	rootCmd.Flags().String(flagName(tracingFileKey), "", "The file receiving the spans with the file exporter")
+	bindFlag(tracingFileKey, rootCmd.Flags())
+
+	rootCmd.Flags().Float64(flagName(tracingSampleRatioKey), 1, "The part of the traces kept, between 0 and 1")
+	bindFlag(tracingSampleRatioKey, rootCmd.Flags())
+
+	rootCmd.Flags().String(flagName(logLevelKey), "info", "The log level: debug, info, warn or error")
+	bindFlag(logLevelKey, rootCmd.Flags())
+
+	rootCmd.Flags().String(flagName(logFormatKey), logging.FormatText, "The log format: text or json")
+	bindFlag(logFormatKey, rootCmd.Flags())
+
+	rootCmd.Flags().String(flagName(attachmentsDirKey), "", "Where the client keeps the attachments, a new temporary dir if empty")
+	bindFlag(attachmentsDirKey, rootCmd.Flags())
+
+	// the conversations can keep them longer or shorter, or make them disappear
+	rootCmd.Flags().Int(flagName(retentionDaysKey), 0, "The messages older than this many days are purged, 0 keeps them")
+	bindFlag(retentionDaysKey, rootCmd.Flags())
+
+	rootCmd.Flags().Int(flagName(retentionMessagesKey), 0, "Only the latest messages of each conversation are kept, 0 keeps them all")
+	bindFlag(retentionMessagesKey, rootCmd.Flags())
+
+	rootCmd.Flags().Duration(flagName(retentionSweepIntervalKey), time.Minute, "How often the messages past their retention are purged")
+	bindFlag(retentionSweepIntervalKey, rootCmd.Flags())
+
+	rootCmd.Flags().Duration(flagName(outboxIntervalKey), 30*time.Second, "How often the messages the peers couldn't store yet are sent again")
+	bindFlag(outboxIntervalKey, rootCmd.Flags())
+
+	// the history is unlocked with a passphrase through the front API, or at startup with a keyfile
+	rootCmd.Flags().String(flagName(encryptionKeyringKey), "", "Where the keys encrypting the history are kept, the history isn't encrypted if empty")
+	bindFlag(encryptionKeyringKey, rootCmd.Flags())
+
+	rootCmd.Flags().String(flagName(encryptionKeyfileKey), "", "The keyfile unlocking the history at startup, a passphrase is expected if empty")
+	bindFlag(encryptionKeyfileKey, rootCmd.Flags())
+}
+
+// flagName is the flag of a nested key, eg. --cluster_node_id for cluster.node_id
+func flagName(key string) string {
+	return strings.Replace(key, ".", "_", -1)
+}
 
-	rootCmd.Flags().String(serverAddressKey, "", "The address where the client can reach the central server")
-	_ = viper.BindPFlag(serverAddressKey, rootCmd.Flags().Lookup(serverAddressKey))
+func bindFlag(key string, flags *pflag.FlagSet) {
+	_ = viper.BindPFlag(key, flags.Lookup(flagName(key)))
 }
@@ -2,89 +2,267 @@ package cmd
 
 import (
 	"context"
-	"fmt"
+	"gop2p/domain"
+	encrypted "gop2p/driven/aes.conversationManager"
+	"gop2p/driven/dht.serverGateway"
+	blobstore "gop2p/driven/fs.blobStore"
 	"gop2p/driven/http.clientGateway"
 	"gop2p/driven/http.serverGateway"
 	"gop2p/driven/inMem.conversationManager"
 	"gop2p/driven/inMem.sessionManager"
+	"gop2p/driven/inMem.signalBroker"
 	"gop2p/driven/inMem.userStore"
-	"io"
+	clusterstore "gop2p/driven/raft.clusterStore"
+	"gop2p/logging"
+	"gop2p/tracing"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"gop2p/uc"
 
 	mux "gop2p/driving/api.mux"
+	"gop2p/metrics"
 	"log"
-
-	opentracing "github.com/opentracing/opentracing-go"
-	"github.com/uber/jaeger-lib/metrics"
-
-	jaegercfg "github.com/uber/jaeger-client-go/config"
-	jaegerlog "github.com/uber/jaeger-client-go/log"
+	"time"
 )
 
-func setTracer() (opentracing.Tracer, io.Closer) {
-	cfg, err := jaegercfg.FromEnv()
+func setTracer(c tracingConfig) func(context.Context) error {
+	shutdown, err := tracing.Setup(tracing.Config{
+		Exporter:    c.Exporter,
+		ServiceName: c.ServiceName,
+		Endpoint:    c.Endpoint,
+		Insecure:    c.Insecure,
+		File:        c.File,
+		SampleRatio: c.SampleRatio,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
+	return shutdown
+}
 
-	// Initialize tracer with a logger and a metrics factory
-	tracer, closer, err := cfg.NewTracer(
-		jaegercfg.Logger(jaegerlog.StdLogger),
-		jaegercfg.Metrics(metrics.NullFactory),
-	)
+// setUp does what every mode needs before starting the routers
+func setUp(c config, mode string) (mux.Transport, runtime) {
+	rt := newRuntime(c)
+	logging.Info(context.Background(), "starting", "mode", mode)
+
+	shutdown := setTracer(c.Tracing)
+	go stopOnExit(rt, shutdown)
+
+	t, err := c.transport()
 	if err != nil {
 		log.Fatal(err)
 	}
-	return tracer, closer
+
+	go rt.reloadOnSIGHUP(c)
+	return t, rt
 }
 
-func startInClientMode(apiPort, p2pPort int, serverAddress string) {
-	fmt.Println("== RUNNING IN CLIENT MODE ==")
+// the routers never return, so what runs in background is stopped and the spans not exported yet are flushed
+// when the process is asked to stop
+func stopOnExit(rt runtime, shutdown func(context.Context) error) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+
+	rt.stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		logging.Warn(ctx, "spans not flushed", "err", err)
+	}
+	os.Exit(0)
+}
+
+func startInClientMode(c config) {
+
+	t, rt := setUp(c, "client")
+
+	startClient(c, t, rt, &url.URL{Host: c.ServerAddress}, servergateway.New(c.ServerAddress, t, domain.Supported()), nil)
+}
+
+func startInDHTClientMode(c config) {
+
+	t, rt := setUp(c, "dht client")
 
-	tracer, closer := setTracer()
-	opentracing.SetGlobalTracer(tracer)
-	defer closer.Close()
+	// the DHT node is both the way we find other clients and a part of the directory,
+	// so it also answers the other nodes on the p2p router
+	keyFile, err := c.dhtKeyFile()
+	if err != nil {
+		log.Fatal(err)
+	}
+	key, err := dhtgateway.LoadKey(keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dht := dhtgateway.New(c.P2PAddress, c.DHT.Bootstrap, t, key)
+	rt.onExit(dht.Close)
+	startClient(c, t, rt, nil, dht, dht)
+}
 
+func startClient(c config, t mux.Transport, rt runtime, serverAddress *url.URL, sg uc.ServerGateway, directory http.Handler) {
 	// in client mode we have 2 servers running :
-	cm := conversationmanager.New()
+	cm := newConversationManager(c.Encryption)
+	bs := newBlobStore(c.Attachments)
+	sb := signalbroker.New()
+	account := uc.NewAccount(c.device())
+
+	sweeper := uc.NewRetentionSweeper(cm, domain.Retention{Days: c.Retention.Days, Messages: c.Retention.Messages})
+	go sweeper.Run(context.Background(), c.Retention.SweepInterval)
+
+	front := uc.NewClientFrontLogic(cm, sg, clientgateway.New(t, domain.Supported()), bs, sb, account)
+	go uc.FlushOutboxEvery(context.Background(), front, c.Outbox.Interval)
 
 	go func(cm uc.ConversationManager) {
 		// handles client's frontend traffic
 		mux.NewClientFrontRouter(
-			uc.NewClientFrontLogic(
-				cm,
-				servergateway.New(serverAddress),
-				clientgateway.New(),
-			),
-			apiPort,
-			serverAddress,
+			mux.ClientFrontRouter{
+				Logic:         front,
+				ServerAddress: serverAddress,
+				Transport:     t,
+				Capabilities:  domain.Supported(),
+				Device:        account.Device,
+			},
+			c.listenOptions(c.APIPort, rt.limiter),
 		)
 	}(cm)
 
+	var senders uc.SenderVerifier
+	if c.VerifySenders {
+		senders = uc.NewSenderVerifier(sg, account)
+	}
+
 	// handles p2p traffic
-	mux.NewClientP2pRouter(uc.NewClientP2pLogic(cm), p2pPort)
+	mux.NewClientP2pRouter(
+		mux.ClientP2pRouter{
+			Logic:        uc.NewClientP2pLogic(cm, bs, sb, account),
+			Capabilities: domain.Supported(),
+			Directory:    directory,
+			Senders:      senders,
+			MaxBodySize:  c.MaxBodySize,
+		},
+		c.listenOptions(c.P2PPort, rt.limiter),
+	)
 }
 
-func startInServerMode(apiPort int) {
-	fmt.Println("== RUNNING IN SERVER MODE ==")
+// newConversationManager encrypts the history when a keyring is configured, it's unlocked right away with a keyfile
+func newConversationManager(c encryptionConfig) uc.ConversationManager {
+	cm := conversationmanager.New()
+	if c.Keyring == "" {
+		return cm
+	}
 
-	tracer, closer := setTracer()
-	opentracing.SetGlobalTracer(tracer)
-	defer closer.Close()
+	if c.Keyfile == "" {
+		logging.Info(context.Background(), "history encrypted, locked until the passphrase is given", "keyring", c.Keyring)
+		return encrypted.New(cm, c.Keyring, encrypted.KDFArgon2id)
+	}
+
+	s := encrypted.New(cm, c.Keyring, encrypted.KDFKeyfile)
+	secret, err := encrypted.ReadKeyfile(c.Keyfile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	valid, ok := s.Unlock(context.Background(), secret)
+	if !ok {
+		log.Fatal("can't open the keyring ", c.Keyring)
+	}
+	if !valid {
+		log.Fatal(c.Keyfile, " doesn't open the keyring ", c.Keyring)
+	}
+	logging.Info(context.Background(), "history encrypted, unlocked with the keyfile", "keyring", c.Keyring)
+	return s
+}
+
+func newBlobStore(c attachmentsConfig) uc.BlobStore {
+	dir := c.Dir
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "gop2p-attachments-")
+		if err != nil {
+			log.Fatal(err)
+		}
+		dir = tmp
+	}
+
+	bs, err := blobstore.New(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logging.Info(context.Background(), "attachments stored", "dir", dir)
+	return bs
+}
+
+func startInServerMode(c config) {
+
+	_, rt := setUp(c, "server")
 
 	us := userstore.New()
-	ctx := context.Background()
+	sm := sessionmanager.New()
+	addTestUsers(us)
+	registerActiveSessions(sm)
 
-	// we just add 2 users for testing
-	us.InsertUser(ctx, "alice", "pass")
-	us.InsertUser(ctx, "bob", "pass")
+	mux.NewServerRouter(
+		mux.ServerRouter{
+			Logic:      uc.NewServerLogic(us, sm),
+			Admin:      uc.NewAdminLogic(us, sm),
+			AdminToken: c.AdminToken,
+		},
+		c.listenOptions(c.APIPort, rt.limiter),
+	)
+}
+
+func startInClusteredServerMode(c config) {
+
+	t, rt := setUp(c, "clustered server")
+
+	// already validated with the config
+	peers, _ := c.clusterPeers()
+
+	// the same store holds both users and sessions, any node can answer since they're replicated
+	cs, err := clusterstore.New(clusterstore.Config{
+		NodeID:    c.Cluster.NodeID,
+		Peers:     peers,
+		DataDir:   c.Cluster.DataDir,
+		Secret:    c.Cluster.Secret,
+		Transport: t,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	registerActiveSessions(cs)
+
+	// writes need an elected leader with its router up, so we retry until the cluster is ready
+	go func() {
+		for !addTestUsers(cs) {
+			time.Sleep(time.Second)
+		}
+	}()
 
 	mux.NewServerRouter(
-		uc.NewServerLogic(
-			us,
-			sessionmanager.New(),
-		),
-		apiPort,
+		mux.ServerRouter{
+			Logic:      uc.NewServerLogic(cs, cs),
+			Admin:      uc.NewAdminLogic(cs, cs),
+			AdminToken: c.AdminToken,
+			Cluster:    cs,
+		},
+		c.listenOptions(c.APIPort, rt.limiter),
 	)
 }
+
+func registerActiveSessions(sm uc.SessionManager) {
+	metrics.RegisterActiveSessions(func() int {
+		sessions, _ := sm.ListSessions(context.Background())
+		return len(sessions)
+	})
+}
+
+// we just add 2 users for testing
+func addTestUsers(us uc.UserStore) bool {
+	ctx := context.Background()
+	return us.InsertUser(ctx, "alice", "pass") &&
+		us.InsertUser(ctx, "bob", "pass")
+}
@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// the limits of the attachments
+const (
+	// MaxAttachmentSize is the size of the biggest file which can be attached, in bytes
+	MaxAttachmentSize = 16 << 20
+	// MaxAttachments is the most files a message can have
+	MaxAttachments = 10
+	// ChunkSize is the most a peer sends at once when transferring an attachment
+	ChunkSize = 256 << 10
+)
+
+// Attachment is a file sent with a message, its content is found by hash in the blob store of each client
+type Attachment struct {
+	// Hash is the hex encoded sha256 of the content, see ContentHash
+	Hash string `json:"hash"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// Blob is what a blob store has of an attachment, it's complete once all of it is written and matches the hash
+type Blob struct {
+	Size     int64
+	Complete bool
+}
+
+// ContentHash is the hash of the content of an attachment
+func ContentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidHash tells if h could be the hash of some content
+func ValidHash(h string) bool {
+	if len(h) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(h)
+	return err == nil
+}
+
+// Valid tells if an attachment received from a peer can be accepted
+func (a Attachment) Valid() bool {
+	return ValidHash(a.Hash) && a.Name != "" && a.Size > 0 && a.Size <= MaxAttachmentSize
+}
@@ -0,0 +1,111 @@
+package domain
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+// the size of the pages of messages
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// Conversation sums up a conversation with another user
+type Conversation struct {
+	With        string  `json:"with"`
+	LastMessage Message `json:"last_message"`
+	// Unread is the number of messages of the other user not read yet
+	Unread int `json:"unread"`
+}
+
+// History is a whole conversation, as synced between the devices of a user
+type History struct {
+	With     string    `json:"with"`
+	Messages []Message `json:"messages"`
+}
+
+// ExportedMessage is a line of an export of the history, the ID of the message only means something on the client
+// it was exported from, the ref is what tells the messages apart everywhere
+type ExportedMessage struct {
+	With    string  `json:"with"`
+	Message Message `json:"message"`
+}
+
+// Page selects the messages of a conversation right before or after a message ID,
+// the latest ones when both are 0. The messages are always sorted oldest first, there's no limit if it's 0
+type Page struct {
+	Before int64
+	After  int64
+	Limit  int
+}
+
+// SearchResult is a message found in the history
+type SearchResult struct {
+	With    string  `json:"with"`
+	Message Message `json:"message"`
+}
+
+// Words are the lower cased sequences of letters and digits of a text, without duplicates: a search matches the
+// messages holding all the words of the query
+func Words(text string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if !seen[w] {
+			seen[w] = true
+			unique = append(unique, w)
+		}
+	}
+	return unique
+}
+
+// Encryption tells if the history is encrypted at rest, and if so whether it's locked
+type Encryption struct {
+	Encrypted bool `json:"encrypted"`
+	Locked    bool `json:"locked"`
+}
+
+// Retention is how long the messages of a conversation are kept, nothing is purged while it's all zero
+type Retention struct {
+	// Days purges the messages older than this many days
+	Days int `json:"days,omitempty"`
+	// Messages keeps only the latest messages
+	Messages int `json:"messages,omitempty"`
+	// TTL is agreed by both users, their messages disappear after this many seconds
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+// Over returns the retention of a conversation, its own settings win over the default ones
+func (r Retention) Over(defaults Retention) Retention {
+	if r.Days == 0 {
+		r.Days = defaults.Days
+	}
+	if r.Messages == 0 {
+		r.Messages = defaults.Messages
+	}
+	if r.TTL == 0 {
+		r.TTL = defaults.TTL
+	}
+	return r
+}
+
+// Cutoff is the date before which the messages are purged, it's zero if they're kept whatever their age
+func (r Retention) Cutoff(now time.Time) time.Time {
+	cutoff := time.Time{}
+	if r.Days > 0 {
+		cutoff = now.AddDate(0, 0, -r.Days)
+	}
+	if ttl := now.Add(-time.Duration(r.TTL) * time.Second); r.TTL > 0 && ttl.After(cutoff) {
+		cutoff = ttl
+	}
+	return cutoff
+}
+
+// Valid tells if none of the settings is negative
+func (r Retention) Valid() bool {
+	return r.Days >= 0 && r.Messages >= 0 && r.TTL >= 0
+}
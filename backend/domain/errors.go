@@ -26,6 +26,17 @@ type ErrUnauthorized struct{}
 
 func (ErrUnauthorized) Error() string { return "you're not allowed to perform this action" }
 
+// ErrLocked is used when the history is encrypted and locked
+type ErrLocked struct{}
+
+func (ErrLocked) Error() string { return "the history is locked" }
+
+// ErrUnavailable is used when the call can't be served for now but can be retried later, eg. by a peer whose
+// history is locked
+type ErrUnavailable struct{}
+
+func (ErrUnavailable) Error() string { return "unavailable for now, retry later" }
+
 // ErrMalformed is used when invalid params are provided to usecases
 type ErrMalformed struct {
 	Details []string
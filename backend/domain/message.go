@@ -1,7 +1,78 @@
 package domain
 
+import "time"
+
 // Message is the struct for conversations
 type Message struct {
+	// ID is assigned by the conversation manager when the message is stored, it increases across all the conversations
+	ID int64
+	// Ref is assigned by the client of the author, it's how the peers refer to the message
+	Ref     string
 	Author  string
 	Content string
+	// Date is when the message was stored by this client, the retention counts from it. It's zero for the messages
+	// stored by the previous versions, which are only purged by count
+	Date time.Time
+
+	// Edits is the number of times the author edited the message
+	Edits int
+	// Deleted messages are kept as tombstones, without content nor reactions
+	Deleted bool
+	// Reactions are the logins of the users who reacted by emoji
+	Reactions map[string][]string `json:",omitempty"`
+	// Attachments are downloaded from the author when they're first read
+	Attachments []Attachment `json:",omitempty"`
+
+	// Status of the delivery of a message of the current user, it's empty for the messages received and the ones
+	// stored by the previous versions
+	Status string `json:",omitempty"`
+}
+
+// the statuses of the messages sent
+const (
+	// MessagePending is stored before being sent, it stays so if the client stops while sending it
+	MessagePending = "pending"
+	// MessageDelivered was received by at least one device of the other user
+	MessageDelivered = "delivered"
+	// MessageFailed wasn't received by any device of the other user
+	MessageFailed = "failed"
+)
+
+// DedupeWindow is how long the ref of a purged message is remembered, so that a redelivery isn't stored again.
+// It's longer than any sender retries
+const DedupeWindow = 24 * time.Hour
+
+// the types of the events sent between the peers
+const (
+	EventMessage  = "message"
+	EventEdit     = "edit"
+	EventDelete   = "delete"
+	EventReaction = "reaction"
+	// EventRetention sets the TTL of the messages of the conversation, for both users
+	EventRetention = "retention"
+)
+
+// Event is what a client sends to a peer : a new message or an operation on one of the conversation,
+// applying it several times has the same effect as applying it once
+type Event struct {
+	Type string
+	// Ref is the message created or the one the operation applies to
+	Ref string
+
+	// Content of a new or edited message
+	Content string
+	// Attachments of a new message
+	Attachments []Attachment
+	// Edits is the version of an edited message, an edit is only applied over an older version
+	Edits int
+
+	// Emoji of a reaction, removed when Removed is set
+	Emoji   string
+	Removed bool
+
+	// TTL of a retention event, in seconds, 0 stops the messages from disappearing
+	TTL int64
+
+	// With is only set when the event is synced to the other devices of its author, it's the conversation
+	With string
 }
@@ -0,0 +1,95 @@
+package domain
+
+// the versions of the protocol spoken between the nodes
+const (
+	// ProtocolLegacy is spoken on the unversioned routes by the nodes which don't advertise any capability
+	ProtocolLegacy = 0
+	// ProtocolV1 is spoken on the /v1/ routes
+	ProtocolV1 = 1
+)
+
+// the optional features of the protocol
+const (
+	// FeatureGzip is advertised by the peers accepting gzip encoded request bodies
+	FeatureGzip = "gzip"
+	// FeatureEvents is advertised by the peers accepting the operations on the messages (see Event)
+	FeatureEvents = "events"
+	// FeatureAttachments is advertised by the peers sending and receiving attachments
+	FeatureAttachments = "attachments"
+	// FeatureSignals is advertised by the peers receiving the signals (see Signal)
+	FeatureSignals = "signals"
+	// FeatureDevices is advertised by the peers syncing the conversations between the devices of their user
+	FeatureDevices = "devices"
+	// FeatureRetention is advertised by the peers agreeing on the TTL of the messages (see EventRetention)
+	FeatureRetention = "retention"
+)
+
+// Capabilities are advertised by a client with its session so that its peers know how to talk to it
+type Capabilities struct {
+	Versions []int    `json:"versions,omitempty"`
+	Features []string `json:"features,omitempty"`
+}
+
+// Supported are the capabilities of this version
+func Supported() Capabilities {
+	return Capabilities{
+		Versions: []int{ProtocolV1, ProtocolLegacy},
+		Features: []string{FeatureGzip, FeatureEvents, FeatureAttachments, FeatureSignals, FeatureDevices, FeatureRetention},
+	}
+}
+
+// Unknown is true when nothing was advertised, it's the case of the nodes of previous versions
+func (c Capabilities) Unknown() bool {
+	return len(c.Versions) == 0
+}
+
+// Has tells if the feature is advertised
+func (c Capabilities) Has(feature string) bool {
+	for _, f := range c.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Encoding is how a message is sent to a peer: the protocol version and the features both sides support
+type Encoding struct {
+	Version  int
+	Features []string
+}
+
+// Has tells if the feature can be used
+func (e Encoding) Has(feature string) bool {
+	return Capabilities{Features: e.Features}.Has(feature)
+}
+
+// Negotiate picks the highest version spoken by both sides, a peer which advertises nothing only speaks the legacy
+// protocol. It's false if there's no common version
+func (c Capabilities) Negotiate(peer Capabilities) (Encoding, bool) {
+	if peer.Unknown() {
+		peer = Capabilities{Versions: []int{ProtocolLegacy}}
+	}
+
+	e := Encoding{Version: -1}
+	for _, v := range c.Versions {
+		for _, pv := range peer.Versions {
+			if v == pv && v > e.Version {
+				e.Version = v
+			}
+		}
+	}
+	if e.Version < 0 {
+		return Encoding{}, false
+	}
+
+	// the legacy protocol has no features
+	if e.Version > ProtocolLegacy {
+		for _, f := range c.Features {
+			if peer.Has(f) {
+				e.Features = append(e.Features, f)
+			}
+		}
+	}
+	return e, true
+}
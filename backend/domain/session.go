@@ -1,8 +1,31 @@
 package domain
 
 // Session is used to know if a client is online
-// and the address he can be reached at
+// and the address it can be reached at
 type Session struct {
 	Online  bool   `json:"online"`
 	Address string `json:"address"`
+
+	// Device tells apart the clients of a user, it's empty for the clients of previous versions
+	Device string `json:"device,omitempty"`
+
+	// PublicKey and ExpiresAt (unix seconds) are only set by decentralized directories
+	// where the client signs its own session record
+	PublicKey []byte `json:"public_key,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+
+	// Capabilities are empty when the client didn't advertise any (eg. a client of a previous version)
+	Capabilities Capabilities `json:"capabilities"`
+}
+
+// WithDevice returns the sessions of a user once s replaced the one of the same device, or the one at the same
+// address (ie. a client restarted as another device). The sessions are kept in the order they started, latest last
+func WithDevice(sessions []Session, s Session) []Session {
+	devices := make([]Session, 0, len(sessions)+1)
+	for _, known := range sessions {
+		if known.Device != s.Device && known.Address != s.Address {
+			devices = append(devices, known)
+		}
+	}
+	return append(devices, s)
 }
@@ -0,0 +1,30 @@
+package domain
+
+import "time"
+
+// the types of the signals
+const (
+	SignalTyping = "typing"
+	SignalSeen   = "seen"
+	// SignalUpdated isn't sent by the peers, it tells the front end that a peer changed the conversation
+	SignalUpdated = "updated"
+)
+
+// the limits of the signals
+const (
+	// SignalRate is how many signals per second a peer can send, with bursts of SignalBurst
+	SignalRate  = 2
+	SignalBurst = 5
+	// TypingInterval is the least time between two typing signals sent to a peer,
+	// so the front end can tell it on every key stroke
+	TypingInterval = 2 * time.Second
+)
+
+// Signal is an ephemeral event between the peers, it's not stored and it's lost if no front end listens
+type Signal struct {
+	Type string `json:"type"`
+	// With is the other user of the conversation
+	With string `json:"with"`
+	// Ref is the last message seen or the message updated
+	Ref string `json:"ref,omitempty"`
+}
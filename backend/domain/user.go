@@ -4,4 +4,5 @@ package domain
 type User struct {
 	Login    string
 	Password string
+	Disabled bool
 }
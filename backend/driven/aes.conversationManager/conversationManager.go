@@ -0,0 +1,566 @@
+// Package encrypted decorates a conversation manager so that the content of the messages and the names of their
+// attachments are only stored encrypted (AES-GCM), whatever the backend. Who talks to whom, when, and the reactions
+// are left readable, they're what the backend looks up.
+//
+// The messages are encrypted with data keys, themselves encrypted with a key derived from a passphrase (argon2id)
+// or read from a keyfile, and kept in a keyring file. Changing the secret only re-encrypts the data keys, rotating
+// the key makes the next messages use a new data key while the previous ones stay readable.
+// The history can't be read nor written while it's locked, until the secret is given again
+package encrypted
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gop2p/domain"
+	"gop2p/tracing"
+	"gop2p/uc"
+)
+
+// prefix of the encrypted strings, followed by the ID of the data key, ":" and the base64 of the nonce and ciphertext.
+// The strings without it were stored before the history was encrypted and are read as is
+const prefix = "gop2p-enc:"
+
+// Store is a uc.ConversationManager and a uc.Vault
+type Store struct {
+	next uc.ConversationManager
+	path string
+	kdf  string
+
+	mu sync.RWMutex
+	// keys is nil while locked
+	keys *keyring
+}
+
+// New decorates next with the keyring found at path, it's created with the kdf when first unlocked.
+// It's locked until then
+func New(next uc.ConversationManager, path, kdf string) *Store {
+	return &Store{next: next, path: path, kdf: kdf}
+}
+
+func (s *Store) Locked() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.keys == nil
+}
+
+// Unlock opens the keyring with the secret, the first unlock creates it
+func (s *Store) Unlock(ctx context.Context, secret string) (bool, bool) {
+	span, _ := tracing.Start(ctx, "encrypted_conversations:unlock")
+	defer span.End()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := readKeyring(s.path)
+	if err != nil {
+		span.Error(err)
+		return false, false
+	}
+
+	var kek []byte
+	if f == nil {
+		if f, kek, err = newKeyringFile(s.kdf, secret); err == nil {
+			err = f.write(s.path)
+		}
+	} else {
+		kek, err = f.derive(secret)
+	}
+	if err != nil {
+		return failed(span, err)
+	}
+
+	keys, err := f.open(kek)
+	if err != nil {
+		return failed(span, err)
+	}
+	s.keys = keys
+	return true, true
+}
+
+func (s *Store) Lock(ctx context.Context) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = nil
+	return true
+}
+
+// ChangeSecret wraps the data keys with a new secret, once the current one checked. The history is then unlocked
+func (s *Store) ChangeSecret(ctx context.Context, current, secret string) (bool, bool) {
+	span, _ := tracing.Start(ctx, "encrypted_conversations:change_secret")
+	defer span.End()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := readKeyring(s.path)
+	if err != nil {
+		span.Error(err)
+		return false, false
+	}
+	if f == nil {
+		span.Error(errors.New("there's no keyring yet, it's created when first unlocked"))
+		return false, true
+	}
+
+	kek, err := f.derive(current)
+	if err != nil {
+		return failed(span, err)
+	}
+	if _, err := f.open(kek); err != nil {
+		return failed(span, err)
+	}
+
+	next, nextKEK, err := f.rewrap(kek, secret)
+	if err == nil {
+		err = next.write(s.path)
+	}
+	if err != nil {
+		return failed(span, err)
+	}
+
+	keys, err := next.open(nextKEK)
+	if err != nil {
+		return failed(span, err)
+	}
+	s.keys = keys
+	return true, true
+}
+
+// RotateKey encrypts the next messages with a new data key, it fails while locked
+func (s *Store) RotateKey(ctx context.Context) bool {
+	span, _ := tracing.Start(ctx, "encrypted_conversations:rotate_key")
+	defer span.End()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.keys == nil {
+		span.Error(errors.New("the history is locked"))
+		return false
+	}
+
+	f := s.keys.file
+	f.Keys = append([]wrappedKey{}, f.Keys...)
+	if err := f.addKey(s.keys.kek); err != nil {
+		span.Error(err)
+		return false
+	}
+	if err := f.write(s.path); err != nil {
+		span.Error(err)
+		return false
+	}
+
+	keys, err := f.open(s.keys.kek)
+	if err != nil {
+		span.Error(err)
+		return false
+	}
+	s.keys = keys
+	span.SetAttribute("key_id", f.Current)
+	return true
+}
+
+// failed tells an invalid secret (valid is false) from a technical failure (ok is false)
+func failed(span tracing.Span, err error) (bool, bool) {
+	span.Error(err)
+	if errors.Is(err, ErrInvalidSecret) {
+		return false, true
+	}
+	return false, false
+}
+
+// unlocked returns the keys, nil while locked
+func (s *Store) unlocked(span tracing.Span) *keyring {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.keys == nil {
+		span.Error(errors.New("the history is locked"))
+	}
+	return s.keys
+}
+
+func (s *Store) GetConversationWith(ctx context.Context, with string, page domain.Page) ([]domain.Message, bool) {
+	span, ctx := tracing.Start(ctx, "encrypted_conversations:get_conversation_with")
+	defer span.End()
+
+	keys := s.unlocked(span)
+	if keys == nil {
+		return nil, false
+	}
+
+	messages, ok := s.next.GetConversationWith(ctx, with, page)
+	if !ok {
+		return nil, false
+	}
+	return keys.decryptAll(span, with, messages)
+}
+
+func (s *Store) AppendToConversationWith(ctx context.Context, with string, msg domain.Message) bool {
+	span, ctx := tracing.Start(ctx, "encrypted_conversations:append_to_conversation")
+	defer span.End()
+
+	keys := s.unlocked(span)
+	if keys == nil {
+		return false
+	}
+
+	encrypted, err := keys.encryptMessage(with, msg)
+	if err != nil {
+		span.Error(err)
+		return false
+	}
+	return s.next.AppendToConversationWith(ctx, with, encrypted)
+}
+
+// AppendOnce encrypts the message first, the backend dedupes it by ref
+func (s *Store) AppendOnce(ctx context.Context, with string, msg domain.Message) (bool, bool) {
+	span, ctx := tracing.Start(ctx, "encrypted_conversations:append_once")
+	defer span.End()
+
+	keys := s.unlocked(span)
+	if keys == nil {
+		return false, false
+	}
+
+	encrypted, err := keys.encryptMessage(with, msg)
+	if err != nil {
+		span.Error(err)
+		return false, false
+	}
+	return s.next.AppendOnce(ctx, with, encrypted)
+}
+
+// MergeOnce encrypts the messages first, the backend dedupes them by ref
+func (s *Store) MergeOnce(ctx context.Context, with string, msgs []domain.Message) (int, bool) {
+	span, ctx := tracing.Start(ctx, "encrypted_conversations:merge_once")
+	defer span.End()
+
+	keys := s.unlocked(span)
+	if keys == nil {
+		return 0, false
+	}
+
+	encrypted := make([]domain.Message, 0, len(msgs))
+	for _, m := range msgs {
+		e, err := keys.encryptMessage(with, m)
+		if err != nil {
+			span.Error(err)
+			return 0, false
+		}
+		encrypted = append(encrypted, e)
+	}
+	return s.next.MergeOnce(ctx, with, encrypted)
+}
+
+func (s *Store) ListConversations(ctx context.Context) ([]domain.Conversation, bool) {
+	span, ctx := tracing.Start(ctx, "encrypted_conversations:list_conversations")
+	defer span.End()
+
+	keys := s.unlocked(span)
+	if keys == nil {
+		return nil, false
+	}
+
+	conversations, ok := s.next.ListConversations(ctx)
+	if !ok {
+		return nil, false
+	}
+	for i, c := range conversations {
+		m, err := keys.decryptMessage(c.With, c.LastMessage)
+		if err != nil {
+			span.Error(err)
+			return nil, false
+		}
+		conversations[i].LastMessage = m
+	}
+	return conversations, true
+}
+
+// MarkConversationRead doesn't need the keys, but the history can't be written while locked
+func (s *Store) MarkConversationRead(ctx context.Context, with string, upTo int64) bool {
+	span, ctx := tracing.Start(ctx, "encrypted_conversations:mark_conversation_read")
+	defer span.End()
+
+	if s.unlocked(span) == nil {
+		return false
+	}
+	return s.next.MarkConversationRead(ctx, with, upTo)
+}
+
+// SearchMessages can't use the index of the backend, which only knows the ciphertexts: every conversation is read
+// and decrypted
+func (s *Store) SearchMessages(ctx context.Context, query string, limit int) ([]domain.SearchResult, bool) {
+	span, ctx := tracing.Start(ctx, "encrypted_conversations:search_messages")
+	defer span.End()
+
+	keys := s.unlocked(span)
+	if keys == nil {
+		return nil, false
+	}
+
+	results := []domain.SearchResult{}
+	terms := domain.Words(query)
+	if len(terms) == 0 {
+		return results, true
+	}
+
+	conversations, ok := s.next.ListConversations(ctx)
+	if !ok {
+		return nil, false
+	}
+	for _, c := range conversations {
+		messages, ok := s.GetConversationWith(ctx, c.With, domain.Page{})
+		if !ok {
+			return nil, false
+		}
+
+	candidates:
+		for _, m := range messages {
+			found := map[string]bool{}
+			for _, w := range domain.Words(m.Content) {
+				found[w] = true
+			}
+			for _, t := range terms {
+				if !found[t] {
+					continue candidates
+				}
+			}
+			results = append(results, domain.SearchResult{With: c.With, Message: m})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Message.ID > results[j].Message.ID })
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, true
+}
+
+func (s *Store) GetMessage(ctx context.Context, with, ref string) (*domain.Message, bool) {
+	span, ctx := tracing.Start(ctx, "encrypted_conversations:get_message")
+	defer span.End()
+
+	keys := s.unlocked(span)
+	if keys == nil {
+		return nil, false
+	}
+
+	m, ok := s.next.GetMessage(ctx, with, ref)
+	if !ok || m == nil {
+		return m, ok
+	}
+	decrypted, err := keys.decryptMessage(with, *m)
+	if err != nil {
+		span.Error(err)
+		return nil, false
+	}
+	return &decrypted, true
+}
+
+func (s *Store) EditMessage(ctx context.Context, with, ref, content string, edits int) bool {
+	span, ctx := tracing.Start(ctx, "encrypted_conversations:edit_message")
+	defer span.End()
+
+	keys := s.unlocked(span)
+	if keys == nil {
+		return false
+	}
+
+	encrypted, err := keys.encrypt(content, with, ref)
+	if err != nil {
+		span.Error(err)
+		return false
+	}
+	return s.next.EditMessage(ctx, with, ref, encrypted, edits)
+}
+
+// DeleteMessage doesn't need the keys, but the history can't be written while locked
+func (s *Store) DeleteMessage(ctx context.Context, with, ref string) bool {
+	span, ctx := tracing.Start(ctx, "encrypted_conversations:delete_message")
+	defer span.End()
+
+	if s.unlocked(span) == nil {
+		return false
+	}
+	return s.next.DeleteMessage(ctx, with, ref)
+}
+
+// SetReaction doesn't need the keys, but the history can't be written while locked
+func (s *Store) SetReaction(ctx context.Context, with, ref, by, emoji string, on bool) bool {
+	span, ctx := tracing.Start(ctx, "encrypted_conversations:set_reaction")
+	defer span.End()
+
+	if s.unlocked(span) == nil {
+		return false
+	}
+	return s.next.SetReaction(ctx, with, ref, by, emoji, on)
+}
+
+// SetMessageStatus doesn't need the keys, but the history can't be written while locked
+func (s *Store) SetMessageStatus(ctx context.Context, with, ref, status string) bool {
+	span, ctx := tracing.Start(ctx, "encrypted_conversations:set_message_status")
+	defer span.End()
+
+	if s.unlocked(span) == nil {
+		return false
+	}
+	return s.next.SetMessageStatus(ctx, with, ref, status)
+}
+
+// GetRetention doesn't need the keys, but the history can't be read while locked
+func (s *Store) GetRetention(ctx context.Context, with string) (domain.Retention, bool) {
+	span, ctx := tracing.Start(ctx, "encrypted_conversations:get_retention")
+	defer span.End()
+
+	if s.unlocked(span) == nil {
+		return domain.Retention{}, false
+	}
+	return s.next.GetRetention(ctx, with)
+}
+
+// SetRetention doesn't need the keys, but the history can't be written while locked
+func (s *Store) SetRetention(ctx context.Context, with string, r domain.Retention) bool {
+	span, ctx := tracing.Start(ctx, "encrypted_conversations:set_retention")
+	defer span.End()
+
+	if s.unlocked(span) == nil {
+		return false
+	}
+	return s.next.SetRetention(ctx, with, r)
+}
+
+// PurgeMessages doesn't need the keys, the sweeper waits for the history to be unlocked anyway
+func (s *Store) PurgeMessages(ctx context.Context, with string, before time.Time, keep int) (int, bool) {
+	span, ctx := tracing.Start(ctx, "encrypted_conversations:purge_messages")
+	defer span.End()
+
+	if s.unlocked(span) == nil {
+		return 0, false
+	}
+	return s.next.PurgeMessages(ctx, with, before, keep)
+}
+
+// FindAttachment decrypts the name of the attachment, the backend finds it by hash
+func (s *Store) FindAttachment(ctx context.Context, with, hash string) (*domain.Attachment, bool) {
+	span, ctx := tracing.Start(ctx, "encrypted_conversations:find_attachment")
+	defer span.End()
+
+	keys := s.unlocked(span)
+	if keys == nil {
+		return nil, false
+	}
+
+	a, ok := s.next.FindAttachment(ctx, with, hash)
+	if !ok || a == nil {
+		return a, ok
+	}
+	name, err := keys.decryptName(*a, with)
+	if err != nil {
+		span.Error(err)
+		return nil, false
+	}
+	a.Name = name
+	return a, true
+}
+
+// encrypt binds the ciphertext to where it's stored (eg. the conversation and the ref of the message), so that it
+// can't be moved to another message
+func (k *keyring) encrypt(plaintext string, where ...string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	sealed, err := sealWith(k.aeads[k.current], []byte(plaintext), additionalData(where))
+	if err != nil {
+		return "", err
+	}
+	return prefix + k.current + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func (k *keyring) decrypt(s string, where ...string) (string, error) {
+	if !strings.HasPrefix(s, prefix) {
+		return s, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(s, prefix), ":", 2)
+	if len(parts) != 2 {
+		return "", errors.New("malformed ciphertext")
+	}
+	aead, ok := k.aeads[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("unknown key %q", parts[0])
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := unsealWith(aead, sealed, additionalData(where))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func additionalData(where []string) []byte {
+	return []byte(strings.Join(where, "\x00"))
+}
+
+// encryptMessage returns a copy, the attachments of msg aren't changed
+func (k *keyring) encryptMessage(with string, msg domain.Message) (domain.Message, error) {
+	var err error
+	if msg.Content, err = k.encrypt(msg.Content, with, msg.Ref); err != nil {
+		return domain.Message{}, err
+	}
+
+	attachments := make([]domain.Attachment, len(msg.Attachments))
+	for i, a := range msg.Attachments {
+		if a.Name, err = k.encrypt(a.Name, with, a.Hash); err != nil {
+			return domain.Message{}, err
+		}
+		attachments[i] = a
+	}
+	if msg.Attachments != nil {
+		msg.Attachments = attachments
+	}
+	return msg, nil
+}
+
+func (k *keyring) decryptMessage(with string, msg domain.Message) (domain.Message, error) {
+	var err error
+	if msg.Content, err = k.decrypt(msg.Content, with, msg.Ref); err != nil {
+		return domain.Message{}, err
+	}
+	for i, a := range msg.Attachments {
+		if msg.Attachments[i].Name, err = k.decryptName(a, with); err != nil {
+			return domain.Message{}, err
+		}
+	}
+	return msg, nil
+}
+
+func (k *keyring) decryptName(a domain.Attachment, with string) (string, error) {
+	return k.decrypt(a.Name, with, a.Hash)
+}
+
+func (k *keyring) decryptAll(span tracing.Span, with string, messages []domain.Message) ([]domain.Message, bool) {
+	for i, m := range messages {
+		decrypted, err := k.decryptMessage(with, m)
+		if err != nil {
+			span.Error(err)
+			return nil, false
+		}
+		messages[i] = decrypted
+	}
+	return messages, true
+}
@@ -0,0 +1,218 @@
+package encrypted_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gop2p/domain"
+	encrypted "gop2p/driven/aes.conversationManager"
+	conversationmanager "gop2p/driven/inMem.conversationManager"
+	"gop2p/uc"
+	"gop2p/uc/porttest"
+)
+
+// unlocked returns a store opened with a new keyfile
+func unlocked(t testing.TB, next uc.ConversationManager) *encrypted.Store {
+	dir := t.TempDir()
+	keyfile := filepath.Join(dir, "history.key")
+	if err := encrypted.NewKeyfile(keyfile); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := encrypted.ReadKeyfile(keyfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := encrypted.New(next, filepath.Join(dir, "keyring.json"), encrypted.KDFKeyfile)
+	if valid, ok := s.Unlock(context.Background(), secret); !valid || !ok {
+		t.Fatal("the keyfile doesn't open the keyring")
+	}
+	return s
+}
+
+func TestConversationManager(t *testing.T) {
+	porttest.RunConversationManagerSuite(t, func() uc.ConversationManager { return unlocked(t, conversationmanager.NewFailable()) })
+}
+
+func BenchmarkConversationManager(b *testing.B) {
+	porttest.RunConversationManagerBenchmarks(b, func() uc.ConversationManager { return unlocked(b, conversationmanager.New()) })
+}
+
+func TestEncryption(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("given a history encrypted with a keyfile", t, func() {
+		dir := t.TempDir()
+		keyfile, keyring := filepath.Join(dir, "history.key"), filepath.Join(dir, "keyring.json")
+		So(encrypted.NewKeyfile(keyfile), ShouldBeNil)
+		secret, err := encrypted.ReadKeyfile(keyfile)
+		So(err, ShouldBeNil)
+
+		backend := conversationmanager.New()
+		s := encrypted.New(backend, keyring, encrypted.KDFKeyfile)
+		So(s.Locked(), ShouldBeTrue)
+		valid, ok := s.Unlock(ctx, secret)
+		So(valid && ok, ShouldBeTrue)
+
+		msg := domain.Message{Ref: "a1", Author: "alice", Content: "meet me at noon",
+			Attachments: []domain.Attachment{{Hash: "h1", Name: "plan.pdf", Size: 3}}}
+		So(s.AppendToConversationWith(ctx, "alice", msg), ShouldBeTrue)
+
+		Convey("the keyfile and the keyring are only readable by their owner", func() {
+			for _, path := range []string{keyfile, keyring} {
+				info, err := os.Stat(path)
+				So(err, ShouldBeNil)
+				So(info.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+			}
+		})
+
+		Convey("the backend only stores the ciphertexts", func() {
+			stored, ok := backend.GetConversationWith(ctx, "alice", domain.Page{})
+			So(ok, ShouldBeTrue)
+			So(stored[0].Content, ShouldNotContainSubstring, "noon")
+			So(stored[0].Attachments[0].Name, ShouldNotContainSubstring, "plan")
+			So(stored[0].Attachments[0].Hash, ShouldEqual, "h1")
+
+			results, ok := backend.SearchMessages(ctx, "noon", 0)
+			So(ok, ShouldBeTrue)
+			So(results, ShouldBeEmpty)
+		})
+
+		Convey("the messages are decrypted when read", func() {
+			messages, ok := s.GetConversationWith(ctx, "alice", domain.Page{})
+			So(ok, ShouldBeTrue)
+			So(messages[0].Content, ShouldEqual, "meet me at noon")
+			So(messages[0].Attachments[0].Name, ShouldEqual, "plan.pdf")
+		})
+
+		Convey("a ciphertext moved to another message can't be read", func() {
+			stored, _ := backend.GetConversationWith(ctx, "alice", domain.Page{})
+			moved := stored[0]
+			moved.Ref = "a2"
+			So(backend.AppendToConversationWith(ctx, "alice", moved), ShouldBeTrue)
+
+			_, ok := s.GetConversationWith(ctx, "alice", domain.Page{})
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("the messages stored before the history was encrypted are read as is", func() {
+			So(backend.AppendToConversationWith(ctx, "bob", domain.Message{Ref: "b1", Author: "bob", Content: "plain"}), ShouldBeTrue)
+			messages, ok := s.GetConversationWith(ctx, "bob", domain.Page{})
+			So(ok, ShouldBeTrue)
+			So(messages[0].Content, ShouldEqual, "plain")
+		})
+
+		Convey("when locked, nothing can be read nor written", func() {
+			So(s.Lock(ctx), ShouldBeTrue)
+			_, ok := s.GetConversationWith(ctx, "alice", domain.Page{})
+			So(ok, ShouldBeFalse)
+			So(s.AppendToConversationWith(ctx, "alice", domain.Message{Ref: "a2", Author: "alice", Content: "hi"}), ShouldBeFalse)
+			So(s.RotateKey(ctx), ShouldBeFalse)
+
+			Convey("even what doesn't need the keys", func() {
+				So(s.MarkConversationRead(ctx, "alice", 1), ShouldBeFalse)
+				So(s.DeleteMessage(ctx, "alice", "a1"), ShouldBeFalse)
+				So(s.SetReaction(ctx, "alice", "a1", "me", "👍", true), ShouldBeFalse)
+				So(s.SetMessageStatus(ctx, "alice", "a1", domain.MessageDelivered), ShouldBeFalse)
+				_, ok := s.GetRetention(ctx, "alice")
+				So(ok, ShouldBeFalse)
+				So(s.SetRetention(ctx, "alice", domain.Retention{Days: 1}), ShouldBeFalse)
+				_, ok = s.PurgeMessages(ctx, "alice", time.Time{}, 1)
+				So(ok, ShouldBeFalse)
+
+				Convey("and the backend is left untouched", func() {
+					messages, ok := backend.GetConversationWith(ctx, "alice", domain.Page{})
+					So(ok, ShouldBeTrue)
+					So(messages, ShouldHaveLength, 1)
+					So(messages[0].Reactions, ShouldBeEmpty)
+				})
+			})
+		})
+
+		Convey("when restarted, another keyfile doesn't open it", func() {
+			other := filepath.Join(dir, "other.key")
+			So(encrypted.NewKeyfile(other), ShouldBeNil)
+			otherSecret, err := encrypted.ReadKeyfile(other)
+			So(err, ShouldBeNil)
+
+			restarted := encrypted.New(backend, keyring, encrypted.KDFKeyfile)
+			valid, ok := restarted.Unlock(ctx, otherSecret)
+			So(ok, ShouldBeTrue)
+			So(valid, ShouldBeFalse)
+			So(restarted.Locked(), ShouldBeTrue)
+
+			Convey("but its own keyfile does", func() {
+				valid, ok := restarted.Unlock(ctx, secret)
+				So(valid && ok, ShouldBeTrue)
+				messages, ok := restarted.GetConversationWith(ctx, "alice", domain.Page{})
+				So(ok, ShouldBeTrue)
+				So(messages[0].Content, ShouldEqual, "meet me at noon")
+			})
+		})
+
+		Convey("something else than a keyfile is an invalid secret", func() {
+			restarted := encrypted.New(backend, keyring, encrypted.KDFKeyfile)
+			valid, ok := restarted.Unlock(ctx, "passphrase")
+			So(ok, ShouldBeTrue)
+			So(valid, ShouldBeFalse)
+		})
+
+		Convey("when the key is rotated", func() {
+			So(s.RotateKey(ctx), ShouldBeTrue)
+			So(s.AppendToConversationWith(ctx, "alice", domain.Message{Ref: "a2", Author: "alice", Content: "or at one"}), ShouldBeTrue)
+
+			Convey("the next messages use the new key", func() {
+				stored, _ := backend.GetConversationWith(ctx, "alice", domain.Page{})
+				So(strings.Split(stored[0].Content, ":")[1], ShouldEqual, "1")
+				So(strings.Split(stored[1].Content, ":")[1], ShouldEqual, "2")
+			})
+
+			Convey("every message stays readable, even after a restart", func() {
+				restarted := encrypted.New(backend, keyring, encrypted.KDFKeyfile)
+				valid, ok := restarted.Unlock(ctx, secret)
+				So(valid && ok, ShouldBeTrue)
+
+				results, ok := restarted.SearchMessages(ctx, "at", 0)
+				So(ok, ShouldBeTrue)
+				So(results, ShouldHaveLength, 2)
+				So(results[0].Message.Content, ShouldEqual, "or at one")
+			})
+		})
+	})
+
+	Convey("given a history encrypted with a passphrase", t, func() {
+		keyring := filepath.Join(t.TempDir(), "keyring.json")
+		backend := conversationmanager.New()
+		s := encrypted.New(backend, keyring, encrypted.KDFArgon2id)
+		valid, ok := s.Unlock(ctx, "correct horse")
+		So(valid && ok, ShouldBeTrue)
+		So(s.AppendToConversationWith(ctx, "alice", domain.Message{Ref: "a1", Author: "alice", Content: "hi"}), ShouldBeTrue)
+
+		Convey("the passphrase can't be changed without the current one", func() {
+			valid, ok := s.ChangeSecret(ctx, "wrong", "battery staple")
+			So(ok, ShouldBeTrue)
+			So(valid, ShouldBeFalse)
+		})
+
+		Convey("when the passphrase is changed, only the new one opens it", func() {
+			valid, ok := s.ChangeSecret(ctx, "correct horse", "battery staple")
+			So(valid && ok, ShouldBeTrue)
+
+			restarted := encrypted.New(backend, keyring, encrypted.KDFArgon2id)
+			valid, ok = restarted.Unlock(ctx, "correct horse")
+			So(ok, ShouldBeTrue)
+			So(valid, ShouldBeFalse)
+
+			valid, ok = restarted.Unlock(ctx, "battery staple")
+			So(valid && ok, ShouldBeTrue)
+			messages, ok := restarted.GetConversationWith(ctx, "alice", domain.Page{})
+			So(ok, ShouldBeTrue)
+			So(messages[0].Content, ShouldEqual, "hi")
+		})
+	})
+}
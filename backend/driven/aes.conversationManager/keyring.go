@@ -0,0 +1,274 @@
+package encrypted
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// the ways the key wrapping the data keys is derived from the secret
+const (
+	// KDFArgon2id derives it from a passphrase
+	KDFArgon2id = "argon2id"
+	// KDFKeyfile reads it from the content of a keyfile, made by NewKeyfile
+	KDFKeyfile = "keyfile"
+)
+
+// the cost of argon2id, as recommended by its RFC for the interactive uses
+const (
+	argonTime    = 1
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+)
+
+const keySize = 32
+
+// ErrInvalidSecret is returned when the secret doesn't open the keyring
+var ErrInvalidSecret = errors.New("the secret doesn't open the keyring")
+
+// keyringFile is what is written on disk, the data keys are only kept wrapped
+type keyringFile struct {
+	KDF     string `json:"kdf"`
+	Salt    []byte `json:"salt,omitempty"`
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+
+	// Current is the ID of the key the messages are encrypted with, the other ones are only read
+	Current string       `json:"current"`
+	Keys    []wrappedKey `json:"keys"`
+}
+
+type wrappedKey struct {
+	ID string `json:"id"`
+	// Key is the nonce followed by the data key sealed with the key derived from the secret
+	Key []byte `json:"key"`
+}
+
+// keyring holds the data keys once unwrapped, it's nil while locked
+type keyring struct {
+	file    keyringFile
+	kek     []byte
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewKeyfile writes a new random key in a file only readable by its owner, it can be copied to another machine
+func NewKeyfile(path string) error {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600)
+}
+
+// ReadKeyfile returns the secret of a keyfile
+func ReadKeyfile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// readKeyring returns nil if there's no keyring yet
+func readKeyring(path string) (*keyringFile, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f := &keyringFile{}
+	if err := json.Unmarshal(b, f); err != nil {
+		return nil, fmt.Errorf("invalid keyring %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// write replaces the keyring at once, so that a crash never leaves it half written
+func (f keyringFile) write(path string) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// newKeyringFile has a first data key, wrapped with the key derived from the secret
+func newKeyringFile(kdf, secret string) (*keyringFile, []byte, error) {
+	f := &keyringFile{KDF: kdf}
+	if kdf == KDFArgon2id {
+		f.Salt = make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, f.Salt); err != nil {
+			return nil, nil, err
+		}
+		f.Time, f.Memory, f.Threads = argonTime, argonMemory, argonThreads
+	}
+
+	kek, err := f.derive(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := f.addKey(kek); err != nil {
+		return nil, nil, err
+	}
+	return f, kek, nil
+}
+
+// derive the key wrapping the data keys from the secret
+func (f keyringFile) derive(secret string) ([]byte, error) {
+	switch f.KDF {
+	case KDFArgon2id:
+		if secret == "" {
+			return nil, ErrInvalidSecret
+		}
+		return argon2.IDKey([]byte(secret), f.Salt, f.Time, f.Memory, f.Threads, keySize), nil
+	case KDFKeyfile:
+		key, err := hex.DecodeString(secret)
+		if err != nil || len(key) != keySize {
+			return nil, ErrInvalidSecret
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unknown kdf %q, expected %s or %s", f.KDF, KDFArgon2id, KDFKeyfile)
+	}
+}
+
+// addKey generates a new data key and makes it the current one
+func (f *keyringFile) addKey(kek []byte) error {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+
+	id := strconv.Itoa(len(f.Keys) + 1)
+	wrapped, err := seal(kek, key, []byte(id))
+	if err != nil {
+		return err
+	}
+	f.Keys = append(f.Keys, wrappedKey{ID: id, Key: wrapped})
+	f.Current = id
+	return nil
+}
+
+// open unwraps every data key, it fails with ErrInvalidSecret if kek isn't the one they're wrapped with
+func (f keyringFile) open(kek []byte) (*keyring, error) {
+	k := &keyring{file: f, kek: kek, current: f.Current, aeads: map[string]cipher.AEAD{}}
+	for _, w := range f.Keys {
+		key, err := unseal(kek, w.Key, []byte(w.ID))
+		if err != nil {
+			return nil, ErrInvalidSecret
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		k.aeads[w.ID] = aead
+	}
+
+	if _, ok := k.aeads[f.Current]; !ok {
+		return nil, fmt.Errorf("the current key %q isn't in the keyring", f.Current)
+	}
+	return k, nil
+}
+
+// rewrap wraps the data keys with the key derived from a new secret, a new salt is drawn for a passphrase
+func (f keyringFile) rewrap(oldKEK []byte, secret string) (*keyringFile, []byte, error) {
+	next := f
+	next.Keys = nil
+	if next.KDF == KDFArgon2id {
+		next.Salt = make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, next.Salt); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	kek, err := next.derive(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, w := range f.Keys {
+		key, err := unseal(oldKEK, w.Key, []byte(w.ID))
+		if err != nil {
+			return nil, nil, err
+		}
+		wrapped, err := seal(kek, key, []byte(w.ID))
+		if err != nil {
+			return nil, nil, err
+		}
+		next.Keys = append(next.Keys, wrappedKey{ID: w.ID, Key: wrapped})
+	}
+	return &next, kek, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns the nonce followed by the ciphertext
+func seal(key, plaintext, additional []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return sealWith(aead, plaintext, additional)
+}
+
+func unseal(key, sealed, additional []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return unsealWith(aead, sealed, additional)
+}
+
+func sealWith(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func unsealWith(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("the ciphertext is too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional)
+}
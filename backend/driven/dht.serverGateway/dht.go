@@ -0,0 +1,316 @@
+package dhtgateway
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gop2p/domain"
+	mux "gop2p/driving/api.mux"
+	"gop2p/tracing"
+	"gop2p/uc"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	republishInterval = recordTTL / 4
+	bootstrapRetry    = 5 * time.Second
+	rpcTimeout        = 3 * time.Second
+)
+
+// Directory is a kademlia-like session directory shared by the clients themselves,
+// it can replace the central server to find the other clients
+type Directory interface {
+	uc.ServerGateway
+	uc.SessionPublisher
+
+	// the directory rpcs are served on the p2p router under /dht/
+	http.Handler
+
+	// Close stops the bootstrap and the republishing of the session, the records expire on the other nodes
+	Close()
+}
+
+type node struct {
+	self      contact
+	sk        ed25519.PrivateKey
+	table     *routingTable
+	records   *recordStore
+	transport mux.Transport
+	mux       *http.ServeMux
+
+	mu        sync.Mutex
+	published *record
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// New is the constructor of the DHT directory, selfAddress is the p2p address where this client
+// can be reached by the other nodes and bootstrap the addresses of some nodes already in the network.
+// The key signs the session, it must be kept across restarts (see LoadKey): the nodes trust the first key
+// publishing a login until its record expires
+func New(selfAddress string, bootstrap []string, t mux.Transport, sk ed25519.PrivateKey) Directory {
+	self := contact{ID: newNodeID(sk.Public().(ed25519.PublicKey)), Address: selfAddress}
+	n := &node{
+		self:      self,
+		sk:        sk,
+		table:     newRoutingTable(self.ID),
+		records:   newRecordStore(),
+		transport: t,
+		stop:      make(chan struct{}),
+	}
+	n.mux = n.routes()
+
+	if len(bootstrap) > 0 {
+		go n.bootstrap(bootstrap)
+	}
+	return n
+}
+
+// LoadKey reads the key of the node from the keyfile, a new one is written there if it doesn't exist yet
+func LoadKey(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		_, sk, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		return sk, os.WriteFile(path, []byte(hex.EncodeToString(sk.Seed())+"\n"), 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid node key %s", path)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func (n *node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mux.ServeHTTP(w, r)
+}
+
+func (n *node) Close() {
+	n.stopOnce.Do(func() { close(n.stop) })
+}
+
+// bootstrap retries until one of the peers answers, then looks up its own id to fill the routing table
+func (n *node) bootstrap(peers []string) {
+	for {
+		span, ctx := tracing.Start(context.Background(), "dht:bootstrap")
+
+		joined := false
+		for _, p := range peers {
+			if p == n.self.Address {
+				continue
+			}
+			if _, err := n.call(ctx, p, rpcPing, rpcRequest{}); err == nil {
+				joined = true
+			}
+		}
+
+		if joined {
+			n.lookup(ctx, n.self.ID, "")
+			span.End()
+			return
+		}
+
+		span.Error(errors.New("no bootstrap peer answered"))
+		span.End()
+		select {
+		case <-n.stop:
+			return
+		case <-time.After(bootstrapRetry):
+		}
+	}
+}
+
+type lookupResult struct {
+	from contact
+	resp *rpcResponse
+	err  error
+}
+
+// lookup is the iterative kademlia lookup of the k closest nodes to target,
+// when a login is provided it stops as soon as one of the nodes returns a valid record for it
+func (n *node) lookup(ctx context.Context, target nodeID, login string) ([]contact, *record) {
+	name := rpcFindNode
+	if login != "" {
+		name = rpcFindValue
+	}
+
+	shortlist := n.table.closest(target, k)
+	queried := map[nodeID]bool{n.self.ID: true}
+
+	for {
+		batch := []contact{}
+		for _, c := range shortlist {
+			if !queried[c.ID] {
+				batch = append(batch, c)
+			}
+			if len(batch) == alpha {
+				break
+			}
+		}
+		if len(batch) == 0 {
+			return shortlist, nil
+		}
+
+		results := make(chan lookupResult, len(batch))
+		for _, c := range batch {
+			queried[c.ID] = true
+			go func(c contact) {
+				resp, err := n.call(ctx, c.Address, name, rpcRequest{Target: target, Login: login})
+				results <- lookupResult{from: c, resp: resp, err: err}
+			}(c)
+		}
+
+		failed := map[nodeID]bool{}
+		var found *record
+		for range batch {
+			res := <-results
+			if res.err != nil {
+				failed[res.from.ID] = true
+				continue
+			}
+
+			if res.resp.Record != nil && res.resp.Record.Login == login && res.resp.Record.verify(time.Now()) == nil {
+				if found == nil || res.resp.Record.ExpiresAt > found.ExpiresAt {
+					found = res.resp.Record
+				}
+			}
+
+			for _, c := range res.resp.Contacts {
+				if c.ID != n.self.ID && !contains(shortlist, c.ID) {
+					shortlist = append(shortlist, c)
+				}
+			}
+		}
+		if found != nil {
+			return shortlist, found
+		}
+
+		kept := shortlist[:0]
+		for _, c := range shortlist {
+			if !failed[c.ID] {
+				kept = append(kept, c)
+			}
+		}
+		shortlist = kept
+		sortByDistance(shortlist, target)
+		if len(shortlist) > k {
+			shortlist = shortlist[:k]
+		}
+	}
+}
+
+func contains(contacts []contact, id nodeID) bool {
+	for _, c := range contacts {
+		if c.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// replicate stores the record on the k closest nodes of its key, it returns the number of nodes that accepted it
+func (n *node) replicate(ctx context.Context, r record) int {
+	closest, _ := n.lookup(ctx, recordKey(r.Login), "")
+
+	stored := 0
+	for _, c := range closest {
+		if _, err := n.call(ctx, c.Address, rpcStore, rpcRequest{Record: &r}); err == nil {
+			stored++
+		}
+	}
+	return stored
+}
+
+// PublishSession signs a new record for the session and stores it in the network,
+// the record is then republished in background until the node stops
+func (n *node) PublishSession(ctx context.Context, login, device, address string, caps domain.Capabilities) bool {
+	span, ctx := tracing.Start(ctx, "dht:publish_session")
+	defer span.End()
+
+	r := newRecord(n.sk, login, device, address, caps, time.Now())
+	if err := n.records.put(r, time.Now()); err != nil {
+		span.Error(err)
+		return false
+	}
+
+	n.mu.Lock()
+	first := n.published == nil
+	n.published = &r
+	n.mu.Unlock()
+
+	if first {
+		go n.republish()
+	}
+
+	// the record is kept locally and republished later, so not reaching anybody yet is not an error
+	if stored := n.replicate(ctx, r); stored == 0 {
+		span.Event("record only stored locally")
+	}
+	return true
+}
+
+// republish signs the session again before it expires, until the node is closed
+func (n *node) republish() {
+	ticker := time.NewTicker(republishInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+
+		n.mu.Lock()
+		r := newRecord(n.sk, n.published.Login, n.published.Device, n.published.Address, n.published.Capabilities, time.Now())
+		n.published = &r
+		n.mu.Unlock()
+
+		span, ctx := tracing.Start(context.Background(), "dht:republish_session")
+		if err := n.records.put(r, time.Now()); err != nil {
+			span.Error(err)
+		}
+		n.replicate(ctx, r)
+		span.End()
+	}
+}
+
+// AskSessionsToServer looks the session of "to" up in the DHT, "from" is not needed since records are public.
+// A login has one device at most: the first key publishing it is trusted, so the other devices can't publish it
+func (n *node) AskSessionsToServer(ctx context.Context, from string, to string) ([]domain.Session, bool) {
+	span, ctx := tracing.Start(ctx, "dht:ask_session")
+	defer span.End()
+
+	if r := n.records.get(to, time.Now()); r != nil {
+		return []domain.Session{*r.session()}, true
+	}
+
+	if n.table.size() == 0 {
+		span.Error(errors.New("not connected to any node"))
+		return nil, false
+	}
+
+	_, r := n.lookup(ctx, recordKey(to), to)
+	if r == nil {
+		return nil, true
+	}
+	return []domain.Session{*r.session()}, true
+}
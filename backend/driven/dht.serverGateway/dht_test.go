@@ -0,0 +1,101 @@
+package dhtgateway_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gop2p/domain"
+	dhtgateway "gop2p/driven/dht.serverGateway"
+	mux "gop2p/driving/api.mux"
+)
+
+const timeout = 5 * time.Second
+
+// startNode serves a node on an ephemeral port, it's closed with the test
+func startNode(t *testing.T, keyFile string, bootstrap ...string) (dhtgateway.Directory, string) {
+	var directory dhtgateway.Directory
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		directory.ServeHTTP(w, r)
+	}))
+	t.Cleanup(s.Close)
+	u, _ := url.Parse(s.URL)
+
+	key, err := dhtgateway.LoadKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	directory = dhtgateway.New(u.Host, bootstrap, mux.DefaultTransport(), key)
+	t.Cleanup(directory.Close)
+	return directory, u.Host
+}
+
+// address is where the directory finds the session of the login, empty if it doesn't
+func address(d dhtgateway.Directory, login string) string {
+	sessions, ok := d.AskSessionsToServer(context.Background(), "", login)
+	if !ok || len(sessions) == 0 {
+		return ""
+	}
+	return sessions[0].Address
+}
+
+// publish the session until the other node finds it, the session is only stored locally until the node joined
+// the network
+func publish(d dhtgateway.Directory, login, at string, other dhtgateway.Directory) string {
+	deadline := time.Now().Add(timeout)
+	for {
+		d.PublishSession(context.Background(), login, "", at, domain.Supported())
+		if found := address(other, login); found == at || time.Now().After(deadline) {
+			return found
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestDirectory(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	Convey("given three nodes, alice's publishing her session", t, func() {
+		first, firstAddress := startNode(t, filepath.Join(dir, "first.key"))
+		alice, aliceAddress := startNode(t, filepath.Join(dir, "alice.key"), firstAddress)
+		bob, _ := startNode(t, filepath.Join(dir, "bob.key"), firstAddress)
+		So(publish(alice, "alice", aliceAddress, first), ShouldEqual, aliceAddress)
+
+		Convey("the other nodes find it", func() {
+			So(address(bob, "alice"), ShouldEqual, aliceAddress)
+		})
+
+		Convey("a node with another key can't take her login", func() {
+			mallory, malloryAddress := startNode(t, filepath.Join(dir, "mallory.key"), firstAddress)
+			for i := 0; i < 10; i++ {
+				So(mallory.PublishSession(ctx, "alice", "", malloryAddress, domain.Supported()), ShouldBeTrue)
+				So(address(bob, "alice"), ShouldEqual, aliceAddress)
+				time.Sleep(20 * time.Millisecond)
+			}
+		})
+
+		Convey("once restarted with her key, she publishes her session again", func() {
+			alice.Close()
+			restarted, restartedAddress := startNode(t, filepath.Join(dir, "alice.key"), firstAddress)
+			So(publish(restarted, "alice", restartedAddress, bob), ShouldEqual, restartedAddress)
+			So(address(first, "alice"), ShouldEqual, restartedAddress)
+		})
+	})
+}
+
+func TestLoadKey(t *testing.T) {
+	Convey("a key is created once, then read again", t, func() {
+		path := filepath.Join(t.TempDir(), "gop2p", "dht.key")
+		created, err := dhtgateway.LoadKey(path)
+		So(err, ShouldBeNil)
+		read, err := dhtgateway.LoadKey(path)
+		So(err, ShouldBeNil)
+		So(read, ShouldResemble, created)
+	})
+}
@@ -0,0 +1,145 @@
+package dhtgateway
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"gop2p/domain"
+)
+
+// recordTTL is how long a published session stays valid if its owner doesn't republish it
+const recordTTL = time.Hour
+
+var (
+	errInvalidSignature = errors.New("invalid record signature")
+	errExpiredRecord    = errors.New("expired record")
+	errKeyMismatch      = errors.New("a record signed with another key is already stored for this login")
+)
+
+// record is a session signed by its owner, it's what is stored among the nodes
+type record struct {
+	Login        string              `json:"login"`
+	Address      string              `json:"address"`
+	PublicKey    []byte              `json:"public_key"`
+	ExpiresAt    int64               `json:"expires_at"`
+	Capabilities domain.Capabilities `json:"capabilities,omitempty"`
+	Device       string              `json:"device,omitempty"`
+	Signature    []byte              `json:"signature"`
+}
+
+func newRecord(sk ed25519.PrivateKey, login, device, address string, caps domain.Capabilities, now time.Time) record {
+	r := record{
+		Login:        login,
+		Address:      address,
+		PublicKey:    sk.Public().(ed25519.PublicKey),
+		ExpiresAt:    now.Add(recordTTL).Unix(),
+		Capabilities: caps,
+		Device:       device,
+	}
+	r.Signature = ed25519.Sign(sk, r.payload())
+	return r
+}
+
+func recordKey(login string) nodeID {
+	return newNodeID([]byte("session:" + login))
+}
+
+// payload is the signed part of the record
+func (r record) payload() []byte {
+	b := bytes.Buffer{}
+	b.WriteString(r.Login)
+	b.WriteByte(0)
+	b.WriteString(r.Address)
+	b.WriteByte(0)
+	b.Write(r.PublicKey)
+	b.WriteByte(0)
+	b.WriteString(strconv.FormatInt(r.ExpiresAt, 10))
+	b.WriteByte(0)
+	b.WriteString(r.Device)
+	b.WriteByte(0)
+	for _, v := range r.Capabilities.Versions {
+		b.WriteString(strconv.Itoa(v))
+		b.WriteByte(',')
+	}
+	b.WriteByte(0)
+	for _, f := range r.Capabilities.Features {
+		b.WriteString(f)
+		b.WriteByte(',')
+	}
+	return b.Bytes()
+}
+
+func (r record) verify(now time.Time) error {
+	if len(r.PublicKey) != ed25519.PublicKeySize || !ed25519.Verify(r.PublicKey, r.payload(), r.Signature) {
+		return errInvalidSignature
+	}
+	if r.ExpiresAt <= now.Unix() {
+		return errExpiredRecord
+	}
+	return nil
+}
+
+func (r record) session() *domain.Session {
+	return &domain.Session{
+		Online:    true,
+		Address:   r.Address,
+		PublicKey: r.PublicKey,
+		ExpiresAt: r.ExpiresAt,
+		Device:    r.Device,
+
+		Capabilities: r.Capabilities,
+	}
+}
+
+// recordStore holds the records this node is responsible for
+type recordStore struct {
+	mu      sync.Mutex
+	records map[string]record
+}
+
+func newRecordStore() *recordStore {
+	return &recordStore{records: map[string]record{}}
+}
+
+// put only accepts valid records, the first key seen for a login is trusted until its record expires
+func (s *recordStore) put(r record, now time.Time) error {
+	if err := r.verify(now); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	known, ok := s.records[r.Login]
+	if ok && known.verify(now) == nil {
+		if !bytes.Equal(known.PublicKey, r.PublicKey) {
+			return errKeyMismatch
+		}
+		// the owner restarted within the same second replaces its record
+		if known.ExpiresAt > r.ExpiresAt {
+			return nil
+		}
+	}
+
+	s.records[r.Login] = r
+	return nil
+}
+
+func (s *recordStore) get(login string, now time.Time) *record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.records[login]
+	if !ok {
+		return nil
+	}
+	if r.verify(now) != nil {
+		delete(s.records, login)
+		return nil
+	}
+	return &r
+}
@@ -0,0 +1,91 @@
+package dhtgateway
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gop2p/domain"
+)
+
+func newKey() ed25519.PrivateKey {
+	_, sk, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return sk
+}
+
+func TestRecord(t *testing.T) {
+	now := time.Now()
+	owner := newKey()
+
+	Convey("given a record signed by its owner", t, func() {
+		r := newRecord(owner, "alice", "laptop", "alice:4000", domain.Supported(), now)
+
+		Convey("it's valid until it expires", func() {
+			So(r.verify(now), ShouldBeNil)
+			So(r.verify(now.Add(recordTTL)), ShouldEqual, errExpiredRecord)
+		})
+
+		Convey("its address can't be changed", func() {
+			r.Address = "mallory:4000"
+			So(r.verify(now), ShouldEqual, errInvalidSignature)
+		})
+
+		Convey("its device can't be changed", func() {
+			r.Device = "phone"
+			So(r.verify(now), ShouldEqual, errInvalidSignature)
+		})
+
+		Convey("its capabilities can't be changed", func() {
+			r.Capabilities = domain.Capabilities{Versions: []int{domain.ProtocolLegacy}}
+			So(r.verify(now), ShouldEqual, errInvalidSignature)
+		})
+
+		Convey("when it's stored", func() {
+			s := newRecordStore()
+			So(s.put(r, now), ShouldBeNil)
+
+			Convey("it's found by login", func() {
+				So(s.get("alice", now), ShouldResemble, &r)
+				So(s.get("bob", now), ShouldBeNil)
+			})
+
+			Convey("another key can't replace it", func() {
+				forged := newRecord(newKey(), "alice", "laptop", "mallory:4000", domain.Supported(), now.Add(time.Minute))
+				So(s.put(forged, now), ShouldEqual, errKeyMismatch)
+				So(s.get("alice", now).Address, ShouldEqual, "alice:4000")
+			})
+
+			Convey("its owner replaces it, even restarted within the same second, but not with an older one", func() {
+				moved := newRecord(owner, "alice", "laptop", "alice:4001", domain.Supported(), now)
+				So(s.put(moved, now), ShouldBeNil)
+				So(s.get("alice", now).Address, ShouldEqual, "alice:4001")
+
+				older := newRecord(owner, "alice", "laptop", "alice:4002", domain.Supported(), now.Add(-time.Minute))
+				So(s.put(older, now), ShouldBeNil)
+				So(s.get("alice", now).Address, ShouldEqual, "alice:4001")
+			})
+
+			Convey("once expired, it's gone and another key can take the login", func() {
+				later := now.Add(recordTTL)
+				So(s.get("alice", later), ShouldBeNil)
+
+				other := newRecord(newKey(), "alice", "phone", "alice:5000", domain.Supported(), later)
+				So(s.put(other, later), ShouldBeNil)
+				So(s.get("alice", later).Address, ShouldEqual, "alice:5000")
+			})
+		})
+
+		Convey("an expired or forged record isn't stored", func() {
+			s := newRecordStore()
+			So(s.put(r, now.Add(recordTTL)), ShouldEqual, errExpiredRecord)
+			r.Signature = nil
+			So(s.put(r, now), ShouldEqual, errInvalidSignature)
+			So(s.get("alice", now), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,167 @@
+package dhtgateway
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+)
+
+const (
+	idLength = sha1.Size
+	idBits   = idLength * 8
+
+	// k is both the size of the buckets and the number of nodes a record is replicated on
+	k = 8
+	// alpha is the number of parallel requests during a lookup
+	alpha = 3
+)
+
+// nodeID is used both for nodes and record keys, they share the same 160 bits space
+type nodeID [idLength]byte
+
+func newNodeID(data []byte) nodeID {
+	return sha1.Sum(data)
+}
+
+func (id nodeID) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(id[:])), nil
+}
+
+func (id *nodeID) UnmarshalText(text []byte) error {
+	b, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	if len(b) != idLength {
+		return errors.New("invalid node id length")
+	}
+	copy(id[:], b)
+	return nil
+}
+
+func (id nodeID) xor(other nodeID) nodeID {
+	var d nodeID
+	for i := range id {
+		d[i] = id[i] ^ other[i]
+	}
+	return d
+}
+
+// prefixLen is the number of leading bits shared by the 2 ids
+func (id nodeID) prefixLen(other nodeID) int {
+	d := id.xor(other)
+	for i, b := range d {
+		for j := 0; j < 8; j++ {
+			if b&(0x80>>uint(j)) != 0 {
+				return i*8 + j
+			}
+		}
+	}
+	return idBits
+}
+
+// contact is how a node is known by the others
+type contact struct {
+	ID      nodeID `json:"id"`
+	Address string `json:"address"`
+}
+
+// sortByDistance sorts the contacts from the closest to the farthest of target
+func sortByDistance(contacts []contact, target nodeID) {
+	sort.Slice(contacts, func(i, j int) bool {
+		di := contacts[i].ID.xor(target)
+		dj := contacts[j].ID.xor(target)
+		return bytes.Compare(di[:], dj[:]) < 0
+	})
+}
+
+// routingTable holds one bucket per shared prefix length with self,
+// each bucket is ordered from the least to the most recently seen contact
+type routingTable struct {
+	self    nodeID
+	mu      sync.Mutex
+	buckets [idBits][]contact
+}
+
+func newRoutingTable(self nodeID) *routingTable {
+	return &routingTable{self: self}
+}
+
+func (t *routingTable) bucketIndex(id nodeID) int {
+	i := t.self.prefixLen(id)
+	if i == idBits {
+		return -1
+	}
+	return i
+}
+
+// update marks a contact as recently seen, when its bucket is full the newcomer is dropped:
+// long-lived nodes are more likely to stay online
+func (t *routingTable) update(c contact) {
+	i := t.bucketIndex(c.ID)
+	if i < 0 || c.Address == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bucket := t.buckets[i]
+	for j, known := range bucket {
+		if known.ID == c.ID {
+			bucket = append(bucket[:j], bucket[j+1:]...)
+			t.buckets[i] = append(bucket, c)
+			return
+		}
+	}
+
+	if len(bucket) < k {
+		t.buckets[i] = append(bucket, c)
+	}
+}
+
+// remove forgets all the contacts reachable at address (used when a node stops answering)
+func (t *routingTable) remove(address string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, bucket := range t.buckets {
+		kept := bucket[:0]
+		for _, c := range bucket {
+			if c.Address != address {
+				kept = append(kept, c)
+			}
+		}
+		t.buckets[i] = kept
+	}
+}
+
+// closest returns at most n contacts sorted by distance to target
+func (t *routingTable) closest(target nodeID, n int) []contact {
+	t.mu.Lock()
+	all := []contact{}
+	for _, bucket := range t.buckets {
+		all = append(all, bucket...)
+	}
+	t.mu.Unlock()
+
+	sortByDistance(all, target)
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+func (t *routingTable) size() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for _, bucket := range t.buckets {
+		n += len(bucket)
+	}
+	return n
+}
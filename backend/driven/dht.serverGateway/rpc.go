@@ -0,0 +1,147 @@
+package dhtgateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	mux "gop2p/driving/api.mux"
+	"gop2p/tracing"
+	"net/http"
+	"time"
+)
+
+const (
+	rpcPing      = "ping"
+	rpcFindNode  = "find_node"
+	rpcFindValue = "find_value"
+	rpcStore     = "store"
+)
+
+// rpcRequest is the body of every call between nodes, only the fields needed by the rpc are set
+type rpcRequest struct {
+	From   contact `json:"from"`
+	Target nodeID  `json:"target"`
+	Login  string  `json:"login,omitempty"`
+	Record *record `json:"record,omitempty"`
+}
+
+type rpcResponse struct {
+	From     contact   `json:"from"`
+	Contacts []contact `json:"contacts,omitempty"`
+	Record   *record   `json:"record,omitempty"`
+}
+
+func (n *node) routes() *http.ServeMux {
+	m := http.NewServeMux()
+	m.HandleFunc("/dht/"+rpcPing, n.handle(rpcPing, func(_ rpcRequest, resp *rpcResponse) int {
+		return http.StatusOK
+	}))
+	m.HandleFunc("/dht/"+rpcFindNode, n.handle(rpcFindNode, func(req rpcRequest, resp *rpcResponse) int {
+		resp.Contacts = n.table.closest(req.Target, k)
+		return http.StatusOK
+	}))
+	m.HandleFunc("/dht/"+rpcFindValue, n.handle(rpcFindValue, func(req rpcRequest, resp *rpcResponse) int {
+		resp.Record = n.records.get(req.Login, time.Now())
+		if resp.Record == nil {
+			resp.Contacts = n.table.closest(recordKey(req.Login), k)
+		}
+		return http.StatusOK
+	}))
+	m.HandleFunc("/dht/"+rpcStore, n.handle(rpcStore, func(req rpcRequest, resp *rpcResponse) int {
+		if req.Record == nil {
+			return http.StatusBadRequest
+		}
+		if err := n.records.put(*req.Record, time.Now()); err != nil {
+			return http.StatusBadRequest
+		}
+		return http.StatusOK
+	}))
+	return m
+}
+
+// handle decodes the rpc, learns about the caller and writes the response
+func (n *node) handle(name string, f func(req rpcRequest, resp *rpcResponse) int) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		span, _ := tracing.StartFromRequest("dht:handle_"+name, r)
+		defer span.End()
+
+		req := rpcRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			span.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		resp := rpcResponse{From: n.self}
+		status := f(req, &resp)
+		n.table.update(req.From)
+
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+
+		body, err := json.Marshal(resp)
+		if err != nil {
+			span.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", mux.ApplicationJSON)
+		w.Write(body)
+	}
+}
+
+// call sends an rpc to the node at address, unreachable nodes are removed from the routing table
+func (n *node) call(ctx context.Context, address, name string, req rpcRequest) (*rpcResponse, error) {
+	span, ctx := tracing.Start(ctx, "dht:call_"+name)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, rpcTimeout)
+	defer cancel()
+
+	req.From = n.self
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, n.transport.URL(address, "/dht/"+name), bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Type", mux.ApplicationJSON)
+	mux.InjectSpanInReq(ctx, httpReq)
+
+	httpResp, err := n.transport.HTTPClient().Do(httpReq)
+	if err != nil {
+		span.Error(err)
+		n.table.remove(address)
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("%s responded %d to %s", address, httpResp.StatusCode, name)
+		span.Error(err)
+		return nil, err
+	}
+
+	resp := rpcResponse{}
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		span.Error(err)
+		return nil, err
+	}
+
+	// the address we reached is more reliable than the one announced
+	resp.From.Address = address
+	n.table.update(resp.From)
+	return &resp, nil
+}
@@ -0,0 +1,204 @@
+package blobstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"gop2p/domain"
+	"gop2p/tracing"
+	"gop2p/uc"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// partialExt is the extension of the blobs being written, a complete blob is only named after its hash
+const partialExt = ".part"
+
+type store struct {
+	dir string
+	// mu serializes the writes so that two transfers of the same blob don't interleave
+	mu sync.Mutex
+}
+
+// New is the constructor of this implementation of the uc.BlobStore keeping every blob in a file of dir
+func New(dir string) (uc.BlobStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &store{dir: dir}, nil
+}
+
+func (s *store) path(hash string) string {
+	return filepath.Join(s.dir, hash)
+}
+
+// the hashes are checked before being used as file names
+func invalidHash(span tracing.Span, hash string) bool {
+	if !domain.ValidHash(hash) {
+		span.Error(fmt.Errorf("invalid hash %q", hash))
+		return true
+	}
+	return false
+}
+
+func (s *store) Stat(ctx context.Context, hash string) (*domain.Blob, bool) {
+	span, ctx := tracing.Start(ctx, "blob_store:stat")
+	defer span.End()
+
+	if invalidHash(span, hash) {
+		return nil, false
+	}
+
+	for _, b := range []struct {
+		path     string
+		complete bool
+	}{{s.path(hash), true}, {s.path(hash) + partialExt, false}} {
+		info, err := os.Stat(b.path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			span.Error(err)
+			return nil, false
+		}
+		return &domain.Blob{Size: info.Size(), Complete: b.complete}, true
+	}
+	return nil, true
+}
+
+func (s *store) Put(ctx context.Context, hash string, content []byte) bool {
+	span, ctx := tracing.Start(ctx, "blob_store:put")
+	defer span.End()
+
+	if invalidHash(span, hash) {
+		return false
+	}
+
+	// written aside then renamed, a blob is never seen half written
+	f, err := os.CreateTemp(s.dir, hash+".put-*")
+	if err != nil {
+		span.Error(err)
+		return false
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		span.Error(err)
+		return false
+	}
+	if err := f.Close(); err != nil {
+		span.Error(err)
+		return false
+	}
+
+	if err := os.Rename(f.Name(), s.path(hash)); err != nil {
+		span.Error(err)
+		return false
+	}
+	return true
+}
+
+func (s *store) Append(ctx context.Context, hash string, offset int64, chunk []byte) bool {
+	span, ctx := tracing.Start(ctx, "blob_store:append")
+	defer span.End()
+
+	if invalidHash(span, hash) {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path(hash)+partialExt, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		span.Error(err)
+		return false
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		span.Error(err)
+		return false
+	}
+	if info.Size() != offset {
+		span.Error(fmt.Errorf("chunk at %d of a blob of %d bytes", offset, info.Size()))
+		return false
+	}
+
+	if _, err := f.Write(chunk); err != nil {
+		span.Error(err)
+		return false
+	}
+	return true
+}
+
+func (s *store) Seal(ctx context.Context, hash string) (bool, bool) {
+	span, ctx := tracing.Start(ctx, "blob_store:seal")
+	defer span.End()
+
+	if invalidHash(span, hash) {
+		return false, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	partial := s.path(hash) + partialExt
+	f, err := os.Open(partial)
+	if err != nil {
+		span.Error(err)
+		return false, false
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		span.Error(err)
+		return false, false
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != hash {
+		span.Error(fmt.Errorf("the content of the blob %s doesn't match its hash", hash))
+		if err := os.Remove(partial); err != nil {
+			span.Error(err)
+			return false, false
+		}
+		return false, true
+	}
+
+	if err := os.Rename(partial, s.path(hash)); err != nil {
+		span.Error(err)
+		return false, false
+	}
+	return true, true
+}
+
+func (s *store) ReadChunk(ctx context.Context, hash string, offset int64, length int) ([]byte, bool) {
+	span, ctx := tracing.Start(ctx, "blob_store:read_chunk")
+	defer span.End()
+
+	if invalidHash(span, hash) {
+		return nil, false
+	}
+
+	f, err := os.Open(s.path(hash))
+	if err != nil {
+		span.Error(err)
+		return nil, false
+	}
+	defer f.Close()
+
+	chunk := make([]byte, length)
+	n, err := f.ReadAt(chunk, offset)
+	if err != nil && err != io.EOF {
+		span.Error(err)
+		return nil, false
+	}
+	return chunk[:n], true
+}
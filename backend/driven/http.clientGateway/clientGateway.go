@@ -1,53 +1,295 @@
 package clientgateway
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"github.com/opentracing/opentracing-go"
-	"github.com/opentracing/opentracing-go/log"
+	"errors"
+	"fmt"
+	"gop2p/api/p2pclient"
 	"gop2p/domain"
 	"gop2p/driving/api.mux"
+	"gop2p/metrics"
+	"gop2p/tracing"
 	"gop2p/uc"
 	"net/http"
+	"sync"
+	"time"
 )
 
 type caller struct {
-	client *http.Client
+	transport mux.Transport
+	own       domain.Capabilities
+
+	// peers are the handshakes with the peers whose session doesn't advertise capabilities
+	mu    sync.Mutex
+	peers map[string]*mux.Negotiator
+}
+
+// New returns a gateway talking to each peer in the best encoding both support, own are the capabilities of this client
+func New(t mux.Transport, own domain.Capabilities) uc.ClientGateway {
+	return &caller{transport: t, own: own, peers: map[string]*mux.Negotiator{}}
 }
 
-func New() uc.ClientGateway {
-	return caller{client: http.DefaultClient}
+func (c *caller) SendMsg(ctx context.Context, to domain.Session, msg domain.Message, from string) string {
+	span, ctx := tracing.Start(ctx, "http:send_message")
+	defer span.End()
+
+	// the message is in the outbox until it's delivered or given up
+	metrics.MessageQueued()
+	defer metrics.MessageDequeued()
+
+	e := domain.Event{Type: domain.EventMessage, Ref: msg.Ref, Content: msg.Content, Attachments: msg.Attachments}
+	switch err := c.send(ctx, span, to, e, from); {
+	case err == nil:
+		metrics.MessageSent()
+		return domain.MessageDelivered
+	case errors.Is(err, errUnavailable):
+		return domain.MessagePending
+	default:
+		metrics.MessageFailed()
+		return domain.MessageFailed
+	}
+}
+
+func (c *caller) SendEvent(ctx context.Context, to domain.Session, e domain.Event, from string) bool {
+	span, ctx := tracing.Start(ctx, "http:send_event")
+	defer span.End()
+	span.SetAttribute("event_type", e.Type)
+
+	return c.send(ctx, span, to, e, from) == nil
 }
 
-func (c caller) SendMsg(ctx context.Context, addr string, msg domain.Message, from string) bool {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "http:send_message")
-	defer span.Finish()
+// errUnavailable is returned when the peer can't store the event for now, see domain.ErrUnavailable
+var errUnavailable = errors.New("the peer is unavailable, retry later")
 
-	reqBody, err := json.Marshal(mux.PostMessageBody{Message: msg.Content})
+// send returns errUnavailable when the peer responds 503
+func (c *caller) send(ctx context.Context, span tracing.Span, to domain.Session, e domain.Event, from string) error {
+	encoding, handshake, err := c.encoding(ctx, to)
 	if err != nil {
-		span.LogFields(log.Error(err))
-		return false
+		span.Error(err)
+		return err
 	}
+	span.SetAttribute("protocol_version", encoding.Version)
 
-	req, err := http.NewRequest(http.MethodPost, "http://"+addr+"/messages/", bytes.NewBuffer(reqBody))
+	// the peers of previous versions would take any event for a new message
+	if e.Type != domain.EventMessage && !encoding.Has(domain.FeatureEvents) {
+		err := fmt.Errorf("%s doesn't support the events", to.Address)
+		span.Error(err)
+		return err
+	}
+	// the peers of this version without the feature don't know this event
+	if e.Type == domain.EventRetention && !encoding.Has(domain.FeatureRetention) {
+		err := fmt.Errorf("%s doesn't support the retention", to.Address)
+		span.Error(err)
+		return err
+	}
+	// and drop the attachments
+	if len(e.Attachments) > 0 && !encoding.Has(domain.FeatureAttachments) {
+		err := fmt.Errorf("%s doesn't support the attachments", to.Address)
+		span.Error(err)
+		return err
+	}
+	// and take the events synced from another device for the ones of a conversation with the user itself
+	if e.With != "" && !encoding.Has(domain.FeatureDevices) {
+		err := fmt.Errorf("%s doesn't support the devices", to.Address)
+		span.Error(err)
+		return err
+	}
+
+	client := p2pclient.New(c.transport.URL(to.Address, ""), c.transport.Doer("client"),
+		mux.InjectTrace, mux.WithEncoding(encoding))
+
+	resp, err := client.PostMessage(ctx, p2pclient.PostMessageParams{User: from}, postMessageBody(e))
+	if err == nil && resp.StatusCode == http.StatusServiceUnavailable {
+		span.Error(fmt.Errorf("%s responded %d", to.Address, resp.StatusCode))
+		return errUnavailable
+	}
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("%s responded %d", to.Address, resp.StatusCode)
+	}
 	if err != nil {
-		span.LogFields(log.Error(err))
-		return false
+		span.Error(err)
+		// the peer may have been restarted with another version
+		if handshake != nil {
+			handshake.Forget()
+		}
+		return err
 	}
-	req.Header.Set("user", from)
 
-	mux.InjectSpanInReq(span, req)
+	return nil
+}
+
+func (c *caller) SendSignal(ctx context.Context, to domain.Session, sig domain.Signal, from string) bool {
+	span, ctx := tracing.Start(ctx, "http:send_signal")
+	defer span.End()
+	span.SetAttribute("signal_type", sig.Type)
 
-	resp, err := c.client.Do(req)
+	encoding, handshake, err := c.encoding(ctx, to)
 	if err != nil {
-		span.LogFields(log.Error(err))
+		span.Error(err)
 		return false
 	}
-	if resp.StatusCode != http.StatusOK {
-		span.LogFields(log.Error(err))
+	if !encoding.Has(domain.FeatureSignals) {
+		span.Error(fmt.Errorf("%s doesn't support the signals", to.Address))
+		return false
+	}
+
+	client := p2pclient.New(c.transport.URL(to.Address, ""), c.transport.Doer("client"),
+		mux.InjectTrace, mux.WithEncoding(encoding))
+
+	body := p2pclient.PostSignalBody{Type: sig.Type}
+	if sig.Ref != "" {
+		body.Ref = &sig.Ref
+	}
+	resp, err := client.PostSignal(ctx, p2pclient.PostSignalParams{User: from}, body)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("%s responded %d", to.Address, resp.StatusCode)
+	}
+	if err != nil {
+		span.Error(err)
+		if handshake != nil {
+			handshake.Forget()
+		}
 		return false
 	}
 
 	return true
 }
+
+func (c *caller) FetchChunk(ctx context.Context, to domain.Session, hash string, offset int64, from string) ([]byte, bool) {
+	span, ctx := tracing.Start(ctx, "http:fetch_chunk")
+	defer span.End()
+
+	encoding, handshake, err := c.encoding(ctx, to)
+	if err != nil {
+		span.Error(err)
+		return nil, false
+	}
+	if !encoding.Has(domain.FeatureAttachments) {
+		span.Error(fmt.Errorf("%s doesn't support the attachments", to.Address))
+		return nil, false
+	}
+
+	client := p2pclient.New(c.transport.URL(to.Address, ""), c.transport.Doer("client"),
+		mux.InjectTrace, mux.WithEncoding(encoding))
+
+	resp, err := client.GetAttachmentChunk(ctx, hash, p2pclient.GetAttachmentChunkParams{
+		User:  from,
+		Range: fmt.Sprintf("bytes=%d-", offset),
+	})
+	if err == nil && resp.StatusCode != http.StatusPartialContent {
+		err = fmt.Errorf("%s responded %d", to.Address, resp.StatusCode)
+	}
+	if err != nil {
+		span.Error(err)
+		if handshake != nil {
+			handshake.Forget()
+		}
+		return nil, false
+	}
+
+	metrics.ChunkReceived(len(resp.Body))
+	return resp.Body, true
+}
+
+func (c *caller) FetchHistory(ctx context.Context, to domain.Session, from string) ([]domain.History, bool) {
+	span, ctx := tracing.Start(ctx, "http:fetch_history")
+	defer span.End()
+
+	encoding, handshake, err := c.encoding(ctx, to)
+	if err != nil {
+		span.Error(err)
+		return nil, false
+	}
+	if !encoding.Has(domain.FeatureDevices) {
+		span.Error(fmt.Errorf("%s doesn't support the devices", to.Address))
+		return nil, false
+	}
+
+	client := p2pclient.New(c.transport.URL(to.Address, ""), c.transport.Doer("client"),
+		mux.InjectTrace, mux.WithEncoding(encoding))
+
+	resp, err := client.GetHistory(ctx, p2pclient.GetHistoryParams{User: from})
+	if err == nil && resp.JSON200 == nil {
+		err = fmt.Errorf("%s responded %d", to.Address, resp.StatusCode)
+	}
+	if err != nil {
+		span.Error(err)
+		if handshake != nil {
+			handshake.Forget()
+		}
+		return nil, false
+	}
+
+	histories := make([]domain.History, 0, len(*resp.JSON200))
+	for _, h := range *resp.JSON200 {
+		messages := make([]domain.Message, 0, len(h.Messages))
+		for _, m := range h.Messages {
+			messages = append(messages, message(m))
+		}
+		histories = append(histories, domain.History{With: h.With, Messages: messages})
+	}
+	return histories, true
+}
+
+// encoding is negotiated from the capabilities of the session, the peer is asked for them when there are none
+// (ie. when the session was registered by a client or a server of a previous version)
+func (c *caller) encoding(ctx context.Context, to domain.Session) (domain.Encoding, *mux.Negotiator, error) {
+	if !to.Capabilities.Unknown() {
+		e, ok := c.own.Negotiate(to.Capabilities)
+		if !ok {
+			return e, nil, fmt.Errorf("no protocol version in common with %s (%v)", to.Address, to.Capabilities.Versions)
+		}
+		return e, nil, nil
+	}
+
+	c.mu.Lock()
+	n, ok := c.peers[to.Address]
+	if !ok {
+		n = mux.NewNegotiator(c.transport, to.Address, c.own)
+		c.peers[to.Address] = n
+	}
+	c.mu.Unlock()
+
+	e, err := n.Encoding(ctx)
+	return e, n, err
+}
+
+// postMessageBody only sets the fields used by the type of event
+func postMessageBody(e domain.Event) p2pclient.PostMessageBody {
+	b := p2pclient.PostMessageBody{Type: &e.Type, Ref: &e.Ref}
+	switch e.Type {
+	case domain.EventMessage:
+		b.Message = &e.Content
+		for _, a := range e.Attachments {
+			b.Attachments = append(b.Attachments, p2pclient.Attachment{Hash: a.Hash, Name: a.Name, Size: a.Size})
+		}
+	case domain.EventEdit:
+		b.Message, b.Edits = &e.Content, &e.Edits
+	case domain.EventReaction:
+		b.Emoji, b.Removed = &e.Emoji, &e.Removed
+	case domain.EventRetention:
+		b.Ttl = &e.TTL
+	}
+	if e.With != "" {
+		b.With = &e.With
+	}
+	return b
+}
+
+func message(m p2pclient.Message) domain.Message {
+	msg := domain.Message{ID: m.ID, Ref: m.Ref, Author: m.Author, Content: m.Content, Edits: m.Edits, Deleted: m.Deleted}
+	if m.Date != nil {
+		// the messages of the devices of previous versions have no date, they're only purged by count
+		msg.Date, _ = time.Parse(time.RFC3339Nano, *m.Date)
+	}
+	if m.Reactions != nil {
+		msg.Reactions = *m.Reactions
+	}
+	if m.Status != nil {
+		msg.Status = *m.Status
+	}
+	for _, a := range m.Attachments {
+		msg.Attachments = append(msg.Attachments, domain.Attachment{Hash: a.Hash, Name: a.Name, Size: a.Size})
+	}
+	return msg
+}
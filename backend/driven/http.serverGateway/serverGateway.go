@@ -2,55 +2,93 @@ package servergateway
 
 import (
 	"context"
-	"encoding/json"
-	"github.com/opentracing/opentracing-go"
-	"github.com/opentracing/opentracing-go/log"
+	"fmt"
+	"gop2p/api/serverclient"
 	"gop2p/domain"
 	"gop2p/driving/api.mux"
+	"gop2p/tracing"
 	"gop2p/uc"
-	"io/ioutil"
 	"net/http"
 )
 
 type caller struct {
-	serverAddress string
-	client        *http.Client
+	server    string
+	transport mux.Transport
+	handshake *mux.Negotiator
 }
 
-func New(serverAddress string) uc.ServerGateway {
-	return caller{serverAddress: serverAddress, client: http.DefaultClient}
+// New returns a gateway talking to the server in the best version both support, own are the capabilities of this client
+func New(serverAddress string, t mux.Transport, own domain.Capabilities) uc.ServerGateway {
+	return caller{
+		server:    serverAddress,
+		transport: t,
+		handshake: mux.NewNegotiator(t, serverAddress, own),
+	}
 }
 
-func (c caller) AskSessionToServer(ctx context.Context, from string, to string) (*domain.Session, bool) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "ask_session_to_server")
-	defer span.Finish()
+func (c caller) AskSessionsToServer(ctx context.Context, from string, to string) ([]domain.Session, bool) {
+	span, ctx := tracing.Start(ctx, "ask_session_to_server")
+	defer span.End()
 
-	req, err := http.NewRequest(http.MethodGet, "http://"+c.serverAddress+"/sessions/"+to, nil)
+	encoding, err := c.handshake.Encoding(ctx)
 	if err != nil {
-		span.LogFields(log.Error(err))
+		span.Error(err)
 		return nil, false
 	}
-	req.Header.Set("user", from)
 
-	mux.InjectSpanInReq(span, req)
+	client := serverclient.New(c.transport.URL(c.server, ""), c.transport.Doer("server"),
+		mux.InjectTrace, mux.WithEncoding(encoding))
 
-	resp, err := c.client.Do(req)
+	resp, err := client.GetSession(ctx, to, serverclient.GetSessionParams{User: from})
 	if err != nil {
-		span.LogFields(log.Error(err))
+		span.Error(err)
+		c.handshake.Forget()
 		return nil, false
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		span.LogFields(log.Error(err))
+	switch {
+	case resp.JSON200 != nil:
+		s := resp.JSON200
+		// the servers of previous versions only know one device
+		if len(s.Devices) == 0 {
+			return []domain.Session{session(serverclient.DeviceSession{
+				Online: s.Online, Address: s.Address, Device: s.Device,
+				PublicKey: s.PublicKey, ExpiresAt: s.ExpiresAt, Capabilities: s.Capabilities,
+			})}, true
+		}
+
+		sessions := make([]domain.Session, 0, len(s.Devices))
+		for _, d := range s.Devices {
+			sessions = append(sessions, session(d))
+		}
+		return sessions, true
+
+	case resp.StatusCode >= http.StatusInternalServerError:
+		span.Error(fmt.Errorf("the server responded %d", resp.StatusCode))
 		return nil, false
-	}
 
-	session := &domain.Session{}
-	if err := json.Unmarshal(body, session); err != nil {
-		span.LogFields(log.Error(err))
+	case resp.StatusCode == http.StatusNotFound:
+		// the route is unknown, the server may have been restarted with another version
+		span.Error(fmt.Errorf("the server responded %d", resp.StatusCode))
+		c.handshake.Forget()
 		return nil, false
+
+	default:
+		// the server doesn't tell apart unknown users and the ones without session
+		return nil, true
 	}
+}
 
-	return session, true
+func session(s serverclient.DeviceSession) domain.Session {
+	session := domain.Session{Online: s.Online, Address: s.Address, PublicKey: s.PublicKey}
+	if s.Device != nil {
+		session.Device = *s.Device
+	}
+	if s.ExpiresAt != nil {
+		session.ExpiresAt = *s.ExpiresAt
+	}
+	if s.Capabilities != nil {
+		session.Capabilities = domain.Capabilities{Versions: s.Capabilities.Versions, Features: s.Capabilities.Features}
+	}
+	return session
 }
@@ -2,81 +2,711 @@ package conversationmanager
 
 import (
 	"context"
-	"errors"
-	"github.com/opentracing/opentracing-go"
-	"github.com/opentracing/opentracing-go/log"
 	"gop2p/domain"
+	"gop2p/fault"
+	"gop2p/tracing"
 	"gop2p/uc"
+	"sort"
 	"sync"
+	"time"
 )
 
+type conversation struct {
+	messages []domain.Message
+	// refs maps the refs of the messages to their index
+	refs map[string]int
+	// lastRead is the ID of the last message read
+	lastRead int64
+	// readAhead are the refs of the messages merged after lastRead, they're read
+	readAhead map[string]bool
+}
+
+// messageRef locates a message from its ID
+type messageRef struct {
+	with  string
+	index int
+}
+
+// state is shared by the copies of the store
+type state struct {
+	mu            sync.RWMutex
+	conversations map[string]*conversation
+	lastID        int64
+	messages      map[int64]messageRef
+	// index maps the words to the IDs of the messages holding them
+	index map[string]map[int64]struct{}
+	// retentions outlive the conversations, which are dropped once purged
+	retentions map[string]domain.Retention
+	// purged are when the refs of the purged messages were purged, by conversation, until domain.DedupeWindow
+	purged map[string]map[string]time.Time
+}
+
 type store struct {
-	rw            *sync.Map
-	failingMethod string
+	s      *state
+	faults *fault.Methods
 }
 
-// New is the constructor of this in memory implementation of the uc.SessionManager
+func newState() *state {
+	return &state{
+		conversations: map[string]*conversation{},
+		messages:      map[int64]messageRef{},
+		index:         map[string]map[int64]struct{}{},
+		retentions:    map[string]domain.Retention{},
+		purged:        map[string]map[string]time.Time{},
+	}
+}
+
+// New is the constructor of this in memory implementation of the uc.ConversationManager
 func New() uc.ConversationManager {
-	return store{rw: &sync.Map{}}
+	return store{s: newState()}
 }
 
+// the methods which can be made to fail
+const (
+	GetConversationWith      fault.Method = "getConversationWith"
+	AppendToConversationWith fault.Method = "appendToConversationWith"
+	AppendOnce               fault.Method = "appendOnce"
+	MergeOnce                fault.Method = "mergeOnce"
+	GetMessage               fault.Method = "getMessage"
+	EditMessage              fault.Method = "editMessage"
+	DeleteMessage            fault.Method = "deleteMessage"
+	SetReaction              fault.Method = "setReaction"
+	SetMessageStatus         fault.Method = "setMessageStatus"
+	FindAttachment           fault.Method = "findAttachment"
+	ListConversations        fault.Method = "listConversations"
+	MarkConversationRead     fault.Method = "markConversationRead"
+	SearchMessages           fault.Method = "searchMessages"
+	GetRetention             fault.Method = "getRetention"
+	SetRetention             fault.Method = "setRetention"
+	PurgeMessages            fault.Method = "purgeMessages"
+)
+
 type FailingConversationManager interface {
 	uc.ConversationManager
-	InjectErrorAt(failingMethod string)
+	InjectErrorAt(method fault.Method)
 }
 
 func NewFailable() FailingConversationManager {
-	return &store{rw: &sync.Map{}, failingMethod: ""}
+	return &store{s: newState(), faults: &fault.Methods{}}
 }
 
-func (s *store) InjectErrorAt(failingMethod string) {
-	s.failingMethod = failingMethod
+func (s *store) InjectErrorAt(method fault.Method) {
+	s.faults.Inject(method)
 }
 
-func (s store) GetConversationWith(ctx context.Context, authorName string) ([]domain.Message, bool) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "conversation_manager:get-conversation_with")
-	defer span.Finish()
+func (s store) GetConversationWith(ctx context.Context, authorName string, page domain.Page) ([]domain.Message, bool) {
+	span, ctx := tracing.Start(ctx, "conversation_manager:get-conversation_with")
+	defer span.End()
 
-	if s.failingMethod == "getConversationWith" {
+	if s.faults.Fails(GetConversationWith) {
 		return nil, false
 	}
 
-	val, ok := s.rw.Load(authorName)
+	s.s.mu.RLock()
+	defer s.s.mu.RUnlock()
+
+	c, ok := s.s.conversations[authorName]
 	if !ok {
 		return nil, true
 	}
 
-	conversation, ok := val.([]domain.Message)
-	if !ok {
-		span.LogFields(log.Error(errors.New("not a conversation stored at Key")))
-		return nil, false
+	// the IDs increase so the messages are sorted by ID
+	messages := c.messages
+	if page.Limit <= 0 {
+		page.Limit = len(messages)
+	}
+	switch {
+	case page.After > 0:
+		from := sort.Search(len(messages), func(i int) bool { return messages[i].ID > page.After })
+		messages = messages[from:]
+		if len(messages) > page.Limit {
+			messages = messages[:page.Limit]
+		}
+	default:
+		if page.Before > 0 {
+			to := sort.Search(len(messages), func(i int) bool { return messages[i].ID >= page.Before })
+			messages = messages[:to]
+		}
+		if len(messages) > page.Limit {
+			messages = messages[len(messages)-page.Limit:]
+		}
+	}
+
+	copies := make([]domain.Message, 0, len(messages))
+	for _, m := range messages {
+		copies = append(copies, clone(m))
 	}
-	return conversation, true
+	return copies, true
 }
 
-func (s store) AppendToConversationWith(ctx context.Context, userName, msgAuthor, msgContent string) bool {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "conversation_manager:append_to_conversation")
-	defer span.Finish()
+func (s store) AppendToConversationWith(ctx context.Context, userName string, msg domain.Message) bool {
+	span, ctx := tracing.Start(ctx, "conversation_manager:append_to_conversation")
+	defer span.End()
 
-	if s.failingMethod == "appendToConversationWith" {
+	if s.faults.Fails(AppendToConversationWith) {
 		return false
 	}
 
+	s.s.mu.Lock()
+	defer s.s.mu.Unlock()
+
 	// userName is the "other" user (not the one storing)
-	val, ok := s.rw.Load(userName)
-	if !ok {
-		// first message in conversation
-		s.rw.Store(userName, []domain.Message{{Author: msgAuthor, Content: msgContent}})
+	s.s.append(userName, msg)
+	return true
+}
+
+// AppendOnce checks the ref and appends under the same lock, so that concurrent redeliveries store it once
+func (s store) AppendOnce(ctx context.Context, with string, msg domain.Message) (bool, bool) {
+	span, ctx := tracing.Start(ctx, "conversation_manager:append_once")
+	defer span.End()
+
+	if s.faults.Fails(AppendOnce) {
+		return false, false
+	}
+
+	s.s.mu.Lock()
+	defer s.s.mu.Unlock()
+
+	if msg.Ref != "" && s.s.known(with, msg.Ref) {
+		return false, true
+	}
+
+	s.s.append(with, msg)
+	return true, true
+}
+
+// MergeOnce inserts the unknown messages by date under the same lock as AppendOnce, the conversation is given its IDs
+// again in this order
+func (s store) MergeOnce(ctx context.Context, with string, msgs []domain.Message) (int, bool) {
+	span, ctx := tracing.Start(ctx, "conversation_manager:merge_once")
+	defer span.End()
+
+	if s.faults.Fails(MergeOnce) {
+		return 0, false
+	}
+
+	s.s.mu.Lock()
+	defer s.s.mu.Unlock()
+
+	return s.s.merge(with, msgs), true
+}
+
+// GetMessage returns nil if there's no message with this ref in the conversation
+func (s store) GetMessage(ctx context.Context, with, ref string) (*domain.Message, bool) {
+	span, ctx := tracing.Start(ctx, "conversation_manager:get_message")
+	defer span.End()
+
+	if s.faults.Fails(GetMessage) {
+		return nil, false
+	}
+
+	s.s.mu.RLock()
+	defer s.s.mu.RUnlock()
+
+	m := s.s.message(with, ref)
+	if m == nil {
+		return nil, true
+	}
+	msg := clone(*m)
+	return &msg, true
+}
+
+// EditMessage replaces the content unless the message is deleted or already at this version (edits) or a later one
+func (s store) EditMessage(ctx context.Context, with, ref, content string, edits int) bool {
+	span, ctx := tracing.Start(ctx, "conversation_manager:edit_message")
+	defer span.End()
+
+	if s.faults.Fails(EditMessage) {
+		return false
+	}
+
+	s.s.mu.Lock()
+	defer s.s.mu.Unlock()
+
+	m := s.s.message(with, ref)
+	if m == nil || m.Deleted || m.Edits >= edits {
 		return true
 	}
 
-	conversation, ok := val.([]domain.Message)
+	s.s.unindexWords(*m)
+	m.Content = content
+	m.Edits = edits
+	s.s.indexWords(*m)
+	return true
+}
+
+// DeleteMessage leaves a tombstone
+func (s store) DeleteMessage(ctx context.Context, with, ref string) bool {
+	span, ctx := tracing.Start(ctx, "conversation_manager:delete_message")
+	defer span.End()
+
+	if s.faults.Fails(DeleteMessage) {
+		return false
+	}
+
+	s.s.mu.Lock()
+	defer s.s.mu.Unlock()
+
+	m := s.s.message(with, ref)
+	if m == nil || m.Deleted {
+		return true
+	}
+
+	s.s.unindexWords(*m)
+	m.Content = ""
+	m.Reactions = nil
+	m.Attachments = nil
+	m.Deleted = true
+	return true
+}
+
+// SetMessageStatus changes the status of the delivery of a message, even deleted
+func (s store) SetMessageStatus(ctx context.Context, with, ref, status string) bool {
+	span, ctx := tracing.Start(ctx, "conversation_manager:set_message_status")
+	defer span.End()
+
+	if s.faults.Fails(SetMessageStatus) {
+		return false
+	}
+
+	s.s.mu.Lock()
+	defer s.s.mu.Unlock()
+
+	if m := s.s.message(with, ref); m != nil {
+		m.Status = status
+	}
+	return true
+}
+
+// SetReaction adds (or removes if on is false) the reaction of a user, a user reacts once with each emoji
+func (s store) SetReaction(ctx context.Context, with, ref, by, emoji string, on bool) bool {
+	span, ctx := tracing.Start(ctx, "conversation_manager:set_reaction")
+	defer span.End()
+
+	if s.faults.Fails(SetReaction) {
+		return false
+	}
+
+	s.s.mu.Lock()
+	defer s.s.mu.Unlock()
+
+	m := s.s.message(with, ref)
+	if m == nil || m.Deleted {
+		return true
+	}
+
+	users := []string{}
+	for _, u := range m.Reactions[emoji] {
+		if u != by {
+			users = append(users, u)
+		}
+	}
+	if on {
+		users = append(users, by)
+		sort.Strings(users)
+	}
+
+	if m.Reactions == nil {
+		m.Reactions = map[string][]string{}
+	}
+	m.Reactions[emoji] = users
+	if len(users) == 0 {
+		delete(m.Reactions, emoji)
+	}
+	return true
+}
+
+// FindAttachment looks for the attachment in the messages of the conversation, the deleted ones have none
+func (s store) FindAttachment(ctx context.Context, with, hash string) (*domain.Attachment, bool) {
+	span, ctx := tracing.Start(ctx, "conversation_manager:find_attachment")
+	defer span.End()
+
+	if s.faults.Fails(FindAttachment) {
+		return nil, false
+	}
+
+	s.s.mu.RLock()
+	defer s.s.mu.RUnlock()
+
+	c, ok := s.s.conversations[with]
 	if !ok {
-		span.LogFields(log.Error(errors.New("not a conversation stored at Key")))
+		return nil, true
+	}
+	for _, m := range c.messages {
+		for _, a := range m.Attachments {
+			if a.Hash == hash {
+				found := a
+				return &found, true
+			}
+		}
+	}
+	return nil, true
+}
+
+// ListConversations sums up the conversations, the most recent first
+func (s store) ListConversations(ctx context.Context) ([]domain.Conversation, bool) {
+	span, ctx := tracing.Start(ctx, "conversation_manager:list_conversations")
+	defer span.End()
+
+	if s.faults.Fails(ListConversations) {
+		return nil, false
+	}
+
+	s.s.mu.RLock()
+	defer s.s.mu.RUnlock()
+
+	conversations := []domain.Conversation{}
+	for with, c := range s.s.conversations {
+		summary := domain.Conversation{With: with, LastMessage: clone(c.messages[len(c.messages)-1])}
+		for i := len(c.messages) - 1; i >= 0 && c.messages[i].ID > c.lastRead; i-- {
+			if c.messages[i].Author == with && !c.readAhead[c.messages[i].Ref] {
+				summary.Unread++
+			}
+		}
+		conversations = append(conversations, summary)
+	}
+
+	// the IDs only tell the order within a conversation since the merged messages are given new ones
+	sort.Slice(conversations, func(i, j int) bool {
+		a, b := conversations[i].LastMessage, conversations[j].LastMessage
+		if !a.Date.Equal(b.Date) {
+			return a.Date.After(b.Date)
+		}
+		return a.ID > b.ID
+	})
+	return conversations, true
+}
+
+// MarkConversationRead marks the messages up to the given ID as read, it never goes back
+func (s store) MarkConversationRead(ctx context.Context, with string, upTo int64) bool {
+	span, ctx := tracing.Start(ctx, "conversation_manager:mark_conversation_read")
+	defer span.End()
+
+	if s.faults.Fails(MarkConversationRead) {
+		return false
+	}
+
+	s.s.mu.Lock()
+	defer s.s.mu.Unlock()
+
+	if c, ok := s.s.conversations[with]; ok && upTo > c.lastRead {
+		c.lastRead = upTo
+	}
+	return true
+}
+
+// SearchMessages returns the messages holding every word of the query (whatever the case), the most recent first,
+// there's no limit if it's 0
+func (s store) SearchMessages(ctx context.Context, query string, limit int) ([]domain.SearchResult, bool) {
+	span, ctx := tracing.Start(ctx, "conversation_manager:search_messages")
+	defer span.End()
+
+	if s.faults.Fails(SearchMessages) {
+		return nil, false
+	}
+
+	s.s.mu.RLock()
+	defer s.s.mu.RUnlock()
+
+	results := []domain.SearchResult{}
+	terms := domain.Words(query)
+	if len(terms) == 0 {
+		return results, true
+	}
+
+	// the rarest word gives the candidates
+	sort.Slice(terms, func(i, j int) bool { return len(s.s.index[terms[i]]) < len(s.s.index[terms[j]]) })
+	ids := []int64{}
+candidates:
+	for id := range s.s.index[terms[0]] {
+		for _, t := range terms[1:] {
+			if _, ok := s.s.index[t][id]; !ok {
+				continue candidates
+			}
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] > ids[j] })
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	for _, id := range ids {
+		ref := s.s.messages[id]
+		results = append(results, domain.SearchResult{
+			With:    ref.with,
+			Message: clone(s.s.conversations[ref.with].messages[ref.index]),
+		})
+	}
+	return results, true
+}
+
+// GetRetention returns the zero retention if none was set
+func (s store) GetRetention(ctx context.Context, with string) (domain.Retention, bool) {
+	span, ctx := tracing.Start(ctx, "conversation_manager:get_retention")
+	defer span.End()
+
+	if s.faults.Fails(GetRetention) {
+		return domain.Retention{}, false
+	}
+
+	s.s.mu.RLock()
+	defer s.s.mu.RUnlock()
+
+	return s.s.retentions[with], true
+}
+
+func (s store) SetRetention(ctx context.Context, with string, r domain.Retention) bool {
+	span, ctx := tracing.Start(ctx, "conversation_manager:set_retention")
+	defer span.End()
+
+	if s.faults.Fails(SetRetention) {
 		return false
 	}
 
-	s.rw.Store(userName, append(conversation, domain.Message{Author: msgAuthor, Content: msgContent}))
+	s.s.mu.Lock()
+	defer s.s.mu.Unlock()
+
+	if r == (domain.Retention{}) {
+		delete(s.s.retentions, with)
+		return true
+	}
+	s.s.retentions[with] = r
 	return true
+}
 
+// PurgeMessages removes the messages stored before the date and the oldest ones beyond keep, the messages without
+// date are only removed by count
+func (s store) PurgeMessages(ctx context.Context, with string, before time.Time, keep int) (int, bool) {
+	span, ctx := tracing.Start(ctx, "conversation_manager:purge_messages")
+	defer span.End()
+
+	if s.faults.Fails(PurgeMessages) {
+		return 0, false
+	}
+
+	s.s.mu.Lock()
+	defer s.s.mu.Unlock()
+
+	c, ok := s.s.conversations[with]
+	if !ok {
+		return 0, true
+	}
+
+	// the IDs increase so the latest messages are the last ones
+	first := 0
+	if keep > 0 && len(c.messages) > keep {
+		first = len(c.messages) - keep
+	}
+
+	kept := make([]domain.Message, 0, len(c.messages)-first)
+	forgotten := []string{}
+	for i, m := range c.messages {
+		if i < first || (!before.IsZero() && !m.Date.IsZero() && m.Date.Before(before)) {
+			s.s.unindexWords(m)
+			delete(s.s.messages, m.ID)
+			forgotten = append(forgotten, m.Ref)
+			delete(c.readAhead, m.Ref)
+			continue
+		}
+		kept = append(kept, m)
+	}
+
+	purged := len(c.messages) - len(kept)
+	if purged == 0 {
+		return 0, true
+	}
+	s.s.forget(with, forgotten, time.Now())
+	if len(kept) == 0 {
+		delete(s.s.conversations, with)
+		return purged, true
+	}
+
+	c.messages = kept
+	c.refs = map[string]int{}
+	for i, m := range kept {
+		if m.Ref != "" {
+			c.refs[m.Ref] = i
+		}
+		s.s.messages[m.ID] = messageRef{with: with, index: i}
+	}
+	return purged, true
+}
+
+// append gives the message the next ID
+func (s *state) append(with string, msg domain.Message) {
+	c, ok := s.conversations[with]
+	if !ok {
+		c = &conversation{refs: map[string]int{}}
+		s.conversations[with] = c
+	}
+
+	s.lastID++
+	msg = clone(msg)
+	msg.ID = s.lastID
+	c.messages = append(c.messages, msg)
+	if msg.Ref != "" {
+		c.refs[msg.Ref] = len(c.messages) - 1
+	}
+
+	s.messages[msg.ID] = messageRef{with: with, index: len(c.messages) - 1}
+	s.indexWords(msg)
+}
+
+// merge inserts the messages whose ref isn't known by date, after the known messages of the same date. The merged
+// conversation takes its IDs and new ones in order, so that they keep increasing. The messages inserted are read, up
+// to the first message of the other user which wasn't read yet
+func (s *state) merge(with string, msgs []domain.Message) int {
+	unknown := []domain.Message{}
+	seen := map[string]bool{}
+	for _, m := range msgs {
+		if m.Ref == "" || seen[m.Ref] || s.known(with, m.Ref) {
+			continue
+		}
+		seen[m.Ref] = true
+		unknown = append(unknown, clone(m))
+	}
+	if len(unknown) == 0 {
+		return 0
+	}
+	sort.SliceStable(unknown, func(i, j int) bool { return unknown[i].Date.Before(unknown[j].Date) })
+
+	c, ok := s.conversations[with]
+	if !ok {
+		c = &conversation{}
+		s.conversations[with] = c
+	}
+
+	ids := make([]int64, 0, len(c.messages)+len(unknown))
+	for _, m := range c.messages {
+		ids = append(ids, m.ID)
+		s.unindexWords(m)
+		delete(s.messages, m.ID)
+	}
+	for range unknown {
+		s.lastID++
+		ids = append(ids, s.lastID)
+	}
+
+	merged := make([]domain.Message, 0, len(ids))
+	// inserted tells the messages merged apart, read the ones read
+	inserted := make([]bool, 0, len(ids))
+	read := make([]bool, 0, len(ids))
+	for k, u := 0, 0; k < len(c.messages) || u < len(unknown); {
+		if u == len(unknown) || (k < len(c.messages) && !c.messages[k].Date.After(unknown[u].Date)) {
+			m := c.messages[k]
+			merged = append(merged, m)
+			inserted = append(inserted, false)
+			read = append(read, m.ID <= c.lastRead || m.Author != with || c.readAhead[m.Ref])
+			k++
+			continue
+		}
+		merged = append(merged, unknown[u])
+		inserted = append(inserted, true)
+		read = append(read, true)
+		u++
+	}
+
+	c.messages = merged
+	c.refs = map[string]int{}
+	if c.readAhead == nil {
+		c.readAhead = map[string]bool{}
+	}
+	// lastRead is moved to the first message not read, the ones merged after it are read ahead
+	unread := false
+	c.lastRead = 0
+	for i := range merged {
+		m := &merged[i]
+		m.ID = ids[i]
+		if m.Ref != "" {
+			c.refs[m.Ref] = i
+		}
+		s.messages[m.ID] = messageRef{with: with, index: i}
+		s.indexWords(*m)
+
+		unread = unread || !read[i]
+		switch {
+		case !unread:
+			c.lastRead = m.ID
+			delete(c.readAhead, m.Ref)
+		case inserted[i]:
+			c.readAhead[m.Ref] = true
+		}
+	}
+	return len(unknown)
+}
+
+// known tells if a message with this ref is in the conversation, or was purged from it within domain.DedupeWindow
+func (s *state) known(with, ref string) bool {
+	if s.message(with, ref) != nil {
+		return true
+	}
+	at, ok := s.purged[with][ref]
+	return ok && time.Since(at) < domain.DedupeWindow
+}
+
+// forget remembers the refs of the purged messages for domain.DedupeWindow, the expired ones are dropped meanwhile
+func (s *state) forget(with string, refs []string, now time.Time) {
+	purged, ok := s.purged[with]
+	if !ok {
+		purged = map[string]time.Time{}
+		s.purged[with] = purged
+	}
+	for ref, at := range purged {
+		if now.Sub(at) >= domain.DedupeWindow {
+			delete(purged, ref)
+		}
+	}
+	for _, ref := range refs {
+		if ref != "" {
+			purged[ref] = now
+		}
+	}
+}
+
+// message returns the stored message, nil if it's unknown
+func (s *state) message(with, ref string) *domain.Message {
+	c, ok := s.conversations[with]
+	if !ok {
+		return nil
+	}
+	i, ok := c.refs[ref]
+	if !ok {
+		return nil
+	}
+	return &c.messages[i]
+}
+
+func (s *state) indexWords(m domain.Message) {
+	for _, w := range domain.Words(m.Content) {
+		if s.index[w] == nil {
+			s.index[w] = map[int64]struct{}{}
+		}
+		s.index[w][m.ID] = struct{}{}
+	}
+}
+
+func (s *state) unindexWords(m domain.Message) {
+	for _, w := range domain.Words(m.Content) {
+		delete(s.index[w], m.ID)
+		if len(s.index[w]) == 0 {
+			delete(s.index, w)
+		}
+	}
+}
+
+// clone copies the reactions and the attachments, the stored messages aren't shared with the callers
+func clone(m domain.Message) domain.Message {
+	if m.Attachments != nil {
+		m.Attachments = append([]domain.Attachment{}, m.Attachments...)
+	}
+	if m.Reactions != nil {
+		reactions := make(map[string][]string, len(m.Reactions))
+		for emoji, users := range m.Reactions {
+			reactions[emoji] = append([]string{}, users...)
+		}
+		m.Reactions = reactions
+	}
+	return m
 }
@@ -0,0 +1,17 @@
+package conversationmanager_test
+
+import (
+	"testing"
+
+	conversationmanager "gop2p/driven/inMem.conversationManager"
+	"gop2p/uc"
+	"gop2p/uc/porttest"
+)
+
+func TestConversationManager(t *testing.T) {
+	porttest.RunConversationManagerSuite(t, func() uc.ConversationManager { return conversationmanager.NewFailable() })
+}
+
+func BenchmarkConversationManager(b *testing.B) {
+	porttest.RunConversationManagerBenchmarks(b, func() uc.ConversationManager { return conversationmanager.New() })
+}
@@ -3,68 +3,126 @@ package sessionmanager
 import (
 	"context"
 	"errors"
-	"github.com/opentracing/opentracing-go"
-	"github.com/opentracing/opentracing-go/log"
 	"gop2p/domain"
+	"gop2p/fault"
+	"gop2p/tracing"
 	"gop2p/uc"
 	"sync"
 )
 
 type store struct {
-	rw            *sync.Map
-	failingMethod string
+	rw *sync.Map
+	// mu serializes the writes, a session is inserted among the others of its user
+	mu     *sync.Mutex
+	faults *fault.Methods
 }
 
 // New is the constructor of this in memory implementation of the uc.SessionManager
 func New() uc.SessionManager {
-	return store{rw: &sync.Map{}}
+	return store{rw: &sync.Map{}, mu: &sync.Mutex{}}
 }
 
+// the methods which can be made to fail
+const (
+	InsertSession fault.Method = "insertSession"
+	GetSessions   fault.Method = "getSessions"
+	ListSessions  fault.Method = "listSessions"
+	DeleteSession fault.Method = "deleteSession"
+)
+
 type FailingSessionManager interface {
 	uc.SessionManager
-	InjectErrorAt(failingMethod string)
+	InjectErrorAt(method fault.Method)
 }
 
 // NewFailable is just for testing purposes
 func NewFailable() FailingSessionManager {
-	return &store{rw: &sync.Map{}, failingMethod: ""}
+	return &store{rw: &sync.Map{}, mu: &sync.Mutex{}, faults: &fault.Methods{}}
 }
 
-func (s *store) InjectErrorAt(failingMethod string) {
-	s.failingMethod = failingMethod
+func (s *store) InjectErrorAt(method fault.Method) {
+	s.faults.Inject(method)
 }
 
-func (s store) InsertSession(ctx context.Context, login, address string) bool {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "session_manager:insert_session")
-	defer span.Finish()
+func (s store) InsertSession(ctx context.Context, login, device, address string, caps domain.Capabilities) bool {
+	span, ctx := tracing.Start(ctx, "session_manager:insert_session")
+	defer span.End()
 
-	if s.failingMethod == "insertSession" {
+	if s.faults.Fails(InsertSession) {
 		return false
 	}
 
-	s.rw.Store(login, domain.Session{Online: true, Address: address})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions, _ := s.load(login)
+	s.rw.Store(login, domain.WithDevice(sessions, domain.Session{Online: true, Address: address, Device: device, Capabilities: caps}))
 	return true
 }
 
-func (s store) GetSession(ctx context.Context, login string) (*domain.Session, bool) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "session_manager:get_session")
-	defer span.Finish()
+func (s store) GetSessions(ctx context.Context, login string) ([]domain.Session, bool) {
+	span, ctx := tracing.Start(ctx, "session_manager:get_sessions")
+	defer span.End()
 
-	if s.failingMethod == "getSession" {
+	if s.faults.Fails(GetSessions) {
 		return nil, false
 	}
 
-	val, ok := s.rw.Load(login)
+	sessions, ok := s.load(login)
 	if !ok {
-		return nil, true
+		span.Error(errors.New("not a session stored at Key"))
 	}
+	return sessions, true
+}
 
-	session, ok := val.(domain.Session)
-	if !ok {
-		err := errors.New("not a session stored at Key")
-		span.LogFields(log.Error(err))
+func (s store) ListSessions(ctx context.Context) (map[string][]domain.Session, bool) {
+	span, ctx := tracing.Start(ctx, "session_manager:list_sessions")
+	defer span.End()
+
+	if s.faults.Fails(ListSessions) {
+		return nil, false
+	}
+
+	sessions := map[string][]domain.Session{}
+	s.rw.Range(func(key, val interface{}) bool {
+		login, isLogin := key.(string)
+		devices, isSession := val.([]domain.Session)
+		if !isLogin || !isSession {
+			span.Error(errors.New("not a session stored at Key"))
+			return true
+		}
+		sessions[login] = append([]domain.Session{}, devices...)
+		return true
+	})
+
+	return sessions, true
+}
+
+func (s store) DeleteSession(ctx context.Context, login string) bool {
+	span, ctx := tracing.Start(ctx, "session_manager:delete_session")
+	defer span.End()
+
+	if s.faults.Fails(DeleteSession) {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rw.Delete(login)
+	return true
+}
+
+// load returns a copy of the sessions of the user, ok is false if something else is stored
+func (s store) load(login string) ([]domain.Session, bool) {
+	val, found := s.rw.Load(login)
+	if !found {
 		return nil, true
 	}
 
-	return &session, true
+	sessions, ok := val.([]domain.Session)
+	if !ok {
+		return nil, false
+	}
+	return append([]domain.Session{}, sessions...), true
 }
@@ -0,0 +1,17 @@
+package sessionmanager_test
+
+import (
+	"testing"
+
+	sessionmanager "gop2p/driven/inMem.sessionManager"
+	"gop2p/uc"
+	"gop2p/uc/porttest"
+)
+
+func TestSessionManager(t *testing.T) {
+	porttest.RunSessionManagerSuite(t, func() uc.SessionManager { return sessionmanager.NewFailable() })
+}
+
+func BenchmarkSessionManager(b *testing.B) {
+	porttest.RunSessionManagerBenchmarks(b, func() uc.SessionManager { return sessionmanager.New() })
+}
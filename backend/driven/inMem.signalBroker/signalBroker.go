@@ -0,0 +1,59 @@
+package signalbroker
+
+import (
+	"context"
+	"gop2p/domain"
+	"gop2p/tracing"
+	"gop2p/uc"
+	"sync"
+)
+
+// buffer is how many signals a subscriber can lag behind before the next ones are dropped for it
+const buffer = 32
+
+type broker struct {
+	mu          sync.Mutex
+	subscribers map[chan domain.Signal]struct{}
+}
+
+// New is the constructor of this in memory implementation of the uc.SignalBroker
+func New() uc.SignalBroker {
+	return &broker{subscribers: map[chan domain.Signal]struct{}{}}
+}
+
+// Publish never blocks, a subscriber too slow misses the signal
+func (b *broker) Publish(ctx context.Context, s domain.Signal) bool {
+	span, ctx := tracing.Start(ctx, "signal_broker:publish")
+	defer span.End()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		select {
+		case sub <- s:
+		default:
+			span.Event("signal dropped")
+		}
+	}
+	return true
+}
+
+func (b *broker) Subscribe(ctx context.Context) (<-chan domain.Signal, bool) {
+	sub := make(chan domain.Signal, buffer)
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		b.mu.Lock()
+		delete(b.subscribers, sub)
+		b.mu.Unlock()
+		close(sub)
+	}()
+
+	return sub, true
+}
@@ -3,16 +3,17 @@ package userstore
 import (
 	"context"
 	"errors"
-	"github.com/opentracing/opentracing-go"
-	"github.com/opentracing/opentracing-go/log"
 	"gop2p/domain"
+	"gop2p/fault"
+	"gop2p/tracing"
 	"gop2p/uc"
+	"sort"
 	"sync"
 )
 
 type store struct {
-	rw            *sync.Map
-	failingMethod string
+	rw     *sync.Map
+	faults *fault.Methods
 }
 
 // New is the constructor of this in memory implementation of the uc.UserStore
@@ -20,24 +21,35 @@ func New() uc.UserStore {
 	return &store{rw: &sync.Map{}}
 }
 
+// the methods which can be made to fail
+const (
+	InsertUser             fault.Method = "insertUser"
+	GetUserByLoginPassword fault.Method = "getUserByLoginPassword"
+	GetUserByLogin         fault.Method = "getUserByLogin"
+	ListUsers              fault.Method = "listUsers"
+	UpdatePassword         fault.Method = "updatePassword"
+	SetUserDisabled        fault.Method = "setUserDisabled"
+	DeleteUser             fault.Method = "deleteUser"
+)
+
 type FailingStore interface {
 	uc.UserStore
-	InjectErrorAt(failingMethod string)
+	InjectErrorAt(method fault.Method)
 }
 
 func NewFailable() FailingStore {
-	return &store{rw: &sync.Map{}, failingMethod: ""}
+	return &store{rw: &sync.Map{}, faults: &fault.Methods{}}
 }
 
-func (s *store) InjectErrorAt(failingMethod string) {
-	s.failingMethod = failingMethod
+func (s *store) InjectErrorAt(method fault.Method) {
+	s.faults.Inject(method)
 }
 
 func (s store) InsertUser(ctx context.Context, login, password string) bool {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "user_store:insert_user")
-	defer span.Finish()
+	span, ctx := tracing.Start(ctx, "user_store:insert_user")
+	defer span.End()
 
-	if s.failingMethod == "insertUser" {
+	if s.faults.Fails(InsertUser) {
 		return false
 	}
 
@@ -47,10 +59,10 @@ func (s store) InsertUser(ctx context.Context, login, password string) bool {
 }
 
 func (s store) GetUserByLoginPassword(ctx context.Context, login, password string) (*domain.User, bool) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "user_store:get_user_by_login_pass")
-	defer span.Finish()
+	span, ctx := tracing.Start(ctx, "user_store:get_user_by_login_pass")
+	defer span.End()
 
-	if s.failingMethod == "getUserByLogicPassword" {
+	if s.faults.Fails(GetUserByLoginPassword) {
 		return nil, false
 	}
 
@@ -61,11 +73,11 @@ func (s store) GetUserByLoginPassword(ctx context.Context, login, password strin
 
 	user, ok := val.(domain.User)
 	if !ok {
-		span.LogFields(log.Error(errors.New("not a user stored at Key")))
+		span.Error(errors.New("not a user stored at Key"))
 		return nil, false
 	}
 	if user.Password != password {
-		span.LogFields(log.Event("passwords don't match"))
+		span.Event("passwords don't match")
 		return nil, true
 	}
 
@@ -73,10 +85,10 @@ func (s store) GetUserByLoginPassword(ctx context.Context, login, password strin
 }
 
 func (s store) GetUserByLogin(ctx context.Context, login string) (*domain.User, bool) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "user_store:get_user_by_login")
-	defer span.Finish()
+	span, ctx := tracing.Start(ctx, "user_store:get_user_by_login")
+	defer span.End()
 
-	if s.failingMethod == "getUserByLogin" {
+	if s.faults.Fails(GetUserByLogin) {
 		return nil, false
 	}
 
@@ -87,9 +99,89 @@ func (s store) GetUserByLogin(ctx context.Context, login string) (*domain.User,
 
 	user, ok := val.(domain.User)
 	if !ok {
-		span.LogFields(log.Error(errors.New("not a user stored at Key")))
+		span.Error(errors.New("not a user stored at Key"))
 		return nil, false
 	}
 
 	return &user, true
 }
+
+func (s store) ListUsers(ctx context.Context) ([]domain.User, bool) {
+	span, ctx := tracing.Start(ctx, "user_store:list_users")
+	defer span.End()
+
+	if s.faults.Fails(ListUsers) {
+		return nil, false
+	}
+
+	users := []domain.User{}
+	ok := true
+	s.rw.Range(func(_, val interface{}) bool {
+		user, isUser := val.(domain.User)
+		if !isUser {
+			span.Error(errors.New("not a user stored at Key"))
+			ok = false
+			return false
+		}
+		users = append(users, user)
+		return true
+	})
+	if !ok {
+		return nil, false
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].Login < users[j].Login })
+	return users, true
+}
+
+func (s store) UpdatePassword(ctx context.Context, login, password string) bool {
+	span, ctx := tracing.Start(ctx, "user_store:update_password")
+	defer span.End()
+
+	if s.faults.Fails(UpdatePassword) {
+		return false
+	}
+
+	return s.update(span, login, func(u *domain.User) { u.Password = password })
+}
+
+func (s store) SetUserDisabled(ctx context.Context, login string, disabled bool) bool {
+	span, ctx := tracing.Start(ctx, "user_store:set_user_disabled")
+	defer span.End()
+
+	if s.faults.Fails(SetUserDisabled) {
+		return false
+	}
+
+	return s.update(span, login, func(u *domain.User) { u.Disabled = disabled })
+}
+
+// update applies f to the user if it exists, updating an unknown user is a no-op
+func (s store) update(span tracing.Span, login string, f func(u *domain.User)) bool {
+	val, ok := s.rw.Load(login)
+	if !ok {
+		return true
+	}
+
+	user, ok := val.(domain.User)
+	if !ok {
+		span.Error(errors.New("not a user stored at Key"))
+		return false
+	}
+
+	f(&user)
+	s.rw.Store(login, user)
+	return true
+}
+
+func (s store) DeleteUser(ctx context.Context, login string) bool {
+	span, ctx := tracing.Start(ctx, "user_store:delete_user")
+	defer span.End()
+
+	if s.faults.Fails(DeleteUser) {
+		return false
+	}
+
+	s.rw.Delete(login)
+	return true
+}
@@ -0,0 +1,17 @@
+package userstore_test
+
+import (
+	"testing"
+
+	userstore "gop2p/driven/inMem.userStore"
+	"gop2p/uc"
+	"gop2p/uc/porttest"
+)
+
+func TestUserStore(t *testing.T) {
+	porttest.RunUserStoreSuite(t, func() uc.UserStore { return userstore.NewFailable() })
+}
+
+func BenchmarkUserStore(b *testing.B) {
+	porttest.RunUserStoreBenchmarks(b, func() uc.UserStore { return userstore.New() })
+}
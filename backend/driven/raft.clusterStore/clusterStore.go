@@ -0,0 +1,483 @@
+package clusterstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gop2p/tracing"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+	"gop2p/domain"
+	mux "gop2p/driving/api.mux"
+	"gop2p/uc"
+)
+
+// raftConfig is the default raft configuration, the tests shorten its timeouts
+var raftConfig = raft.DefaultConfig
+
+const (
+	applyTimeout = 5 * time.Second
+	secretHeader = "X-Cluster-Secret"
+)
+
+// Peer is a member of the cluster, every node must be started with the same list of peers
+type Peer struct {
+	ID string
+	// RaftAddress is where the node replicates its log with the others
+	RaftAddress string
+	// APIAddress is where the node serves the server API, used to forward the writes to the leader
+	APIAddress string
+}
+
+// Config of the local node
+type Config struct {
+	NodeID string
+	Peers  []Peer
+	// DataDir is where the raft log and snapshots are persisted, everything is kept in memory if empty
+	DataDir string
+	// Secret is shared by the nodes to authenticate the forwarded writes
+	Secret string
+	// Transport is used to reach the other nodes' API
+	Transport mux.Transport
+	// RaftTransport replicates the log, a TCP one listening on the node's raft address if nil.
+	// Raft doesn't authenticate its peers: the raft port must only be reachable from the cluster network
+	RaftTransport raft.Transport
+}
+
+// Store is shared by all the nodes of the cluster: writes go through the raft leader
+// and reads are served locally once the node caught up with the leader
+type Store interface {
+	uc.UserStore
+	uc.SessionManager
+
+	// the internal cluster routes are served on the server router under /cluster/
+	http.Handler
+}
+
+type store struct {
+	cfg  Config
+	raft *raft.Raft
+	fsm  *fsm
+	mux  *http.ServeMux
+}
+
+// New starts the local raft node, the cluster is bootstrapped with the configured peers on first start
+func New(cfg Config) (Store, error) {
+	self, ok := peerByID(cfg.Peers, cfg.NodeID)
+	if !ok {
+		return nil, fmt.Errorf("node %q is not in the list of peers", cfg.NodeID)
+	}
+
+	rc := raftConfig()
+	rc.LocalID = raft.ServerID(cfg.NodeID)
+
+	transport := cfg.RaftTransport
+	if transport == nil {
+		tcp, err := newTCPTransport(self.RaftAddress)
+		if err != nil {
+			return nil, err
+		}
+		transport = tcp
+	}
+
+	logs, stable, snaps, err := newRaftStores(cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	hasState, err := raft.HasExistingState(logs, stable, snaps)
+	if err != nil {
+		return nil, err
+	}
+
+	f := newFSM()
+	r, err := raft.NewRaft(rc, f, logs, stable, snaps, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasState {
+		servers := []raft.Server{}
+		for _, p := range cfg.Peers {
+			servers = append(servers, raft.Server{
+				ID:      raft.ServerID(p.ID),
+				Address: raft.ServerAddress(p.RaftAddress),
+			})
+		}
+		// every node bootstraps with the same configuration, raft only keeps the first one to win an election
+		if err := r.BootstrapCluster(raft.Configuration{Servers: servers}).Error(); err != nil && err != raft.ErrCantBootstrap {
+			return nil, err
+		}
+	}
+
+	s := &store{
+		cfg:  cfg,
+		raft: r,
+		fsm:  f,
+	}
+	s.mux = s.routes()
+	return s, nil
+}
+
+func newTCPTransport(address string) (raft.Transport, error) {
+	advertise, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	return raft.NewTCPTransport(":"+port, advertise, 3, 10*time.Second, os.Stderr)
+}
+
+func newRaftStores(dir string) (raft.LogStore, raft.StableStore, raft.SnapshotStore, error) {
+	if dir == "" {
+		inMem := raft.NewInmemStore()
+		return inMem, inMem, raft.NewInmemSnapshotStore(), nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, nil, nil, err
+	}
+	bolt, err := raftboltdb.NewBoltStore(filepath.Join(dir, "raft.db"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	snaps, err := raft.NewFileSnapshotStore(dir, 2, os.Stderr)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return bolt, bolt, snaps, nil
+}
+
+func peerByID(peers []Peer, id string) (Peer, bool) {
+	for _, p := range peers {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return Peer{}, false
+}
+
+func (s *store) leaderAPIAddress() (string, error) {
+	leader := string(s.raft.Leader())
+	if leader == "" {
+		return "", errors.New("no leader elected")
+	}
+	for _, p := range s.cfg.Peers {
+		if p.RaftAddress == leader {
+			return p.APIAddress, nil
+		}
+	}
+	return "", fmt.Errorf("unknown leader %s", leader)
+}
+
+// apply replicates the command, followers forward it to the leader then wait to have applied it
+// themselves so a client reading right after its write on the same node sees it
+func (s *store) apply(ctx context.Context, c command) error {
+	if s.raft.State() == raft.Leader {
+		_, err := s.applyLocally(c)
+		return err
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	index, err := s.callLeader(ctx, "apply", b)
+	if err != nil {
+		return err
+	}
+	return s.waitApplied(index)
+}
+
+func (s *store) applyLocally(c command) (uint64, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return 0, err
+	}
+
+	f := s.raft.Apply(b, applyTimeout)
+	if err := f.Error(); err != nil {
+		return 0, err
+	}
+	if err, ok := f.Response().(error); ok {
+		return 0, err
+	}
+	return f.Index(), nil
+}
+
+// sync makes sure the local state is at least as recent as the leader's one when the read starts
+func (s *store) sync(ctx context.Context) error {
+	if s.raft.State() == raft.Leader {
+		return s.raft.VerifyLeader().Error()
+	}
+
+	index, err := s.callLeader(ctx, "index", nil)
+	if err != nil {
+		return err
+	}
+	return s.waitApplied(index)
+}
+
+func (s *store) waitApplied(index uint64) error {
+	deadline := time.Now().Add(applyTimeout)
+	for s.raft.AppliedIndex() < index {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("index %d not applied in time", index)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return nil
+}
+
+// callLeader calls one of the internal routes on the leader, they all respond the leader's raft index
+func (s *store) callLeader(ctx context.Context, route string, body []byte) (uint64, error) {
+	span, ctx := tracing.Start(ctx, "cluster_store:call_leader_"+route)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, applyTimeout)
+	defer cancel()
+
+	leader, err := s.leaderAPIAddress()
+	if err != nil {
+		span.Error(err)
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.cfg.Transport.URL(leader, "/cluster/"+route), bytes.NewBuffer(body))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(secretHeader, s.cfg.Secret)
+	mux.InjectSpanInReq(ctx, req)
+
+	resp, err := s.cfg.Transport.HTTPClient().Do(req)
+	if err != nil {
+		span.Error(err)
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("leader responded %d", resp.StatusCode)
+		span.Error(err)
+		return 0, err
+	}
+
+	r := indexResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return 0, err
+	}
+	return r.Index, nil
+}
+
+func (s *store) InsertUser(ctx context.Context, login, password string) bool {
+	span, ctx := tracing.Start(ctx, "cluster_store:insert_user")
+	defer span.End()
+
+	// obviously we wouldn't store users with plain-text password in real-life
+	if err := s.apply(ctx, command{Op: opInsertUser, Login: login, Password: password}); err != nil {
+		span.Error(err)
+		return false
+	}
+	return true
+}
+
+func (s *store) GetUserByLoginPassword(ctx context.Context, login, password string) (*domain.User, bool) {
+	span, ctx := tracing.Start(ctx, "cluster_store:get_user_by_login_pass")
+	defer span.End()
+
+	if err := s.sync(ctx); err != nil {
+		span.Error(err)
+		return nil, false
+	}
+
+	user, ok := s.fsm.user(login)
+	if !ok {
+		return nil, true
+	}
+	if user.Password != password {
+		span.Event("passwords don't match")
+		return nil, true
+	}
+	return &user, true
+}
+
+func (s *store) GetUserByLogin(ctx context.Context, login string) (*domain.User, bool) {
+	span, ctx := tracing.Start(ctx, "cluster_store:get_user_by_login")
+	defer span.End()
+
+	if err := s.sync(ctx); err != nil {
+		span.Error(err)
+		return nil, false
+	}
+
+	user, ok := s.fsm.user(login)
+	if !ok {
+		return nil, true
+	}
+	return &user, true
+}
+
+func (s *store) ListUsers(ctx context.Context) ([]domain.User, bool) {
+	span, ctx := tracing.Start(ctx, "cluster_store:list_users")
+	defer span.End()
+
+	if err := s.sync(ctx); err != nil {
+		span.Error(err)
+		return nil, false
+	}
+	return s.fsm.users(), true
+}
+
+func (s *store) UpdatePassword(ctx context.Context, login, password string) bool {
+	span, ctx := tracing.Start(ctx, "cluster_store:update_password")
+	defer span.End()
+
+	if err := s.apply(ctx, command{Op: opUpdatePassword, Login: login, Password: password}); err != nil {
+		span.Error(err)
+		return false
+	}
+	return true
+}
+
+func (s *store) SetUserDisabled(ctx context.Context, login string, disabled bool) bool {
+	span, ctx := tracing.Start(ctx, "cluster_store:set_user_disabled")
+	defer span.End()
+
+	if err := s.apply(ctx, command{Op: opSetUserDisabled, Login: login, Disabled: disabled}); err != nil {
+		span.Error(err)
+		return false
+	}
+	return true
+}
+
+func (s *store) DeleteUser(ctx context.Context, login string) bool {
+	span, ctx := tracing.Start(ctx, "cluster_store:delete_user")
+	defer span.End()
+
+	if err := s.apply(ctx, command{Op: opDeleteUser, Login: login}); err != nil {
+		span.Error(err)
+		return false
+	}
+	return true
+}
+
+func (s *store) InsertSession(ctx context.Context, login, device, address string, caps domain.Capabilities) bool {
+	span, ctx := tracing.Start(ctx, "cluster_store:insert_session")
+	defer span.End()
+
+	if err := s.apply(ctx, command{Op: opInsertSession, Login: login, Device: device, Address: address, Capabilities: caps}); err != nil {
+		span.Error(err)
+		return false
+	}
+	return true
+}
+
+func (s *store) GetSessions(ctx context.Context, login string) ([]domain.Session, bool) {
+	span, ctx := tracing.Start(ctx, "cluster_store:get_sessions")
+	defer span.End()
+
+	if err := s.sync(ctx); err != nil {
+		span.Error(err)
+		return nil, false
+	}
+	return s.fsm.devices(login), true
+}
+
+func (s *store) ListSessions(ctx context.Context) (map[string][]domain.Session, bool) {
+	span, ctx := tracing.Start(ctx, "cluster_store:list_sessions")
+	defer span.End()
+
+	if err := s.sync(ctx); err != nil {
+		span.Error(err)
+		return nil, false
+	}
+	return s.fsm.sessions(), true
+}
+
+func (s *store) DeleteSession(ctx context.Context, login string) bool {
+	span, ctx := tracing.Start(ctx, "cluster_store:delete_session")
+	defer span.End()
+
+	if err := s.apply(ctx, command{Op: opDeleteSession, Login: login}); err != nil {
+		span.Error(err)
+		return false
+	}
+	return true
+}
+
+type indexResponse struct {
+	Index uint64 `json:"index"`
+}
+
+func (s *store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *store) routes() *http.ServeMux {
+	m := http.NewServeMux()
+
+	// a follower forwards its writes to the leader
+	m.HandleFunc("/cluster/apply", s.leaderOnly(func(r *http.Request) (uint64, error) {
+		c := command{}
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			return 0, err
+		}
+		return s.applyLocally(c)
+	}))
+
+	// a follower asks for the index it has to reach before serving a read
+	m.HandleFunc("/cluster/index", s.leaderOnly(func(r *http.Request) (uint64, error) {
+		if err := s.raft.VerifyLeader().Error(); err != nil {
+			return 0, err
+		}
+		return s.raft.AppliedIndex(), nil
+	}))
+
+	return m
+}
+
+func (s *store) leaderOnly(f func(r *http.Request) (uint64, error)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, _ := tracing.StartFromRequest("cluster_store:handle_"+filepath.Base(r.URL.Path), r)
+		defer span.End()
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(s.cfg.Secret)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if s.raft.State() != raft.Leader {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		index, err := f(r)
+		if err != nil {
+			span.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", mux.ApplicationJSON)
+		w.Write([]byte(`{"index":` + strconv.FormatUint(index, 10) + `}`))
+	}
+}
@@ -0,0 +1,62 @@
+package clusterstore_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	clusterstore "gop2p/driven/raft.clusterStore"
+	"gop2p/uc"
+	"gop2p/uc/porttest"
+)
+
+// newSingleNode starts a cluster of one node replicating in memory, and waits for it to be the leader
+func newSingleNode(t *testing.T) clusterstore.Store {
+	addr, transport := raft.NewInmemTransport("")
+	s, err := clusterstore.New(clusterstore.Config{
+		NodeID:        "n1",
+		Peers:         []clusterstore.Peer{{ID: "n1", RaftAddress: string(addr)}},
+		Secret:        "s3cr3t",
+		RaftTransport: transport,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reads fail until a leader is elected
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, ok := s.ListSessions(context.Background()); ok {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no leader elected in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSessionManager(t *testing.T) {
+	porttest.RunSessionManagerSuite(t, func() uc.SessionManager { return newSingleNode(t) })
+}
+
+func TestUserStore(t *testing.T) {
+	porttest.RunUserStoreSuite(t, func() uc.UserStore { return newSingleNode(t) })
+}
+
+func TestClusterRoutes(t *testing.T) {
+	s := newSingleNode(t)
+
+	for secret, code := range map[string]int{"s3cr3t": http.StatusOK, "wrong": http.StatusUnauthorized, "": http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodPost, "/cluster/index", nil)
+		req.Header.Set("X-Cluster-Secret", secret)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != code {
+			t.Errorf("with secret %q, the leader responded %d instead of %d", secret, w.Code, code)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package clusterstore
+
+import (
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// a single node doesn't have to wait for the default heartbeat timeout to elect itself
+func init() {
+	raftConfig = func() *raft.Config {
+		c := raft.DefaultConfig()
+		c.HeartbeatTimeout = 50 * time.Millisecond
+		c.ElectionTimeout = 50 * time.Millisecond
+		c.LeaderLeaseTimeout = 50 * time.Millisecond
+		return c
+	}
+}
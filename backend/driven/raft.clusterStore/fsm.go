@@ -0,0 +1,172 @@
+package clusterstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/raft"
+	"gop2p/domain"
+)
+
+const (
+	opInsertUser      = "insert_user"
+	opUpdatePassword  = "update_password"
+	opSetUserDisabled = "set_user_disabled"
+	opDeleteUser      = "delete_user"
+	opInsertSession   = "insert_session"
+	opDeleteSession   = "delete_session"
+)
+
+// command is what is replicated in the raft log, only the fields needed by Op are set
+type command struct {
+	Op       string `json:"op"`
+	Login    string `json:"login"`
+	Password string `json:"password,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Device   string `json:"device,omitempty"`
+	Disabled bool   `json:"disabled,omitempty"`
+
+	Capabilities domain.Capabilities `json:"capabilities,omitempty"`
+}
+
+// state is the replicated state, every node holds a full copy of it
+type state struct {
+	Users map[string]domain.User `json:"users"`
+	// Devices are the sessions of each user, the snapshots of previous versions only have one per user in Sessions
+	Devices  map[string][]domain.Session `json:"devices"`
+	Sessions map[string]domain.Session   `json:"sessions,omitempty"`
+}
+
+// fsm applies the committed commands to the local copy of the state
+type fsm struct {
+	mu    sync.RWMutex
+	state state
+}
+
+func newFSM() *fsm {
+	return &fsm{state: state{
+		Users:   map[string]domain.User{},
+		Devices: map[string][]domain.Session{},
+	}}
+}
+
+func (f *fsm) Apply(l *raft.Log) interface{} {
+	c := command{}
+	if err := json.Unmarshal(l.Data, &c); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch c.Op {
+	case opInsertUser:
+		f.state.Users[c.Login] = domain.User{Login: c.Login, Password: c.Password}
+	case opUpdatePassword:
+		if u, ok := f.state.Users[c.Login]; ok {
+			u.Password = c.Password
+			f.state.Users[c.Login] = u
+		}
+	case opSetUserDisabled:
+		if u, ok := f.state.Users[c.Login]; ok {
+			u.Disabled = c.Disabled
+			f.state.Users[c.Login] = u
+		}
+	case opDeleteUser:
+		delete(f.state.Users, c.Login)
+	case opInsertSession:
+		s := domain.Session{Online: true, Address: c.Address, Device: c.Device, Capabilities: c.Capabilities}
+		f.state.Devices[c.Login] = domain.WithDevice(f.state.Devices[c.Login], s)
+	case opDeleteSession:
+		delete(f.state.Devices, c.Login)
+	default:
+		return fmt.Errorf("unknown command %q", c.Op)
+	}
+	return nil
+}
+
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	// the state is serialized right away so it can't change while being persisted
+	b, err := json.Marshal(f.state)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot(b), nil
+}
+
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	s := state{}
+	if err := json.NewDecoder(rc).Decode(&s); err != nil {
+		return err
+	}
+	if s.Devices == nil {
+		s.Devices = map[string][]domain.Session{}
+	}
+	for login, session := range s.Sessions {
+		s.Devices[login] = []domain.Session{session}
+	}
+	s.Sessions = nil
+
+	f.mu.Lock()
+	f.state = s
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *fsm) user(login string) (domain.User, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	u, ok := f.state.Users[login]
+	return u, ok
+}
+
+func (f *fsm) users() []domain.User {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	users := []domain.User{}
+	for _, u := range f.state.Users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Login < users[j].Login })
+	return users
+}
+
+func (f *fsm) sessions() map[string][]domain.Session {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	sessions := map[string][]domain.Session{}
+	for login, devices := range f.state.Devices {
+		sessions[login] = append([]domain.Session{}, devices...)
+	}
+	return sessions
+}
+
+func (f *fsm) devices(login string) []domain.Session {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return append([]domain.Session(nil), f.state.Devices[login]...)
+}
+
+type snapshot []byte
+
+func (s snapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := sink.Write(s); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s snapshot) Release() {}
@@ -0,0 +1,232 @@
+package mux
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/go-playground/validator"
+	"gop2p/domain"
+	"gop2p/uc"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// adminAuthenticated only lets the requests bearing the admin token through
+func adminAuthenticated(token string, next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// AdminUser is how users are listed to the operators, without their password
+type AdminUser struct {
+	Login    string `json:"login"`
+	Disabled bool   `json:"disabled"`
+}
+
+// AdminSession is an active session as listed to the operators
+type AdminSession struct {
+	Login   string `json:"login"`
+	Device  string `json:"device,omitempty"`
+	Address string `json:"address"`
+}
+
+// ResetPasswordBody is the body of the expected handleResetPassword request
+type ResetPasswordBody struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// FromJSON is the standard json.Unmarshal method
+func (b *ResetPasswordBody) FromJSON(r io.Reader) error {
+	return json.NewDecoder(r).Decode(b)
+}
+
+// Validate is used to check request validity
+func (b *ResetPasswordBody) Validate() error {
+	return validator.New().Struct(b)
+}
+
+// SetUserDisabledBody is the body of the expected handleSetUserDisabled request
+type SetUserDisabledBody struct {
+	Disabled bool `json:"disabled"`
+}
+
+// FromJSON is the standard json.Unmarshal method
+func (b *SetUserDisabledBody) FromJSON(r io.Reader) error {
+	return json.NewDecoder(r).Decode(b)
+}
+
+func adminUsersHandler(logic uc.AdminLogic) func(w http.ResponseWriter, r *http.Request) {
+	listHandler := handleAdminListUsers(logic)
+	deleteHandler := handleAdminDeleteUser(logic)
+	passwordHandler := handleAdminResetPassword(logic)
+	disabledHandler := handleAdminSetUserDisabled(logic)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := paramAtIndex(r, 3)     // /admin/users/:login
+		attribute := paramAtIndex(r, 4) // /admin/users/:login/:attribute
+
+		switch {
+		case login == "" && r.Method == http.MethodGet:
+			listHandler(w, r)
+
+		case login != "" && attribute == "" && r.Method == http.MethodDelete:
+			deleteHandler(w, r)
+
+		case login != "" && attribute == "password" && r.Method == http.MethodPut:
+			passwordHandler(w, r)
+
+		case login != "" && attribute == "disabled" && r.Method == http.MethodPut:
+			disabledHandler(w, r)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func handleAdminListUsers(logic uc.AdminLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := spanFromReq("http:admin_list_users", r)
+		defer span.End()
+
+		users, err := logic.ListUsers(ctx)
+		if err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+
+		resp := []AdminUser{}
+		for _, u := range users {
+			resp = append(resp, AdminUser{Login: u.Login, Disabled: u.Disabled})
+		}
+		writeJSON(ctx, w, resp)
+	}
+}
+
+func handleAdminDeleteUser(logic uc.AdminLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := spanFromReq("http:admin_delete_user", r)
+		defer span.End()
+
+		if err := logic.DeleteUser(ctx, paramAtIndex(r, 3)); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+		spanHttpOK(span)
+	}
+}
+
+func handleAdminResetPassword(logic uc.AdminLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := spanFromReq("http:admin_reset_password", r)
+		defer span.End()
+
+		b := ResetPasswordBody{}
+		if err := b.FromJSON(r.Body); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+			return
+		}
+
+		if err := b.Validate(); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+			return
+		}
+
+		if err := logic.ResetPassword(ctx, paramAtIndex(r, 3), b.Password); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+		spanHttpOK(span)
+	}
+}
+
+func handleAdminSetUserDisabled(logic uc.AdminLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := spanFromReq("http:admin_set_user_disabled", r)
+		defer span.End()
+
+		b := SetUserDisabledBody{}
+		if err := b.FromJSON(r.Body); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+			return
+		}
+
+		if err := logic.SetUserDisabled(ctx, paramAtIndex(r, 3), b.Disabled); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+		spanHttpOK(span)
+	}
+}
+
+func adminSessionsHandler(logic uc.AdminLogic) func(w http.ResponseWriter, r *http.Request) {
+	listHandler := handleAdminListSessions(logic)
+	revokeHandler := handleAdminRevokeSession(logic)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := paramAtIndex(r, 3) // /admin/sessions/:login
+
+		switch {
+		case login == "" && r.Method == http.MethodGet:
+			listHandler(w, r)
+
+		case login != "" && r.Method == http.MethodDelete:
+			revokeHandler(w, r)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func handleAdminListSessions(logic uc.AdminLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := spanFromReq("http:admin_list_sessions", r)
+		defer span.End()
+
+		sessions, err := logic.ListSessions(ctx)
+		if err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+
+		resp := []AdminSession{}
+		for login, devices := range sessions {
+			for _, s := range devices {
+				resp = append(resp, AdminSession{Login: login, Device: s.Device, Address: s.Address})
+			}
+		}
+		// the devices of a user stay in the order they started
+		sort.SliceStable(resp, func(i, j int) bool { return resp[i].Login < resp[j].Login })
+		writeJSON(ctx, w, resp)
+	}
+}
+
+func handleAdminRevokeSession(logic uc.AdminLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := spanFromReq("http:admin_revoke_session", r)
+		defer span.End()
+
+		if err := logic.RevokeSession(ctx, paramAtIndex(r, 3)); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+		spanHttpOK(span)
+	}
+}
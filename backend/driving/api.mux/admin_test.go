@@ -0,0 +1,114 @@
+package mux_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gop2p/domain"
+	"gop2p/uc"
+
+	. "github.com/smartystreets/goconvey/convey"
+	mux "gop2p/driving/api.mux"
+)
+
+const adminToken = "adminToken"
+
+func TestAdminAuthentication(t *testing.T) {
+	router := mux.ServerRouter{
+		AdminToken: adminToken,
+		Admin: uc.AdminLogic{
+			ListUsers: func(_ context.Context) ([]domain.User, error) {
+				return []domain.User{{Login: "alice", Password: "secret"}}, nil
+			},
+		},
+	}
+
+	Convey("when /admin/users/ is called without token", t,
+		withServer(router, func(s *httptest.Server) {
+			r, err := s.Client().Get(s.URL + "/admin/users/")
+			So(err, ShouldBeNil)
+			itRespondsWithStatus(http.StatusUnauthorized, r)
+		}),
+	)
+
+	Convey("when /admin/users/ is called with the wrong token", t,
+		withServer(router, func(s *httptest.Server) {
+			r := doAdminRequest(s, http.MethodGet, "/admin/users/", "wrong")
+			itRespondsWithStatus(http.StatusUnauthorized, r)
+		}),
+	)
+
+	Convey("when /admin/users/ is called with the right token", t,
+		withServer(router, func(s *httptest.Server) {
+			r := doAdminRequest(s, http.MethodGet, "/admin/users/", adminToken)
+			itRespondsWithStatus(http.StatusOK, r)
+
+			Convey("the users are listed without their password", func() {
+				users := []map[string]interface{}{}
+				So(json.NewDecoder(r.Body).Decode(&users), ShouldBeNil)
+				So(users, ShouldHaveLength, 1)
+				So(users[0]["login"], ShouldEqual, "alice")
+				So(users[0], ShouldNotContainKey, "password")
+			})
+		}),
+	)
+
+	Convey("when no admin token is configured, admin routes are not served", t,
+		withServer(mux.ServerRouter{Admin: router.Admin}, func(s *httptest.Server) {
+			r := doAdminRequest(s, http.MethodGet, "/admin/users/", "")
+			itRespondsWithStatus(http.StatusNotFound, r)
+		}),
+	)
+}
+
+func TestAdminRevokeSession(t *testing.T) {
+	login := "alice"
+
+	Convey("when /admin/sessions/:login is called with a DELETE", t, func() {
+		spy := new(spy)
+		router := mux.ServerRouter{
+			AdminToken: adminToken,
+			Admin: uc.AdminLogic{
+				RevokeSession: func(_ context.Context, l string) error {
+					spy.called++
+					Convey("the usecase is called with the right login", t, func() {
+						So(l, ShouldEqual, login)
+					})
+					return nil
+				},
+			},
+		}
+
+		Convey("then", withServer(router, func(s *httptest.Server) {
+			r := doAdminRequest(s, http.MethodDelete, "/admin/sessions/"+login, adminToken)
+			itRespondsWithStatus(http.StatusOK, r)
+			itRespondsAnEmptyBody(r)
+		}))
+		So(spy.called, ShouldEqual, 1)
+	})
+
+	Convey("when the usecase returns a userNotFound error", t,
+		withServer(mux.ServerRouter{
+			AdminToken: adminToken,
+			Admin: uc.AdminLogic{
+				RevokeSession: func(_ context.Context, _ string) error { return domain.ErrResourceNotFound{} },
+			},
+		}, func(s *httptest.Server) {
+			r := doAdminRequest(s, http.MethodDelete, "/admin/sessions/"+login, adminToken)
+			itRespondsWithStatus(http.StatusUnauthorized, r)
+		}),
+	)
+}
+
+func doAdminRequest(s *httptest.Server, method, path, token string) *http.Response {
+	req, err := http.NewRequest(method, s.URL+path, nil)
+	So(err, ShouldBeNil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	r, err := s.Client().Do(req)
+	So(err, ShouldBeNil)
+	return r
+}
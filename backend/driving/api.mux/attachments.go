@@ -0,0 +1,148 @@
+package mux
+
+import (
+	"errors"
+	"fmt"
+	"gop2p/domain"
+	"gop2p/metrics"
+	"gop2p/tracing"
+	"gop2p/uc"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ApplicationOctetStream is the content type of the attachments
+const ApplicationOctetStream = "application/octet-stream"
+
+func clientFrontAttachmentsHandler(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	handler := handleUploadAttachment(logic)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			handler(w, r)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// handleUploadAttachment stores the body as the content of the file given by the name query parameter
+func handleUploadAttachment(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := tracing.Start(r.Context(), "http:upload_attachment")
+		defer span.End()
+
+		a, err := logic.UploadAttachment(ctx, r.URL.Query().Get("name"), r.Body)
+		if err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+
+		writeJSON(ctx, w, a)
+	}
+}
+
+// handleDownloadAttachment streams an attachment of a conversation, once downloaded from the other user if needed
+func handleDownloadAttachment(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		span, ctx := tracing.Start(r.Context(), "http:download_attachment")
+		defer span.End()
+
+		// /conversations/:user/attachments/:hash
+		a, err := logic.DownloadAttachment(ctx, paramAtIndex(r, 2), paramAtIndex(r, 4))
+		if err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+
+		w.Header().Set("Content-Type", ApplicationOctetStream)
+		w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+
+		for offset := int64(0); offset < a.Size; {
+			chunk, err := logic.ReadAttachment(ctx, a.Hash, offset)
+			if err == nil && len(chunk) == 0 {
+				err = errors.New("the attachment is shorter than expected")
+			}
+			if err != nil {
+				// the headers are already sent, the client sees a truncated body
+				span.Error(err)
+				return
+			}
+			if _, err := w.Write(chunk); err != nil {
+				span.Error(err)
+				return
+			}
+			offset += int64(len(chunk))
+		}
+		spanHttpOK(span)
+	}
+}
+
+// handleGetAttachmentChunk sends a part of an attachment to a peer, the range is bytes=<offset>-
+func handleGetAttachmentChunk(logic uc.ClientP2PLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		span, ctx := spanFromReq("http:get_attachment_chunk", r)
+		defer span.End()
+
+		from := r.Header.Get("user")
+		if from == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		offset, err := rangeOffset(r.Header.Get("Range"))
+		if err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+			return
+		}
+
+		// /attachments/:hash
+		chunk, a, err := logic.ServeAttachmentChunk(ctx, paramAtIndex(r, 2), offset, domain.User{Login: from})
+		if err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+
+		w.Header().Set("Content-Type", ApplicationOctetStream)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(len(chunk))-1, a.Size))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(chunk)
+		metrics.ChunkSent(len(chunk))
+		spanHttpOK(span)
+	}
+}
+
+// rangeOffset parses the start of a bytes=<offset>- range, it's 0 without range
+func rangeOffset(header string) (int64, error) {
+	if header == "" {
+		return 0, nil
+	}
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || !strings.HasSuffix(spec, "-") {
+		return 0, fmt.Errorf("unsupported range %q", header)
+	}
+	offset, err := strconv.ParseInt(strings.TrimSuffix(spec, "-"), 10, 64)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("unsupported range %q", header)
+	}
+	return offset, nil
+}
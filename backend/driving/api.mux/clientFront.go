@@ -1,12 +1,12 @@
 package mux
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"github.com/go-playground/validator"
-	"github.com/opentracing/opentracing-go"
-	"github.com/opentracing/opentracing-go/log"
 	"gop2p/domain"
+	"gop2p/tracing"
 	"gop2p/uc"
 	"io"
 	"net/http"
@@ -14,11 +14,11 @@ import (
 	"net/url"
 )
 
-func clientFrontSessionsHandler(logic uc.ClientFrontLogic, serverAddress *url.URL) func(w http.ResponseWriter, r *http.Request) {
+func clientFrontSessionsHandler(logic uc.ClientFrontLogic, serverAddress *url.URL, t Transport, caps domain.Capabilities, device string) func(w http.ResponseWriter, r *http.Request) {
 	handleSuccessfulRegistration := func(ctx context.Context, username string) func(resp *http.Response) (err error) {
 		return func(resp *http.Response) (err error) {
-			span, ctx := opentracing.StartSpanFromContext(ctx, "post_session_response")
-			defer span.Finish()
+			span, ctx := tracing.Start(ctx, "post_session_response")
+			defer span.End()
 
 			if resp.StatusCode == http.StatusOK {
 				if err := logic.NewSessionRegistered(ctx, username); err != nil {
@@ -27,27 +27,60 @@ func clientFrontSessionsHandler(logic uc.ClientFrontLogic, serverAddress *url.UR
 				spanHttpOK(span)
 				return
 			}
-			span.LogFields(log.Message(resp.Status))
+			span.Event(resp.Status)
 			return
 		}
 	}
 
+	// without central server, the client announces its session by itself
+	if serverAddress == nil {
+		handler := handlePublishSession(logic, caps)
+		return func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodPost:
+				handler(w, r)
+
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		}
+	}
+
+	server := NewNegotiator(t, serverAddress.Host, caps)
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodPost:
-			span := opentracing.GlobalTracer().StartSpan("http:post_session")
-			defer span.Finish()
-			ctx := opentracing.ContextWithSpan(context.Background(), span)
+			span, ctx := tracing.Start(r.Context(), "http:post_session")
+			defer span.End()
+
+			encoding, err := server.Encoding(ctx)
+			if err != nil {
+				span.Error(err)
+				mapDomainErrToHttpCode(ctx, domain.ErrTechnical{}, w)
+				return
+			}
+
+			// the servers of previous versions don't store the capabilities nor the devices
+			if encoding.Version > domain.ProtocolLegacy {
+				if err := withSession(r, caps, device); err != nil {
+					span.Error(err)
+					mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+					return
+				}
+			}
 
 			proxy := httputil.ReverseProxy{
 				Director: func(req *http.Request) {
-					InjectSpanInReq(span, req)
+					InjectSpanInReq(ctx, req)
 					req.Header.Add("X-Forwarded-Host", r.Host)
 					req.Header.Add("X-Origin-Host", r.Host)
-					req.URL.Scheme = "http"
+					req.URL.Path = Prefix(encoding.Version) + req.URL.Path
+					req.URL.Scheme = t.scheme()
 					req.URL.Host = serverAddress.Host
 					req.Host = serverAddress.Host
 				},
+				Transport: t.roundTripper(),
 			}
 
 			// since we just forward the request, we'd like not to access the body so we use the "user" header to get
@@ -61,6 +94,61 @@ func clientFrontSessionsHandler(logic uc.ClientFrontLogic, serverAddress *url.UR
 	}
 }
 
+// withSession adds the capabilities and the device of the client to the session sent to the server
+func withSession(r *http.Request, caps domain.Capabilities, device string) error {
+	fields := map[string]json.RawMessage{}
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		return err
+	}
+
+	c, err := json.Marshal(caps)
+	if err != nil {
+		return err
+	}
+	fields["capabilities"] = c
+
+	d, err := json.Marshal(device)
+	if err != nil {
+		return err
+	}
+	fields["device"] = d
+
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	return nil
+}
+
+func handlePublishSession(logic uc.ClientFrontLogic, caps domain.Capabilities) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := tracing.Start(r.Context(), "http:publish_session")
+		defer span.End()
+
+		// the body is the same as the one sent to the central server, the password is just ignored here
+		b := CreateNewSessionBody{}
+		if err := b.FromJSON(r.Body); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+			return
+		}
+
+		if b.Login == "" || b.Address == "" {
+			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+			return
+		}
+
+		if err := logic.PublishSession(ctx, b.Login, b.Address, caps); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+		spanHttpOK(span)
+	}
+}
+
 func clientFrontMessagessHandler(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
 	handler := handleSendMessageToOtherClient(logic)
 
@@ -75,10 +163,11 @@ func clientFrontMessagessHandler(logic uc.ClientFrontLogic) func(w http.Response
 	}
 }
 
-// PostMessageBody is the body of the expected handleNewMessage request
+// PostMessageBody is the body of the expected handleNewMessage request, the attachments are uploaded first
 type SendNewMessageBody struct {
-	Message string `json:"message" validate:"required"`
-	To      string `json:"to" validate:"required"`
+	Message     string              `json:"message" validate:"required_without=Attachments"`
+	To          string              `json:"to" validate:"required"`
+	Attachments []domain.Attachment `json:"attachments,omitempty"`
 }
 
 // FromJSON is the standard json.Unmarshal method
@@ -93,26 +182,26 @@ func (nS *SendNewMessageBody) Validate() error {
 
 func handleSendMessageToOtherClient(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		span := opentracing.GlobalTracer().StartSpan("http:post_messages")
-		defer span.Finish()
-		ctx := opentracing.ContextWithSpan(context.Background(), span)
+		span, ctx := tracing.Start(r.Context(), "http:post_messages")
+		defer span.End()
 
 		b := SendNewMessageBody{}
 		if err := b.FromJSON(r.Body); err != nil {
-			span.LogFields(log.Error(err))
+			span.Error(err)
 			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
 			return
 		}
 
 		if err := b.Validate(); err != nil {
-			span.LogFields(log.Error(err))
+			span.Error(err)
 			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
 			return
 		}
 
-		if err := logic.SendMessageToOtherClient(ctx, b.To, b.Message); err != nil {
-			span.LogFields(log.Error(err))
+		if err := logic.SendMessageToOtherClient(ctx, b.To, b.Message, b.Attachments); err != nil {
+			span.Error(err)
 			mapDomainErrToHttpCode(ctx, err, w)
+			return
 		}
 		spanHttpOK(span)
 	}
@@ -120,10 +209,52 @@ func handleSendMessageToOtherClient(logic uc.ClientFrontLogic) func(w http.Respo
 
 func clientFrontConversationsHandler(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
 	handler := handleGetConversationWith(logic)
+	listHandler := handleListConversations(logic)
+	messageHandler := clientFrontMessageHandler(logic)
+	reactionHandler := clientFrontReactionHandler(logic)
+	resendHandler := clientFrontResendHandler(logic)
+	attachmentHandler := handleDownloadAttachment(logic)
+	signalHandler := handleSendSignal(logic)
+	exportHandler := handleExportConversation(logic)
+	retentionHandler := handleRetention(logic)
 
 	return func(w http.ResponseWriter, r *http.Request) {
+		// /conversations/:user/messages/:ref/reactions/:emoji, /conversations/:user/messages/:ref/resend,
+		// /conversations/:user/attachments/:hash,
+		// /conversations/:user/signals, /conversations/:user/export and /conversations/:user/retention
+		switch {
+		case paramAtIndex(r, 2) != "" && paramAtIndex(r, 3) == "export" && paramAtIndex(r, 4) == "":
+			exportHandler(w, r)
+			return
+		case paramAtIndex(r, 2) != "" && paramAtIndex(r, 3) == "retention" && paramAtIndex(r, 4) == "":
+			retentionHandler(w, r)
+			return
+		case paramAtIndex(r, 3) == "signals" && paramAtIndex(r, 4) == "":
+			signalHandler(w, r)
+			return
+		case paramAtIndex(r, 3) == "attachments" && paramAtIndex(r, 4) != "" && paramAtIndex(r, 5) == "":
+			attachmentHandler(w, r)
+			return
+		case paramAtIndex(r, 3) == "messages" && paramAtIndex(r, 4) != "" && paramAtIndex(r, 5) == "":
+			messageHandler(w, r)
+			return
+		case paramAtIndex(r, 3) == "messages" && paramAtIndex(r, 5) == "reactions" && paramAtIndex(r, 6) != "":
+			reactionHandler(w, r)
+			return
+		case paramAtIndex(r, 3) == "messages" && paramAtIndex(r, 5) == "resend" && paramAtIndex(r, 6) == "":
+			resendHandler(w, r)
+			return
+		case paramAtIndex(r, 3) != "":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
 		switch r.Method {
 		case http.MethodGet:
+			if paramAtIndex(r, 2) == "" { // /conversations/
+				listHandler(w, r)
+				return
+			}
 			handler(w, r)
 
 		default:
@@ -132,30 +263,201 @@ func clientFrontConversationsHandler(logic uc.ClientFrontLogic) func(w http.Resp
 	}
 }
 
+func clientFrontMessageHandler(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	editHandler := handleEditMessage(logic)
+	deleteHandler := handleDeleteMessage(logic)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			editHandler(w, r)
+
+		case http.MethodDelete:
+			deleteHandler(w, r)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// EditMessageBody is the body of the expected handleEditMessage request
+type EditMessageBody struct {
+	Message string `json:"message" validate:"required"`
+}
+
+// FromJSON is the standard json.Unmarshal method
+func (nS *EditMessageBody) FromJSON(r io.Reader) error {
+	return json.NewDecoder(r).Decode(nS)
+}
+
+// Validate is used to check request validity
+func (nS *EditMessageBody) Validate() error {
+	return validator.New().Struct(nS)
+}
+
+func handleEditMessage(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := tracing.Start(r.Context(), "http:edit_message")
+		defer span.End()
+
+		b := EditMessageBody{}
+		if err := b.FromJSON(r.Body); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+			return
+		}
+
+		if err := b.Validate(); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+			return
+		}
+
+		// /conversations/:user/messages/:ref
+		if err := logic.EditMessage(ctx, paramAtIndex(r, 2), paramAtIndex(r, 4), b.Message); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+		spanHttpOK(span)
+	}
+}
+
+func handleDeleteMessage(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := tracing.Start(r.Context(), "http:delete_message")
+		defer span.End()
+
+		// /conversations/:user/messages/:ref
+		if err := logic.DeleteMessage(ctx, paramAtIndex(r, 2), paramAtIndex(r, 4)); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+		spanHttpOK(span)
+	}
+}
+
+func clientFrontReactionHandler(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		span, ctx := tracing.Start(r.Context(), "http:react_to_message")
+		defer span.End()
+
+		// /conversations/:user/messages/:ref/reactions/:emoji
+		on := r.Method == http.MethodPut
+		if err := logic.ReactToMessage(ctx, paramAtIndex(r, 2), paramAtIndex(r, 4), paramAtIndex(r, 6), on); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+		spanHttpOK(span)
+	}
+}
+
+func clientFrontResendHandler(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		span, ctx := tracing.Start(r.Context(), "http:resend_message")
+		defer span.End()
+
+		// /conversations/:user/messages/:ref/resend
+		if err := logic.ResendMessage(ctx, paramAtIndex(r, 2), paramAtIndex(r, 4)); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+		spanHttpOK(span)
+	}
+}
+
 func handleGetConversationWith(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		span := opentracing.GlobalTracer().StartSpan("http:get_conversations")
-		defer span.Finish()
-		ctx := opentracing.ContextWithSpan(context.Background(), span)
+		span, ctx := tracing.Start(r.Context(), "http:get_conversations")
+		defer span.End()
 
-		with := paramAtIndex(r, 2) // /conversations/:to
+		with := paramAtIndex(r, 2) // /conversations/:to?before=&after=&limit=
 		if with == "" {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		messages, err := logic.GetConversationWith(ctx, with)
+
+		page := domain.Page{}
+		var limit int64
+		for name, v := range map[string]*int64{"before": &page.Before, "after": &page.After, "limit": &limit} {
+			if err := queryInt(r, name, v); err != nil {
+				span.Error(err)
+				mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+				return
+			}
+		}
+		page.Limit = int(limit)
+
+		messages, err := logic.GetConversationWith(ctx, with, page)
+		if err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+
+		if messages == nil {
+			messages = []domain.Message{}
+		}
+		writeJSON(ctx, w, messages)
+		spanHttpOK(span)
+	}
+}
+
+func handleListConversations(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := tracing.Start(r.Context(), "http:list_conversations")
+		defer span.End()
+
+		conversations, err := logic.ListConversations(ctx)
 		if err != nil {
-			span.LogFields(log.Error(err))
+			span.Error(err)
 			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+
+		writeJSON(ctx, w, conversations)
+	}
+}
+
+func clientFrontSearchHandler(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		span, ctx := tracing.Start(r.Context(), "http:search_messages")
+		defer span.End()
+
+		var limit int64
+		if err := queryInt(r, "limit", &limit); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+			return
 		}
 
-		body, err := json.Marshal(messages)
+		results, err := logic.SearchMessages(ctx, r.URL.Query().Get("q"), int(limit))
 		if err != nil {
-			span.LogFields(log.Error(err))
+			span.Error(err)
 			mapDomainErrToHttpCode(ctx, err, w)
+			return
 		}
 
-		w.Write(body)
+		writeJSON(ctx, w, results)
 		spanHttpOK(span)
 	}
 }
@@ -1,19 +1,18 @@
 package mux
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"github.com/go-playground/validator"
-	"github.com/opentracing/opentracing-go"
-	"github.com/opentracing/opentracing-go/log"
 	"gop2p/domain"
+	"gop2p/metrics"
 	"gop2p/uc"
 	"io"
 	"net/http"
 )
 
-func clientp2pHandler(logic uc.ClientP2PLogic) func(w http.ResponseWriter, r *http.Request) {
-	handler := handleMessageReceived(logic)
+func clientp2pHandler(logic uc.ClientP2PLogic, maxBody int64) func(w http.ResponseWriter, r *http.Request) {
+	handler := handleMessageReceived(logic, maxBody)
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
@@ -26,9 +25,45 @@ func clientp2pHandler(logic uc.ClientP2PLogic) func(w http.ResponseWriter, r *ht
 	}
 }
 
-// PostMessageBody is the body of the expected handleNewMessage request
+// verifiedSender only lets through the requests of the user claimed in the header, as checked by the verifier if
+// any. The requests without user are left to the handler
+func verifiedSender(v uc.SenderVerifier, next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	if v == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		from := r.Header.Get("user")
+		if from == "" {
+			next(w, r)
+			return
+		}
+
+		span, ctx := spanFromReq("http:p2p_verify_sender", r)
+		if err := v.VerifySender(ctx, from, r.RemoteAddr); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			span.End()
+			return
+		}
+		span.End()
+		next(w, r)
+	}
+}
+
+// PostMessageBody is the body of the expected handleNewMessage request, it's a new message when there's no type
+// (the peers of previous versions only send the message)
 type PostMessageBody struct {
-	Message string `json:"message" validate:"required"`
+	Message     string              `json:"message"`
+	Ref         string              `json:"ref"`
+	Type        string              `json:"type" validate:"omitempty,oneof=message edit delete reaction retention"`
+	Edits       int                 `json:"edits" validate:"gte=0"`
+	Emoji       string              `json:"emoji"`
+	Removed     bool                `json:"removed"`
+	TTL         int64               `json:"ttl" validate:"gte=0"`
+	Attachments []domain.Attachment `json:"attachments"`
+	// With is only set by the other devices of the user, it's the conversation of the event
+	With string `json:"with"`
 }
 
 // FromJSON is the standard json.Unmarshal method
@@ -38,14 +73,50 @@ func (nS *PostMessageBody) FromJSON(r io.Reader) error {
 
 // Validate is used to check request validity
 func (nS *PostMessageBody) Validate() error {
-	return validator.New().Struct(nS)
+	if err := validator.New().Struct(nS); err != nil {
+		return err
+	}
+
+	switch nS.Type {
+	case "", domain.EventMessage:
+		if nS.Message == "" && len(nS.Attachments) == 0 {
+			return errors.New("the message is missing")
+		}
+	case domain.EventEdit:
+		if nS.Message == "" || nS.Ref == "" || nS.Edits == 0 {
+			return errors.New("an edit needs the ref, the message and the edits")
+		}
+	case domain.EventDelete:
+		if nS.Ref == "" {
+			return errors.New("the ref is missing")
+		}
+	case domain.EventReaction:
+		if nS.Ref == "" || nS.Emoji == "" {
+			return errors.New("a reaction needs the ref and the emoji")
+		}
+	}
+	return nil
+}
+
+// Event is the event sent by the peer
+func (nS *PostMessageBody) Event() domain.Event {
+	return domain.Event{
+		Type:        nS.Type,
+		Ref:         nS.Ref,
+		Content:     nS.Message,
+		Attachments: nS.Attachments,
+		Edits:       nS.Edits,
+		Emoji:       nS.Emoji,
+		Removed:     nS.Removed,
+		TTL:         nS.TTL,
+		With:        nS.With,
+	}
 }
 
-func handleMessageReceived(logic uc.ClientP2PLogic) func(w http.ResponseWriter, r *http.Request) {
+func handleMessageReceived(logic uc.ClientP2PLogic, maxBody int64) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		span := spanFromReq("http:p2p_message_received", r)
-		defer span.Finish()
-		ctx := opentracing.ContextWithSpan(context.Background(), span)
+		span, ctx := spanFromReq("http:p2p_message_received", r)
+		defer span.End()
 
 		from := r.Header.Get("user")
 		if from == "" {
@@ -53,24 +124,60 @@ func handleMessageReceived(logic uc.ClientP2PLogic) func(w http.ResponseWriter,
 			return
 		}
 
+		body, err := requestBody(w, r, maxBody)
+		if err != nil {
+			span.Error(err)
+			mapBodyErrToHttpCode(ctx, err, w)
+			return
+		}
+
 		b := PostMessageBody{}
-		if err := b.FromJSON(r.Body); err != nil {
-			span.LogFields(log.Error(err))
-			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+		if err := b.FromJSON(body); err != nil {
+			span.Error(err)
+			mapBodyErrToHttpCode(ctx, err, w)
 			return
 		}
 
 		if err := b.Validate(); err != nil {
-			span.LogFields(log.Error(err))
+			span.Error(err)
 			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
 			return
 		}
 
-		if err := logic.HandleMessageReceived(ctx, b.Message, domain.User{Login: from}); err != nil {
-			span.LogFields(log.Error(err))
+		if err := logic.HandleMessageReceived(ctx, b.Event(), domain.User{Login: from}); err != nil {
+			span.Error(err)
 			mapDomainErrToHttpCode(ctx, err, w)
+			return
 		}
 
+		metrics.MessageReceived()
 		spanHttpOK(span)
 	}
 }
+
+func handleGetHistory(logic uc.ClientP2PLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := spanFromReq("http:p2p_get_history", r)
+		defer span.End()
+
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		from := r.Header.Get("user")
+		if from == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		histories, err := logic.ServeHistory(ctx, domain.User{Login: from})
+		if err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+
+		writeJSON(ctx, w, histories)
+	}
+}
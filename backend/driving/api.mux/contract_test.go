@@ -0,0 +1,318 @@
+package mux_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	. "github.com/smartystreets/goconvey/convey"
+	"gop2p/api"
+	"gop2p/domain"
+	mux "gop2p/driving/api.mux"
+	"gop2p/uc"
+)
+
+// contractCase is a request sent to a router, its response must match the OpenAPI document.
+// Malformed requests are not validated against the document since they're not supposed to
+type contractCase struct {
+	method    string
+	path      string
+	header    map[string]string
+	body      string
+	malformed bool
+}
+
+type frontLogicStub struct{}
+
+func (frontLogicStub) NewSessionRegistered(context.Context, string) error { return nil }
+func (frontLogicStub) PublishSession(context.Context, string, string, domain.Capabilities) error {
+	return nil
+}
+func (frontLogicStub) SendMessageToOtherClient(context.Context, string, string, []domain.Attachment) error {
+	return nil
+}
+func (frontLogicStub) GetConversationWith(context.Context, string, domain.Page) ([]domain.Message, error) {
+	return []domain.Message{
+		{ID: 1, Ref: "a1", Author: "bob", Content: "hi", Edits: 1, Reactions: map[string][]string{"👍": {"alice"}}},
+		{ID: 2, Ref: "b2", Author: "alice", Deleted: true},
+	}, nil
+}
+func (frontLogicStub) ListConversations(context.Context) ([]domain.Conversation, error) {
+	return []domain.Conversation{{With: "bob", LastMessage: domain.Message{ID: 1, Author: "bob", Content: "hi"}, Unread: 1}}, nil
+}
+func (frontLogicStub) SearchMessages(context.Context, string, int) ([]domain.SearchResult, error) {
+	return []domain.SearchResult{{With: "bob", Message: domain.Message{ID: 1, Author: "bob", Content: "hi"}}}, nil
+}
+func (frontLogicStub) ExportConversations(_ context.Context, with string) ([]domain.History, error) {
+	if with == "" {
+		with = "bob"
+	}
+	return []domain.History{{With: with, Messages: []domain.Message{{ID: 1, Ref: "a1", Author: with, Content: "hi"}}}}, nil
+}
+func (frontLogicStub) ImportConversations(_ context.Context, histories []domain.History) (int, error) {
+	return len(histories), nil
+}
+func (frontLogicStub) GetRetention(context.Context, string) (domain.Retention, error) {
+	return domain.Retention{Days: 30, TTL: 3600}, nil
+}
+func (frontLogicStub) SetRetention(context.Context, string, domain.Retention) error { return nil }
+func (frontLogicStub) HistoryEncryption(context.Context) (domain.Encryption, error) {
+	return domain.Encryption{Encrypted: true, Locked: true}, nil
+}
+func (frontLogicStub) UnlockHistory(context.Context, string) error               { return nil }
+func (frontLogicStub) LockHistory(context.Context) error                         { return nil }
+func (frontLogicStub) ChangeHistorySecret(context.Context, string, string) error { return nil }
+func (frontLogicStub) RotateHistoryKey(context.Context) error                    { return nil }
+func (frontLogicStub) EditMessage(context.Context, string, string, string) error { return nil }
+func (frontLogicStub) DeleteMessage(context.Context, string, string) error       { return nil }
+func (frontLogicStub) ReactToMessage(context.Context, string, string, string, bool) error {
+	return nil
+}
+func (frontLogicStub) ResendMessage(context.Context, string, string) error { return nil }
+func (frontLogicStub) FlushOutbox(context.Context, time.Time) (int, error) { return 0, nil }
+func (frontLogicStub) UploadAttachment(_ context.Context, name string, _ io.Reader) (*domain.Attachment, error) {
+	return &domain.Attachment{Hash: attachment.Hash, Name: name, Size: attachment.Size}, nil
+}
+func (frontLogicStub) DownloadAttachment(context.Context, string, string) (*domain.Attachment, error) {
+	return &attachment, nil
+}
+func (frontLogicStub) ReadAttachment(_ context.Context, _ string, offset int64) ([]byte, error) {
+	return []byte(attachmentContent)[offset:], nil
+}
+
+func (frontLogicStub) SendSignal(context.Context, string, string, string) error { return nil }
+
+// Signals is a stream ending after its first signal
+func (frontLogicStub) Signals(context.Context) (<-chan domain.Signal, error) {
+	signals := make(chan domain.Signal, 1)
+	signals <- domain.Signal{Type: domain.SignalTyping, With: "bob"}
+	close(signals)
+	return signals, nil
+}
+
+const attachmentContent = "hello"
+
+var attachment = domain.Attachment{Hash: domain.ContentHash([]byte(attachmentContent)), Name: "hello.txt", Size: int64(len(attachmentContent))}
+
+type p2pLogicStub struct{}
+
+func (p2pLogicStub) HandleMessageReceived(context.Context, domain.Event, domain.User) error {
+	return nil
+}
+func (p2pLogicStub) HandleSignalReceived(context.Context, domain.Signal, domain.User) error {
+	return nil
+}
+
+func (p2pLogicStub) ServeHistory(context.Context, domain.User) ([]domain.History, error) {
+	return []domain.History{{With: "bob", Messages: []domain.Message{{Ref: "a1", Author: "bob", Content: "hi"}}}}, nil
+}
+
+func (p2pLogicStub) ServeAttachmentChunk(_ context.Context, _ string, offset int64, _ domain.User) ([]byte, *domain.Attachment, error) {
+	return []byte(attachmentContent)[offset:], &attachment, nil
+}
+
+func TestServerContract(t *testing.T) {
+	auth := map[string]string{"Authorization": "Bearer " + adminToken}
+	router := mux.ServerRouter{
+		AdminToken: adminToken,
+		Logic: uc.ServerLogic{
+			StartSession: func(context.Context, string, string, string, string, domain.Capabilities) error { return nil },
+			ProvideUserSession: func(context.Context, string, string) ([]domain.Session, error) {
+				return []domain.Session{
+					{Online: true, Device: "laptop", Address: "bob:4000", Capabilities: domain.Supported()},
+					{Online: true, Device: "phone", Address: "bob-phone:4000", Capabilities: domain.Supported()},
+				}, nil
+			},
+		},
+		Admin: uc.AdminLogic{
+			ListUsers:       func(context.Context) ([]domain.User, error) { return []domain.User{{Login: "bob"}}, nil },
+			ResetPassword:   func(context.Context, string, string) error { return nil },
+			SetUserDisabled: func(context.Context, string, bool) error { return nil },
+			DeleteUser:      func(context.Context, string) error { return nil },
+			ListSessions: func(context.Context) (map[string][]domain.Session, error) {
+				return map[string][]domain.Session{"bob": {{Online: true, Device: "laptop", Address: "bob:4000"}}}, nil
+			},
+			RevokeSession: func(context.Context, string) error { return nil },
+		},
+	}
+
+	checkContract(t, "server", api.ServerSpec, router, []contractCase{
+		{method: http.MethodGet, path: "/healthz"},
+		{method: http.MethodGet, path: "/metrics"},
+		{method: http.MethodGet, path: "/openapi.json"},
+		{method: http.MethodGet, path: "/v1/capabilities"},
+		{method: http.MethodPost, path: "/v1/sessions/", body: `{"login":"bob","password":"pass","device":"laptop","address":"bob:4000","capabilities":{"versions":[1,0],"features":["gzip"]}}`},
+		{method: http.MethodPost, path: "/v1/sessions/", body: `{"login":"bob"}`, malformed: true},
+		{method: http.MethodGet, path: "/v1/sessions/bob", header: map[string]string{"user": "alice"}},
+		{method: http.MethodGet, path: "/v1/sessions/bob", malformed: true},
+		{method: http.MethodGet, path: "/v1/admin/users/", header: auth},
+		{method: http.MethodGet, path: "/v1/admin/users/", malformed: true},
+		{method: http.MethodDelete, path: "/v1/admin/users/bob", header: auth},
+		{method: http.MethodPut, path: "/v1/admin/users/bob/password", header: auth, body: `{"password":"new"}`},
+		{method: http.MethodPut, path: "/v1/admin/users/bob/disabled", header: auth, body: `{"disabled":true}`},
+		{method: http.MethodGet, path: "/v1/admin/sessions/", header: auth},
+		{method: http.MethodDelete, path: "/v1/admin/sessions/bob", header: auth},
+	})
+}
+
+func TestFrontContract(t *testing.T) {
+	// the stream and the exports are plain text to the spec
+	openapi3filter.RegisterBodyDecoder(mux.TextEventStream, openapi3filter.FileBodyDecoder)
+	openapi3filter.RegisterBodyDecoder(mux.ApplicationJSONLines, openapi3filter.FileBodyDecoder)
+	jsonLines := map[string]string{"Content-Type": mux.ApplicationJSONLines}
+	checkContract(t, "client front", api.FrontSpec, mux.ClientFrontRouter{Logic: frontLogicStub{}, Capabilities: domain.Supported()}, []contractCase{
+		{method: http.MethodGet, path: "/healthz"},
+		{method: http.MethodGet, path: "/metrics"},
+		{method: http.MethodGet, path: "/openapi.json"},
+		{method: http.MethodGet, path: "/v1/capabilities"},
+		{method: http.MethodPost, path: "/v1/sessions/", body: `{"login":"bob","password":"pass","address":"bob:4000"}`},
+		{method: http.MethodPost, path: "/v1/messages/", body: `{"to":"alice","message":"hi"}`},
+		{method: http.MethodPost, path: "/v1/messages/", body: `{"to":"alice","attachments":[{"hash":"` + attachment.Hash + `","name":"hello.txt","size":5}]}`},
+		{method: http.MethodPost, path: "/v1/messages/", body: `{"to":"alice"}`, malformed: true},
+		{method: http.MethodPost, path: "/v1/attachments/?name=hello.txt", header: map[string]string{"Content-Type": "application/octet-stream"}, body: attachmentContent},
+		{method: http.MethodGet, path: "/v1/conversations/alice/attachments/" + attachment.Hash},
+		{method: http.MethodGet, path: "/v1/conversations/"},
+		{method: http.MethodGet, path: "/v1/conversations/alice"},
+		{method: http.MethodGet, path: "/v1/conversations/alice?before=10&limit=20"},
+		{method: http.MethodGet, path: "/v1/conversations/alice?after=abc", malformed: true},
+		{method: http.MethodPut, path: "/v1/conversations/alice/messages/a1", body: `{"message":"hi!"}`},
+		{method: http.MethodPut, path: "/v1/conversations/alice/messages/a1", body: `{}`, malformed: true},
+		{method: http.MethodDelete, path: "/v1/conversations/alice/messages/a1"},
+		{method: http.MethodPut, path: "/v1/conversations/alice/messages/a1/reactions/%F0%9F%91%8D"},
+		{method: http.MethodDelete, path: "/v1/conversations/alice/messages/a1/reactions/%F0%9F%91%8D"},
+		{method: http.MethodPost, path: "/v1/conversations/alice/messages/a1/resend"},
+		{method: http.MethodGet, path: "/v1/search?q=hi&limit=10"},
+		{method: http.MethodGet, path: "/v1/search?limit=abc", malformed: true},
+		{method: http.MethodPost, path: "/v1/conversations/alice/signals", body: `{"type":"typing"}`},
+		{method: http.MethodPost, path: "/v1/conversations/alice/signals", body: `{"type":"updated"}`, malformed: true},
+		{method: http.MethodGet, path: "/v1/events"},
+		{method: http.MethodGet, path: "/v1/conversations/alice/export"},
+		{method: http.MethodGet, path: "/v1/export"},
+		{method: http.MethodPost, path: "/v1/import", header: jsonLines, body: `{"with":"alice","message":{"Ref":"a1","Author":"alice","Content":"hi"}}` + "\n"},
+		{method: http.MethodPost, path: "/v1/import", header: jsonLines, body: `{"with":"alice",`, malformed: true},
+		{method: http.MethodGet, path: "/v1/conversations/alice/retention"},
+		{method: http.MethodPut, path: "/v1/conversations/alice/retention", body: `{"messages":1000,"ttl":86400}`},
+		{method: http.MethodPut, path: "/v1/conversations/alice/retention", body: `{"days":`, malformed: true},
+		{method: http.MethodGet, path: "/v1/encryption"},
+		{method: http.MethodPost, path: "/v1/encryption/unlock", body: `{"secret":"passphrase"}`},
+		{method: http.MethodPost, path: "/v1/encryption/unlock", body: `{}`, malformed: true},
+		{method: http.MethodPost, path: "/v1/encryption/lock"},
+		{method: http.MethodPut, path: "/v1/encryption/secret", body: `{"current":"passphrase","secret":"another"}`},
+		{method: http.MethodPost, path: "/v1/encryption/rotate"},
+	})
+}
+
+func TestP2PContract(t *testing.T) {
+	checkContract(t, "client p2p", api.P2PSpec, mux.ClientP2pRouter{Logic: p2pLogicStub{}, Capabilities: domain.Supported()}, []contractCase{
+		{method: http.MethodGet, path: "/healthz"},
+		{method: http.MethodGet, path: "/metrics"},
+		{method: http.MethodGet, path: "/openapi.json"},
+		{method: http.MethodGet, path: "/v1/capabilities"},
+		{method: http.MethodPost, path: "/v1/messages/", header: map[string]string{"user": "alice"}, body: `{"message":"hi"}`},
+		{method: http.MethodPost, path: "/v1/messages/", header: map[string]string{"user": "alice"}, body: `{"type":"edit","ref":"a1","message":"hi!","edits":1}`},
+		{method: http.MethodPost, path: "/v1/messages/", header: map[string]string{"user": "alice"}, body: `{"type":"reaction","ref":"a1","emoji":"👍","removed":true}`},
+		{method: http.MethodPost, path: "/v1/messages/", header: map[string]string{"user": "alice"}, body: `{"type":"retention","ttl":3600}`},
+		{method: http.MethodPost, path: "/v1/messages/", header: map[string]string{"user": "alice"}, body: `{"type":"retention","ttl":-1}`, malformed: true},
+		{method: http.MethodPost, path: "/v1/messages/", header: map[string]string{"user": "alice"}, body: `{"type":"delete"}`, malformed: true},
+		{method: http.MethodPost, path: "/v1/messages/", header: map[string]string{"user": "alice"}, body: `{"ref":"a2","attachments":[{"hash":"` + attachment.Hash + `","name":"hello.txt","size":5}]}`},
+		{method: http.MethodGet, path: "/v1/attachments/" + attachment.Hash, header: map[string]string{"user": "alice", "Range": "bytes=2-"}},
+		{method: http.MethodGet, path: "/v1/attachments/" + attachment.Hash, header: map[string]string{"user": "alice", "Range": "bytes=-2"}, malformed: true},
+		{method: http.MethodPost, path: "/v1/signals/", header: map[string]string{"user": "alice"}, body: `{"type":"seen","ref":"a1"}`},
+		{method: http.MethodPost, path: "/v1/signals/", header: map[string]string{"user": "alice"}, body: `{"type":"updated"}`, malformed: true},
+		{method: http.MethodPost, path: "/v1/messages/", header: map[string]string{"user": "bob"}, body: `{"ref":"a3","message":"hi","with":"carol"}`},
+		{method: http.MethodGet, path: "/v1/history", header: map[string]string{"user": "bob"}},
+		{method: http.MethodGet, path: "/v1/history", malformed: true},
+		{method: http.MethodPost, path: "/v1/messages/", body: `{"message":"hi"}`, malformed: true},
+	})
+}
+
+// checkContract sends the cases to the router and validates them against the spec,
+// every operation of the spec must be covered so that a route can't be documented without being served
+func checkContract(t *testing.T, name string, spec []byte, router interface{ SetRoutes(*http.ServeMux) }, cases []contractCase) {
+	ctx := context.Background()
+
+	doc, err := openapi3.NewLoader().LoadFromData(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Validate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	specRouter, err := legacy.NewRouter(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := http.NewServeMux()
+	router.SetRoutes(m)
+	s := httptest.NewServer(m)
+	defer s.Close()
+
+	options := &openapi3filter.Options{
+		IncludeResponseStatus: true,
+		AuthenticationFunc:    openapi3filter.NoopAuthenticationFunc,
+	}
+	covered := map[*openapi3.Operation]bool{}
+
+	for _, c := range cases {
+		Convey("the "+name+" router answers "+c.method+" "+c.path+" as documented", t, func() {
+			req, err := http.NewRequest(c.method, s.URL+c.path, strings.NewReader(c.body))
+			So(err, ShouldBeNil)
+			for k, v := range c.header {
+				req.Header.Set(k, v)
+			}
+			if c.body != "" && req.Header.Get("Content-Type") == "" {
+				req.Header.Set("Content-Type", mux.ApplicationJSON)
+			}
+
+			route, pathParams, err := specRouter.FindRoute(req)
+			So(err, ShouldBeNil)
+			covered[route.Operation] = true
+
+			input := &openapi3filter.RequestValidationInput{Request: req, PathParams: pathParams, Route: route, Options: options}
+			if !c.malformed {
+				So(openapi3filter.ValidateRequest(ctx, input), ShouldBeNil)
+			}
+			req.Body = io.NopCloser(strings.NewReader(c.body))
+
+			resp, err := s.Client().Do(req)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+
+			responseInput := &openapi3filter.ResponseValidationInput{
+				RequestValidationInput: input,
+				Status:                 resp.StatusCode,
+				Header:                 resp.Header,
+				Options:                options,
+			}
+			responseInput.SetBodyBytes(body)
+			So(openapi3filter.ValidateResponse(ctx, responseInput), ShouldBeNil)
+
+			if c.malformed {
+				So(resp.StatusCode, ShouldBeBetweenOrEqual, http.StatusBadRequest, http.StatusUnauthorized)
+			} else {
+				So(resp.StatusCode, ShouldBeIn, http.StatusOK, http.StatusPartialContent)
+			}
+		})
+	}
+
+	Convey("every operation of the "+name+" router is covered by the contract tests", t, func() {
+		for path, item := range doc.Paths {
+			for method, op := range item.Operations() {
+				So(covered[op], ShouldBeTrue)
+				if !covered[op] {
+					t.Logf("%s %s is not covered", method, path)
+				}
+			}
+		}
+	})
+}
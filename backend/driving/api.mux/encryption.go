@@ -0,0 +1,173 @@
+package mux
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/go-playground/validator"
+	"gop2p/domain"
+	"gop2p/tracing"
+	"gop2p/uc"
+)
+
+// UnlockHistoryBody is the body of the expected handleUnlockHistory request
+type UnlockHistoryBody struct {
+	Secret string `json:"secret" validate:"required"`
+}
+
+// FromJSON is the standard json.Unmarshal method
+func (b *UnlockHistoryBody) FromJSON(r io.Reader) error {
+	return json.NewDecoder(r).Decode(b)
+}
+
+// Validate is used to check request validity
+func (b *UnlockHistoryBody) Validate() error {
+	return validator.New().Struct(b)
+}
+
+// ChangeHistorySecretBody is the body of the expected handleChangeHistorySecret request
+type ChangeHistorySecretBody struct {
+	Current string `json:"current" validate:"required"`
+	Secret  string `json:"secret" validate:"required"`
+}
+
+// FromJSON is the standard json.Unmarshal method
+func (b *ChangeHistorySecretBody) FromJSON(r io.Reader) error {
+	return json.NewDecoder(r).Decode(b)
+}
+
+// Validate is used to check request validity
+func (b *ChangeHistorySecretBody) Validate() error {
+	return validator.New().Struct(b)
+}
+
+// clientFrontEncryptionHandler serves /encryption and /encryption/:action
+func clientFrontEncryptionHandler(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	statusHandler := handleHistoryEncryption(logic)
+	unlockHandler := handleUnlockHistory(logic)
+	lockHandler := handleLockHistory(logic)
+	secretHandler := handleChangeHistorySecret(logic)
+	rotateHandler := handleRotateHistoryKey(logic)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		action := paramAtIndex(r, 2)
+
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+			statusHandler(w, r)
+
+		case action == "unlock" && r.Method == http.MethodPost:
+			unlockHandler(w, r)
+
+		case action == "lock" && r.Method == http.MethodPost:
+			lockHandler(w, r)
+
+		case action == "secret" && r.Method == http.MethodPut:
+			secretHandler(w, r)
+
+		case action == "rotate" && r.Method == http.MethodPost:
+			rotateHandler(w, r)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func handleHistoryEncryption(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := tracing.Start(r.Context(), "http:history_encryption")
+		defer span.End()
+
+		e, err := logic.HistoryEncryption(ctx)
+		if err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+
+		writeJSON(ctx, w, e)
+		spanHttpOK(span)
+	}
+}
+
+func handleUnlockHistory(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := tracing.Start(r.Context(), "http:unlock_history")
+		defer span.End()
+
+		b := UnlockHistoryBody{}
+		if err := b.FromJSON(r.Body); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+			return
+		}
+		if err := b.Validate(); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+			return
+		}
+
+		if err := logic.UnlockHistory(ctx, b.Secret); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+		spanHttpOK(span)
+	}
+}
+
+func handleLockHistory(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := tracing.Start(r.Context(), "http:lock_history")
+		defer span.End()
+
+		if err := logic.LockHistory(ctx); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+		spanHttpOK(span)
+	}
+}
+
+func handleChangeHistorySecret(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := tracing.Start(r.Context(), "http:change_history_secret")
+		defer span.End()
+
+		b := ChangeHistorySecretBody{}
+		if err := b.FromJSON(r.Body); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+			return
+		}
+		if err := b.Validate(); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+			return
+		}
+
+		if err := logic.ChangeHistorySecret(ctx, b.Current, b.Secret); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+		spanHttpOK(span)
+	}
+}
+
+func handleRotateHistoryKey(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := tracing.Start(r.Context(), "http:rotate_history_key")
+		defer span.End()
+
+		if err := logic.RotateHistoryKey(ctx); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+		spanHttpOK(span)
+	}
+}
@@ -0,0 +1,139 @@
+package mux
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"gop2p/domain"
+	"gop2p/tracing"
+	"gop2p/uc"
+)
+
+// ApplicationJSONLines is the content-type of the exports, a domain.ExportedMessage per line
+const ApplicationJSONLines = "application/x-ndjson"
+
+// ImportResult is the response of an import
+type ImportResult struct {
+	// Imported is how many messages weren't known yet
+	Imported int `json:"imported"`
+}
+
+// handleExportConversation streams the whole conversation with a user, /conversations/:user/export
+func handleExportConversation(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		span, ctx := tracing.Start(r.Context(), "http:export_conversation")
+		defer span.End()
+
+		with := paramAtIndex(r, 2)
+		histories, err := logic.ExportConversations(ctx, with)
+		if err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+
+		writeJSONLines(ctx, w, with+".jsonl", histories)
+		spanHttpOK(span)
+	}
+}
+
+// clientFrontExportHandler streams every conversation, /export
+func clientFrontExportHandler(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		span, ctx := tracing.Start(r.Context(), "http:export_conversations")
+		defer span.End()
+
+		histories, err := logic.ExportConversations(ctx, "")
+		if err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+
+		writeJSONLines(ctx, w, "conversations.jsonl", histories)
+		spanHttpOK(span)
+	}
+}
+
+// clientFrontImportHandler restores an export, whether of a conversation or of all of them, /import
+func clientFrontImportHandler(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		span, ctx := tracing.Start(r.Context(), "http:import_conversations")
+		defer span.End()
+
+		histories, err := readJSONLines(r.Body)
+		if err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+			return
+		}
+
+		imported, err := logic.ImportConversations(ctx, histories)
+		if err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+
+		writeJSON(ctx, w, ImportResult{Imported: imported})
+		spanHttpOK(span)
+	}
+}
+
+// writeJSONLines writes a line per message, the browsers save it under filename
+func writeJSONLines(ctx context.Context, w http.ResponseWriter, filename string, histories []domain.History) {
+	w.Header().Set("Content-Type", ApplicationJSONLines)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+
+	enc := json.NewEncoder(w)
+	for _, h := range histories {
+		for _, m := range h.Messages {
+			if err := enc.Encode(domain.ExportedMessage{With: h.With, Message: m}); err != nil {
+				// the headers are already sent, the client sees a truncated export
+				tracing.FromContext(ctx).Error(err)
+				return
+			}
+		}
+	}
+}
+
+// readJSONLines groups the consecutive messages of the same conversation
+func readJSONLines(r io.Reader) ([]domain.History, error) {
+	histories := []domain.History{}
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var m domain.ExportedMessage
+		err := dec.Decode(&m)
+		if err == io.EOF {
+			return histories, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", line, err)
+		}
+
+		if last := len(histories) - 1; last >= 0 && histories[last].With == m.With {
+			histories[last].Messages = append(histories[last].Messages, m.Message)
+			continue
+		}
+		histories = append(histories, domain.History{With: m.With, Messages: []domain.Message{m.Message}})
+	}
+}
@@ -0,0 +1,64 @@
+package mux
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"gop2p/logging"
+	"gop2p/metrics"
+)
+
+// statusRecorder keeps the status written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets the handlers stream their response
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// instrument counts, times and logs every request of the router by route pattern and status,
+// the handlers get a logger stamped with the route (and the login when the caller tells it) in the request context
+func instrument(router string, m *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := m.Handler(r)
+		if route == "" {
+			route = "unknown"
+		}
+
+		ctx := logging.With(r.Context(), "router", router, "route", route, "method", r.Method)
+		if login := r.Header.Get("user"); login != "" {
+			ctx = logging.WithLogin(ctx, login)
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		m.ServeHTTP(rec, r.WithContext(ctx))
+		elapsed := time.Since(start)
+
+		metrics.ObserveRequest(router, route, r.Method, rec.status, elapsed)
+		logRequest(ctx, rec.status, elapsed)
+	})
+}
+
+// logRequest only logs the successful requests at debug level, the clients poll a lot
+func logRequest(ctx context.Context, status int, elapsed time.Duration) {
+	switch {
+	case status >= http.StatusInternalServerError:
+		logging.Error(ctx, "request failed", "status", status, "duration", elapsed)
+	case status >= http.StatusBadRequest:
+		logging.Warn(ctx, "request rejected", "status", status, "duration", elapsed)
+	default:
+		logging.Debug(ctx, "request handled", "status", status, "duration", elapsed)
+	}
+}
@@ -0,0 +1,39 @@
+package mux_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	mux "gop2p/driving/api.mux"
+)
+
+func TestMetrics(t *testing.T) {
+	routers := map[string]interface{ SetRoutes(*http.ServeMux) }{
+		"server":       mux.ServerRouter{},
+		"client front": mux.ClientFrontRouter{},
+		"client p2p":   mux.ClientP2pRouter{},
+	}
+
+	for name, router := range routers {
+		Convey("when /metrics is called on the "+name+" router", t, func() {
+			r := http.NewServeMux()
+			router.SetRoutes(r)
+			s := httptest.NewServer(r)
+			defer s.Close()
+
+			resp, err := s.Client().Get(s.URL + "/metrics")
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+
+			itRespondsWithStatus(http.StatusOK, resp)
+			Convey("it exposes the app metrics", func() {
+				So(string(body), ShouldContainSubstring, "gop2p_outbox_depth")
+			})
+		})
+	}
+}
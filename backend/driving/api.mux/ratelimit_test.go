@@ -0,0 +1,79 @@
+package mux_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gop2p/domain"
+	mux "gop2p/driving/api.mux"
+)
+
+func TestRateLimiter(t *testing.T) {
+	Convey("given a router limited to bursts of 2 requests", t, func() {
+		limiter := mux.NewRateLimiter(0.001, 2)
+		r := http.NewServeMux()
+		mux.ServerRouter{}.SetRoutes(r)
+		s := httptest.NewServer(limiter.Wrap(r))
+		defer s.Close()
+
+		get := func() *http.Response {
+			resp, err := s.Client().Get(s.URL + "/healthz")
+			So(err, ShouldBeNil)
+			return resp
+		}
+
+		Convey("when the burst is consumed", func() {
+			So(get().StatusCode, ShouldEqual, http.StatusOK)
+			So(get().StatusCode, ShouldEqual, http.StatusOK)
+			resp := get()
+
+			itRespondsWithStatus(http.StatusTooManyRequests, resp)
+			Convey("it tells when to retry", func() {
+				So(resp.Header.Get("Retry-After"), ShouldNotBeEmpty)
+			})
+		})
+
+		Convey("when the limit is disabled while running", func() {
+			limiter.SetLimits(0, 0)
+
+			Convey("every request goes through", func() {
+				for i := 0; i < 5; i++ {
+					So(get().StatusCode, ShouldEqual, http.StatusOK)
+				}
+			})
+		})
+	})
+}
+
+func TestSignalsRateLimit(t *testing.T) {
+	Convey("given a p2p router", t, func() {
+		r := http.NewServeMux()
+		mux.ClientP2pRouter{Logic: p2pLogicStub{}}.SetRoutes(r)
+		s := httptest.NewServer(r)
+		defer s.Close()
+
+		signal := func(from string) *http.Response {
+			req, err := http.NewRequest(http.MethodPost, s.URL+"/v1/signals/", strings.NewReader(`{"type":"typing"}`))
+			So(err, ShouldBeNil)
+			req.Header.Set("user", from)
+			resp, err := s.Client().Do(req)
+			So(err, ShouldBeNil)
+			return resp
+		}
+
+		Convey("when a peer sends more signals than the burst", func() {
+			for i := 0; i < domain.SignalBurst; i++ {
+				So(signal("alice").StatusCode, ShouldEqual, http.StatusOK)
+			}
+			resp := signal("alice")
+
+			itRespondsWithStatus(http.StatusTooManyRequests, resp)
+			Convey("the other peers can still send theirs", func() {
+				So(signal("bob").StatusCode, ShouldEqual, http.StatusOK)
+			})
+		})
+	})
+}
@@ -0,0 +1,67 @@
+package mux
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gop2p/domain"
+	"gop2p/tracing"
+	"gop2p/uc"
+)
+
+// handleRetention gets or sets the retention of a conversation, /conversations/:user/retention
+func handleRetention(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	getHandler := handleGetRetention(logic)
+	setHandler := handleSetRetention(logic)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			getHandler(w, r)
+
+		case http.MethodPut:
+			setHandler(w, r)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func handleGetRetention(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := tracing.Start(r.Context(), "http:get_retention")
+		defer span.End()
+
+		retention, err := logic.GetRetention(ctx, paramAtIndex(r, 2))
+		if err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+
+		writeJSON(ctx, w, retention)
+		spanHttpOK(span)
+	}
+}
+
+func handleSetRetention(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := tracing.Start(r.Context(), "http:set_retention")
+		defer span.End()
+
+		retention := domain.Retention{}
+		if err := json.NewDecoder(r.Body).Decode(&retention); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+			return
+		}
+
+		if err := logic.SetRetention(ctx, paramAtIndex(r, 2), retention); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+		spanHttpOK(span)
+	}
+}
@@ -1,7 +1,12 @@
 package mux
 
 import (
+	"context"
 	"fmt"
+	"gop2p/api"
+	"gop2p/domain"
+	"gop2p/logging"
+	"gop2p/metrics"
 	"gop2p/uc"
 	"log"
 	"net/http"
@@ -14,85 +19,173 @@ const ApplicationJSON = "application/json"
 // ServerRouter is used to map logic (usecases) and http routes
 type ServerRouter struct {
 	Logic uc.ServerLogic
+
+	// Admin routes are only served when an AdminToken is set
+	Admin      uc.AdminLogic
+	AdminToken string
+
+	// Cluster is the optional handler of the internal routes between the nodes of a clustered server
+	Cluster http.Handler
 }
 
 // ClientP2pRouter is the router used by clients for p2p internal communications
 type ClientP2pRouter struct {
 	Logic uc.ClientP2PLogic
+
+	// Capabilities are answered to the peers' handshake
+	Capabilities domain.Capabilities
+
+	// Directory is the optional handler of a decentralized session directory (eg. a DHT)
+	Directory http.Handler
+
+	// Senders is optional, the peers are trusted to be the user they claim without it
+	Senders uc.SenderVerifier
+
+	// MaxBodySize limits the bodies of the peers once decompressed, DefaultMaxBodySize if not set
+	MaxBodySize int64
 }
 
 // ClientFrontRouter is the router used by clients to allow interactions with the frontend
 type ClientFrontRouter struct {
-	Logic         uc.ClientFrontLogic
+	Logic uc.ClientFrontLogic
+
+	// ServerAddress is nil when the client runs without central server
 	ServerAddress *url.URL
+	// Transport is used to reach the central server, plain http if not set
+	Transport Transport
+
+	// Capabilities are advertised with the session of the client
+	Capabilities domain.Capabilities
+	// Device tells the sessions of the user apart
+	Device string
 }
 
-// NewServerRouter initializes the server router
-func NewServerRouter(l uc.ServerLogic, port int) {
-	mux := http.NewServeMux()
-	ServerRouter{
-		Logic: l,
-	}.SetRoutes(mux)
+// ListenOptions are the settings shared by every router
+type ListenOptions struct {
+	Port int
 
-	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: mux,
-	}
+	// the router is served with TLS when both files are set
+	CertFile string
+	KeyFile  string
 
-	fmt.Println("listening on", port)
-	log.Fatal(server.ListenAndServe())
+	// Limiter is optional
+	Limiter *RateLimiter
 }
 
-// NewClientFrontRouter initializes the client frontend router
-func NewClientFrontRouter(l uc.ClientFrontLogic, port int, serverAddress string) {
-	mux := http.NewServeMux()
-	ClientFrontRouter{
-		Logic:         l,
-		ServerAddress: &url.URL{Host: serverAddress},
-	}.SetRoutes(mux)
-
+func listenAndServe(h http.Handler, o ListenOptions) {
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: mux,
+		Addr:    fmt.Sprintf(":%d", o.Port),
+		Handler: o.Limiter.Wrap(h),
 	}
 
-	fmt.Println("listening on", port)
+	logging.Info(context.Background(), "listening", "port", o.Port, "tls", o.CertFile != "")
+	if o.CertFile != "" && o.KeyFile != "" {
+		log.Fatal(server.ListenAndServeTLS(o.CertFile, o.KeyFile))
+	}
 	log.Fatal(server.ListenAndServe())
+}
+
+// NewServerRouter initializes the server router
+func NewServerRouter(r ServerRouter, o ListenOptions) {
+	listenAndServe(r.Handler(), o)
+}
 
+// NewClientFrontRouter initializes the client frontend router
+func NewClientFrontRouter(r ClientFrontRouter, o ListenOptions) {
+	listenAndServe(r.Handler(), o)
 }
 
 // NewClientP2pRouter initializes the client p2p router
-func NewClientP2pRouter(l uc.ClientP2PLogic, port int) {
+func NewClientP2pRouter(r ClientP2pRouter, o ListenOptions) {
+	listenAndServe(r.Handler(), o)
+}
+
+// Handler returns the instrumented routes, so that they can be served by another server (eg. in tests)
+func (r ServerRouter) Handler() http.Handler {
 	mux := http.NewServeMux()
-	ClientP2pRouter{
-		Logic: l,
-	}.SetRoutes(mux)
-	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: mux,
-	}
+	r.SetRoutes(mux)
+	return instrument("server", mux)
+}
 
-	fmt.Println("listening on", port)
-	log.Fatal(server.ListenAndServe())
+// Handler returns the instrumented routes, so that they can be served by another server (eg. in tests)
+func (r ClientFrontRouter) Handler() http.Handler {
+	mux := http.NewServeMux()
+	r.SetRoutes(mux)
+	return instrument("client_front", mux)
+}
 
+// Handler returns the instrumented routes, so that they can be served by another server (eg. in tests)
+func (r ClientP2pRouter) Handler() http.Handler {
+	mux := http.NewServeMux()
+	r.SetRoutes(mux)
+	return instrument("client_p2p", mux)
 }
 
 // SetRoutes plugs routes with logic
 func (r ServerRouter) SetRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {})
-	mux.HandleFunc("/sessions/", serverSessionsHandler(r.Logic))
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/openapi.json", serveSpec(api.ServerSpec))
+	// the server has no optional feature
+	mux.HandleFunc(V1+"/capabilities", capabilitiesHandler(domain.Capabilities{Versions: domain.Supported().Versions}))
+	handleVersioned(mux, "/sessions/", serverSessionsHandler(r.Logic))
+	if r.Cluster != nil {
+		mux.Handle("/cluster/", r.Cluster)
+	}
+	if r.AdminToken != "" {
+		handleVersioned(mux, "/admin/users/", adminAuthenticated(r.AdminToken, adminUsersHandler(r.Admin)))
+		handleVersioned(mux, "/admin/sessions/", adminAuthenticated(r.AdminToken, adminSessionsHandler(r.Admin)))
+	}
 }
 
 // SetRoutes plugs routes with logic
 func (r ClientP2pRouter) SetRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {})
-	mux.HandleFunc("/messages/", clientp2pHandler(r.Logic))
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/openapi.json", serveSpec(api.P2PSpec))
+	mux.HandleFunc(V1+"/capabilities", capabilitiesHandler(r.Capabilities))
+	handleVersioned(mux, "/messages/", verifiedSender(r.Senders, clientp2pHandler(r.Logic, r.maxBodySize())))
+	handleVersioned(mux, "/attachments/", verifiedSender(r.Senders, handleGetAttachmentChunk(r.Logic)))
+	handleVersioned(mux, "/signals/", verifiedSender(r.Senders, clientp2pSignalsHandler(r.Logic, r.maxBodySize()).ServeHTTP))
+	mux.HandleFunc(V1+"/history", verifiedSender(r.Senders, handleGetHistory(r.Logic)))
+	if r.Directory != nil {
+		mux.Handle("/dht/", r.Directory)
+	}
+}
+
+func (r ClientP2pRouter) maxBodySize() int64 {
+	if r.MaxBodySize <= 0 {
+		return DefaultMaxBodySize
+	}
+	return r.MaxBodySize
 }
 
 // SetRoutes plugs routes with logic
 func (r ClientFrontRouter) SetRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {})
-	mux.HandleFunc("/sessions/", clientFrontSessionsHandler(r.Logic, r.ServerAddress))
-	mux.HandleFunc("/conversations/", clientFrontConversationsHandler(r.Logic))
-	mux.HandleFunc("/messages/", clientFrontMessagessHandler(r.Logic))
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/openapi.json", serveSpec(api.FrontSpec))
+	mux.HandleFunc(V1+"/capabilities", capabilitiesHandler(r.Capabilities))
+	handleVersioned(mux, "/sessions/", clientFrontSessionsHandler(r.Logic, r.ServerAddress, r.Transport, r.Capabilities, r.Device))
+	handleVersioned(mux, "/conversations/", clientFrontConversationsHandler(r.Logic))
+	handleVersioned(mux, "/messages/", clientFrontMessagessHandler(r.Logic))
+	handleVersioned(mux, "/search", clientFrontSearchHandler(r.Logic))
+	handleVersioned(mux, "/export", clientFrontExportHandler(r.Logic))
+	handleVersioned(mux, "/import", clientFrontImportHandler(r.Logic))
+	handleVersioned(mux, "/encryption", clientFrontEncryptionHandler(r.Logic))
+	handleVersioned(mux, "/encryption/", clientFrontEncryptionHandler(r.Logic))
+	handleVersioned(mux, "/attachments/", clientFrontAttachmentsHandler(r.Logic))
+	handleVersioned(mux, "/events", clientFrontEventsHandler(r.Logic))
+}
+
+// serveSpec serves the OpenAPI document of the router
+func serveSpec(spec []byte) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", ApplicationJSON)
+		w.Write(spec)
+	}
 }
@@ -1,11 +1,8 @@
 package mux
 
 import (
-	"context"
 	"encoding/json"
 	"github.com/go-playground/validator"
-	"github.com/opentracing/opentracing-go"
-	"github.com/opentracing/opentracing-go/log"
 	"gop2p/domain"
 	"gop2p/uc"
 	"io"
@@ -35,6 +32,11 @@ type CreateNewSessionBody struct {
 	Login    string `json:"login" validate:"required"`
 	Password string `json:"password" validate:"required"`
 	Address  string `json:"address"`
+	// Device is missing in the sessions of the clients of previous versions
+	Device string `json:"device"`
+
+	// Capabilities are missing in the sessions of the clients of previous versions
+	Capabilities domain.Capabilities `json:"capabilities"`
 }
 
 // FromJSON is the standard json.Unmarshal method
@@ -47,21 +49,27 @@ func (nS *CreateNewSessionBody) Validate() error {
 	return validator.New().Struct(nS)
 }
 
+// SessionResponse is the session of the device which started last, for the clients of previous versions, and the
+// sessions of every device
+type SessionResponse struct {
+	domain.Session
+	Devices []domain.Session `json:"devices"`
+}
+
 func handleStartSession(logic uc.ServerLogic) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		span := spanFromReq("http:handle_start_session", r)
-		defer span.Finish()
-		ctx := opentracing.ContextWithSpan(context.Background(), span)
+		span, ctx := spanFromReq("http:handle_start_session", r)
+		defer span.End()
 
 		b := CreateNewSessionBody{}
 		if err := b.FromJSON(r.Body); err != nil {
-			span.LogFields(log.Error(err))
+			span.Error(err)
 			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
 			return
 		}
 
 		if err := b.Validate(); err != nil {
-			span.LogFields(log.Error(err))
+			span.Error(err)
 			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
 			return
 		}
@@ -73,8 +81,8 @@ func handleStartSession(logic uc.ServerLogic) func(w http.ResponseWriter, r *htt
 			address = r.RemoteAddr
 		}
 
-		if err := logic.StartSession(ctx, b.Login, b.Password, address); err != nil {
-			span.LogFields(log.Error(err))
+		if err := logic.StartSession(ctx, b.Login, b.Password, b.Device, address, b.Capabilities); err != nil {
+			span.Error(err)
 			mapDomainErrToHttpCode(ctx, err, w)
 			return
 		}
@@ -85,9 +93,8 @@ func handleStartSession(logic uc.ServerLogic) func(w http.ResponseWriter, r *htt
 
 func handleGetSession(logic uc.ServerLogic) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		span := spanFromReq("http:handle_get_session", r)
-		defer span.Finish()
-		ctx := opentracing.ContextWithSpan(context.Background(), span)
+		span, ctx := spanFromReq("http:handle_get_session", r)
+		defer span.End()
 
 		from := r.Header.Get("user")
 		if from == "" {
@@ -101,21 +108,18 @@ func handleGetSession(logic uc.ServerLogic) func(w http.ResponseWriter, r *http.
 			return
 		}
 
-		s, err := logic.ProvideUserSession(ctx, from, to)
+		sessions, err := logic.ProvideUserSession(ctx, from, to)
 		if err != nil {
-			span.LogFields(log.Error(err))
+			span.Error(err)
 			mapDomainErrToHttpCode(ctx, err, w)
 			return
 		}
-
-		body, err := json.Marshal(s)
-		if err != nil {
-			span.LogFields(log.Error(err))
-			mapDomainErrToHttpCode(ctx, domain.ErrTechnical{}, w)
+		if len(sessions) == 0 {
+			mapDomainErrToHttpCode(ctx, domain.ErrResourceNotFound{}, w)
 			return
 		}
 
-		w.Write(body)
+		writeJSON(ctx, w, SessionResponse{Session: sessions[len(sessions)-1], Devices: sessions})
 		spanHttpOK(span)
 	}
 }
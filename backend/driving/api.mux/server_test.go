@@ -129,7 +129,7 @@ func TestSessionsOtherMethods(t *testing.T) {
 func newProvideSessionRouterWithParamExpectations(t *testing.T, spy *spy, from, to string) mux.ServerRouter {
 	return mux.ServerRouter{
 		Logic: uc.ServerLogic{
-			ProvideUserSession: func(_ context.Context, src, dst string) (*domain.Session, error) {
+			ProvideUserSession: func(_ context.Context, src, dst string) ([]domain.Session, error) {
 				Convey("provideSession usecase is spy with the right params", t, func() {
 					spy.called++
 					So(src, ShouldEqual, from)
@@ -142,7 +142,7 @@ func newProvideSessionRouterWithParamExpectations(t *testing.T, spy *spy, from,
 
 func setStartSessionUsecaseReturn(err error) mux.ServerRouter {
 	return mux.ServerRouter{Logic: uc.ServerLogic{
-		StartSession: func(_ context.Context, _, _, _ string) error {
+		StartSession: func(_ context.Context, _, _, _, _ string, _ domain.Capabilities) error {
 			return err
 		},
 	}}
@@ -153,7 +153,7 @@ func setStartSessionUsecaseReturn(err error) mux.ServerRouter {
 func newStartSessionRouterWithParamExpectations(t *testing.T, spy *spy, login, password, address string) mux.ServerRouter {
 	return mux.ServerRouter{
 		Logic: uc.ServerLogic{
-			StartSession: func(_ context.Context, l, p, rma string) error {
+			StartSession: func(_ context.Context, l, p, _, rma string, _ domain.Capabilities) error {
 				Convey("the startSession usecase is called with the right params", t, func() {
 					spy.called++
 					So(l, ShouldEqual, login)
@@ -2,16 +2,19 @@ package mux
 
 import (
 	"context"
-	"github.com/opentracing/opentracing-go"
-	"github.com/opentracing/opentracing-go/ext"
+	"encoding/json"
+	"fmt"
 	"gop2p/domain"
-	"log"
+	"gop2p/metrics"
+	"gop2p/tracing"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func mapDomainErrToHttpCode(ctx context.Context, err error, w http.ResponseWriter) {
-	span := opentracing.SpanFromContext(ctx)
+	span := tracing.FromContext(ctx)
 
 	switch err.(type) {
 	case nil:
@@ -26,6 +29,15 @@ func mapDomainErrToHttpCode(ctx context.Context, err error, w http.ResponseWrite
 	case domain.ErrMalformed:
 		writeSpanAndHeader(span, w, http.StatusBadRequest)
 		return
+	case domain.ErrUnauthorized:
+		writeSpanAndHeader(span, w, http.StatusUnauthorized)
+		return
+	case domain.ErrLocked:
+		writeSpanAndHeader(span, w, http.StatusLocked)
+		return
+	case domain.ErrUnavailable:
+		writeSpanAndHeader(span, w, http.StatusServiceUnavailable)
+		return
 	default:
 		writeSpanAndHeader(span, w, http.StatusInternalServerError)
 		return
@@ -34,44 +46,169 @@ func mapDomainErrToHttpCode(ctx context.Context, err error, w http.ResponseWrite
 
 const spanHttpStatusKey = "http_status"
 
-func spanHttpOK(span opentracing.Span) {
-	span.SetTag(spanHttpStatusKey, http.StatusOK)
+func spanHttpOK(span tracing.Span) {
+	span.SetAttribute(spanHttpStatusKey, http.StatusOK)
 }
 
-func writeSpanAndHeader(span opentracing.Span, w http.ResponseWriter, status int) {
+func writeSpanAndHeader(span tracing.Span, w http.ResponseWriter, status int) {
 	if span != nil {
-		span.SetTag(spanHttpStatusKey, status)
+		span.SetAttribute(spanHttpStatusKey, status)
 	}
 	w.WriteHeader(status)
 }
 
+// writeJSON responds v as JSON
+func writeJSON(ctx context.Context, w http.ResponseWriter, v interface{}) {
+	span := tracing.FromContext(ctx)
+
+	body, err := json.Marshal(v)
+	if err != nil {
+		if span != nil {
+			span.Error(err)
+		}
+		mapDomainErrToHttpCode(ctx, domain.ErrTechnical{}, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", ApplicationJSON)
+	w.Write(body)
+	if span != nil {
+		spanHttpOK(span)
+	}
+}
+
 func paramAtIndex(r *http.Request, index int) string {
 	p := strings.Split(r.URL.Path, "/")
-	if len(p) < index {
+	if len(p) <= index {
 		return ""
 	}
 	return p[index]
 }
 
-func InjectSpanInReq(span opentracing.Span, req *http.Request) {
-	err := opentracing.GlobalTracer().Inject(
-		span.Context(),
-		opentracing.HTTPHeaders,
-		opentracing.HTTPHeadersCarrier(req.Header))
+// queryInt parses the query parameter into v, it's left untouched if the parameter is missing
+func queryInt(r *http.Request, name string, v *int64) error {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return nil
+	}
+	i, err := strconv.ParseInt(raw, 10, 64)
 	if err != nil {
-		log.Println(err)
+		return fmt.Errorf("invalid %s: %w", name, err)
 	}
+	*v = i
+	return nil
 }
 
-func spanFromReq(spanName string, r *http.Request) opentracing.Span {
-	wireContext, err := opentracing.GlobalTracer().Extract(
-		opentracing.HTTPHeaders,
-		opentracing.HTTPHeadersCarrier(r.Header))
-	if err != nil {
-		log.Println(spanName, err)
+// InjectSpanInReq propagates the trace of ctx to the called node (W3C traceparent header)
+func InjectSpanInReq(ctx context.Context, req *http.Request) {
+	tracing.Inject(ctx, req.Header)
+}
+
+// InjectTrace is InjectSpanInReq for the generated clients
+func InjectTrace(ctx context.Context, req *http.Request) error {
+	InjectSpanInReq(ctx, req)
+	return nil
+}
+
+// spanFromReq continues the trace of the caller, if any
+func spanFromReq(spanName string, r *http.Request) (tracing.Span, context.Context) {
+	return tracing.StartFromRequest(spanName, r)
+}
+
+// Transport is how the nodes call each other's routers, https when the deployment uses TLS
+type Transport struct {
+	Scheme string
+	Client *http.Client
+
+	// Retries is the number of times a call made with Do is retried
+	Retries int
+}
+
+// DefaultRetries is used by the default transport
+const DefaultRetries = 2
+
+const retryBackoff = 100 * time.Millisecond
+
+// DefaultTransport is plain http
+func DefaultTransport() Transport {
+	return Transport{Scheme: "http", Client: http.DefaultClient, Retries: DefaultRetries}
+}
+
+// URL of path on the router listening at address
+func (t Transport) URL(address, path string) string {
+	return t.scheme() + "://" + address + path
+}
+
+func (t Transport) scheme() string {
+	if t.Scheme == "" {
+		return "http"
+	}
+	return t.Scheme
+}
+
+// HTTPClient never returns nil
+func (t Transport) HTTPClient() *http.Client {
+	if t.Client == nil {
+		return http.DefaultClient
+	}
+	return t.Client
+}
+
+// roundTripper is nil (ie. http.DefaultTransport) when not set
+func (t Transport) roundTripper() http.RoundTripper {
+	return t.HTTPClient().Transport
+}
+
+// Do sends the request built by newReq, it's built again and retried on network errors and 5xx responses
+// with an exponential backoff, every retry is counted for the gateway
+func (t Transport) Do(ctx context.Context, gateway string, newReq func() (*http.Request, error)) (*http.Response, error) {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := t.HTTPClient().Do(req.WithContext(ctx))
+		if (err == nil && resp.StatusCode < http.StatusInternalServerError) || attempt >= t.Retries {
+			return resp, err
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		metrics.GatewayRetry(gateway)
 	}
+}
+
+// Doer sends requests like Do, for the generated clients. The body of the requests must be rewindable
+// (ie. GetBody is set, as it is by http.NewRequest with a bytes reader)
+func (t Transport) Doer(gateway string) RetryingDoer {
+	return RetryingDoer{t: t, gateway: gateway}
+}
+
+// RetryingDoer is returned by Transport.Doer
+type RetryingDoer struct {
+	t       Transport
+	gateway string
+}
 
-	return opentracing.StartSpan(
-		spanName,
-		ext.RPCServerOption(wireContext))
+func (d RetryingDoer) Do(req *http.Request) (*http.Response, error) {
+	return d.t.Do(req.Context(), d.gateway, func() (*http.Request, error) {
+		r := req.Clone(req.Context())
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r.Body = body
+		}
+		return r, nil
+	})
 }
@@ -0,0 +1,163 @@
+package mux
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-playground/validator"
+	"gop2p/domain"
+	"gop2p/tracing"
+	"gop2p/uc"
+	"io"
+	"net/http"
+)
+
+// TextEventStream is the content-type of the server-sent events
+const TextEventStream = "text/event-stream"
+
+// SignalBody is the body of the expected signal requests, from the front end and from the peers
+type SignalBody struct {
+	Type string `json:"type" validate:"required,oneof=typing seen"`
+	Ref  string `json:"ref"`
+}
+
+// FromJSON is the standard json.Unmarshal method
+func (nS *SignalBody) FromJSON(r io.Reader) error {
+	return json.NewDecoder(r).Decode(nS)
+}
+
+// Validate is used to check request validity
+func (nS *SignalBody) Validate() error {
+	return validator.New().Struct(nS)
+}
+
+// clientp2pSignalsHandler is limited per sender, the signals are sent as the user types
+func clientp2pSignalsHandler(logic uc.ClientP2PLogic, maxBody int64) http.Handler {
+	limiter := NewRateLimiter(domain.SignalRate, domain.SignalBurst)
+	sender := func(r *http.Request) string { return r.Header.Get("user") }
+
+	return limiter.WrapBy(sender, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		handleSignalReceived(logic, maxBody)(w, r)
+	}))
+}
+
+func handleSignalReceived(logic uc.ClientP2PLogic, maxBody int64) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := spanFromReq("http:p2p_signal_received", r)
+		defer span.End()
+
+		from := r.Header.Get("user")
+		if from == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		body, err := requestBody(w, r, maxBody)
+		if err != nil {
+			span.Error(err)
+			mapBodyErrToHttpCode(ctx, err, w)
+			return
+		}
+
+		b := SignalBody{}
+		if err := b.FromJSON(body); err != nil {
+			span.Error(err)
+			mapBodyErrToHttpCode(ctx, err, w)
+			return
+		}
+
+		if err := b.Validate(); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+			return
+		}
+
+		if err := logic.HandleSignalReceived(ctx, domain.Signal{Type: b.Type, Ref: b.Ref}, domain.User{Login: from}); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+		spanHttpOK(span)
+	}
+}
+
+func handleSendSignal(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := tracing.Start(r.Context(), "http:send_signal")
+		defer span.End()
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		b := SignalBody{}
+		if err := b.FromJSON(r.Body); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+			return
+		}
+
+		if err := b.Validate(); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+			return
+		}
+
+		// /conversations/:user/signals
+		if err := logic.SendSignal(ctx, paramAtIndex(r, 2), b.Type, b.Ref); err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+		spanHttpOK(span)
+	}
+}
+
+// clientFrontEventsHandler streams the signals to the front end as server-sent events until it disconnects
+func clientFrontEventsHandler(logic uc.ClientFrontLogic) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span, ctx := tracing.Start(r.Context(), "http:events")
+		defer span.End()
+
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			span.Error(fmt.Errorf("%T can't stream", w))
+			mapDomainErrToHttpCode(ctx, domain.ErrTechnical{}, w)
+			return
+		}
+
+		signals, err := logic.Signals(ctx)
+		if err != nil {
+			span.Error(err)
+			mapDomainErrToHttpCode(ctx, err, w)
+			return
+		}
+
+		w.Header().Set("Content-Type", TextEventStream)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		spanHttpOK(span)
+
+		for s := range signals {
+			data, err := json.Marshal(s)
+			if err != nil {
+				span.Error(err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", s.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package mux
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gop2p/domain"
+	"gop2p/tracing"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// V1 is the prefix of the routes of domain.ProtocolV1, the legacy protocol is spoken on the unversioned ones
+const V1 = "/v1"
+
+// Prefix of the routes of a protocol version
+func Prefix(version int) string {
+	if version == domain.ProtocolV1 {
+		return V1
+	}
+	return ""
+}
+
+// handleVersioned serves h on the unversioned pattern for the nodes of previous versions and under V1,
+// the handlers see the same path in both cases
+func handleVersioned(mux *http.ServeMux, pattern string, h http.HandlerFunc) {
+	mux.HandleFunc(pattern, h)
+	mux.Handle(V1+pattern, http.StripPrefix(V1, h))
+}
+
+// capabilitiesHandler is the handshake, the nodes of previous versions answer 404
+func capabilitiesHandler(caps domain.Capabilities) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(r.Context(), w, caps)
+	}
+}
+
+// WithEncoding is a request editor for the generated clients, whose paths are the V1 ones,
+// so that they speak the negotiated encoding
+func WithEncoding(e domain.Encoding) func(ctx context.Context, req *http.Request) error {
+	return func(_ context.Context, req *http.Request) error {
+		req.URL.Path = Prefix(e.Version) + strings.TrimPrefix(req.URL.Path, V1)
+
+		if e.Has(domain.FeatureGzip) && req.GetBody != nil {
+			return gzipBody(req)
+		}
+		return nil
+	}
+}
+
+func gzipBody(req *http.Request) error {
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	b := &bytes.Buffer{}
+	zw := gzip.NewWriter(b)
+	if _, err := io.Copy(zw, body); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	compressed := b.Bytes()
+	req.Header.Set("Content-Encoding", "gzip")
+	req.ContentLength = int64(len(compressed))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(compressed)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return nil
+}
+
+// DefaultMaxBodySize is the size the bodies of the peers are limited to when the router sets none, once decompressed
+const DefaultMaxBodySize = 1 << 20
+
+// errBodyTooLarge is returned when a body is larger than allowed once decompressed
+var errBodyTooLarge = errors.New("body too large")
+
+// requestBody decodes the body of the peers which gzip it, the raw and decompressed bodies are limited to max bytes
+func requestBody(w http.ResponseWriter, r *http.Request, max int64) (io.Reader, error) {
+	raw := http.MaxBytesReader(w, r.Body, max)
+	switch r.Header.Get("Content-Encoding") {
+	case "":
+		return raw, nil
+	case "gzip":
+		zr, err := gzip.NewReader(raw)
+		if err != nil {
+			return nil, err
+		}
+		return &limitedBody{r: io.LimitReader(zr, max+1), max: max}, nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", r.Header.Get("Content-Encoding"))
+	}
+}
+
+// limitedBody fails once more than max bytes are read, instead of cutting the body short
+type limitedBody struct {
+	r    io.Reader
+	read int64
+	max  int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.max {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+// tooLarge tells if the body was refused for its size
+func tooLarge(err error) bool {
+	var maxBytes *http.MaxBytesError
+	return errors.Is(err, errBodyTooLarge) || errors.As(err, &maxBytes)
+}
+
+// mapBodyErrToHttpCode answers 413 when the body is too large, 400 otherwise
+func mapBodyErrToHttpCode(ctx context.Context, err error, w http.ResponseWriter) {
+	if tooLarge(err) {
+		writeSpanAndHeader(tracing.FromContext(ctx), w, http.StatusRequestEntityTooLarge)
+		return
+	}
+	mapDomainErrToHttpCode(ctx, domain.ErrMalformed{}, w)
+}
+
+// Negotiator picks the encoding spoken with the node at an address, the node is asked for its capabilities
+// (the handshake) until it answers, then its answer is kept
+type Negotiator struct {
+	t       Transport
+	address string
+	own     domain.Capabilities
+
+	mu       sync.Mutex
+	encoding *domain.Encoding
+}
+
+func NewNegotiator(t Transport, address string, own domain.Capabilities) *Negotiator {
+	return &Negotiator{t: t, address: address, own: own}
+}
+
+// Encoding returns the negotiated encoding, an error if the node can't be reached or has no version in common
+func (n *Negotiator) Encoding(ctx context.Context) (domain.Encoding, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.encoding != nil {
+		return *n.encoding, nil
+	}
+
+	peer, err := Handshake(ctx, n.t, n.address)
+	if err != nil {
+		return domain.Encoding{}, err
+	}
+	e, ok := n.own.Negotiate(peer)
+	if !ok {
+		return domain.Encoding{}, fmt.Errorf("no protocol version in common with %s (%v)", n.address, peer.Versions)
+	}
+	n.encoding = &e
+	return e, nil
+}
+
+// Forget makes the next call to Encoding ask the node again (eg. after it failed, it may have been upgraded)
+func (n *Negotiator) Forget() {
+	n.mu.Lock()
+	n.encoding = nil
+	n.mu.Unlock()
+}
+
+// Handshake asks the node at address for its capabilities, they're unknown if it's of a previous version
+func Handshake(ctx context.Context, t Transport, address string) (domain.Capabilities, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.URL(address, V1+"/capabilities"), nil)
+	if err != nil {
+		return domain.Capabilities{}, err
+	}
+	InjectSpanInReq(ctx, req)
+
+	resp, err := t.HTTPClient().Do(req)
+	if err != nil {
+		return domain.Capabilities{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		caps := domain.Capabilities{}
+		if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+			return domain.Capabilities{}, err
+		}
+		return caps, nil
+	case http.StatusNotFound:
+		return domain.Capabilities{}, nil
+	default:
+		return domain.Capabilities{}, fmt.Errorf("%s answered the handshake with %d", address, resp.StatusCode)
+	}
+}
@@ -0,0 +1,148 @@
+package mux_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gop2p/domain"
+	mux "gop2p/driving/api.mux"
+)
+
+type p2pSpy struct {
+	p2pLogicStub
+	received []string
+}
+
+func (s *p2pSpy) HandleMessageReceived(_ context.Context, e domain.Event, _ domain.User) error {
+	s.received = append(s.received, e.Content)
+	return nil
+}
+
+func withP2PServer(router mux.ClientP2pRouter, f func(*httptest.Server)) func() {
+	return func() {
+		r := http.NewServeMux()
+		router.SetRoutes(r)
+		s := httptest.NewServer(r)
+		defer s.Close()
+		f(s)
+	}
+}
+
+// postMessage sends a message the way the client gateway does once the encoding is negotiated
+func postMessage(s *httptest.Server, e domain.Encoding, msg string) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, s.URL+mux.V1+"/messages/", bytes.NewReader([]byte(`{"message":"`+msg+`"}`)))
+	So(err, ShouldBeNil)
+	req.Header.Set("user", "alice")
+	So(mux.WithEncoding(e)(context.Background(), req), ShouldBeNil)
+
+	resp, err := s.Client().Do(req)
+	So(err, ShouldBeNil)
+	return resp
+}
+
+func TestVersionedRoutes(t *testing.T) {
+	logic := &p2pSpy{}
+
+	Convey("given a client p2p router", t, withP2PServer(mux.ClientP2pRouter{Logic: logic, Capabilities: domain.Supported()}, func(s *httptest.Server) {
+		logic.received = nil
+
+		Convey("when a peer of a previous version sends a message on the unversioned route", func() {
+			resp := postMessage(s, domain.Encoding{Version: domain.ProtocolLegacy}, "legacy")
+			itRespondsWithStatus(http.StatusOK, resp)
+
+			Convey("the message is received", func() {
+				So(logic.received, ShouldResemble, []string{"legacy"})
+			})
+		})
+
+		Convey("when a peer sends a gzipped message on the v1 route", func() {
+			resp := postMessage(s, domain.Encoding{Version: domain.ProtocolV1, Features: []string{domain.FeatureGzip}}, "v1")
+			itRespondsWithStatus(http.StatusOK, resp)
+
+			Convey("the message is received", func() {
+				So(logic.received, ShouldResemble, []string{"v1"})
+			})
+		})
+	}))
+
+	Convey("given a client p2p router limiting the bodies to 100 bytes", t, withP2PServer(mux.ClientP2pRouter{Logic: logic, Capabilities: domain.Supported(), MaxBodySize: 100}, func(s *httptest.Server) {
+		logic.received = nil
+		gzipped := domain.Encoding{Version: domain.ProtocolV1, Features: []string{domain.FeatureGzip}}
+
+		Convey("when a peer sends a message which fits, it's received", func() {
+			resp := postMessage(s, gzipped, "hi")
+			itRespondsWithStatus(http.StatusOK, resp)
+			So(logic.received, ShouldResemble, []string{"hi"})
+		})
+
+		Convey("when a peer sends a larger message, it's refused", func() {
+			resp := postMessage(s, domain.Encoding{Version: domain.ProtocolV1}, strings.Repeat("a", 200))
+			itRespondsWithStatus(http.StatusRequestEntityTooLarge, resp)
+			So(logic.received, ShouldBeEmpty)
+		})
+
+		Convey("when a peer sends a message larger once decompressed, it's refused", func() {
+			resp := postMessage(s, gzipped, strings.Repeat("a", 200))
+			itRespondsWithStatus(http.StatusRequestEntityTooLarge, resp)
+			So(logic.received, ShouldBeEmpty)
+		})
+	}))
+}
+
+func TestNegotiation(t *testing.T) {
+	ctx := context.Background()
+	own := domain.Supported()
+
+	negotiate := func(s *httptest.Server) (domain.Encoding, error) {
+		u, err := url.Parse(s.URL)
+		So(err, ShouldBeNil)
+		return mux.NewNegotiator(mux.DefaultTransport(), u.Host, own).Encoding(ctx)
+	}
+
+	Convey("given a peer of this version", t, withP2PServer(mux.ClientP2pRouter{Logic: &p2pSpy{}, Capabilities: domain.Supported()}, func(s *httptest.Server) {
+		e, err := negotiate(s)
+		So(err, ShouldBeNil)
+
+		Convey("v1 is spoken with all the features", func() {
+			So(e, ShouldResemble, domain.Encoding{Version: domain.ProtocolV1, Features: domain.Supported().Features})
+		})
+	}))
+
+	Convey("given a peer of a previous version, without handshake", t, func() {
+		old := http.NewServeMux()
+		old.HandleFunc("/messages/", func(http.ResponseWriter, *http.Request) {})
+		s := httptest.NewServer(old)
+		defer s.Close()
+
+		e, err := negotiate(s)
+		So(err, ShouldBeNil)
+
+		Convey("the legacy protocol is spoken", func() {
+			So(e, ShouldResemble, domain.Encoding{Version: domain.ProtocolLegacy})
+		})
+	})
+
+	Convey("given a peer without any version in common", t, withP2PServer(mux.ClientP2pRouter{Logic: &p2pSpy{}, Capabilities: domain.Capabilities{Versions: []int{42}}}, func(s *httptest.Server) {
+		_, err := negotiate(s)
+
+		Convey("the negotiation fails", func() {
+			So(err, ShouldNotBeNil)
+		})
+	}))
+
+	Convey("given a session advertising v1 without features", t, func() {
+		e, ok := own.Negotiate(domain.Capabilities{Versions: []int{domain.ProtocolV1}})
+
+		Convey("v1 is spoken without gzip", func() {
+			So(ok, ShouldBeTrue)
+			So(e.Version, ShouldEqual, domain.ProtocolV1)
+			So(e.Has(domain.FeatureGzip), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,307 @@
+// Package fault makes the nodes and their stores misbehave on purpose, to test how the others cope with it
+package fault
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Any matches every node in a link
+const Any = "*"
+
+// DefaultReorderDelay is how long a reordered request is held back when Faults.ReorderDelay isn't set
+const DefaultReorderDelay = 50 * time.Millisecond
+
+// ErrDropped is returned for the requests lost on the way, ErrPartitioned for the ones between partitioned nodes
+var (
+	ErrDropped     = errors.New("request dropped")
+	ErrPartitioned = errors.New("nodes partitioned")
+)
+
+// Faults of the traffic from a node to another, the probabilities are between 0 and 1
+type Faults struct {
+	// Latency is added to every request, plus up to Jitter at random
+	Latency time.Duration
+	Jitter  time.Duration
+
+	// Drop is the probability that a request never reaches the other node
+	Drop float64
+	// Duplicate is the probability that a request is received twice, the caller gets the first response
+	Duplicate float64
+	// Reorder is the probability that a request is held back for ReorderDelay, so that the concurrent ones overtake it
+	Reorder      float64
+	ReorderDelay time.Duration
+}
+
+type link struct {
+	from, to string
+}
+
+// Network knows the nodes by their name and the faults between them. The random choices of a link only depend on
+// the seed and on the requests sent on the link before, so a scenario plays the same way each time
+type Network struct {
+	mu     sync.Mutex
+	seed   int64
+	nodes  map[string]string
+	faults map[link]Faults
+	cuts   map[link]struct{}
+	rands  map[link]*rand.Rand
+}
+
+// NewNetwork with no faults at all
+func NewNetwork(seed int64) *Network {
+	return &Network{
+		seed:   seed,
+		nodes:  map[string]string{},
+		faults: map[link]Faults{},
+		cuts:   map[link]struct{}{},
+		rands:  map[link]*rand.Rand{},
+	}
+}
+
+// Node names the node listening at address (host:port), the addresses not named are their own name
+func (n *Network) Node(name, address string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.nodes[address] = name
+}
+
+// Set the faults of the traffic from a node to another, either can be Any
+func (n *Network) Set(from, to string, f Faults) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.faults[link{from, to}] = f
+}
+
+// Partition cuts every node of a group from the nodes of the others, both ways, until Heal
+func (n *Network) Partition(groups ...[]string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for i, a := range groups {
+		for _, b := range groups[i+1:] {
+			for _, from := range a {
+				for _, to := range b {
+					n.cuts[link{from, to}] = struct{}{}
+					n.cuts[link{to, from}] = struct{}{}
+				}
+			}
+		}
+	}
+}
+
+// Isolate cuts a node from all the others, both ways, until Heal
+func (n *Network) Isolate(name string) {
+	n.Partition([]string{name}, []string{Any})
+}
+
+// Heal removes the partitions, the faults are kept
+func (n *Network) Heal() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.cuts = map[link]struct{}{}
+}
+
+// Reset removes the partitions and the faults, and plays the random choices from the start again
+func (n *Network) Reset() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.faults = map[link]Faults{}
+	n.cuts = map[link]struct{}{}
+	n.rands = map[link]*rand.Rand{}
+}
+
+func (n *Network) name(address string) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if name, ok := n.nodes[address]; ok {
+		return name
+	}
+	return address
+}
+
+// candidates are the links matching from and to, the most specific first
+func candidates(from, to string) []link {
+	return []link{{from, to}, {from, Any}, {Any, to}, {Any, Any}}
+}
+
+// decision is what happens to a request
+type decision struct {
+	cut       bool
+	delay     time.Duration
+	drop      bool
+	duplicate bool
+}
+
+// decide draws the fate of a request, the draws are always made in the same order to stay reproducible
+func (n *Network) decide(from, to string) decision {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, l := range candidates(from, to) {
+		if _, ok := n.cuts[l]; ok {
+			return decision{cut: true}
+		}
+	}
+
+	var f Faults
+	for _, l := range candidates(from, to) {
+		if found, ok := n.faults[l]; ok {
+			f = found
+			break
+		}
+	}
+
+	r := n.rand(link{from, to})
+	d := decision{delay: f.Latency}
+	jitter, drop, duplicate, reorder := r.Float64(), r.Float64(), r.Float64(), r.Float64()
+
+	d.delay += time.Duration(jitter * float64(f.Jitter))
+	d.drop = drop < f.Drop
+	d.duplicate = duplicate < f.Duplicate
+	if reorder < f.Reorder {
+		if f.ReorderDelay > 0 {
+			d.delay += f.ReorderDelay
+		} else {
+			d.delay += DefaultReorderDelay
+		}
+	}
+	return d
+}
+
+// rand of a link, seeded from the network seed and the link so that the links don't depend on each other
+func (n *Network) rand(l link) *rand.Rand {
+	if r, ok := n.rands[l]; ok {
+		return r
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s>%s", l.from, l.to)
+	r := rand.New(rand.NewSource(n.seed ^ int64(h.Sum64())))
+	n.rands[l] = r
+	return r
+}
+
+// Client returns a copy of c (or of the default client if nil) sending the requests of the node through the network
+func (n *Network) Client(from string, c *http.Client) *http.Client {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	next := c.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	copied := *c
+	copied.Transport = n.RoundTripper(from, next)
+	return &copied
+}
+
+// RoundTripper sends the requests of the node through the network
+func (n *Network) RoundTripper(from string, next http.RoundTripper) http.RoundTripper {
+	return roundTripper{n: n, from: from, next: next}
+}
+
+type roundTripper struct {
+	n    *Network
+	from string
+	next http.RoundTripper
+}
+
+func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	to := rt.n.name(req.URL.Host)
+	d := rt.n.decide(rt.from, to)
+	if d.cut {
+		return nil, fmt.Errorf("%s to %s: %w", rt.from, to, ErrPartitioned)
+	}
+
+	if d.delay > 0 {
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(d.delay):
+		}
+	}
+	if d.drop {
+		return nil, fmt.Errorf("%s to %s: %w", rt.from, to, ErrDropped)
+	}
+	if !d.duplicate {
+		return rt.next.RoundTrip(req)
+	}
+
+	// the body is read once for both requests
+	var body []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+
+	resp, err := rt.next.RoundTrip(withBody(req, body))
+	if err != nil {
+		return nil, err
+	}
+	if dup, err := rt.next.RoundTrip(withBody(req, body)); err == nil {
+		io.Copy(io.Discard, dup.Body)
+		dup.Body.Close()
+	}
+	return resp, nil
+}
+
+func withBody(req *http.Request, body []byte) *http.Request {
+	r := req.Clone(req.Context())
+	if body != nil {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+	}
+	return r
+}
+
+// Listener closes the connections accepted by an isolated node, whoever the sender is. The other faults are
+// applied by the senders' RoundTripper
+func (n *Network) Listener(name string, l net.Listener) net.Listener {
+	return listener{Listener: l, n: n, name: name}
+}
+
+type listener struct {
+	net.Listener
+	n    *Network
+	name string
+}
+
+func (l listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if !l.n.isolated(l.name) {
+			return conn, nil
+		}
+		conn.Close()
+	}
+}
+
+// isolated tells if every node is cut from this one
+func (n *Network) isolated(name string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	_, ok := n.cuts[link{Any, name}]
+	return ok
+}
@@ -0,0 +1,112 @@
+package fault_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gop2p/fault"
+)
+
+// echo counts the requests and the bodies it receives
+type echo struct {
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (e *echo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := io.ReadAll(r.Body)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.bodies = append(e.bodies, string(b))
+}
+
+// drops sends n requests from alice to bob and tells which ones were dropped
+func drops(network *fault.Network, url string, n int) []bool {
+	client := network.Client("alice", nil)
+	dropped := make([]bool, n)
+	for i := range dropped {
+		r, err := client.Post(url, "text/plain", strings.NewReader("hi"))
+		if err == nil {
+			r.Body.Close()
+		}
+		dropped[i] = errors.Is(err, fault.ErrDropped)
+	}
+	return dropped
+}
+
+func TestNetwork(t *testing.T) {
+	Convey("given bob behind a network", t, func() {
+		bob := &echo{}
+		s := httptest.NewUnstartedServer(bob)
+		network := fault.NewNetwork(42)
+		s.Listener = network.Listener("bob", s.Listener)
+		s.Start()
+		defer s.Close()
+		network.Node("bob", strings.TrimPrefix(s.URL, "http://"))
+
+		Convey("without faults every request gets through", func() {
+			So(drops(network, s.URL, 10), ShouldNotContain, true)
+			So(bob.bodies, ShouldHaveLength, 10)
+		})
+
+		Convey("when half the requests are dropped", func() {
+			network.Set("alice", "bob", fault.Faults{Drop: 0.5})
+			first := drops(network, s.URL, 20)
+
+			Convey("some are and some aren't", func() {
+				So(first, ShouldContain, true)
+				So(first, ShouldContain, false)
+			})
+
+			Convey("the same ones are dropped with the same seed", func() {
+				network.Reset()
+				network.Set("alice", "bob", fault.Faults{Drop: 0.5})
+				So(drops(network, s.URL, 20), ShouldResemble, first)
+			})
+
+			Convey("the other links aren't affected", func() {
+				r, err := network.Client("carol", nil).Get(s.URL)
+				So(err, ShouldBeNil)
+				r.Body.Close()
+			})
+		})
+
+		Convey("when the requests are duplicated", func() {
+			network.Set(fault.Any, "bob", fault.Faults{Duplicate: 1})
+			drops(network, s.URL, 1)
+
+			Convey("bob gets the body twice", func() {
+				So(bob.bodies, ShouldResemble, []string{"hi", "hi"})
+			})
+		})
+
+		Convey("when alice and bob are partitioned", func() {
+			network.Partition([]string{"alice"}, []string{"bob", "carol"})
+			_, err := network.Client("alice", nil).Get(s.URL)
+
+			Convey("alice can't reach bob until healed", func() {
+				So(errors.Is(err, fault.ErrPartitioned), ShouldBeTrue)
+
+				network.Heal()
+				r, err := network.Client("alice", nil).Get(s.URL)
+				So(err, ShouldBeNil)
+				r.Body.Close()
+			})
+		})
+
+		Convey("when bob is isolated", func() {
+			network.Isolate("bob")
+
+			Convey("even the clients which don't go through the network can't connect", func() {
+				_, err := http.Get(s.URL)
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
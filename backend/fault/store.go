@@ -0,0 +1,43 @@
+package fault
+
+import "sync"
+
+// Method is a method of a store, each failable store declares its own
+type Method string
+
+// Methods are the methods of a store made to fail, the zero value fails nothing
+type Methods struct {
+	mu      sync.RWMutex
+	failing map[Method]struct{}
+}
+
+// Inject makes the method fail until Reset, several methods can fail at once
+func (m *Methods) Inject(method Method) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.failing == nil {
+		m.failing = map[Method]struct{}{}
+	}
+	m.failing[method] = struct{}{}
+}
+
+// Reset makes every method work again
+func (m *Methods) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.failing = nil
+}
+
+// Fails tells if the method must fail, it's false for a nil Methods so that the stores which can't fail don't need one
+func (m *Methods) Fails(method Method) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.failing[method]
+	return ok
+}
@@ -0,0 +1,288 @@
+// Package harness runs a central server and its clients in the test process, on ephemeral ports, so that the
+// scenarios spanning several nodes can be played with go test
+package harness
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"gop2p/domain"
+	blobstore "gop2p/driven/fs.blobStore"
+	clientgateway "gop2p/driven/http.clientGateway"
+	servergateway "gop2p/driven/http.serverGateway"
+	conversationmanager "gop2p/driven/inMem.conversationManager"
+	sessionmanager "gop2p/driven/inMem.sessionManager"
+	signalbroker "gop2p/driven/inMem.signalBroker"
+	userstore "gop2p/driven/inMem.userStore"
+	mux "gop2p/driving/api.mux"
+	"gop2p/fault"
+	"gop2p/tracing"
+	"gop2p/uc"
+)
+
+// DefaultTimeout is how long the Await helpers wait
+const DefaultTimeout = 5 * time.Second
+
+// DefaultSeed of the faults of the network
+const DefaultSeed = 1
+
+// ServerName is the name of the central server in the faults of the network, the clients are named after their device
+const ServerName = "server"
+
+// pollInterval is how often the Await helpers look again
+const pollInterval = 20 * time.Millisecond
+
+// Network is a central server and its clients, everything is stopped with the test
+type Network struct {
+	t testing.TB
+
+	// Server is the central server, Users its store
+	Server *httptest.Server
+	Users  uc.UserStore
+
+	Clients []*Client
+
+	// Faults of the traffic between the server and the clients, there's none until they're set
+	Faults *fault.Network
+
+	// Timeout of the Await helpers, DefaultTimeout if not changed
+	Timeout time.Duration
+}
+
+// Client is a client of the network, driven through its front API like a front end would
+type Client struct {
+	network *Network
+
+	// Name of the client in the faults of the network
+	Name string
+
+	// Front is the router of the front end, P2P the one of the other clients
+	Front *httptest.Server
+	P2P   *httptest.Server
+
+	Account *uc.Account
+
+	// Sweeper purges the messages past their retention when it's told to, there's no default retention
+	Sweeper *uc.RetentionSweeper
+}
+
+// New starts the central server and n clients, the spans are not exported
+func New(t testing.TB, n int) *Network {
+	t.Helper()
+
+	if _, err := tracing.Setup(tracing.Config{Exporter: tracing.ExporterNone}); err != nil {
+		t.Fatal(err)
+	}
+
+	us := userstore.New()
+	sm := sessionmanager.New()
+	network := &Network{t: t, Users: us, Faults: fault.NewNetwork(DefaultSeed), Timeout: DefaultTimeout}
+	network.Server = network.serve(ServerName, mux.ServerRouter{Logic: uc.NewServerLogic(us, sm)}.Handler())
+
+	for i := 0; i < n; i++ {
+		network.Clients = append(network.Clients, network.startClient(fmt.Sprintf("device-%d", i)))
+	}
+	return network
+}
+
+// startClient wires a client the way the start command does
+func (n *Network) startClient(device string) *Client {
+	n.t.Helper()
+
+	bs, err := blobstore.New(n.t.TempDir())
+	if err != nil {
+		n.t.Fatal(err)
+	}
+
+	t := mux.DefaultTransport()
+	t.Client = n.Faults.Client(device, nil)
+	serverAddress := address(n.Server)
+	cm := conversationmanager.New()
+	sb := signalbroker.New()
+	account := uc.NewAccount(device)
+
+	sg := servergateway.New(serverAddress, t, domain.Supported())
+
+	p2p := n.serve(device, mux.ClientP2pRouter{
+		Logic:        uc.NewClientP2pLogic(cm, bs, sb, account),
+		Capabilities: domain.Supported(),
+		Senders:      uc.NewSenderVerifier(sg, account),
+	}.Handler())
+
+	front := httptest.NewServer(mux.ClientFrontRouter{
+		Logic:         uc.NewClientFrontLogic(cm, sg, clientgateway.New(t, domain.Supported()), bs, sb, account),
+		ServerAddress: &url.URL{Host: serverAddress},
+		Transport:     t,
+		Capabilities:  domain.Supported(),
+		Device:        device,
+	}.Handler())
+	// the front end streams may still be open, so they're closed before the server
+	n.t.Cleanup(front.Close)
+	n.t.Cleanup(front.CloseClientConnections)
+
+	return &Client{network: n, Name: device, Front: front, P2P: p2p, Account: account, Sweeper: uc.NewRetentionSweeper(cm, domain.Retention{})}
+}
+
+// serve the handler of a node behind the faults of the network
+func (n *Network) serve(name string, h http.Handler) *httptest.Server {
+	s := httptest.NewUnstartedServer(h)
+	s.Listener = n.Faults.Listener(name, s.Listener)
+	s.Start()
+	n.t.Cleanup(s.Close)
+
+	n.Faults.Node(name, address(s))
+	return s
+}
+
+// Register creates the user on the central server if needed and logs the client in with it
+func (c *Client) Register(login, password string) error {
+	ctx := context.Background()
+
+	user, ok := c.network.Users.GetUserByLogin(ctx, login)
+	if !ok {
+		return fmt.Errorf("the user %s can't be read", login)
+	}
+	if user == nil && !c.network.Users.InsertUser(ctx, login, password) {
+		return fmt.Errorf("the user %s can't be created", login)
+	}
+
+	// the front API takes the login of the session from the header, like the terminal client sends it
+	header := http.Header{}
+	header.Set("user", login)
+	return c.call(http.MethodPost, mux.V1+"/sessions/", header, mux.CreateNewSessionBody{
+		Login:    login,
+		Password: password,
+		Address:  address(c.P2P),
+	}, nil)
+}
+
+// Send a message to another user
+func (c *Client) Send(to, content string) error {
+	return c.call(http.MethodPost, mux.V1+"/messages/", nil, mux.SendNewMessageBody{Message: content, To: to}, nil)
+}
+
+// Conversation returns the messages exchanged with another user, the latest last
+func (c *Client) Conversation(with string) ([]domain.Message, error) {
+	messages := []domain.Message{}
+	err := c.call(http.MethodGet, mux.V1+"/conversations/"+url.PathEscape(with), nil, nil, &messages)
+	return messages, err
+}
+
+// Export returns the JSON Lines export of the conversation with another user, or of all of them if with is empty
+func (c *Client) Export(with string) ([]byte, error) {
+	path := mux.V1 + "/export"
+	if with != "" {
+		path = mux.V1 + "/conversations/" + url.PathEscape(with) + "/export"
+	}
+
+	r, err := c.Front.Client().Get(c.Front.URL + path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s responded %s", path, r.Status)
+	}
+	return io.ReadAll(r.Body)
+}
+
+// Import an export, it returns how many messages weren't known yet
+func (c *Client) Import(export []byte) (int, error) {
+	r, err := c.Front.Client().Post(c.Front.URL+mux.V1+"/import", mux.ApplicationJSONLines, bytes.NewReader(export))
+	if err != nil {
+		return 0, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("POST %s responded %s", mux.V1+"/import", r.Status)
+	}
+	result := mux.ImportResult{}
+	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	return result.Imported, nil
+}
+
+// Retention returns the retention of the conversation with another user
+func (c *Client) Retention(with string) (domain.Retention, error) {
+	r := domain.Retention{}
+	err := c.call(http.MethodGet, mux.V1+"/conversations/"+url.PathEscape(with)+"/retention", nil, nil, &r)
+	return r, err
+}
+
+// SetRetention changes the retention of the conversation with another user, the TTL is sent to the other user
+func (c *Client) SetRetention(with string, r domain.Retention) error {
+	return c.call(http.MethodPut, mux.V1+"/conversations/"+url.PathEscape(with)+"/retention", nil, r, nil)
+}
+
+// AwaitMessage waits until the conversation with the author holds a message it wrote with this content
+func (c *Client) AwaitMessage(author, content string) (domain.Message, error) {
+	deadline := time.Now().Add(c.network.Timeout)
+	for {
+		messages, err := c.Conversation(author)
+		if err != nil {
+			return domain.Message{}, err
+		}
+		for _, m := range messages {
+			if m.Author == author && m.Content == content {
+				return m, nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return domain.Message{}, fmt.Errorf("no message %q from %s after %s", content, author, c.network.Timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// call sends body as JSON to the front API and decodes the response in resp if not nil
+func (c *Client) call(method, path string, header http.Header, body, resp interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewBuffer(b)
+	}
+
+	req, err := http.NewRequest(method, c.Front.URL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	for k := range header {
+		req.Header.Set(k, header.Get(k))
+	}
+	req.Header.Set("Content-Type", mux.ApplicationJSON)
+
+	r, err := c.Front.Client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s responded %s", method, path, r.Status)
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.NewDecoder(r.Body).Decode(resp)
+}
+
+// address is the host:port of a test server, as the sessions hold it
+func address(s *httptest.Server) string {
+	u, _ := url.Parse(s.URL)
+	return u.Host
+}
@@ -0,0 +1,256 @@
+package harness_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gop2p/domain"
+	mux "gop2p/driving/api.mux"
+	"gop2p/fault"
+	"gop2p/harness"
+)
+
+// the scenario of test.sh
+func TestAliceAndBob(t *testing.T) {
+	network := harness.New(t, 2)
+	alice, bob := network.Clients[0], network.Clients[1]
+
+	Convey("given alice and bob registered on the central server", t, func() {
+		So(alice.Register("alice", "pass"), ShouldBeNil)
+		So(bob.Register("bob", "pass"), ShouldBeNil)
+
+		Convey("when they exchange a few messages", func() {
+			So(alice.Send("bob", "salut bob, c est alice"), ShouldBeNil)
+			_, err := bob.AwaitMessage("alice", "salut bob, c est alice")
+			So(err, ShouldBeNil)
+
+			So(bob.Send("alice", "salut alice !"), ShouldBeNil)
+			_, err = alice.AwaitMessage("bob", "salut alice !")
+			So(err, ShouldBeNil)
+
+			Convey("the message of alice is delivered, the one she received has no status", func() {
+				messages, err := alice.Conversation("bob")
+				So(err, ShouldBeNil)
+				So(messages[len(messages)-2].Status, ShouldEqual, domain.MessageDelivered)
+				So(messages[len(messages)-1].Status, ShouldBeEmpty)
+			})
+
+			Convey("both have the whole conversation, in order", func() {
+				for _, c := range []*harness.Client{alice, bob} {
+					with := "bob"
+					if c == bob {
+						with = "alice"
+					}
+					messages, err := c.Conversation(with)
+					So(err, ShouldBeNil)
+					So(len(messages), ShouldBeGreaterThanOrEqualTo, 2)
+					last := messages[len(messages)-2:]
+					So(last[0].Content, ShouldEqual, "salut bob, c est alice")
+					So(last[1].Content, ShouldEqual, "salut alice !")
+				}
+			})
+		})
+
+		Convey("a message to a user without session isn't sent", func() {
+			So(alice.Send("carol", "hi"), ShouldNotBeNil)
+		})
+
+		Convey("a peer claiming to be a user without session is rejected", func() {
+			req, err := http.NewRequest(http.MethodPost, bob.P2P.URL+mux.V1+"/messages/", strings.NewReader(`{"type":"message","ref":"forged","message":"it's carol"}`))
+			So(err, ShouldBeNil)
+			req.Header.Set("user", "carol")
+			req.Header.Set("Content-Type", mux.ApplicationJSON)
+			r, err := bob.P2P.Client().Do(req)
+			So(err, ShouldBeNil)
+			r.Body.Close()
+			So(r.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			messages, err := bob.Conversation("carol")
+			So(err, ShouldBeNil)
+			So(messages, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestSameUserOnTwoDevices(t *testing.T) {
+	network := harness.New(t, 3)
+	laptop, phone, bob := network.Clients[0], network.Clients[1], network.Clients[2]
+
+	Convey("given alice logged in on two devices", t, func() {
+		So(laptop.Register("alice", "pass"), ShouldBeNil)
+		So(phone.Register("alice", "pass"), ShouldBeNil)
+		So(bob.Register("bob", "pass"), ShouldBeNil)
+
+		Convey("when bob writes to her", func() {
+			So(bob.Send("alice", "hello"), ShouldBeNil)
+
+			Convey("both devices get it", func() {
+				_, err := laptop.AwaitMessage("bob", "hello")
+				So(err, ShouldBeNil)
+				_, err = phone.AwaitMessage("bob", "hello")
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("when she answers from her phone", func() {
+			So(phone.Send("bob", "hi bob"), ShouldBeNil)
+
+			Convey("bob and her laptop get it", func() {
+				_, err := bob.AwaitMessage("alice", "hi bob")
+				So(err, ShouldBeNil)
+				messages, err := laptop.Conversation("bob")
+				So(err, ShouldBeNil)
+				So(messages, ShouldNotBeEmpty)
+				So(messages[len(messages)-1].Content, ShouldEqual, "hi bob")
+			})
+		})
+	})
+}
+
+func TestFaults(t *testing.T) {
+	network := harness.New(t, 2)
+	alice, bob := network.Clients[0], network.Clients[1]
+
+	Convey("given alice and bob registered on the central server", t, func() {
+		network.Faults.Reset()
+		So(alice.Register("alice", "pass"), ShouldBeNil)
+		So(bob.Register("bob", "pass"), ShouldBeNil)
+
+		Convey("when they're partitioned", func() {
+			network.Faults.Partition([]string{alice.Name}, []string{bob.Name})
+
+			Convey("alice can't reach bob, her message is kept as failed", func() {
+				So(alice.Send("bob", "are you there?"), ShouldNotBeNil)
+				messages, err := alice.Conversation("bob")
+				So(err, ShouldBeNil)
+				So(messages[len(messages)-1].Content, ShouldEqual, "are you there?")
+				So(messages[len(messages)-1].Status, ShouldEqual, domain.MessageFailed)
+			})
+
+			Convey("once healed, she can again", func() {
+				network.Faults.Heal()
+				So(alice.Send("bob", "there you are"), ShouldBeNil)
+				_, err := bob.AwaitMessage("alice", "there you are")
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("when bob is isolated", func() {
+			network.Faults.Isolate(bob.Name)
+
+			Convey("nobody can reach him", func() {
+				So(alice.Send("bob", "hello?"), ShouldNotBeNil)
+			})
+		})
+
+		Convey("when every message to bob is duplicated", func() {
+			network.Faults.Set(fault.Any, bob.Name, fault.Faults{Duplicate: 1})
+			So(alice.Send("bob", "once"), ShouldBeNil)
+
+			Convey("he only has it once", func() {
+				messages, err := bob.Conversation("alice")
+				So(err, ShouldBeNil)
+				count := 0
+				for _, m := range messages {
+					if m.Content == "once" {
+						count++
+					}
+				}
+				So(count, ShouldEqual, 1)
+			})
+		})
+
+		Convey("when the traffic to bob is slow", func() {
+			network.Faults.Set(alice.Name, bob.Name, fault.Faults{Latency: 50 * time.Millisecond})
+			start := time.Now()
+			So(alice.Send("bob", "slowly"), ShouldBeNil)
+
+			Convey("the message still arrives, late", func() {
+				So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 50*time.Millisecond)
+				_, err := bob.AwaitMessage("alice", "slowly")
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestExportImport(t *testing.T) {
+	network := harness.New(t, 3)
+	alice, bob, newMachine := network.Clients[0], network.Clients[1], network.Clients[2]
+
+	Convey("given alice and bob who exchanged messages", t, func() {
+		So(alice.Register("alice", "pass"), ShouldBeNil)
+		So(bob.Register("bob", "pass"), ShouldBeNil)
+		So(alice.Send("bob", "hi bob"), ShouldBeNil)
+		So(bob.Send("alice", "hi alice"), ShouldBeNil)
+		_, err := alice.AwaitMessage("bob", "hi alice")
+		So(err, ShouldBeNil)
+
+		Convey("when alice imports her export on a new machine", func() {
+			export, err := alice.Export("")
+			So(err, ShouldBeNil)
+			imported, err := newMachine.Import(export)
+			So(err, ShouldBeNil)
+			So(imported, ShouldBeGreaterThanOrEqualTo, 2)
+
+			Convey("her conversation with bob is restored", func() {
+				restored, err := newMachine.Conversation("bob")
+				So(err, ShouldBeNil)
+				original, err := alice.Conversation("bob")
+				So(err, ShouldBeNil)
+				So(len(restored), ShouldEqual, len(original))
+				So(restored[len(restored)-1].Content, ShouldEqual, "hi alice")
+			})
+
+			Convey("importing the export of the conversation again adds nothing", func() {
+				export, err := alice.Export("bob")
+				So(err, ShouldBeNil)
+				imported, err := newMachine.Import(export)
+				So(err, ShouldBeNil)
+				So(imported, ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestDisappearingMessages(t *testing.T) {
+	network := harness.New(t, 2)
+	alice, bob := network.Clients[0], network.Clients[1]
+
+	Convey("given alice and bob who exchanged messages", t, func() {
+		So(alice.Register("alice", "pass"), ShouldBeNil)
+		So(bob.Register("bob", "pass"), ShouldBeNil)
+		So(alice.Send("bob", "this will disappear"), ShouldBeNil)
+		_, err := bob.AwaitMessage("alice", "this will disappear")
+		So(err, ShouldBeNil)
+
+		Convey("when alice makes their messages disappear after a minute", func() {
+			So(alice.SetRetention("bob", domain.Retention{TTL: 60}), ShouldBeNil)
+
+			Convey("bob has the same TTL", func() {
+				r, err := bob.Retention("alice")
+				So(err, ShouldBeNil)
+				So(r.TTL, ShouldEqual, 60)
+			})
+
+			Convey("a minute later, the messages are gone for both", func() {
+				later := time.Now().Add(2 * time.Minute)
+				for _, c := range []*harness.Client{alice, bob} {
+					_, err := c.Sweeper.Sweep(context.Background(), later)
+					So(err, ShouldBeNil)
+				}
+
+				messages, err := alice.Conversation("bob")
+				So(err, ShouldBeNil)
+				So(messages, ShouldBeEmpty)
+				messages, err = bob.Conversation("alice")
+				So(err, ShouldBeNil)
+				So(messages, ShouldBeEmpty)
+			})
+		})
+	})
+}
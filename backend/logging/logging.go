@@ -0,0 +1,148 @@
+// Package logging is a structured and leveled logger (log/slog) carried by the context,
+// the lines are stamped with what the context knows (trace, login, route...) and the secrets are redacted.
+// The level can be changed while running (eg. on config reload)
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// the output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Redacted replaces the value of the sensitive attributes
+const Redacted = "[redacted]"
+
+// the attributes never written as is, whatever their group
+var sensitiveKeys = map[string]bool{
+	"password": true,
+	"content":  true,
+	"message":  true,
+}
+
+var (
+	level = new(slog.LevelVar)
+	root  atomic.Pointer[slog.Logger]
+
+	contextAttrs []func(context.Context) []slog.Attr
+)
+
+func init() {
+	_ = Setup(os.Stderr, FormatText)
+}
+
+// Setup replaces the root logger, the loggers already in a context keep the previous output
+func Setup(w io.Writer, format string) error {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+
+	var h slog.Handler
+	switch format {
+	case FormatText:
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q, expected %s or %s", format, FormatText, FormatJSON)
+	}
+
+	root.Store(slog.New(contextHandler{h}))
+	return nil
+}
+
+// ParseLevel converts a level name (debug, info, warn or error)
+func ParseLevel(name string) (slog.Level, error) {
+	l := slog.LevelInfo
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("unknown log level %q", name)
+	}
+	return l, nil
+}
+
+// SetLevel is safe to call concurrently with the logging functions
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// AddContextAttrs registers f to stamp every line with attributes found in its context (eg. the trace ids),
+// it must be called before logging (eg. in an init)
+func AddContextAttrs(f func(context.Context) []slog.Attr) {
+	contextAttrs = append(contextAttrs, f)
+}
+
+type ctxKey struct{}
+
+type loginKey struct{}
+
+// WithLogin returns a context whose lines are stamped with the login of the user acting,
+// unlike With a later call replaces it
+func WithLogin(ctx context.Context, login string) context.Context {
+	return context.WithValue(ctx, loginKey{}, login)
+}
+
+// With returns a context whose logger adds args (key, value pairs) to every line
+func With(ctx context.Context, args ...interface{}) context.Context {
+	return context.WithValue(ctx, ctxKey{}, FromContext(ctx).With(args...))
+}
+
+// FromContext returns the logger of ctx, the root one if there's none
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return root.Load()
+}
+
+func Debug(ctx context.Context, msg string, args ...interface{}) {
+	FromContext(ctx).DebugContext(ctx, msg, args...)
+}
+
+func Info(ctx context.Context, msg string, args ...interface{}) {
+	FromContext(ctx).InfoContext(ctx, msg, args...)
+}
+
+func Warn(ctx context.Context, msg string, args ...interface{}) {
+	FromContext(ctx).WarnContext(ctx, msg, args...)
+}
+
+func Error(ctx context.Context, msg string, args ...interface{}) {
+	FromContext(ctx).ErrorContext(ctx, msg, args...)
+}
+
+func redact(_ []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, Redacted)
+	}
+	return a
+}
+
+// contextHandler adds the login and the registered context attributes to the record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if login, ok := ctx.Value(loginKey{}).(string); ok && login != "" {
+		r.AddAttrs(slog.String("login", login))
+	}
+	for _, f := range contextAttrs {
+		r.AddAttrs(f(ctx)...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
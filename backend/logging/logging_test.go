@@ -0,0 +1,64 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gop2p/logging"
+)
+
+func TestLogging(t *testing.T) {
+	Convey("given a json logger", t, func() {
+		out := &bytes.Buffer{}
+		So(logging.Setup(out, logging.FormatJSON), ShouldBeNil)
+		defer logging.Setup(os.Stderr, logging.FormatText)
+
+		line := func() map[string]interface{} {
+			l := map[string]interface{}{}
+			So(json.Unmarshal(out.Bytes(), &l), ShouldBeNil)
+			return l
+		}
+
+		Convey("when the context carries attributes", func() {
+			ctx := logging.With(context.Background(), "route", "/sessions/")
+			logging.Info(ctx, "hello")
+
+			Convey("they're on the line", func() {
+				So(line()["route"], ShouldEqual, "/sessions/")
+			})
+		})
+
+		Convey("when the login is set twice", func() {
+			ctx := logging.WithLogin(logging.WithLogin(context.Background(), "alice"), "bob")
+			logging.Info(ctx, "hello")
+
+			Convey("the last one is on the line", func() {
+				So(line()["login"], ShouldEqual, "bob")
+			})
+		})
+
+		Convey("when sensitive attributes are logged", func() {
+			logging.Info(context.Background(), "hello", "password", "s3cr3t", "content", "hi bob")
+
+			Convey("their value is redacted", func() {
+				So(line()["password"], ShouldEqual, logging.Redacted)
+				So(line()["content"], ShouldEqual, logging.Redacted)
+			})
+		})
+
+		Convey("when the level is raised", func() {
+			logging.SetLevel(slog.LevelWarn)
+			defer logging.SetLevel(slog.LevelInfo)
+			logging.Info(context.Background(), "hello")
+
+			Convey("the lower levels are dropped", func() {
+				So(out.Len(), ShouldEqual, 0)
+			})
+		})
+	})
+}
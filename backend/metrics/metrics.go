@@ -0,0 +1,109 @@
+// Package metrics holds the prometheus collectors of the app,
+// they're all in the same registry served on /metrics by every router
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gop2p"
+
+// Registry is also used by the tracer to expose its own metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by router, route and status.",
+	}, []string{"router", "route", "method", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP requests latency, by router, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"router", "route", "method", "status"})
+
+	messages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_total",
+		Help:      "P2P messages, by result: sent, received or failed.",
+	}, []string{"result"})
+
+	gatewayRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gateway_retries_total",
+		Help:      "Calls retried by the gateways, by gateway.",
+	}, []string{"gateway"})
+
+	attachmentBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "attachment_bytes_total",
+		Help:      "Bytes of attachments transferred between the peers, by direction: sent or received.",
+	}, []string{"direction"})
+
+	outboxDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "outbox_depth",
+		Help:      "Messages waiting to be delivered to a peer.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		messages,
+		gatewayRetries,
+		attachmentBytes,
+		outboxDepth,
+	)
+}
+
+// Handler serves the registry
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest counts a request and its latency, route must be the route pattern (not the path) to keep the cardinality low
+func ObserveRequest(router, route, method string, status int, d time.Duration) {
+	s := strconv.Itoa(status)
+	httpRequests.WithLabelValues(router, route, method, s).Inc()
+	httpDuration.WithLabelValues(router, route, method, s).Observe(d.Seconds())
+}
+
+func MessageSent()     { messages.WithLabelValues("sent").Inc() }
+func MessageReceived() { messages.WithLabelValues("received").Inc() }
+func MessageFailed()   { messages.WithLabelValues("failed").Inc() }
+
+// ChunkSent and ChunkReceived count the bytes of the attachments transferred
+func ChunkSent(n int)     { attachmentBytes.WithLabelValues("sent").Add(float64(n)) }
+func ChunkReceived(n int) { attachmentBytes.WithLabelValues("received").Add(float64(n)) }
+
+// GatewayRetry counts a retried call of the gateway
+func GatewayRetry(gateway string) {
+	gatewayRetries.WithLabelValues(gateway).Inc()
+}
+
+// MessageQueued and MessageDequeued track the messages in the outbox
+func MessageQueued()   { outboxDepth.Inc() }
+func MessageDequeued() { outboxDepth.Dec() }
+
+// RegisterActiveSessions exposes the number of sessions known by the server, count is called on every scrape
+func RegisterActiveSessions(count func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_sessions",
+		Help:      "Sessions known by the server.",
+	}, func() float64 {
+		return float64(count())
+	}))
+}
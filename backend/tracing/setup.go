@@ -0,0 +1,124 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// the exporters where the spans can be sent
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// Config of the tracer
+type Config struct {
+	// Exporter is none, otlp, stdout or file
+	Exporter    string
+	ServiceName string
+
+	// Endpoint is the host:port of the OTLP/HTTP collector, the OTEL_EXPORTER_OTLP_* env vars are used if empty
+	Endpoint string
+	Insecure bool
+
+	// File receives the spans as JSON with the file exporter
+	File string
+
+	// SampleRatio is the part of the traces kept, between 0 and 1
+	SampleRatio float64
+}
+
+// Validate reports the first invalid setting
+func (c Config) Validate() error {
+	switch c.Exporter {
+	case ExporterNone, ExporterOTLP, ExporterStdout:
+	case ExporterFile:
+		if c.File == "" {
+			return fmt.Errorf("a file is mandatory with the %s exporter", ExporterFile)
+		}
+	default:
+		return fmt.Errorf("unknown exporter %q, expected %s, %s, %s or %s", c.Exporter, ExporterNone, ExporterOTLP, ExporterStdout, ExporterFile)
+	}
+
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("the sample ratio must be between 0 and 1, got %v", c.SampleRatio)
+	}
+	return nil
+}
+
+// Setup installs the global tracer and the W3C trace context propagation,
+// the returned function flushes the spans not exported yet and must be called before exiting
+func Setup(c Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	exporter, closer, err := newExporter(c)
+	if err != nil {
+		return nil, err
+	}
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(c.ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			closer.Close()
+		}
+		return err
+	}, nil
+}
+
+// newExporter returns a nil exporter when the spans aren't exported at all
+func newExporter(c Config) (sdktrace.SpanExporter, io.Closer, error) {
+	switch c.Exporter {
+	case ExporterOTLP:
+		opts := []otlptracehttp.Option{}
+		if c.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(c.Endpoint))
+		}
+		if c.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		e, err := otlptracehttp.New(context.Background(), opts...)
+		return e, nil, err
+
+	case ExporterStdout:
+		e, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		return e, nil, err
+
+	case ExporterFile:
+		f, err := os.OpenFile(c.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		e, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		return e, f, err
+
+	default:
+		return nil, nil, nil
+	}
+}
@@ -0,0 +1,102 @@
+// Package tracing is the only place that knows about the tracing SDK (OpenTelemetry),
+// the rest of the app starts spans, records errors and propagates the trace context over http with it
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"gop2p/logging"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentation = "gop2p"
+
+// Span is the part of a span used by the app
+type Span interface {
+	// End must be called once the work is done, usually deferred
+	End()
+	// Error records err, marks the span as failed and logs it
+	Error(err error)
+	// Event records something that happened during the span
+	Event(name string)
+	// SetAttribute annotates the span, the value is stored as a string unless it's a bool or an int
+	SetAttribute(key string, value interface{})
+}
+
+// span keeps its context to log its errors with the trace ids
+type span struct {
+	s    trace.Span
+	name string
+	ctx  context.Context
+}
+
+func init() {
+	logging.AddContextAttrs(func(ctx context.Context) []slog.Attr {
+		sc := trace.SpanContextFromContext(ctx)
+		if !sc.IsValid() {
+			return nil
+		}
+		return []slog.Attr{
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		}
+	})
+}
+
+// Start starts a span, child of the one in ctx if any, and returns the context holding it
+func Start(ctx context.Context, name string) (Span, context.Context) {
+	ctx, s := otel.Tracer(instrumentation).Start(ctx, name)
+	return span{s: s, name: name, ctx: ctx}, ctx
+}
+
+// FromContext returns the current span of ctx, a no-op one if there's none
+func FromContext(ctx context.Context) Span {
+	return span{s: trace.SpanFromContext(ctx), ctx: ctx}
+}
+
+// Inject writes the trace context of ctx in the headers of an outgoing request (W3C traceparent)
+func Inject(ctx context.Context, h http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// StartFromRequest starts a server span, child of the remote one carried by the request headers if any
+func StartFromRequest(name string, r *http.Request) (Span, context.Context) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, s := otel.Tracer(instrumentation).Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+	return span{s: s, name: name, ctx: ctx}, ctx
+}
+
+func (s span) End() {
+	s.s.End()
+}
+
+func (s span) Error(err error) {
+	logging.Warn(s.ctx, "span error", "span", s.name, "err", err)
+	s.s.RecordError(err)
+	s.s.SetStatus(codes.Error, err.Error())
+}
+
+func (s span) Event(name string) {
+	s.s.AddEvent(name)
+}
+
+func (s span) SetAttribute(key string, value interface{}) {
+	switch v := value.(type) {
+	case bool:
+		s.s.SetAttributes(attribute.Bool(key, v))
+	case int:
+		s.s.SetAttributes(attribute.Int(key, v))
+	case string:
+		s.s.SetAttributes(attribute.String(key, v))
+	default:
+		s.s.SetAttributes(attribute.String(key, fmt.Sprint(v)))
+	}
+}
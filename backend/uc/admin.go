@@ -0,0 +1,150 @@
+package uc
+
+import (
+	"context"
+	"gop2p/domain"
+	"gop2p/tracing"
+)
+
+// AdminLogic handles the operators' actions on the central server, it's a struct for the same reasons as ServerLogic
+type AdminLogic struct {
+	ListUsers       func(ctx context.Context) ([]domain.User, error)
+	ResetPassword   func(ctx context.Context, login, password string) error
+	SetUserDisabled func(ctx context.Context, login string, disabled bool) error
+	DeleteUser      func(ctx context.Context, login string) error
+	ListSessions    func(ctx context.Context) (map[string][]domain.Session, error)
+	RevokeSession   func(ctx context.Context, login string) error
+}
+
+type adminInteractor struct {
+	uS UserStore
+	sM SessionManager
+}
+
+func NewAdminLogic(uS UserStore, sM SessionManager) AdminLogic {
+	i := adminInteractor{
+		uS,
+		sM,
+	}
+	return AdminLogic{
+		ListUsers:       i.ListUsers,
+		ResetPassword:   i.ResetPassword,
+		SetUserDisabled: i.SetUserDisabled,
+		DeleteUser:      i.DeleteUser,
+		ListSessions:    i.ListSessions,
+		RevokeSession:   i.RevokeSession,
+	}
+}
+
+// ListUsers returns all the users, passwords included so the driving side has to filter them out
+func (i adminInteractor) ListUsers(ctx context.Context) ([]domain.User, error) {
+	span, ctx := tracing.Start(ctx, "uc:admin_list_users")
+	defer span.End()
+
+	users, ok := i.uS.ListUsers(ctx)
+	if !ok {
+		return nil, domain.ErrTechnical{}
+	}
+	return users, nil
+}
+
+// ResetPassword replaces the password of an existing user, their current session is kept
+func (i adminInteractor) ResetPassword(ctx context.Context, login, password string) error {
+	span, ctx := tracing.Start(ctx, "uc:admin_reset_password")
+	defer span.End()
+
+	if password == "" {
+		return domain.ErrMalformed{Details: []string{"the password can't be empty"}}
+	}
+
+	if err := i.userMustExist(ctx, login); err != nil {
+		return err
+	}
+
+	if ok := i.uS.UpdatePassword(ctx, login, password); !ok {
+		return domain.ErrTechnical{}
+	}
+	return nil
+}
+
+// SetUserDisabled disables (or enables back) a user, a disabled user loses their current session
+func (i adminInteractor) SetUserDisabled(ctx context.Context, login string, disabled bool) error {
+	span, ctx := tracing.Start(ctx, "uc:admin_set_user_disabled")
+	defer span.End()
+
+	if err := i.userMustExist(ctx, login); err != nil {
+		return err
+	}
+
+	if ok := i.uS.SetUserDisabled(ctx, login, disabled); !ok {
+		return domain.ErrTechnical{}
+	}
+
+	if disabled {
+		if ok := i.sM.DeleteSession(ctx, login); !ok {
+			return domain.ErrTechnical{}
+		}
+	}
+	return nil
+}
+
+// DeleteUser removes a user and their session
+func (i adminInteractor) DeleteUser(ctx context.Context, login string) error {
+	span, ctx := tracing.Start(ctx, "uc:admin_delete_user")
+	defer span.End()
+
+	if err := i.userMustExist(ctx, login); err != nil {
+		return err
+	}
+
+	// the session goes first so a failure never leaves a session without user
+	if ok := i.sM.DeleteSession(ctx, login); !ok {
+		return domain.ErrTechnical{}
+	}
+	if ok := i.uS.DeleteUser(ctx, login); !ok {
+		return domain.ErrTechnical{}
+	}
+	return nil
+}
+
+// ListSessions returns the active sessions of every device by login
+func (i adminInteractor) ListSessions(ctx context.Context) (map[string][]domain.Session, error) {
+	span, ctx := tracing.Start(ctx, "uc:admin_list_sessions")
+	defer span.End()
+
+	sessions, ok := i.sM.ListSessions(ctx)
+	if !ok {
+		return nil, domain.ErrTechnical{}
+	}
+	return sessions, nil
+}
+
+// RevokeSession kicks a user out of all their devices, they have to start new sessions to be reachable again
+func (i adminInteractor) RevokeSession(ctx context.Context, login string) error {
+	span, ctx := tracing.Start(ctx, "uc:admin_revoke_session")
+	defer span.End()
+
+	sessions, ok := i.sM.GetSessions(ctx, login)
+	if !ok {
+		return domain.ErrTechnical{}
+	}
+	if len(sessions) == 0 {
+		return domain.ErrResourceNotFound{}
+	}
+
+	if ok := i.sM.DeleteSession(ctx, login); !ok {
+		return domain.ErrTechnical{}
+	}
+	return nil
+}
+
+func (i adminInteractor) userMustExist(ctx context.Context, login string) error {
+	u, ok := i.uS.GetUserByLogin(ctx, login)
+	if !ok {
+		return domain.ErrTechnical{}
+	}
+	if u == nil {
+		return domain.ErrResourceNotFound{}
+	}
+	return nil
+}
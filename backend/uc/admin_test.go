@@ -0,0 +1,140 @@
+package uc_test
+
+import (
+	"context"
+	"gop2p/domain"
+	"gop2p/uc"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	sessionManager "gop2p/driven/inMem.sessionManager"
+	userStore "gop2p/driven/inMem.userStore"
+)
+
+// cleanAdminLogic provides the admin usecases with fresh stores
+func cleanAdminLogic() (uc.UserStore, uc.SessionManager, uc.AdminLogic) {
+	us := userStore.New()
+	sm := sessionManager.New()
+	return us, sm, uc.NewAdminLogic(us, sm)
+}
+
+func TestAdminUsers(t *testing.T) {
+	aliceName := "alice"
+	aliceAddr := "alice:1234"
+	ctx := context.Background()
+
+	Convey("given a connected user", t, func() {
+		uS, sM, aI := cleanAdminLogic()
+		So(uS.InsertUser(ctx, aliceName, "pass"), ShouldBeTrue)
+		So(sM.InsertSession(ctx, aliceName, "laptop", aliceAddr, domain.Capabilities{}), ShouldBeTrue)
+
+		Convey("they are listed", func() {
+			users, err := aI.ListUsers(ctx)
+			So(err, ShouldBeNil)
+			So(users, ShouldHaveLength, 1)
+			So(users[0].Login, ShouldEqual, aliceName)
+		})
+
+		Convey("when their password is reset", func() {
+			err := aI.ResetPassword(ctx, aliceName, "newPass")
+			noErrorReturned(err)
+			Convey("they can only log in with the new one", func() {
+				u, _ := uS.GetUserByLoginPassword(ctx, aliceName, "newPass")
+				So(u, ShouldNotBeNil)
+				u, _ = uS.GetUserByLoginPassword(ctx, aliceName, "pass")
+				So(u, ShouldBeNil)
+			})
+		})
+
+		Convey("when they are disabled", func() {
+			err := aI.SetUserDisabled(ctx, aliceName, true)
+			noErrorReturned(err)
+			noSessionIsCreated(sM, aliceName)
+
+			Convey("they can't start a new session", func() {
+				err := uc.NewServerLogic(uS, sM).StartSession(ctx, aliceName, "pass", "laptop", aliceAddr, domain.Capabilities{})
+				So(err, ShouldHaveSameTypeAs, domain.ErrUnauthorized{})
+			})
+
+			Convey("they can start a new session once enabled back", func() {
+				So(aI.SetUserDisabled(ctx, aliceName, false), ShouldBeNil)
+				err := uc.NewServerLogic(uS, sM).StartSession(ctx, aliceName, "pass", "laptop", aliceAddr, domain.Capabilities{})
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("when they are deleted", func() {
+			err := aI.DeleteUser(ctx, aliceName)
+			noErrorReturned(err)
+			noSessionIsCreated(sM, aliceName)
+			Convey("they are removed from the store", func() {
+				u, ok := uS.GetUserByLogin(ctx, aliceName)
+				So(ok, ShouldBeTrue)
+				So(u, ShouldBeNil)
+			})
+		})
+
+		Convey("when an unknown user password is reset", func() {
+			resourceNotFoundErrIsReturned(aI.ResetPassword(ctx, "unknown", "pass"))
+		})
+		Convey("when an unknown user is disabled", func() {
+			resourceNotFoundErrIsReturned(aI.SetUserDisabled(ctx, "unknown", true))
+		})
+		Convey("when an unknown user is deleted", func() {
+			resourceNotFoundErrIsReturned(aI.DeleteUser(ctx, "unknown"))
+		})
+	})
+
+	Convey("when everything should go fine", t, func() {
+		us := userStore.NewFailable()
+		So(us.InsertUser(ctx, aliceName, "pass"), ShouldBeTrue)
+
+		Convey("but a tech error happens when listing the users", func() {
+			us.InjectErrorAt(userStore.ListUsers)
+			users, err := uc.NewAdminLogic(us, sessionManager.New()).ListUsers(ctx)
+			techErrIsReturned(err)
+			So(users, ShouldBeNil)
+		})
+
+		Convey("but a tech error happens when deleting the session of a deleted user", func() {
+			sm := sessionManager.NewFailable()
+			sm.InjectErrorAt(sessionManager.DeleteSession)
+			err := uc.NewAdminLogic(us, sm).DeleteUser(ctx, aliceName)
+			techErrIsReturned(err)
+			Convey("the user is kept", func() {
+				u, _ := us.GetUserByLogin(ctx, aliceName)
+				So(u, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestAdminSessions(t *testing.T) {
+	aliceName := "alice"
+	aliceAddr := "alice:1234"
+	ctx := context.Background()
+
+	Convey("given a connected user", t, func() {
+		uS, sM, aI := cleanAdminLogic()
+		So(uS.InsertUser(ctx, aliceName, "pass"), ShouldBeTrue)
+		So(sM.InsertSession(ctx, aliceName, "laptop", aliceAddr, domain.Capabilities{}), ShouldBeTrue)
+
+		Convey("their session is listed", func() {
+			sessions, err := aI.ListSessions(ctx)
+			So(err, ShouldBeNil)
+			So(sessions, ShouldContainKey, aliceName)
+			So(sessions[aliceName], ShouldHaveLength, 1)
+			So(sessions[aliceName][0].Address, ShouldEqual, aliceAddr)
+		})
+
+		Convey("when their session is revoked", func() {
+			err := aI.RevokeSession(ctx, aliceName)
+			noErrorReturned(err)
+			noSessionIsCreated(sM, aliceName)
+		})
+
+		Convey("revoking a missing session returns a resourceNotFound error", func() {
+			resourceNotFoundErrIsReturned(aI.RevokeSession(ctx, "unknown"))
+		})
+	})
+}
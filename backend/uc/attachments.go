@@ -0,0 +1,230 @@
+package uc
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"gop2p/domain"
+	"gop2p/logging"
+	"gop2p/tracing"
+	"io"
+	"path/filepath"
+)
+
+// UploadAttachment is used by the client to store a file before sending it with a message
+func (i clientFrontInteractor) UploadAttachment(ctx context.Context, name string, content io.Reader) (*domain.Attachment, error) {
+	span, ctx := tracing.Start(ctx, "uc:upload_attachment")
+	defer span.End()
+
+	name = filepath.Base(name)
+	if name == "." || name == string(filepath.Separator) {
+		return nil, domain.ErrMalformed{Details: []string{"the name is missing"}}
+	}
+
+	// one more byte tells if it's too big
+	b, err := io.ReadAll(io.LimitReader(content, domain.MaxAttachmentSize+1))
+	if err != nil {
+		span.Error(err)
+		return nil, domain.ErrMalformed{Details: []string{"the content can't be read"}}
+	}
+	if len(b) == 0 {
+		return nil, domain.ErrMalformed{Details: []string{"the file is empty"}}
+	}
+	if len(b) > domain.MaxAttachmentSize {
+		return nil, domain.ErrMalformed{Details: []string{fmt.Sprintf("the file is bigger than %d bytes", domain.MaxAttachmentSize)}}
+	}
+
+	a := domain.Attachment{Hash: domain.ContentHash(b), Name: name, Size: int64(len(b))}
+
+	blob, ok := i.bs.Stat(ctx, a.Hash)
+	if !ok {
+		return nil, domain.ErrTechnical{}
+	}
+	if blob == nil || !blob.Complete {
+		if ok := i.bs.Put(ctx, a.Hash, b); !ok {
+			return nil, domain.ErrTechnical{}
+		}
+	}
+
+	return &a, nil
+}
+
+// checkUploaded tells if the attachments of a message are all in the blob store
+func (i clientFrontInteractor) checkUploaded(ctx context.Context, attachments []domain.Attachment) error {
+	if len(attachments) > domain.MaxAttachments {
+		return domain.ErrMalformed{Details: []string{"too many attachments"}}
+	}
+
+	for _, a := range attachments {
+		if !a.Valid() {
+			return domain.ErrMalformed{Details: []string{"invalid attachment"}}
+		}
+		blob, ok := i.bs.Stat(ctx, a.Hash)
+		if !ok {
+			return domain.ErrTechnical{}
+		}
+		if blob == nil || !blob.Complete || blob.Size != a.Size {
+			return domain.ErrMalformed{Details: []string{fmt.Sprintf("%s isn't uploaded", a.Name)}}
+		}
+	}
+	return nil
+}
+
+// DownloadAttachment is used by the client to get an attachment of a conversation, it's downloaded from a device of
+// the other user, or another device of the current one, if it's not complete yet. A transfer which fails with a
+// device goes on with the next one, and is resumed by the next download if none is left
+func (i clientFrontInteractor) DownloadAttachment(ctx context.Context, with, hash string) (*domain.Attachment, error) {
+	span, ctx := tracing.Start(ctx, "uc:download_attachment")
+	defer span.End()
+
+	if err := i.unlocked(); err != nil {
+		return nil, err
+	}
+	a, ok := i.cm.FindAttachment(ctx, with, hash)
+	if !ok {
+		return nil, domain.ErrTechnical{}
+	}
+	if a == nil {
+		return nil, domain.ErrResourceNotFound{}
+	}
+
+	blob, ok := i.bs.Stat(ctx, hash)
+	if !ok {
+		return nil, domain.ErrTechnical{}
+	}
+	if blob != nil && blob.Complete {
+		return a, nil
+	}
+
+	emitter := i.account.Login()
+	if emitter == "" {
+		span.Error(errors.New("missing current user session"))
+		return nil, domain.ErrUnauthorized{}
+	}
+	ctx = logging.WithLogin(ctx, emitter)
+
+	sessions, err := i.peerSessions(ctx, emitter, with)
+	if err != nil {
+		return nil, err
+	}
+	sessions = append(sessions, i.ownDevices(ctx, emitter)...)
+
+	var offset int64
+	if blob != nil {
+		offset = blob.Size
+	}
+	for _, s := range sessions {
+		if offset, err = i.fetchChunks(ctx, s, *a, offset); err != nil {
+			return nil, err
+		}
+		if offset == a.Size {
+			break
+		}
+	}
+	if offset < a.Size {
+		span.Error(fmt.Errorf("no device could send the attachment from %d", offset))
+		return nil, domain.ErrTechnical{}
+	}
+
+	valid, ok := i.bs.Seal(ctx, hash)
+	if !ok {
+		return nil, domain.ErrTechnical{}
+	}
+	if !valid {
+		// it's dropped, the next download starts again
+		span.Error(errors.New("the attachment is corrupted"))
+		return nil, domain.ErrTechnical{}
+	}
+
+	logging.Info(ctx, "attachment downloaded", "with", with, "size", a.Size)
+	return a, nil
+}
+
+// fetchChunks downloads the attachment from a device until it fails, it returns how much is downloaded then
+func (i clientFrontInteractor) fetchChunks(ctx context.Context, s domain.Session, a domain.Attachment, offset int64) (int64, error) {
+	span := tracing.FromContext(ctx)
+
+	for offset < a.Size {
+		chunk, ok := i.cg.FetchChunk(ctx, s, a.Hash, offset, i.account.Login())
+		if !ok {
+			return offset, nil
+		}
+		if len(chunk) == 0 || offset+int64(len(chunk)) > a.Size {
+			span.Error(fmt.Errorf("unexpected chunk of %d bytes at %d", len(chunk), offset))
+			return offset, domain.ErrTechnical{}
+		}
+		if ok := i.bs.Append(ctx, a.Hash, offset, chunk); !ok {
+			return offset, domain.ErrTechnical{}
+		}
+		offset += int64(len(chunk))
+	}
+	return offset, nil
+}
+
+// ReadAttachment is used by the client to read a downloaded attachment chunk by chunk
+func (i clientFrontInteractor) ReadAttachment(ctx context.Context, hash string, offset int64) ([]byte, error) {
+	span, ctx := tracing.Start(ctx, "uc:read_attachment")
+	defer span.End()
+
+	chunk, ok := i.bs.ReadChunk(ctx, hash, offset, domain.ChunkSize)
+	if !ok {
+		return nil, domain.ErrTechnical{}
+	}
+	return chunk, nil
+}
+
+// ServeAttachmentChunk is used by the client to send a part of an attachment to a peer, only the attachments of
+// their conversation are served. The other devices of the user are served the attachments of every conversation
+func (i clientp2pInteractor) ServeAttachmentChunk(ctx context.Context, hash string, offset int64, requester domain.User) ([]byte, *domain.Attachment, error) {
+	span, ctx := tracing.Start(ctx, "uc:serve_attachment_chunk")
+	defer span.End()
+
+	if err := i.unlocked(); err != nil {
+		return nil, nil, err
+	}
+	a, ok := i.findAttachment(ctx, hash, requester)
+	if !ok {
+		return nil, nil, domain.ErrTechnical{}
+	}
+	if a == nil {
+		span.Error(errors.New("attachment not found"))
+		return nil, nil, domain.ErrResourceNotFound{}
+	}
+
+	blob, ok := i.bs.Stat(ctx, hash)
+	if !ok {
+		return nil, nil, domain.ErrTechnical{}
+	}
+	if blob == nil || !blob.Complete {
+		span.Error(errors.New("attachment not downloaded"))
+		return nil, nil, domain.ErrResourceNotFound{}
+	}
+	if offset < 0 || offset >= blob.Size {
+		return nil, nil, domain.ErrMalformed{Details: []string{"the offset is out of the attachment"}}
+	}
+
+	chunk, ok := i.bs.ReadChunk(ctx, hash, offset, domain.ChunkSize)
+	if !ok {
+		return nil, nil, domain.ErrTechnical{}
+	}
+	return chunk, a, nil
+}
+
+// findAttachment looks for the attachment in the conversations the requester can read, the history must be unlocked
+func (i clientp2pInteractor) findAttachment(ctx context.Context, hash string, requester domain.User) (*domain.Attachment, bool) {
+	if login := i.account.Login(); login == "" || requester.Login != login {
+		return i.cm.FindAttachment(ctx, requester.Login, hash)
+	}
+
+	conversations, ok := i.cm.ListConversations(ctx)
+	if !ok {
+		return nil, false
+	}
+	for _, c := range conversations {
+		a, ok := i.cm.FindAttachment(ctx, c.With, hash)
+		if !ok || a != nil {
+			return a, ok
+		}
+	}
+	return nil, true
+}
@@ -0,0 +1,167 @@
+package uc_test
+
+import (
+	"bytes"
+	"context"
+	"gop2p/domain"
+	"gop2p/uc"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	blobstore "gop2p/driven/fs.blobStore"
+	conversationManager "gop2p/driven/inMem.conversationManager"
+	signalbroker "gop2p/driven/inMem.signalBroker"
+)
+
+func TestUploadAttachment(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("given a client", t, func() {
+		bs, err := blobstore.New(t.TempDir())
+		So(err, ShouldBeNil)
+		peer := &peerStub{}
+		logic := uc.NewClientFrontLogic(conversationManager.New(), peer, peer, bs, nil, uc.NewAccount(""))
+		So(logic.NewSessionRegistered(ctx, "me"), ShouldBeNil)
+
+		Convey("when a file is uploaded", func() {
+			a, err := logic.UploadAttachment(ctx, "../notes.txt", strings.NewReader("see you"))
+			So(err, ShouldBeNil)
+
+			Convey("it's named after its base name and found by the hash of its content", func() {
+				So(*a, ShouldResemble, domain.Attachment{Hash: domain.ContentHash([]byte("see you")), Name: "notes.txt", Size: 7})
+			})
+
+			Convey("it can be sent without text", func() {
+				So(logic.SendMessageToOtherClient(ctx, "bob", "", []domain.Attachment{*a}), ShouldBeNil)
+			})
+		})
+
+		Convey("an attachment which wasn't uploaded can't be sent", func() {
+			a := domain.Attachment{Hash: domain.ContentHash([]byte("?")), Name: "unknown", Size: 1}
+			So(logic.SendMessageToOtherClient(ctx, "bob", "hi", []domain.Attachment{a}), ShouldHaveSameTypeAs, domain.ErrMalformed{})
+		})
+
+		Convey("an empty file is malformed", func() {
+			_, err := logic.UploadAttachment(ctx, "empty", strings.NewReader(""))
+			So(err, ShouldHaveSameTypeAs, domain.ErrMalformed{})
+		})
+
+		Convey("a file bigger than the max is malformed", func() {
+			_, err := logic.UploadAttachment(ctx, "big", bytes.NewReader(make([]byte, domain.MaxAttachmentSize+1)))
+			So(err, ShouldHaveSameTypeAs, domain.ErrMalformed{})
+		})
+	})
+}
+
+func TestDownloadAttachment(t *testing.T) {
+	ctx := context.Background()
+	content := bytes.Repeat([]byte("0123456789"), domain.ChunkSize/4)
+	a := domain.Attachment{Hash: domain.ContentHash(content), Name: "digits.txt", Size: int64(len(content))}
+
+	Convey("given a message of bob with an attachment of 3 chunks", t, func() {
+		bs, err := blobstore.New(t.TempDir())
+		So(err, ShouldBeNil)
+		cm := conversationManager.New()
+		So(cm.AppendToConversationWith(ctx, "bob", domain.Message{Ref: "r1", Author: "bob", Attachments: []domain.Attachment{a}}), ShouldBeTrue)
+		peer := &peerStub{files: map[string][]byte{a.Hash: content}, chunksLeft: -1}
+		logic := uc.NewClientFrontLogic(cm, peer, peer, bs, nil, uc.NewAccount(""))
+		So(logic.NewSessionRegistered(ctx, "me"), ShouldBeNil)
+
+		read := func() []byte {
+			b := []byte{}
+			for offset := int64(0); offset < a.Size; {
+				chunk, err := logic.ReadAttachment(ctx, a.Hash, offset)
+				So(err, ShouldBeNil)
+				So(chunk, ShouldNotBeEmpty)
+				b = append(b, chunk...)
+				offset += int64(len(chunk))
+			}
+			return b
+		}
+
+		Convey("when it's downloaded", func() {
+			downloaded, err := logic.DownloadAttachment(ctx, "bob", a.Hash)
+			So(err, ShouldBeNil)
+
+			Convey("its content is the one of bob", func() {
+				So(*downloaded, ShouldResemble, a)
+				So(read(), ShouldResemble, content)
+			})
+
+			Convey("it's not downloaded again", func() {
+				peer.chunksLeft = 0
+				_, err := logic.DownloadAttachment(ctx, "bob", a.Hash)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("when the transfer fails after the first chunk", func() {
+			peer.chunksLeft = 1
+			_, err := logic.DownloadAttachment(ctx, "bob", a.Hash)
+			techErrIsReturned(err)
+
+			Convey("the next download resumes from the second chunk", func() {
+				peer.chunksLeft = 2
+				_, err := logic.DownloadAttachment(ctx, "bob", a.Hash)
+				So(err, ShouldBeNil)
+				So(read(), ShouldResemble, content)
+			})
+		})
+
+		Convey("when bob sends something else than the attachment", func() {
+			peer.files[a.Hash] = bytes.ToUpper(bytes.Repeat([]byte("abcdefghij"), domain.ChunkSize/4))
+			_, err := logic.DownloadAttachment(ctx, "bob", a.Hash)
+
+			Convey("it's dropped", func() {
+				techErrIsReturned(err)
+				blob, ok := bs.Stat(ctx, a.Hash)
+				So(ok, ShouldBeTrue)
+				So(blob, ShouldBeNil)
+			})
+		})
+
+		Convey("an attachment which isn't in the conversation is not found", func() {
+			_, err := logic.DownloadAttachment(ctx, "alice", a.Hash)
+			resourceNotFoundErrIsReturned(err)
+		})
+	})
+}
+
+func TestServeAttachmentChunk(t *testing.T) {
+	ctx := context.Background()
+	content := []byte("see you")
+	a := domain.Attachment{Hash: domain.ContentHash(content), Name: "notes.txt", Size: int64(len(content))}
+
+	Convey("given an attachment sent to bob", t, func() {
+		bs, err := blobstore.New(t.TempDir())
+		So(err, ShouldBeNil)
+		So(bs.Put(ctx, a.Hash, content), ShouldBeTrue)
+		cm := conversationManager.New()
+		So(cm.AppendToConversationWith(ctx, "bob", domain.Message{Ref: "r1", Author: "me", Attachments: []domain.Attachment{a}}), ShouldBeTrue)
+		logic := uc.NewClientP2pLogic(cm, bs, signalbroker.New(), uc.NewAccount(""))
+
+		Convey("bob is served the chunk from the offset he asks", func() {
+			chunk, served, err := logic.ServeAttachmentChunk(ctx, a.Hash, 4, domain.User{Login: "bob"})
+			So(err, ShouldBeNil)
+			So(string(chunk), ShouldEqual, "you")
+			So(*served, ShouldResemble, a)
+		})
+
+		Convey("an offset out of the attachment is malformed", func() {
+			_, _, err := logic.ServeAttachmentChunk(ctx, a.Hash, a.Size, domain.User{Login: "bob"})
+			So(err, ShouldHaveSameTypeAs, domain.ErrMalformed{})
+		})
+
+		Convey("alice isn't served", func() {
+			_, _, err := logic.ServeAttachmentChunk(ctx, a.Hash, 0, domain.User{Login: "alice"})
+			resourceNotFoundErrIsReturned(err)
+		})
+
+		Convey("when the message is deleted, bob isn't served anymore", func() {
+			So(cm.DeleteMessage(ctx, "bob", "r1"), ShouldBeTrue)
+			_, _, err := logic.ServeAttachmentChunk(ctx, a.Hash, 0, domain.User{Login: "bob"})
+			resourceNotFoundErrIsReturned(err)
+		})
+	})
+}
@@ -2,87 +2,449 @@ package uc
 
 import (
 	"context"
-	"github.com/opentracing/opentracing-go"
-	"github.com/opentracing/opentracing-go/log"
+	"crypto/rand"
+	"encoding/hex"
 	"github.com/pkg/errors"
 	"gop2p/domain"
+	"gop2p/logging"
+	"gop2p/tracing"
+	"io"
+	"strings"
+	"time"
 )
 
 // ClientFrontLogic handles the logic exposed to the frontend
 type ClientFrontLogic interface {
 	NewSessionRegistered(ctx context.Context, username string) error
-	SendMessageToOtherClient(ctx context.Context, toUserName string, msg string) error
-	GetConversationWith(ctx context.Context, authorName string) ([]domain.Message, error)
+	PublishSession(ctx context.Context, username, address string, caps domain.Capabilities) error
+	SendMessageToOtherClient(ctx context.Context, toUserName string, msg string, attachments []domain.Attachment) error
+	GetConversationWith(ctx context.Context, authorName string, page domain.Page) ([]domain.Message, error)
+	ListConversations(ctx context.Context) ([]domain.Conversation, error)
+	SearchMessages(ctx context.Context, query string, limit int) ([]domain.SearchResult, error)
+	ExportConversations(ctx context.Context, with string) ([]domain.History, error)
+	ImportConversations(ctx context.Context, histories []domain.History) (int, error)
+	GetRetention(ctx context.Context, with string) (domain.Retention, error)
+	SetRetention(ctx context.Context, with string, r domain.Retention) error
+	HistoryEncryption(ctx context.Context) (domain.Encryption, error)
+	UnlockHistory(ctx context.Context, secret string) error
+	LockHistory(ctx context.Context) error
+	ChangeHistorySecret(ctx context.Context, current, secret string) error
+	RotateHistoryKey(ctx context.Context) error
+	EditMessage(ctx context.Context, with, ref, content string) error
+	DeleteMessage(ctx context.Context, with, ref string) error
+	ReactToMessage(ctx context.Context, with, ref, emoji string, on bool) error
+	ResendMessage(ctx context.Context, with, ref string) error
+	FlushOutbox(ctx context.Context, now time.Time) (int, error)
+	UploadAttachment(ctx context.Context, name string, content io.Reader) (*domain.Attachment, error)
+	DownloadAttachment(ctx context.Context, with, hash string) (*domain.Attachment, error)
+	ReadAttachment(ctx context.Context, hash string, offset int64) ([]byte, error)
+	SendSignal(ctx context.Context, with, signalType, ref string) error
+	Signals(ctx context.Context) (<-chan domain.Signal, error)
 }
 
 type clientFrontInteractor struct {
-	currentUsername string
-	cm              ConversationManager
-	sg              ServerGateway
-	cg              ClientGateway
+	account *Account
+	cm      ConversationManager
+	sg      ServerGateway
+	cg      ClientGateway
+	bs      BlobStore
+	sb      SignalBroker
+	typing  *throttle
+	outbox  *outbox
+	// unsynced is synced once the history is unlocked
+	unsynced *unsynced
 }
 
-func NewClientFrontLogic(cm ConversationManager, sg ServerGateway, cg ClientGateway) ClientFrontLogic {
+func NewClientFrontLogic(cm ConversationManager, sg ServerGateway, cg ClientGateway, bs BlobStore, sb SignalBroker, a *Account) ClientFrontLogic {
 	return &clientFrontInteractor{
-		currentUsername: "",
-		cm:              cm,
-		sg:              sg,
-		cg:              cg,
+		account:  a,
+		cm:       cm,
+		sg:       sg,
+		cg:       cg,
+		bs:       bs,
+		sb:       sb,
+		typing:   newThrottle(domain.TypingInterval),
+		outbox:   newOutbox(),
+		unsynced: &unsynced{},
 	}
 }
 
-// RegisterNewSession is used by the client to register a new session
+// RegisterNewSession is used by the client to register a new session, the messages the other devices of the user
+// have are then copied
 func (i *clientFrontInteractor) NewSessionRegistered(ctx context.Context, username string) error {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "uc:new_session_registered")
-	defer span.Finish()
+	span, ctx := tracing.Start(ctx, "uc:new_session_registered")
+	defer span.End()
 
-	i.currentUsername = username
+	i.account.setLogin(username)
+	ctx = logging.WithLogin(ctx, username)
+	logging.Info(ctx, "session registered", "device", i.account.Device)
+
+	i.syncHistory(ctx, username)
 	return nil
 }
 
-// SendMessageToOtherClient is used by the client to send a message to another one
-func (i clientFrontInteractor) SendMessageToOtherClient(ctx context.Context, toUserName string, msg string) error {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "uc:send_message_to_other_client")
-	defer span.Finish()
+// PublishSession is used by the client to announce its own session when no central server is involved,
+// it only works if the ServerGateway is also a SessionPublisher (eg. a DHT)
+func (i *clientFrontInteractor) PublishSession(ctx context.Context, username, address string, caps domain.Capabilities) error {
+	span, ctx := tracing.Start(ctx, "uc:publish_session")
+	defer span.End()
+
+	if !validAddress(address) {
+		return domain.ErrMalformed{Details: []string{"the address provided is invalid"}}
+	}
 
-	emitter := i.currentUsername
+	sp, ok := i.sg.(SessionPublisher)
+	if !ok {
+		span.Error(errors.New("server gateway can't publish sessions"))
+		return domain.ErrTechnical{}
+	}
+
+	if ok := sp.PublishSession(ctx, username, i.account.Device, address, caps); !ok {
+		return domain.ErrTechnical{}
+	}
+
+	i.account.setLogin(username)
+	logging.Info(logging.WithLogin(ctx, username), "session published", "address", address)
+	return nil
+}
+
+// SendMessageToOtherClient is used by the client to send a message to every device of another user, and to the
+// other devices of the current one. The attachments must have been uploaded first
+func (i clientFrontInteractor) SendMessageToOtherClient(ctx context.Context, toUserName string, msg string, attachments []domain.Attachment) error {
+	span, ctx := tracing.Start(ctx, "uc:send_message_to_other_client")
+	defer span.End()
+
+	emitter := i.account.Login()
 	if emitter == "" {
-		span.LogFields(log.Error(errors.New("missing current user session")))
+		span.Error(errors.New("missing current user session"))
 		return domain.ErrUnauthorized{}
 	}
+	ctx = logging.WithLogin(ctx, emitter)
+
+	if msg == "" && len(attachments) == 0 {
+		return domain.ErrMalformed{Details: []string{"the message is empty"}}
+	}
+	if err := i.unlocked(); err != nil {
+		return err
+	}
+	if err := i.checkUploaded(ctx, attachments); err != nil {
+		return err
+	}
+
+	sessions, err := i.peerSessions(ctx, emitter, toUserName)
+	if err != nil {
+		return err
+	}
+
+	// the message is stored before being sent so that it's never delivered without being in the history, its
+	// status then tells what happened
+	m := domain.Message{Ref: newRef(), Author: emitter, Content: msg, Date: time.Now(), Attachments: attachments, Status: domain.MessagePending}
+	if ok := i.cm.AppendToConversationWith(ctx, toUserName, m); !ok {
+		return domain.ErrTechnical{}
+	}
+	i.syncDevices(ctx, emitter, toUserName, domain.Event{Type: domain.EventMessage, Ref: m.Ref, Content: m.Content, Attachments: m.Attachments})
+
+	switch i.deliver(ctx, sessions, m, emitter) {
+	case domain.MessageDelivered:
+		i.setStatus(ctx, toUserName, m.Ref, domain.MessageDelivered)
+	case domain.MessagePending:
+		tracing.FromContext(ctx).SetAttribute("status", domain.MessagePending)
+		i.outbox.add(toUserName, domain.Event{Type: domain.EventMessage, Ref: m.Ref}, m.Date)
+	default:
+		i.setStatus(ctx, toUserName, m.Ref, domain.MessageFailed)
+		return domain.ErrTechnical{}
+	}
+
+	return nil
+}
+
+// ResendMessage is used by the client to send again one of its own messages that wasn't delivered, with the same
+// ref so that the devices which already have it ignore it
+func (i clientFrontInteractor) ResendMessage(ctx context.Context, with, ref string) error {
+	span, ctx := tracing.Start(ctx, "uc:resend_message")
+	defer span.End()
+
+	m, err := i.ownMessage(ctx, with, ref)
+	if err != nil {
+		return err
+	}
+	switch {
+	case m.Deleted:
+		return domain.ErrMalformed{Details: []string{"the message is deleted"}}
+	case m.Status != domain.MessagePending && m.Status != domain.MessageFailed:
+		return domain.ErrMalformed{Details: []string{"the message is already delivered"}}
+	case time.Since(m.Date) >= domain.DedupeWindow:
+		return domain.ErrMalformed{Details: []string{"the message is too old to be sent again"}}
+	}
 
-	s, ok := i.sg.AskSessionToServer(opentracing.ContextWithSpan(context.Background(), span), emitter, toUserName)
+	emitter := i.account.Login()
+	ctx = logging.WithLogin(ctx, emitter)
+	sessions, err := i.peerSessions(ctx, emitter, with)
+	if err != nil {
+		return err
+	}
+
+	switch i.deliver(ctx, sessions, *m, emitter) {
+	case domain.MessageDelivered:
+		i.setStatus(ctx, with, ref, domain.MessageDelivered)
+	case domain.MessagePending:
+		span.SetAttribute("status", domain.MessagePending)
+		if m.Status == domain.MessageFailed {
+			i.setStatus(ctx, with, ref, domain.MessagePending)
+		}
+		i.outbox.add(with, domain.Event{Type: domain.EventMessage, Ref: ref}, m.Date)
+	default:
+		i.setStatus(ctx, with, ref, domain.MessageFailed)
+		return domain.ErrTechnical{}
+	}
+
+	return nil
+}
+
+// deliver sends the message to every session of the peer and tells how it went: it stays pending while the devices
+// of the peer can't store it for now, and none has it yet
+func (i clientFrontInteractor) deliver(ctx context.Context, sessions []domain.Session, m domain.Message, emitter string) string {
+	pending := false
+	delivered := fanOut(ctx, sessions, func(s domain.Session) bool {
+		status := i.cg.SendMsg(ctx, s, m, emitter)
+		pending = pending || status == domain.MessagePending
+		return status == domain.MessageDelivered
+	})
+	switch {
+	case delivered:
+		return domain.MessageDelivered
+	case pending:
+		return domain.MessagePending
+	default:
+		return domain.MessageFailed
+	}
+}
+
+// setStatus stores how the delivery of a message ended, the message stays pending if it can't be
+func (i clientFrontInteractor) setStatus(ctx context.Context, with, ref, status string) {
+	span := tracing.FromContext(ctx)
+	span.SetAttribute("status", status)
+
+	if ok := i.cm.SetMessageStatus(ctx, with, ref, status); !ok {
+		span.Error(errors.New("status not stored"))
+	}
+}
+
+// GetConversationWith is used by the client to get a page of a given conversation,
+// the other user's messages in it are then read
+func (i clientFrontInteractor) GetConversationWith(ctx context.Context, authorName string, page domain.Page) ([]domain.Message, error) {
+	span, ctx := tracing.Start(ctx, "uc:get_conversation_with")
+	defer span.End()
+
+	if err := i.unlocked(); err != nil {
+		return nil, err
+	}
+	if page.Before > 0 && page.After > 0 {
+		return nil, domain.ErrMalformed{Details: []string{"a page is either before or after a message"}}
+	}
+	if page.Before < 0 || page.After < 0 || page.Limit < 0 || page.Limit > domain.MaxPageSize {
+		return nil, domain.ErrMalformed{Details: []string{"invalid page"}}
+	}
+	if page.Limit == 0 {
+		page.Limit = domain.DefaultPageSize
+	}
+
+	messages, ok := i.cm.GetConversationWith(ctx, authorName, page)
+	if !ok {
+		return nil, domain.ErrTechnical{}
+	}
+
+	if len(messages) > 0 {
+		if ok := i.cm.MarkConversationRead(ctx, authorName, messages[len(messages)-1].ID); !ok {
+			return nil, domain.ErrTechnical{}
+		}
+	}
+
+	return messages, nil
+}
+
+// ListConversations is used by the client to sum up the conversations they have, the most recent first
+func (i clientFrontInteractor) ListConversations(ctx context.Context) ([]domain.Conversation, error) {
+	span, ctx := tracing.Start(ctx, "uc:list_conversations")
+	defer span.End()
+
+	if err := i.unlocked(); err != nil {
+		return nil, err
+	}
+	conversations, ok := i.cm.ListConversations(ctx)
 	if !ok {
+		return nil, domain.ErrTechnical{}
+	}
+
+	return conversations, nil
+}
+
+// SearchMessages is used by the client to find the messages holding every word of the query
+func (i clientFrontInteractor) SearchMessages(ctx context.Context, query string, limit int) ([]domain.SearchResult, error) {
+	span, ctx := tracing.Start(ctx, "uc:search_messages")
+	defer span.End()
+
+	if strings.TrimSpace(query) == "" {
+		return nil, domain.ErrMalformed{Details: []string{"the query is empty"}}
+	}
+	if limit < 0 || limit > domain.MaxPageSize {
+		return nil, domain.ErrMalformed{Details: []string{"invalid limit"}}
+	}
+	if limit == 0 {
+		limit = domain.DefaultPageSize
+	}
+
+	if err := i.unlocked(); err != nil {
+		return nil, err
+	}
+	results, ok := i.cm.SearchMessages(ctx, query, limit)
+	if !ok {
+		return nil, domain.ErrTechnical{}
+	}
+
+	return results, nil
+}
+
+// EditMessage is used by the client to change the content of one of its own messages, for both users
+func (i clientFrontInteractor) EditMessage(ctx context.Context, with, ref, content string) error {
+	span, ctx := tracing.Start(ctx, "uc:edit_message")
+	defer span.End()
+
+	if content == "" {
+		return domain.ErrMalformed{Details: []string{"the content is empty"}}
+	}
+
+	m, err := i.ownMessage(ctx, with, ref)
+	if err != nil {
+		return err
+	}
+	if m.Deleted {
+		return domain.ErrMalformed{Details: []string{"the message is deleted"}}
+	}
+
+	e := domain.Event{Type: domain.EventEdit, Ref: ref, Content: content, Edits: m.Edits + 1}
+	if ok := i.cm.EditMessage(ctx, with, ref, e.Content, e.Edits); !ok {
 		return domain.ErrTechnical{}
 	}
-	if s == nil {
-		span.LogFields(log.Error(errors.New("session not found")))
-		return domain.ErrResourceNotFound{}
+
+	i.sendOrQueue(ctx, with, e)
+	return nil
+}
+
+// DeleteMessage is used by the client to delete one of its own messages for both users, a tombstone is kept
+func (i clientFrontInteractor) DeleteMessage(ctx context.Context, with, ref string) error {
+	span, ctx := tracing.Start(ctx, "uc:delete_message")
+	defer span.End()
+
+	if _, err := i.ownMessage(ctx, with, ref); err != nil {
+		return err
 	}
 
-	if ok := i.cm.AppendToConversationWith(ctx, toUserName, emitter, msg); !ok {
+	if ok := i.cm.DeleteMessage(ctx, with, ref); !ok {
 		return domain.ErrTechnical{}
 	}
 
-	if ok := i.cg.SendMsg(ctx, s.Address,
-		domain.Message{Author: emitter, Content: msg},
-		i.currentUsername,
-	); !ok {
+	i.sendOrQueue(ctx, with, domain.Event{Type: domain.EventDelete, Ref: ref})
+	return nil
+}
+
+// ReactToMessage is used by the client to add (or remove) a reaction to any message of a conversation
+func (i clientFrontInteractor) ReactToMessage(ctx context.Context, with, ref, emoji string, on bool) error {
+	span, ctx := tracing.Start(ctx, "uc:react_to_message")
+	defer span.End()
+
+	if i.account.Login() == "" {
+		span.Error(errors.New("missing current user session"))
+		return domain.ErrUnauthorized{}
+	}
+	if emoji == "" {
+		return domain.ErrMalformed{Details: []string{"the emoji is empty"}}
+	}
+
+	if err := i.unlocked(); err != nil {
+		return err
+	}
+	m, ok := i.cm.GetMessage(ctx, with, ref)
+	if !ok {
+		return domain.ErrTechnical{}
+	}
+	if m == nil {
+		return domain.ErrResourceNotFound{}
+	}
+	if m.Deleted {
+		return domain.ErrMalformed{Details: []string{"the message is deleted"}}
+	}
+
+	if ok := i.cm.SetReaction(ctx, with, ref, i.account.Login(), emoji, on); !ok {
 		return domain.ErrTechnical{}
 	}
 
+	i.sendOrQueue(ctx, with, domain.Event{Type: domain.EventReaction, Ref: ref, Emoji: emoji, Removed: !on})
 	return nil
 }
 
-// GetConversationWith is used by the client to get a given conversation
-func (i clientFrontInteractor) GetConversationWith(ctx context.Context, authorName string) ([]domain.Message, error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "uc:get_conversation_with")
-	defer span.Finish()
+// ownMessage returns the message if it was written by the current user
+func (i clientFrontInteractor) ownMessage(ctx context.Context, with, ref string) (*domain.Message, error) {
+	span := tracing.FromContext(ctx)
+
+	if i.account.Login() == "" {
+		span.Error(errors.New("missing current user session"))
+		return nil, domain.ErrUnauthorized{}
+	}
 
-	messages, ok := i.cm.GetConversationWith(ctx, authorName)
+	if err := i.unlocked(); err != nil {
+		return nil, err
+	}
+	m, ok := i.cm.GetMessage(ctx, with, ref)
 	if !ok {
 		return nil, domain.ErrTechnical{}
 	}
+	if m == nil {
+		return nil, domain.ErrResourceNotFound{}
+	}
+	if m.Author != i.account.Login() {
+		span.Error(errors.New("the message wasn't written by the current user"))
+		return nil, domain.ErrUnauthorized{}
+	}
 
-	return messages, nil
+	return m, nil
+}
+
+// deliverEvent sends the event to every device of the other user of the conversation
+func (i clientFrontInteractor) deliverEvent(ctx context.Context, emitter, with string, e domain.Event) error {
+	sessions, err := i.peerSessions(ctx, emitter, with)
+	if err != nil {
+		return err
+	}
+
+	delivered := fanOut(ctx, sessions, func(s domain.Session) bool {
+		return i.cg.SendEvent(ctx, s, e, emitter)
+	})
+	if !delivered {
+		return domain.ErrTechnical{}
+	}
+
+	return nil
+}
+
+// sendOrQueue sends the event of a change already applied, it's queued in the outbox to be sent again when the peer
+// can't receive it: the change is kept either way. It waits behind what's queued for the conversation already
+func (i clientFrontInteractor) sendOrQueue(ctx context.Context, with string, e domain.Event) {
+	emitter := i.account.Login()
+	ctx = logging.WithLogin(ctx, emitter)
+
+	i.syncDevices(ctx, emitter, with, e)
+	if i.outbox.waiting(with) || i.deliverEvent(ctx, emitter, with, e) != nil {
+		tracing.FromContext(ctx).SetAttribute("status", domain.MessagePending)
+		i.outbox.add(with, e, time.Now())
+	}
+}
+
+// newRef is a random ref for a new message
+func newRef() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
 }
@@ -0,0 +1,397 @@
+package uc_test
+
+import (
+	"context"
+	"fmt"
+	"gop2p/domain"
+	"gop2p/uc"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	conversationManager "gop2p/driven/inMem.conversationManager"
+)
+
+func TestListConversations(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("given a client with 2 conversations", t, func() {
+		cm := conversationManager.New()
+		So(cm.AppendToConversationWith(ctx, "bob", domain.Message{Author: "bob", Content: "hi"}), ShouldBeTrue)
+		So(cm.AppendToConversationWith(ctx, "bob", domain.Message{Author: "bob", Content: "are you there ?"}), ShouldBeTrue)
+		So(cm.AppendToConversationWith(ctx, "alice", domain.Message{Author: "me", Content: "hello"}), ShouldBeTrue)
+		logic := uc.NewClientFrontLogic(cm, nil, nil, nil, nil, uc.NewAccount(""))
+
+		Convey("both users are listed once, the most recent conversation first", func() {
+			conversations, err := logic.ListConversations(ctx)
+			So(err, ShouldBeNil)
+			So(conversations, ShouldHaveLength, 2)
+			So(conversations[0].With, ShouldEqual, "alice")
+			So(conversations[1].With, ShouldEqual, "bob")
+
+			Convey("with their last message and the messages of the other user not read yet", func() {
+				So(conversations[0].LastMessage.Content, ShouldEqual, "hello")
+				So(conversations[0].Unread, ShouldEqual, 0)
+				So(conversations[1].LastMessage.Content, ShouldEqual, "are you there ?")
+				So(conversations[1].Unread, ShouldEqual, 2)
+			})
+		})
+
+		Convey("when the conversation with bob is read", func() {
+			_, err := logic.GetConversationWith(ctx, "bob", domain.Page{})
+			So(err, ShouldBeNil)
+
+			Convey("it has no unread message", func() {
+				conversations, err := logic.ListConversations(ctx)
+				So(err, ShouldBeNil)
+				So(conversations[1].Unread, ShouldEqual, 0)
+			})
+		})
+	})
+
+	Convey("when a tech error happens with the conversation manager", t, func() {
+		cm := conversationManager.NewFailable()
+		cm.InjectErrorAt(conversationManager.ListConversations)
+
+		conversations, err := uc.NewClientFrontLogic(cm, nil, nil, nil, nil, uc.NewAccount("")).ListConversations(ctx)
+		techErrIsReturned(err)
+		So(conversations, ShouldBeNil)
+	})
+}
+
+func TestGetConversationWith(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("given a conversation of 120 messages", t, func() {
+		cm := conversationManager.New()
+		for i := 1; i <= 120; i++ {
+			So(cm.AppendToConversationWith(ctx, "bob", domain.Message{Author: "bob", Content: fmt.Sprintf("message %d", i)}), ShouldBeTrue)
+		}
+		logic := uc.NewClientFrontLogic(cm, nil, nil, nil, nil, uc.NewAccount(""))
+
+		Convey("without cursor, the latest page is returned oldest first", func() {
+			messages, err := logic.GetConversationWith(ctx, "bob", domain.Page{})
+			So(err, ShouldBeNil)
+			So(messages, ShouldHaveLength, domain.DefaultPageSize)
+			So(messages[0].Content, ShouldEqual, "message 71")
+			So(messages[len(messages)-1].Content, ShouldEqual, "message 120")
+		})
+
+		Convey("the page before a message ends right before it", func() {
+			latest, err := logic.GetConversationWith(ctx, "bob", domain.Page{Limit: 1})
+			So(err, ShouldBeNil)
+
+			messages, err := logic.GetConversationWith(ctx, "bob", domain.Page{Before: latest[0].ID, Limit: 10})
+			So(err, ShouldBeNil)
+			So(messages, ShouldHaveLength, 10)
+			So(messages[0].Content, ShouldEqual, "message 110")
+			So(messages[9].Content, ShouldEqual, "message 119")
+		})
+
+		Convey("the page after a message starts right after it", func() {
+			first, err := logic.GetConversationWith(ctx, "bob", domain.Page{Before: 2})
+			So(err, ShouldBeNil)
+			So(first, ShouldHaveLength, 1)
+
+			messages, err := logic.GetConversationWith(ctx, "bob", domain.Page{After: first[0].ID, Limit: 3})
+			So(err, ShouldBeNil)
+			So(messages, ShouldHaveLength, 3)
+			So(messages[0].Content, ShouldEqual, "message 2")
+		})
+
+		Convey("a page both before and after a message is malformed", func() {
+			_, err := logic.GetConversationWith(ctx, "bob", domain.Page{Before: 10, After: 2})
+			So(err, ShouldHaveSameTypeAs, domain.ErrMalformed{})
+		})
+
+		Convey("a page bigger than the max is malformed", func() {
+			_, err := logic.GetConversationWith(ctx, "bob", domain.Page{Limit: domain.MaxPageSize + 1})
+			So(err, ShouldHaveSameTypeAs, domain.ErrMalformed{})
+		})
+	})
+
+	Convey("when a tech error happens with the conversation manager", t, func() {
+		cm := conversationManager.NewFailable()
+		cm.InjectErrorAt(conversationManager.GetConversationWith)
+
+		messages, err := uc.NewClientFrontLogic(cm, nil, nil, nil, nil, uc.NewAccount("")).GetConversationWith(ctx, "bob", domain.Page{})
+		techErrIsReturned(err)
+		So(messages, ShouldBeNil)
+	})
+}
+
+func TestSearchMessages(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("given a client with 2 conversations", t, func() {
+		cm := conversationManager.New()
+		So(cm.AppendToConversationWith(ctx, "bob", domain.Message{Author: "bob", Content: "Lunch tomorrow?"}), ShouldBeTrue)
+		So(cm.AppendToConversationWith(ctx, "alice", domain.Message{Author: "me", Content: "lunch is at noon, tomorrow"}), ShouldBeTrue)
+		So(cm.AppendToConversationWith(ctx, "bob", domain.Message{Author: "me", Content: "no lunch for me"}), ShouldBeTrue)
+		logic := uc.NewClientFrontLogic(cm, nil, nil, nil, nil, uc.NewAccount(""))
+
+		Convey("the messages holding every word are found whatever the case, the most recent first", func() {
+			results, err := logic.SearchMessages(ctx, "tomorrow LUNCH", 0)
+			So(err, ShouldBeNil)
+			So(results, ShouldHaveLength, 2)
+			So(results[0].With, ShouldEqual, "alice")
+			So(results[1].With, ShouldEqual, "bob")
+			So(results[1].Message.Content, ShouldEqual, "Lunch tomorrow?")
+		})
+
+		Convey("the results are limited", func() {
+			results, err := logic.SearchMessages(ctx, "lunch", 1)
+			So(err, ShouldBeNil)
+			So(results, ShouldHaveLength, 1)
+			So(results[0].Message.Content, ShouldEqual, "no lunch for me")
+		})
+
+		Convey("nothing is found for an unknown word", func() {
+			results, err := logic.SearchMessages(ctx, "dinner", 0)
+			So(err, ShouldBeNil)
+			So(results, ShouldBeEmpty)
+		})
+
+		Convey("an empty query is malformed", func() {
+			_, err := logic.SearchMessages(ctx, "  ", 0)
+			So(err, ShouldHaveSameTypeAs, domain.ErrMalformed{})
+		})
+	})
+}
+
+func TestSendMessageToOtherClient(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("given a logged in user", t, func() {
+		cm := conversationManager.NewFailable()
+		peer := &peerStub{}
+		logic := uc.NewClientFrontLogic(cm, peer, peer, nil, nil, uc.NewAccount(""))
+		So(logic.NewSessionRegistered(ctx, "me"), ShouldBeNil)
+
+		last := func() domain.Message {
+			messages, ok := cm.GetConversationWith(ctx, "bob", domain.Page{})
+			So(ok, ShouldBeTrue)
+			So(messages, ShouldHaveLength, 1)
+			return messages[0]
+		}
+
+		Convey("when bob receives the message, it's stored as delivered", func() {
+			So(logic.SendMessageToOtherClient(ctx, "bob", "hi", nil), ShouldBeNil)
+			So(peer.sentTo, ShouldResemble, []string{"bob:4000"})
+			So(last().Status, ShouldEqual, domain.MessageDelivered)
+		})
+
+		Convey("when bob can't be reached", func() {
+			peer.unreachable = true
+			err := logic.SendMessageToOtherClient(ctx, "bob", "hi", nil)
+
+			Convey("it's kept in the history as failed", func() {
+				So(err, ShouldHaveSameTypeAs, domain.ErrTechnical{})
+				So(last().Content, ShouldEqual, "hi")
+				So(last().Status, ShouldEqual, domain.MessageFailed)
+			})
+		})
+
+		Convey("when the history of bob is locked, it stays pending until it's sent again", func() {
+			peer.locked = true
+			So(logic.SendMessageToOtherClient(ctx, "bob", "hi", nil), ShouldBeNil)
+			So(last().Status, ShouldEqual, domain.MessagePending)
+		})
+
+		Convey("when the status can't be stored, the message is still sent and stays pending", func() {
+			cm.InjectErrorAt(conversationManager.SetMessageStatus)
+			So(logic.SendMessageToOtherClient(ctx, "bob", "hi", nil), ShouldBeNil)
+			So(last().Status, ShouldEqual, domain.MessagePending)
+		})
+
+		Convey("when the message can't be stored, it's not sent", func() {
+			cm.InjectErrorAt(conversationManager.AppendToConversationWith)
+			techErrIsReturned(logic.SendMessageToOtherClient(ctx, "bob", "hi", nil))
+			So(peer.sentTo, ShouldBeEmpty)
+		})
+	})
+}
+
+// peerStub is both the server, knowing the session of every user, and the peer receiving the events
+// and the signals and serving its files
+type peerStub struct {
+	events  []domain.Event
+	signals []domain.Signal
+	files   map[string][]byte
+	// chunksLeft is how many chunks are served before failing, there's no limit if it's negative
+	chunksLeft int
+	// devices are the sessions of the user itself, the peer only has one
+	devices []domain.Session
+	// histories are served by the other devices of the user
+	histories []domain.History
+	// sentTo is the address of every message and event sent
+	sentTo []string
+	// refs are the refs of the messages delivered
+	refs []string
+	// unreachable peers receive no message
+	unreachable bool
+	// locked peers can't store the messages for now
+	locked bool
+}
+
+func (p *peerStub) AskSessionsToServer(_ context.Context, from, to string) ([]domain.Session, bool) {
+	if from == to {
+		return p.devices, true
+	}
+	return []domain.Session{{Online: true, Address: "bob:4000"}}, true
+}
+
+func (p *peerStub) SendMsg(_ context.Context, s domain.Session, m domain.Message, _ string) string {
+	if p.unreachable {
+		return domain.MessageFailed
+	}
+	if p.locked {
+		return domain.MessagePending
+	}
+	p.sentTo = append(p.sentTo, s.Address)
+	p.refs = append(p.refs, m.Ref)
+	return domain.MessageDelivered
+}
+
+func (p *peerStub) SendEvent(_ context.Context, s domain.Session, e domain.Event, _ string) bool {
+	if p.unreachable || p.locked {
+		return false
+	}
+	p.sentTo = append(p.sentTo, s.Address)
+	p.events = append(p.events, e)
+	return true
+}
+
+func (p *peerStub) FetchHistory(context.Context, domain.Session, string) ([]domain.History, bool) {
+	return p.histories, true
+}
+
+func (p *peerStub) SendSignal(_ context.Context, _ domain.Session, s domain.Signal, _ string) bool {
+	p.signals = append(p.signals, s)
+	return true
+}
+
+func (p *peerStub) FetchChunk(_ context.Context, _ domain.Session, hash string, offset int64, _ string) ([]byte, bool) {
+	if p.chunksLeft == 0 {
+		return nil, false
+	}
+	p.chunksLeft--
+
+	content := p.files[hash]
+	end := offset + domain.ChunkSize
+	if end > int64(len(content)) {
+		end = int64(len(content))
+	}
+	return content[offset:end], true
+}
+
+func TestMessageOperations(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("given a conversation with a message of each user", t, func() {
+		cm := conversationManager.New()
+		So(cm.AppendToConversationWith(ctx, "bob", domain.Message{Ref: "mine", Author: "me", Content: "hi"}), ShouldBeTrue)
+		So(cm.AppendToConversationWith(ctx, "bob", domain.Message{Ref: "his", Author: "bob", Content: "hello"}), ShouldBeTrue)
+		peer := &peerStub{}
+		logic := uc.NewClientFrontLogic(cm, peer, peer, nil, nil, uc.NewAccount(""))
+		So(logic.NewSessionRegistered(ctx, "me"), ShouldBeNil)
+
+		message := func(ref string) domain.Message {
+			m, ok := cm.GetMessage(ctx, "bob", ref)
+			So(ok, ShouldBeTrue)
+			So(m, ShouldNotBeNil)
+			return *m
+		}
+
+		Convey("when the user edits its message twice", func() {
+			So(logic.EditMessage(ctx, "bob", "mine", "hi!"), ShouldBeNil)
+			So(logic.EditMessage(ctx, "bob", "mine", "hi!!"), ShouldBeNil)
+
+			Convey("it's edited and the peer is sent each version", func() {
+				So(message("mine").Content, ShouldEqual, "hi!!")
+				So(peer.events, ShouldResemble, []domain.Event{
+					{Type: domain.EventEdit, Ref: "mine", Content: "hi!", Edits: 1},
+					{Type: domain.EventEdit, Ref: "mine", Content: "hi!!", Edits: 2},
+				})
+			})
+		})
+
+		Convey("when the user deletes its message", func() {
+			So(logic.DeleteMessage(ctx, "bob", "mine"), ShouldBeNil)
+
+			Convey("a tombstone is left and the peer is told", func() {
+				So(message("mine").Deleted, ShouldBeTrue)
+				So(peer.events, ShouldResemble, []domain.Event{{Type: domain.EventDelete, Ref: "mine"}})
+			})
+
+			Convey("it can't be edited anymore", func() {
+				So(logic.EditMessage(ctx, "bob", "mine", "back"), ShouldHaveSameTypeAs, domain.ErrMalformed{})
+			})
+		})
+
+		Convey("when the user tries to edit or delete the message of bob", func() {
+			editErr := logic.EditMessage(ctx, "bob", "his", "hacked")
+			deleteErr := logic.DeleteMessage(ctx, "bob", "his")
+
+			Convey("it's refused and nothing is sent", func() {
+				So(editErr, ShouldHaveSameTypeAs, domain.ErrUnauthorized{})
+				So(deleteErr, ShouldHaveSameTypeAs, domain.ErrUnauthorized{})
+				So(message("his").Content, ShouldEqual, "hello")
+				So(peer.events, ShouldBeEmpty)
+			})
+		})
+
+		Convey("when the user reacts to the message of bob", func() {
+			So(logic.ReactToMessage(ctx, "bob", "his", "👍", true), ShouldBeNil)
+
+			Convey("the reaction is added and the peer is told", func() {
+				So(message("his").Reactions, ShouldResemble, map[string][]string{"👍": {"me"}})
+				So(peer.events, ShouldResemble, []domain.Event{{Type: domain.EventReaction, Ref: "his", Emoji: "👍"}})
+			})
+		})
+
+		Convey("when bob can't receive the events", func() {
+			peer.locked = true
+			So(logic.EditMessage(ctx, "bob", "mine", "hi!"), ShouldBeNil)
+			So(logic.DeleteMessage(ctx, "bob", "mine"), ShouldBeNil)
+
+			Convey("the changes are kept", func() {
+				So(message("mine").Edits, ShouldEqual, 1)
+				So(message("mine").Deleted, ShouldBeTrue)
+				So(peer.events, ShouldBeEmpty)
+			})
+
+			Convey("they're sent again in order once bob can receive them", func() {
+				peer.locked = false
+				delivered, err := logic.FlushOutbox(ctx, time.Now())
+				So(err, ShouldBeNil)
+				So(delivered, ShouldEqual, 2)
+				So(peer.events, ShouldResemble, []domain.Event{
+					{Type: domain.EventEdit, Ref: "mine", Content: "hi!", Edits: 1},
+					{Type: domain.EventDelete, Ref: "mine"},
+				})
+			})
+		})
+
+		Convey("an unknown message is not found", func() {
+			resourceNotFoundErrIsReturned(logic.DeleteMessage(ctx, "bob", "unknown"))
+		})
+	})
+}
+
+func BenchmarkSendMessageToOtherClient(b *testing.B) {
+	ctx := context.Background()
+	quiet(b)
+	peer := &peerStub{}
+	logic := uc.NewClientFrontLogic(conversationManager.New(), peer, peer, nil, nil, uc.NewAccount(""))
+	if err := logic.NewSessionRegistered(ctx, "me"); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := logic.SendMessageToOtherClient(ctx, "bob", "hi bob", nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
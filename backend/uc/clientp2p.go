@@ -2,29 +2,128 @@ package uc
 
 import (
 	"context"
-	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
 	"gop2p/domain"
+	"gop2p/tracing"
+	"time"
 )
 
 // ClientP2PLogic handles the logic of the central server
 type ClientP2PLogic interface {
-	HandleMessageReceived(ctx context.Context, msg string, emitter domain.User) error
+	HandleMessageReceived(ctx context.Context, e domain.Event, emitter domain.User) error
+	ServeAttachmentChunk(ctx context.Context, hash string, offset int64, requester domain.User) ([]byte, *domain.Attachment, error)
+	HandleSignalReceived(ctx context.Context, s domain.Signal, emitter domain.User) error
+	ServeHistory(ctx context.Context, requester domain.User) ([]domain.History, error)
 }
 
 type clientp2pInteractor struct {
-	cm ConversationManager
+	account *Account
+	cm      ConversationManager
+	bs      BlobStore
+	sb      SignalBroker
 }
 
-func NewClientP2pLogic(cm ConversationManager) ClientP2PLogic {
-	return clientp2pInteractor{cm: cm}
+func NewClientP2pLogic(cm ConversationManager, bs BlobStore, sb SignalBroker, a *Account) ClientP2PLogic {
+	return clientp2pInteractor{account: a, cm: cm, bs: bs, sb: sb}
 }
 
-// HandleMessageReceived is used by the client to handle a new message
-func (i clientp2pInteractor) HandleMessageReceived(ctx context.Context, msg string, emitter domain.User) error {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "uc:handle_new_message_received")
-	defer span.Finish()
+// HandleMessageReceived is used by the client to handle a new message or an operation on one of the conversation
+// with the emitter, or on any conversation when the emitter is another device of the user. Receiving the same event
+// again changes nothing, the front ends are told the conversation changed. Nothing is stored while the history is
+// locked, the peer is told to retry later
+func (i clientp2pInteractor) HandleMessageReceived(ctx context.Context, e domain.Event, emitter domain.User) error {
+	span, ctx := tracing.Start(ctx, "uc:handle_new_message_received")
+	defer span.End()
 
-	if ok := i.cm.AppendToConversationWith(ctx, emitter.Login, emitter.Login, msg); !ok {
+	with := emitter.Login
+	if e.With != "" {
+		if login := i.account.Login(); login == "" || emitter.Login != login {
+			span.Error(errors.New("the emitter isn't the user of this client"))
+			return domain.ErrUnauthorized{}
+		}
+		with = e.With
+	}
+
+	if err := i.unlocked(); err != nil {
+		span.Error(errors.New("the history is locked"))
+		return err
+	}
+	if err := i.applyEvent(ctx, span, &e, with, emitter.Login); err != nil {
+		return err
+	}
+
+	if ok := i.sb.Publish(ctx, domain.Signal{Type: domain.SignalUpdated, With: with, Ref: e.Ref}); !ok {
+		// the front ends will see it when they refresh
+		span.Error(errors.New("update not published"))
+	}
+	return nil
+}
+
+// applyEvent sets the ref of the messages of the peers of previous versions, author is who sent the event. A message
+// is stored once by ref, even when it's redelivered after being purged
+func (i clientp2pInteractor) applyEvent(ctx context.Context, span tracing.Span, e *domain.Event, with, author string) error {
+	// the peers of previous versions only send new messages, without ref
+	if e.Type == "" || e.Type == domain.EventMessage {
+		if e.Ref == "" {
+			e.Ref = newRef()
+		}
+		if len(e.Attachments) > domain.MaxAttachments {
+			return domain.ErrMalformed{Details: []string{"too many attachments"}}
+		}
+		for _, a := range e.Attachments {
+			if !a.Valid() {
+				return domain.ErrMalformed{Details: []string{"invalid attachment"}}
+			}
+		}
+		// the attachments are downloaded when they're first read
+		m := domain.Message{Ref: e.Ref, Author: author, Content: e.Content, Date: time.Now(), Attachments: e.Attachments}
+		stored, ok := i.cm.AppendOnce(ctx, with, m)
+		if !ok {
+			return domain.ErrTechnical{}
+		}
+		// a redelivery is acked like the first delivery, so that the sender stops retrying
+		if !stored {
+			span.SetAttribute("duplicate", true)
+		}
+		return nil
+	}
+
+	// the retention applies to the whole conversation
+	if e.Type == domain.EventRetention {
+		return i.applyRetention(ctx, with, e.TTL)
+	}
+
+	m, ok := i.cm.GetMessage(ctx, with, e.Ref)
+	if !ok {
+		return domain.ErrTechnical{}
+	}
+	if m == nil {
+		span.Error(errors.New("message not found"))
+		return domain.ErrResourceNotFound{}
+	}
+
+	switch e.Type {
+	case domain.EventEdit:
+		if m.Author != author {
+			span.Error(errors.New("the message wasn't written by the emitter"))
+			return domain.ErrUnauthorized{}
+		}
+		ok = i.cm.EditMessage(ctx, with, e.Ref, e.Content, e.Edits)
+	case domain.EventDelete:
+		if m.Author != author {
+			span.Error(errors.New("the message wasn't written by the emitter"))
+			return domain.ErrUnauthorized{}
+		}
+		ok = i.cm.DeleteMessage(ctx, with, e.Ref)
+	case domain.EventReaction:
+		if e.Emoji == "" {
+			return domain.ErrMalformed{Details: []string{"the emoji is missing"}}
+		}
+		ok = i.cm.SetReaction(ctx, with, e.Ref, author, e.Emoji, !e.Removed)
+	default:
+		return domain.ErrMalformed{Details: []string{"unknown event type"}}
+	}
+	if !ok {
 		return domain.ErrTechnical{}
 	}
 
@@ -0,0 +1,177 @@
+package uc_test
+
+import (
+	"context"
+	"fmt"
+	"gop2p/domain"
+	"gop2p/uc"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	conversationManager "gop2p/driven/inMem.conversationManager"
+	signalbroker "gop2p/driven/inMem.signalBroker"
+)
+
+func TestHandleMessageReceived(t *testing.T) {
+	ctx := context.Background()
+	bob := domain.User{Login: "bob"}
+
+	Convey("given a client which received a message from bob", t, func() {
+		cm := conversationManager.New()
+		logic := uc.NewClientP2pLogic(cm, nil, signalbroker.New(), uc.NewAccount(""))
+		So(logic.HandleMessageReceived(ctx, domain.Event{Type: domain.EventMessage, Ref: "r1", Content: "hi"}, bob), ShouldBeNil)
+
+		history := func() []domain.Message {
+			messages, ok := cm.GetConversationWith(ctx, "bob", domain.Page{})
+			So(ok, ShouldBeTrue)
+			return messages
+		}
+
+		Convey("when the same message is received again", func() {
+			So(logic.HandleMessageReceived(ctx, domain.Event{Type: domain.EventMessage, Ref: "r1", Content: "hi"}, bob), ShouldBeNil)
+
+			Convey("it's stored once", func() {
+				So(history(), ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("when the same message is received many times at once", func() {
+			var wg sync.WaitGroup
+			errs := make(chan error, 10)
+			for n := 0; n < 10; n++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs <- logic.HandleMessageReceived(ctx, domain.Event{Type: domain.EventMessage, Ref: "r2", Content: "again"}, bob)
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			Convey("every delivery is acked and it's stored once", func() {
+				for err := range errs {
+					So(err, ShouldBeNil)
+				}
+				So(history(), ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("when it's redelivered after being purged", func() {
+			So(logic.HandleMessageReceived(ctx, domain.Event{Type: domain.EventMessage, Ref: "r2", Content: "later"}, bob), ShouldBeNil)
+			purged, ok := cm.PurgeMessages(ctx, "bob", time.Time{}, 1)
+			So(ok, ShouldBeTrue)
+			So(purged, ShouldEqual, 1)
+			So(logic.HandleMessageReceived(ctx, domain.Event{Type: domain.EventMessage, Ref: "r1", Content: "hi"}, bob), ShouldBeNil)
+
+			Convey("it's not stored again", func() {
+				messages := history()
+				So(messages, ShouldHaveLength, 1)
+				So(messages[0].Ref, ShouldEqual, "r2")
+			})
+		})
+
+		Convey("when a message without ref is received from a peer of a previous version", func() {
+			So(logic.HandleMessageReceived(ctx, domain.Event{Content: "hello"}, bob), ShouldBeNil)
+
+			Convey("it's stored with a ref", func() {
+				messages := history()
+				So(messages, ShouldHaveLength, 2)
+				So(messages[1].Ref, ShouldNotBeEmpty)
+			})
+		})
+
+		Convey("when bob edits it twice and the first edit arrives last", func() {
+			So(logic.HandleMessageReceived(ctx, domain.Event{Type: domain.EventEdit, Ref: "r1", Content: "hi!!", Edits: 2}, bob), ShouldBeNil)
+			So(logic.HandleMessageReceived(ctx, domain.Event{Type: domain.EventEdit, Ref: "r1", Content: "hi!", Edits: 1}, bob), ShouldBeNil)
+
+			Convey("the latest edit is kept", func() {
+				So(history()[0].Content, ShouldEqual, "hi!!")
+				So(history()[0].Edits, ShouldEqual, 2)
+			})
+		})
+
+		Convey("when bob deletes it twice", func() {
+			for i := 0; i < 2; i++ {
+				So(logic.HandleMessageReceived(ctx, domain.Event{Type: domain.EventDelete, Ref: "r1"}, bob), ShouldBeNil)
+			}
+
+			Convey("a tombstone is left", func() {
+				So(history(), ShouldHaveLength, 1)
+				So(history()[0].Deleted, ShouldBeTrue)
+				So(history()[0].Content, ShouldBeEmpty)
+			})
+
+			Convey("it can't be edited anymore", func() {
+				So(logic.HandleMessageReceived(ctx, domain.Event{Type: domain.EventEdit, Ref: "r1", Content: "back", Edits: 1}, bob), ShouldBeNil)
+				So(history()[0].Content, ShouldBeEmpty)
+			})
+
+			Convey("it's not found anymore", func() {
+				results, ok := cm.SearchMessages(ctx, "hi", 0)
+				So(ok, ShouldBeTrue)
+				So(results, ShouldBeEmpty)
+			})
+		})
+
+		Convey("when bob reacts twice with the same emoji", func() {
+			for i := 0; i < 2; i++ {
+				So(logic.HandleMessageReceived(ctx, domain.Event{Type: domain.EventReaction, Ref: "r1", Emoji: "👍"}, bob), ShouldBeNil)
+			}
+
+			Convey("the reaction is counted once", func() {
+				So(history()[0].Reactions, ShouldResemble, map[string][]string{"👍": {"bob"}})
+			})
+
+			Convey("then removes it", func() {
+				So(logic.HandleMessageReceived(ctx, domain.Event{Type: domain.EventReaction, Ref: "r1", Emoji: "👍", Removed: true}, bob), ShouldBeNil)
+				So(history()[0].Reactions, ShouldBeEmpty)
+			})
+		})
+
+		Convey("when alice tries to edit it in the conversation with her", func() {
+			err := logic.HandleMessageReceived(ctx, domain.Event{Type: domain.EventEdit, Ref: "r1", Content: "hacked", Edits: 1}, domain.User{Login: "alice"})
+
+			Convey("it's not found", func() {
+				resourceNotFoundErrIsReturned(err)
+			})
+		})
+	})
+
+	Convey("given a message of the current user stored in the conversation with bob", t, func() {
+		cm := conversationManager.New()
+		So(cm.AppendToConversationWith(ctx, "bob", domain.Message{Ref: "r1", Author: "me", Content: "hi"}), ShouldBeTrue)
+
+		Convey("when bob tries to edit it", func() {
+			err := uc.NewClientP2pLogic(cm, nil, signalbroker.New(), uc.NewAccount("")).HandleMessageReceived(ctx, domain.Event{Type: domain.EventEdit, Ref: "r1", Content: "hacked", Edits: 1}, bob)
+
+			Convey("it's refused", func() {
+				So(err, ShouldHaveSameTypeAs, domain.ErrUnauthorized{})
+			})
+		})
+	})
+
+	Convey("when a tech error happens with the conversation manager", t, func() {
+		cm := conversationManager.NewFailable()
+		cm.InjectErrorAt(conversationManager.AppendOnce)
+
+		err := uc.NewClientP2pLogic(cm, nil, signalbroker.New(), uc.NewAccount("")).HandleMessageReceived(ctx, domain.Event{Type: domain.EventMessage, Ref: "r1", Content: "hi"}, bob)
+		techErrIsReturned(err)
+	})
+}
+
+func BenchmarkHandleMessageReceived(b *testing.B) {
+	ctx := context.Background()
+	quiet(b)
+	logic := uc.NewClientP2pLogic(conversationManager.New(), nil, signalbroker.New(), uc.NewAccount(""))
+	bob := domain.User{Login: "bob"}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		e := domain.Event{Type: domain.EventMessage, Ref: fmt.Sprintf("r%d", i), Content: "hi"}
+		if err := logic.HandleMessageReceived(ctx, e, bob); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
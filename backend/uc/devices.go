@@ -0,0 +1,211 @@
+package uc
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"gop2p/domain"
+	"gop2p/logging"
+	"gop2p/tracing"
+	"sync"
+)
+
+// Account is the user logged in on a client and the device the client is, it's shared by the front and p2p logic
+type Account struct {
+	// Device tells this client apart from the other devices of the user
+	Device string
+
+	mu    sync.RWMutex
+	login string
+}
+
+func NewAccount(device string) *Account {
+	return &Account{Device: device}
+}
+
+// Login is empty until a session is registered
+func (a *Account) Login() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.login
+}
+
+func (a *Account) setLogin(login string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.login = login
+}
+
+// unsynced is the login whose history couldn't be synced from the other devices yet, while the history was locked
+type unsynced struct {
+	mu    sync.Mutex
+	login string
+}
+
+func (u *unsynced) set(login string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.login = login
+}
+
+// take returns the login to sync, if any, and forgets it
+func (u *unsynced) take() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	login := u.login
+	u.login = ""
+	return login
+}
+
+// peerSessions returns the sessions of every device of the other user
+func (i clientFrontInteractor) peerSessions(ctx context.Context, emitter, with string) ([]domain.Session, error) {
+	sessions, ok := i.sg.AskSessionsToServer(ctx, emitter, with)
+	if !ok {
+		return nil, domain.ErrTechnical{}
+	}
+	if len(sessions) == 0 {
+		tracing.FromContext(ctx).Error(errors.New("session not found"))
+		return nil, domain.ErrResourceNotFound{}
+	}
+	return sessions, nil
+}
+
+// ownDevices returns the sessions of the other devices of the user, they're only synced when they can be found
+func (i clientFrontInteractor) ownDevices(ctx context.Context, login string) []domain.Session {
+	sessions, ok := i.sg.AskSessionsToServer(ctx, login, login)
+	if !ok {
+		tracing.FromContext(ctx).Error(errors.New("the other devices can't be found"))
+		return nil
+	}
+
+	devices := []domain.Session{}
+	for _, s := range sessions {
+		if s.Device != i.account.Device {
+			devices = append(devices, s)
+		}
+	}
+	return devices
+}
+
+// fanOut sends to every device, it's delivered when one of them at least got it
+func fanOut(ctx context.Context, sessions []domain.Session, send func(s domain.Session) bool) bool {
+	delivered := false
+	for _, s := range sessions {
+		if send(s) {
+			delivered = true
+			continue
+		}
+		tracing.FromContext(ctx).Error(fmt.Errorf("not delivered to the device %q at %s", s.Device, s.Address))
+	}
+	return delivered
+}
+
+// syncDevices sends an event of the conversation to the other devices of the user, the ones not reached catch up
+// when they log in again
+func (i clientFrontInteractor) syncDevices(ctx context.Context, login, with string, e domain.Event) {
+	e.With = with
+	fanOut(ctx, i.ownDevices(ctx, login), func(s domain.Session) bool {
+		return i.cg.SendEvent(ctx, s, e, login)
+	})
+}
+
+// syncHistory copies the messages the other devices of the user have and this one doesn't, it's synced again once
+// unlocked when the history can't be written
+func (i clientFrontInteractor) syncHistory(ctx context.Context, login string) {
+	span := tracing.FromContext(ctx)
+
+	if err := i.unlocked(); err != nil {
+		span.Error(errors.New("the history is locked, it's synced once unlocked"))
+		i.unsynced.set(login)
+		return
+	}
+
+	copied := 0
+	for _, s := range i.ownDevices(ctx, login) {
+		histories, ok := i.cg.FetchHistory(ctx, s, login)
+		if !ok {
+			continue
+		}
+
+		n, ok := mergeUnknown(ctx, i.cm, histories)
+		copied += n
+		if !ok {
+			// it may have been locked meanwhile
+			i.unsynced.set(login)
+			return
+		}
+	}
+
+	if copied > 0 {
+		span.SetAttribute("messages_synced", copied)
+		logging.Info(ctx, "history synced from the other devices", "messages", copied)
+	}
+}
+
+// ServeHistory is used by the client to send its conversations to another device of its user
+func (i clientp2pInteractor) ServeHistory(ctx context.Context, requester domain.User) ([]domain.History, error) {
+	span, ctx := tracing.Start(ctx, "uc:serve_history")
+	defer span.End()
+
+	if login := i.account.Login(); login == "" || requester.Login != login {
+		span.Error(errors.New("the requester isn't the user of this client"))
+		return nil, domain.ErrUnauthorized{}
+	}
+	if err := i.unlocked(); err != nil {
+		return nil, err
+	}
+
+	histories, ok := allHistories(ctx, i.cm)
+	if !ok {
+		return nil, domain.ErrTechnical{}
+	}
+	return histories, nil
+}
+
+// allHistories reads every conversation, the oldest first so that appending them in order keeps the most recent last
+func allHistories(ctx context.Context, cm ConversationManager) ([]domain.History, bool) {
+	conversations, ok := cm.ListConversations(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	histories := make([]domain.History, 0, len(conversations))
+	for j := len(conversations) - 1; j >= 0; j-- {
+		c := conversations[j]
+		messages, ok := cm.GetConversationWith(ctx, c.With, domain.Page{})
+		if !ok {
+			return nil, false
+		}
+		histories = append(histories, domain.History{With: c.With, Messages: messages})
+	}
+	return histories, true
+}
+
+// mergeUnknown merges the messages whose ref isn't in their conversation yet (nor was purged from it lately, see
+// ConversationManager.MergeOnce) in date order, they're read. The ones without ref are skipped. It returns how many
+// were stored, up to the failure if any
+func mergeUnknown(ctx context.Context, cm ConversationManager, histories []domain.History) (int, bool) {
+	// the histories of a conversation are merged at once
+	order := []string{}
+	byConversation := map[string][]domain.Message{}
+	for _, h := range histories {
+		if _, ok := byConversation[h.With]; !ok {
+			order = append(order, h.With)
+		}
+		byConversation[h.With] = append(byConversation[h.With], h.Messages...)
+	}
+
+	merged := 0
+	for _, with := range order {
+		stored, ok := cm.MergeOnce(ctx, with, byConversation[with])
+		if !ok {
+			return merged, false
+		}
+		merged += stored
+	}
+	return merged, true
+}
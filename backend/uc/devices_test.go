@@ -0,0 +1,123 @@
+package uc_test
+
+import (
+	"context"
+	"gop2p/domain"
+	"gop2p/uc"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	conversationManager "gop2p/driven/inMem.conversationManager"
+	signalbroker "gop2p/driven/inMem.signalBroker"
+)
+
+func TestSendToDevices(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("given a user connected from its laptop and its phone", t, func() {
+		cm := conversationManager.New()
+		peer := &peerStub{devices: []domain.Session{
+			{Online: true, Device: "laptop", Address: "me-laptop:4000"},
+			{Online: true, Device: "phone", Address: "me-phone:4000"},
+		}}
+		logic := uc.NewClientFrontLogic(cm, peer, peer, nil, nil, uc.NewAccount("laptop"))
+		So(logic.NewSessionRegistered(ctx, "me"), ShouldBeNil)
+
+		Convey("when it sends a message from the laptop", func() {
+			So(logic.SendMessageToOtherClient(ctx, "bob", "hi", nil), ShouldBeNil)
+
+			Convey("the peer and the phone get it, not the laptop", func() {
+				So(peer.sentTo, ShouldResemble, []string{"me-phone:4000", "bob:4000"})
+			})
+
+			Convey("the phone is told which conversation it's in", func() {
+				So(peer.events, ShouldHaveLength, 1)
+				So(peer.events[0].Type, ShouldEqual, domain.EventMessage)
+				So(peer.events[0].With, ShouldEqual, "bob")
+				So(peer.events[0].Content, ShouldEqual, "hi")
+			})
+		})
+	})
+
+	Convey("given the phone of a user already had a conversation", t, func() {
+		cm := conversationManager.New()
+		So(cm.AppendToConversationWith(ctx, "bob", domain.Message{Ref: "r1", Author: "bob", Content: "hello"}), ShouldBeTrue)
+		peer := &peerStub{
+			devices: []domain.Session{{Online: true, Device: "phone", Address: "me-phone:4000"}},
+			histories: []domain.History{{With: "bob", Messages: []domain.Message{
+				{Ref: "r1", Author: "bob", Content: "hello"},
+				{Ref: "r2", Author: "me", Content: "hi"},
+				{Ref: "r3", Author: "bob", Content: "how are you?"},
+			}}},
+		}
+		logic := uc.NewClientFrontLogic(cm, peer, peer, nil, nil, uc.NewAccount("laptop"))
+
+		Convey("when the user logs in on its laptop", func() {
+			So(logic.NewSessionRegistered(ctx, "me"), ShouldBeNil)
+
+			Convey("the messages it misses are copied, once", func() {
+				messages, err := logic.GetConversationWith(ctx, "bob", domain.Page{})
+				So(err, ShouldBeNil)
+				So(messages, ShouldHaveLength, 3)
+				So(messages[1].Ref, ShouldEqual, "r2")
+			})
+
+			Convey("they're read, the one it didn't read yet stays unread", func() {
+				conversations, err := logic.ListConversations(ctx)
+				So(err, ShouldBeNil)
+				So(conversations[0].Unread, ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestReceiveFromDevices(t *testing.T) {
+	ctx := context.Background()
+	me := domain.User{Login: "me"}
+	bob := domain.User{Login: "bob"}
+
+	Convey("given a client the user is logged in", t, func() {
+		cm := conversationManager.New()
+		account := uc.NewAccount("phone")
+		peer := &peerStub{}
+		front := uc.NewClientFrontLogic(cm, peer, peer, nil, nil, account)
+		So(front.NewSessionRegistered(ctx, "me"), ShouldBeNil)
+		logic := uc.NewClientP2pLogic(cm, nil, signalbroker.New(), account)
+
+		Convey("when another device of the user sends what it sent to bob", func() {
+			err := logic.HandleMessageReceived(ctx, domain.Event{Type: domain.EventMessage, Ref: "r1", Content: "hi", With: "bob"}, me)
+			noErrorReturned(err)
+
+			Convey("it's in the conversation with bob, from the user", func() {
+				m, ok := cm.GetMessage(ctx, "bob", "r1")
+				So(ok, ShouldBeTrue)
+				So(m, ShouldNotBeNil)
+				So(m.Author, ShouldEqual, "me")
+			})
+		})
+
+		Convey("when someone else claims to be a device of the user", func() {
+			err := logic.HandleMessageReceived(ctx, domain.Event{Type: domain.EventMessage, Ref: "r1", Content: "hi", With: "alice"}, bob)
+
+			unauthorizedErrIsReturned(err)
+			Convey("nothing is stored", func() {
+				m, ok := cm.GetMessage(ctx, "alice", "r1")
+				So(ok, ShouldBeTrue)
+				So(m, ShouldBeNil)
+			})
+		})
+
+		Convey("its history is only served to the devices of the user", func() {
+			So(cm.AppendToConversationWith(ctx, "bob", domain.Message{Ref: "r1", Author: "bob", Content: "hello"}), ShouldBeTrue)
+
+			histories, err := logic.ServeHistory(ctx, me)
+			So(err, ShouldBeNil)
+			So(histories, ShouldHaveLength, 1)
+			So(histories[0].With, ShouldEqual, "bob")
+			So(histories[0].Messages, ShouldHaveLength, 1)
+
+			_, err = logic.ServeHistory(ctx, bob)
+			unauthorizedErrIsReturned(err)
+		})
+	})
+}
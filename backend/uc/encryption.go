@@ -0,0 +1,132 @@
+package uc
+
+import (
+	"context"
+	"gop2p/domain"
+	"gop2p/logging"
+	"gop2p/tracing"
+)
+
+// HistoryEncryption is used by the client to know if its history is encrypted, and if it must be unlocked first
+func (i clientFrontInteractor) HistoryEncryption(ctx context.Context) (domain.Encryption, error) {
+	span, _ := tracing.Start(ctx, "uc:history_encryption")
+	defer span.End()
+
+	v, ok := i.cm.(Vault)
+	if !ok {
+		return domain.Encryption{}, nil
+	}
+	return domain.Encryption{Encrypted: true, Locked: v.Locked()}, nil
+}
+
+// UnlockHistory is used by the client to open its encrypted history with its passphrase or keyfile, the first
+// unlock sets the secret
+func (i clientFrontInteractor) UnlockHistory(ctx context.Context, secret string) error {
+	span, ctx := tracing.Start(ctx, "uc:unlock_history")
+	defer span.End()
+
+	v, err := i.vault()
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return domain.ErrMalformed{Details: []string{"the secret is empty"}}
+	}
+
+	valid, ok := v.Unlock(ctx, secret)
+	if !ok {
+		return domain.ErrTechnical{}
+	}
+	if !valid {
+		return domain.ErrUnauthorized{}
+	}
+
+	// the history the other devices sent while it was locked is synced now, if still logged in as the same user
+	if login := i.unsynced.take(); login != "" && login == i.account.Login() {
+		i.syncHistory(logging.WithLogin(ctx, login), login)
+	}
+	return nil
+}
+
+// LockHistory is used by the client to forget the keys of its history until it's unlocked again
+func (i clientFrontInteractor) LockHistory(ctx context.Context) error {
+	span, ctx := tracing.Start(ctx, "uc:lock_history")
+	defer span.End()
+
+	v, err := i.vault()
+	if err != nil {
+		return err
+	}
+	if ok := v.Lock(ctx); !ok {
+		return domain.ErrTechnical{}
+	}
+	return nil
+}
+
+// ChangeHistorySecret is used by the client to replace the secret of its history, the messages aren't encrypted
+// again since only the keys are
+func (i clientFrontInteractor) ChangeHistorySecret(ctx context.Context, current, secret string) error {
+	span, ctx := tracing.Start(ctx, "uc:change_history_secret")
+	defer span.End()
+
+	v, err := i.vault()
+	if err != nil {
+		return err
+	}
+	if current == "" || secret == "" {
+		return domain.ErrMalformed{Details: []string{"the secret is empty"}}
+	}
+
+	valid, ok := v.ChangeSecret(ctx, current, secret)
+	if !ok {
+		return domain.ErrTechnical{}
+	}
+	if !valid {
+		return domain.ErrUnauthorized{}
+	}
+	return nil
+}
+
+// RotateHistoryKey is used by the client to encrypt its next messages with a new key
+func (i clientFrontInteractor) RotateHistoryKey(ctx context.Context) error {
+	span, ctx := tracing.Start(ctx, "uc:rotate_history_key")
+	defer span.End()
+
+	v, err := i.vault()
+	if err != nil {
+		return err
+	}
+	if v.Locked() {
+		return domain.ErrLocked{}
+	}
+	if ok := v.RotateKey(ctx); !ok {
+		return domain.ErrTechnical{}
+	}
+	return nil
+}
+
+// vault returns the conversation manager if it keeps the history encrypted
+func (i clientFrontInteractor) vault() (Vault, error) {
+	v, ok := i.cm.(Vault)
+	if !ok {
+		return nil, domain.ErrMalformed{Details: []string{"the history isn't encrypted"}}
+	}
+	return v, nil
+}
+
+// unlocked fails with ErrLocked while the history can't be read
+func (i clientFrontInteractor) unlocked() error {
+	if v, ok := i.cm.(Vault); ok && v.Locked() {
+		return domain.ErrLocked{}
+	}
+	return nil
+}
+
+// unlocked fails with ErrUnavailable while the history can't be written, the peers keep their messages pending
+// and send them again later
+func (i clientp2pInteractor) unlocked() error {
+	if v, ok := i.cm.(Vault); ok && v.Locked() {
+		return domain.ErrUnavailable{}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package uc_test
+
+import (
+	"context"
+	"gop2p/domain"
+	"gop2p/uc"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	encrypted "gop2p/driven/aes.conversationManager"
+	conversationManager "gop2p/driven/inMem.conversationManager"
+	signalbroker "gop2p/driven/inMem.signalBroker"
+)
+
+func TestHistoryEncryption(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("given a client whose history isn't encrypted", t, func() {
+		logic := uc.NewClientFrontLogic(conversationManager.New(), nil, nil, nil, nil, uc.NewAccount(""))
+
+		Convey("its history is said not encrypted", func() {
+			e, err := logic.HistoryEncryption(ctx)
+			So(err, ShouldBeNil)
+			So(e, ShouldResemble, domain.Encryption{})
+		})
+
+		Convey("it can't be unlocked", func() {
+			malformedErrIsReturned(logic.UnlockHistory(ctx, "passphrase"))
+		})
+	})
+
+	Convey("given a client whose history is encrypted with a passphrase", t, func() {
+		backend := conversationManager.New()
+		cm := encrypted.New(backend, filepath.Join(t.TempDir(), "keyring.json"), encrypted.KDFArgon2id)
+		logic := uc.NewClientFrontLogic(cm, nil, nil, nil, nil, uc.NewAccount(""))
+
+		Convey("the history is locked at first", func() {
+			e, err := logic.HistoryEncryption(ctx)
+			So(err, ShouldBeNil)
+			So(e, ShouldResemble, domain.Encryption{Encrypted: true, Locked: true})
+
+			_, err = logic.ListConversations(ctx)
+			So(err, ShouldHaveSameTypeAs, domain.ErrLocked{})
+			_, err = logic.SearchMessages(ctx, "hi", 0)
+			So(err, ShouldHaveSameTypeAs, domain.ErrLocked{})
+			_, err = logic.ExportConversations(ctx, "")
+			So(err, ShouldHaveSameTypeAs, domain.ErrLocked{})
+			_, err = logic.GetRetention(ctx, "bob")
+			So(err, ShouldHaveSameTypeAs, domain.ErrLocked{})
+			So(logic.SetRetention(ctx, "bob", domain.Retention{Days: 1}), ShouldHaveSameTypeAs, domain.ErrLocked{})
+			_, err = logic.DownloadAttachment(ctx, "bob", "hash")
+			So(err, ShouldHaveSameTypeAs, domain.ErrLocked{})
+		})
+
+		Convey("the devices of the user aren't served its history nor its attachments until it's unlocked", func() {
+			account := uc.NewAccount("laptop")
+			So(uc.NewClientFrontLogic(cm, &peerStub{}, &peerStub{}, nil, nil, account).NewSessionRegistered(ctx, "me"), ShouldBeNil)
+			p2p := uc.NewClientP2pLogic(cm, nil, signalbroker.New(), account)
+
+			_, err := p2p.ServeHistory(ctx, domain.User{Login: "me"})
+			So(err, ShouldHaveSameTypeAs, domain.ErrUnavailable{})
+			_, _, err = p2p.ServeAttachmentChunk(ctx, "hash", 0, domain.User{Login: "me"})
+			So(err, ShouldHaveSameTypeAs, domain.ErrUnavailable{})
+		})
+
+		Convey("when the user logs in while it's locked, the history of the other devices is synced once unlocked", func() {
+			peer := &peerStub{
+				devices:   []domain.Session{{Online: true, Device: "phone", Address: "me-phone:4000"}},
+				histories: []domain.History{{With: "bob", Messages: []domain.Message{{Ref: "r1", Author: "bob", Content: "hello"}}}},
+			}
+			logic := uc.NewClientFrontLogic(cm, peer, peer, nil, nil, uc.NewAccount("laptop"))
+			So(logic.NewSessionRegistered(ctx, "me"), ShouldBeNil)
+			stored, _ := backend.GetConversationWith(ctx, "bob", domain.Page{})
+			So(stored, ShouldBeEmpty)
+
+			So(logic.UnlockHistory(ctx, "passphrase"), ShouldBeNil)
+			messages, err := logic.GetConversationWith(ctx, "bob", domain.Page{})
+			So(err, ShouldBeNil)
+			So(messages, ShouldHaveLength, 1)
+			So(messages[0].Content, ShouldEqual, "hello")
+		})
+
+		Convey("the messages of the peers are refused until it's unlocked, so that they keep them pending", func() {
+			p2p := uc.NewClientP2pLogic(cm, nil, signalbroker.New(), uc.NewAccount(""))
+			e := domain.Event{Type: domain.EventMessage, Ref: "b1", Content: "hi"}
+			So(p2p.HandleMessageReceived(ctx, e, domain.User{Login: "bob"}), ShouldHaveSameTypeAs, domain.ErrUnavailable{})
+			stored, _ := backend.GetConversationWith(ctx, "bob", domain.Page{})
+			So(stored, ShouldBeEmpty)
+
+			So(logic.UnlockHistory(ctx, "passphrase"), ShouldBeNil)
+			So(p2p.HandleMessageReceived(ctx, e, domain.User{Login: "bob"}), ShouldBeNil)
+		})
+
+		Convey("the key can't be rotated while locked", func() {
+			So(logic.RotateHistoryKey(ctx), ShouldHaveSameTypeAs, domain.ErrLocked{})
+		})
+
+		Convey("an empty secret is refused", func() {
+			malformedErrIsReturned(logic.UnlockHistory(ctx, ""))
+		})
+
+		Convey("once unlocked, the history is kept encrypted", func() {
+			So(logic.UnlockHistory(ctx, "passphrase"), ShouldBeNil)
+			_, err := logic.ImportConversations(ctx, []domain.History{{With: "bob", Messages: []domain.Message{{Ref: "b1", Author: "bob", Content: "hi"}}}})
+			So(err, ShouldBeNil)
+
+			messages, err := logic.GetConversationWith(ctx, "bob", domain.Page{})
+			So(err, ShouldBeNil)
+			So(messages[0].Content, ShouldEqual, "hi")
+
+			stored, ok := backend.GetConversationWith(ctx, "bob", domain.Page{})
+			So(ok, ShouldBeTrue)
+			So(stored[0].Content, ShouldNotContainSubstring, "hi")
+
+			Convey("locked again, it can only be unlocked with the passphrase", func() {
+				So(logic.LockHistory(ctx), ShouldBeNil)
+				_, err := logic.GetConversationWith(ctx, "bob", domain.Page{})
+				So(err, ShouldHaveSameTypeAs, domain.ErrLocked{})
+
+				unauthorizedErrIsReturned(logic.UnlockHistory(ctx, "wrong"))
+				So(logic.UnlockHistory(ctx, "passphrase"), ShouldBeNil)
+			})
+
+			Convey("when the passphrase is changed, the previous one doesn't open it anymore", func() {
+				unauthorizedErrIsReturned(logic.ChangeHistorySecret(ctx, "wrong", "another"))
+				So(logic.ChangeHistorySecret(ctx, "passphrase", "another"), ShouldBeNil)
+				So(logic.LockHistory(ctx), ShouldBeNil)
+
+				So(logic.UnlockHistory(ctx, "passphrase"), ShouldHaveSameTypeAs, domain.ErrUnauthorized{})
+				So(logic.UnlockHistory(ctx, "another"), ShouldBeNil)
+				messages, err := logic.GetConversationWith(ctx, "bob", domain.Page{})
+				So(err, ShouldBeNil)
+				So(messages[0].Content, ShouldEqual, "hi")
+			})
+
+			Convey("when the key is rotated, the previous messages stay readable", func() {
+				So(logic.RotateHistoryKey(ctx), ShouldBeNil)
+				_, err := logic.ImportConversations(ctx, []domain.History{{With: "bob", Messages: []domain.Message{{Ref: "b2", Author: "bob", Content: "bye"}}}})
+				So(err, ShouldBeNil)
+
+				messages, err := logic.GetConversationWith(ctx, "bob", domain.Page{})
+				So(err, ShouldBeNil)
+				So(messages, ShouldHaveLength, 2)
+				So(messages[0].Content, ShouldEqual, "hi")
+				So(messages[1].Content, ShouldEqual, "bye")
+			})
+		})
+	})
+}
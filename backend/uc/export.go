@@ -0,0 +1,68 @@
+package uc
+
+import (
+	"context"
+	"fmt"
+	"gop2p/domain"
+	"gop2p/logging"
+	"gop2p/tracing"
+)
+
+// ExportConversations is used by the client to get the whole conversation with another user, or every conversation
+// if with is empty, so that the history can be restored on another machine
+func (i clientFrontInteractor) ExportConversations(ctx context.Context, with string) ([]domain.History, error) {
+	span, ctx := tracing.Start(ctx, "uc:export_conversations")
+	defer span.End()
+
+	if err := i.unlocked(); err != nil {
+		return nil, err
+	}
+	if with != "" {
+		messages, ok := i.cm.GetConversationWith(ctx, with, domain.Page{})
+		if !ok {
+			return nil, domain.ErrTechnical{}
+		}
+		return []domain.History{{With: with, Messages: messages}}, nil
+	}
+
+	histories, ok := allHistories(ctx, i.cm)
+	if !ok {
+		return nil, domain.ErrTechnical{}
+	}
+	return histories, nil
+}
+
+// ImportConversations is used by the client to restore an export, the messages already known (by ref) are skipped
+// so that importing twice changes nothing. The messages are merged by date and read, it returns how many were
+// imported
+func (i clientFrontInteractor) ImportConversations(ctx context.Context, histories []domain.History) (int, error) {
+	span, ctx := tracing.Start(ctx, "uc:import_conversations")
+	defer span.End()
+
+	if err := i.unlocked(); err != nil {
+		return 0, err
+	}
+	details := []string{}
+	for _, h := range histories {
+		if h.With == "" {
+			details = append(details, "a conversation has no user")
+		}
+		for _, m := range h.Messages {
+			if m.Ref == "" || m.Author == "" {
+				details = append(details, fmt.Sprintf("a message with %s has no ref or author", h.With))
+			}
+		}
+	}
+	if len(details) > 0 {
+		return 0, domain.ErrMalformed{Details: details}
+	}
+
+	imported, ok := mergeUnknown(ctx, i.cm, histories)
+	span.SetAttribute("messages_imported", imported)
+	if !ok {
+		return imported, domain.ErrTechnical{}
+	}
+
+	logging.Info(ctx, "conversations imported", "messages", imported)
+	return imported, nil
+}
@@ -0,0 +1,222 @@
+package uc_test
+
+import (
+	"context"
+	"fmt"
+	"gop2p/domain"
+	"gop2p/uc"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	conversationManager "gop2p/driven/inMem.conversationManager"
+	signalbroker "gop2p/driven/inMem.signalBroker"
+)
+
+func TestExportConversations(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("given a client with a conversation with bob and carol", t, func() {
+		cm := conversationManager.NewFailable()
+		So(cm.AppendToConversationWith(ctx, "bob", domain.Message{Ref: "b1", Author: "bob", Content: "hi", Reactions: map[string][]string{"👍": {"me"}}}), ShouldBeTrue)
+		So(cm.AppendToConversationWith(ctx, "bob", domain.Message{Ref: "b2", Author: "me", Deleted: true}), ShouldBeTrue)
+		So(cm.AppendToConversationWith(ctx, "carol", domain.Message{Ref: "c1", Author: "carol", Content: "hello", Edits: 1}), ShouldBeTrue)
+		logic := uc.NewClientFrontLogic(cm, nil, nil, nil, nil, uc.NewAccount(""))
+
+		Convey("the conversation with bob is exported with the metadata of the messages", func() {
+			histories, err := logic.ExportConversations(ctx, "bob")
+			So(err, ShouldBeNil)
+			So(histories, ShouldHaveLength, 1)
+			So(histories[0].With, ShouldEqual, "bob")
+			So(histories[0].Messages, ShouldHaveLength, 2)
+			So(histories[0].Messages[0].Reactions, ShouldResemble, map[string][]string{"👍": {"me"}})
+			So(histories[0].Messages[1].Deleted, ShouldBeTrue)
+		})
+
+		Convey("every conversation is exported, the most recent last", func() {
+			histories, err := logic.ExportConversations(ctx, "")
+			So(err, ShouldBeNil)
+			So(histories, ShouldHaveLength, 2)
+			So(histories[0].With, ShouldEqual, "bob")
+			So(histories[1].With, ShouldEqual, "carol")
+		})
+
+		Convey("when the conversations can't be read, the export fails", func() {
+			cm.InjectErrorAt(conversationManager.ListConversations)
+			_, err := logic.ExportConversations(ctx, "")
+			techErrIsReturned(err)
+		})
+
+		Convey("when the export is imported on a new machine", func() {
+			histories, err := logic.ExportConversations(ctx, "")
+			So(err, ShouldBeNil)
+
+			restored := conversationManager.New()
+			newLogic := uc.NewClientFrontLogic(restored, nil, nil, nil, nil, uc.NewAccount(""))
+			imported, err := newLogic.ImportConversations(ctx, histories)
+			So(err, ShouldBeNil)
+			So(imported, ShouldEqual, 3)
+
+			Convey("the history is the same, in the same order", func() {
+				restoredHistories, err := newLogic.ExportConversations(ctx, "")
+				So(err, ShouldBeNil)
+				So(refs(restoredHistories), ShouldResemble, refs(histories))
+				So(restoredHistories[1].With, ShouldEqual, "carol")
+				So(restoredHistories[1].Messages[0].Edits, ShouldEqual, 1)
+			})
+
+			Convey("and imported again, nothing changes", func() {
+				imported, err := newLogic.ImportConversations(ctx, histories)
+				So(err, ShouldBeNil)
+				So(imported, ShouldEqual, 0)
+
+				messages, ok := restored.GetConversationWith(ctx, "bob", domain.Page{})
+				So(ok, ShouldBeTrue)
+				So(messages, ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("when an export only partly known is imported, the new messages are appended", func() {
+			imported, err := logic.ImportConversations(ctx, []domain.History{
+				{With: "bob", Messages: []domain.Message{{Ref: "b1", Author: "bob", Content: "hi"}, {Ref: "b3", Author: "bob", Content: "bye"}}},
+			})
+			So(err, ShouldBeNil)
+			So(imported, ShouldEqual, 1)
+		})
+
+		Convey("when older messages are imported, they're merged by date and read", func() {
+			now := time.Now()
+			So(cm.AppendToConversationWith(ctx, "dave", domain.Message{Ref: "d2", Author: "dave", Content: "unread", Date: now.Add(-time.Hour)}), ShouldBeTrue)
+			So(cm.AppendToConversationWith(ctx, "dave", domain.Message{Ref: "d4", Author: "me", Content: "hey", Date: now}), ShouldBeTrue)
+
+			imported, err := logic.ImportConversations(ctx, []domain.History{{With: "dave", Messages: []domain.Message{
+				{Ref: "d3", Author: "dave", Content: "later", Date: now.Add(-30 * time.Minute)},
+				{Ref: "d1", Author: "dave", Content: "first", Date: now.Add(-2 * time.Hour)},
+			}}})
+			So(err, ShouldBeNil)
+			So(imported, ShouldEqual, 2)
+
+			messages, err := logic.GetConversationWith(ctx, "dave", domain.Page{Limit: 2})
+			So(err, ShouldBeNil)
+			So(messages[0].Ref, ShouldEqual, "d3")
+			So(messages[1].Ref, ShouldEqual, "d4")
+
+			older, err := logic.GetConversationWith(ctx, "dave", domain.Page{Before: messages[0].ID})
+			So(err, ShouldBeNil)
+			So(older, ShouldHaveLength, 2)
+			So(older[0].Ref, ShouldEqual, "d1")
+			So(older[1].Ref, ShouldEqual, "d2")
+
+			conversations, err := logic.ListConversations(ctx)
+			So(err, ShouldBeNil)
+			So(conversations[0].With, ShouldEqual, "dave")
+			So(conversations[0].LastMessage.Ref, ShouldEqual, "d4")
+		})
+
+		Convey("the messages imported are read, not the ones already unread", func() {
+			now := time.Now()
+			So(cm.AppendToConversationWith(ctx, "dave", domain.Message{Ref: "d2", Author: "dave", Content: "unread", Date: now.Add(-time.Hour)}), ShouldBeTrue)
+
+			_, err := logic.ImportConversations(ctx, []domain.History{{With: "dave", Messages: []domain.Message{
+				{Ref: "d1", Author: "dave", Content: "first", Date: now.Add(-2 * time.Hour)},
+				{Ref: "d3", Author: "dave", Content: "later", Date: now.Add(-30 * time.Minute)},
+			}}})
+			So(err, ShouldBeNil)
+
+			conversations, ok := cm.ListConversations(ctx)
+			So(ok, ShouldBeTrue)
+			So(conversations[0].With, ShouldEqual, "dave")
+			So(conversations[0].Unread, ShouldEqual, 1)
+		})
+
+		Convey("when a message is imported while it's received, it's stored once", func() {
+			// the calls to the store yield to the other goroutines, like a slower store would
+			slow := yielding{cm}
+			logic := uc.NewClientFrontLogic(slow, nil, nil, nil, nil, uc.NewAccount(""))
+			p2p := uc.NewClientP2pLogic(slow, nil, signalbroker.New(), uc.NewAccount(""))
+			var wg sync.WaitGroup
+			start := make(chan struct{})
+			errs := make(chan error, 2*100)
+			for i := 0; i < 100; i++ {
+				wg.Add(2)
+				ref := fmt.Sprintf("e%d", i)
+				go func() {
+					defer wg.Done()
+					<-start
+					_, err := logic.ImportConversations(ctx, []domain.History{{With: "erin", Messages: []domain.Message{{Ref: ref, Author: "erin", Content: "hi"}}}})
+					errs <- err
+				}()
+				go func() {
+					defer wg.Done()
+					<-start
+					errs <- p2p.HandleMessageReceived(ctx, domain.Event{Type: domain.EventMessage, Ref: ref, Content: "hi"}, domain.User{Login: "erin"})
+				}()
+			}
+			close(start)
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				So(err, ShouldBeNil)
+			}
+
+			messages, ok := cm.GetConversationWith(ctx, "erin", domain.Page{Limit: domain.MaxPageSize})
+			So(ok, ShouldBeTrue)
+			So(messages, ShouldHaveLength, 100)
+		})
+
+		Convey("an import with a message without ref is refused, nothing is imported", func() {
+			_, err := logic.ImportConversations(ctx, []domain.History{
+				{With: "dave", Messages: []domain.Message{{Ref: "d1", Author: "dave"}, {Author: "dave", Content: "no ref"}}},
+			})
+			malformedErrIsReturned(err)
+
+			messages, ok := cm.GetConversationWith(ctx, "dave", domain.Page{})
+			So(ok, ShouldBeTrue)
+			So(messages, ShouldBeEmpty)
+		})
+
+		Convey("when the messages can't be merged, the import fails", func() {
+			cm.InjectErrorAt(conversationManager.MergeOnce)
+			_, err := logic.ImportConversations(ctx, []domain.History{{With: "dave", Messages: []domain.Message{{Ref: "d1", Author: "dave"}}}})
+			techErrIsReturned(err)
+		})
+	})
+}
+
+// yielding lets the other goroutines run after each read or write of the conversations
+type yielding struct {
+	uc.ConversationManager
+}
+
+func (y yielding) GetMessage(ctx context.Context, with, ref string) (*domain.Message, bool) {
+	defer runtime.Gosched()
+	return y.ConversationManager.GetMessage(ctx, with, ref)
+}
+
+func (y yielding) AppendToConversationWith(ctx context.Context, with string, msg domain.Message) bool {
+	defer runtime.Gosched()
+	return y.ConversationManager.AppendToConversationWith(ctx, with, msg)
+}
+
+func (y yielding) AppendOnce(ctx context.Context, with string, msg domain.Message) (bool, bool) {
+	defer runtime.Gosched()
+	return y.ConversationManager.AppendOnce(ctx, with, msg)
+}
+
+func (y yielding) MergeOnce(ctx context.Context, with string, msgs []domain.Message) (int, bool) {
+	defer runtime.Gosched()
+	return y.ConversationManager.MergeOnce(ctx, with, msgs)
+}
+
+// refs of the messages by conversation
+func refs(histories []domain.History) map[string][]string {
+	r := map[string][]string{}
+	for _, h := range histories {
+		for _, m := range h.Messages {
+			r[h.With] = append(r[h.With], m.Ref)
+		}
+	}
+	return r
+}
@@ -0,0 +1,11 @@
+package uc
+
+import (
+	"context"
+	"time"
+)
+
+// NewSenderVerifierWith resolves the hosts with lookupHost and reads the time with now, for the tests
+func NewSenderVerifierWith(sg ServerGateway, a *Account, lookupHost func(context.Context, string) ([]string, error), now func() time.Time) SenderVerifier {
+	return newSenderVerifier(sg, a, lookupHost, now)
+}
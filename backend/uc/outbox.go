@@ -0,0 +1,179 @@
+package uc
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"gop2p/domain"
+	"gop2p/logging"
+	"gop2p/tracing"
+	"sync"
+	"time"
+)
+
+// outbox keeps the messages the peers couldn't store yet (by ref), and the events they couldn't receive, until they're
+// sent again. They're only sent again within the dedupe window of the receivers, so that a message can't be stored
+// twice: they're failed past it
+type outbox struct {
+	mu     sync.Mutex
+	queued []queued
+}
+
+type queued struct {
+	with string
+	e    domain.Event
+	at   time.Time
+}
+
+func newOutbox() *outbox {
+	return &outbox{}
+}
+
+// add queues the event, a message already queued isn't queued twice
+func (o *outbox) add(with string, e domain.Event, at time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, q := range o.queued {
+		if e.Type == domain.EventMessage && q.e.Type == e.Type && q.with == with && q.e.Ref == e.Ref {
+			return
+		}
+	}
+	o.queued = append(o.queued, queued{with: with, e: e, at: at})
+}
+
+// waiting tells if something is queued for the conversation
+func (o *outbox) waiting(with string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, q := range o.queued {
+		if q.with == with {
+			return true
+		}
+	}
+	return false
+}
+
+// take empties the outbox
+func (o *outbox) take() []queued {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	taken := o.queued
+	o.queued = nil
+	return taken
+}
+
+// putBack queues again what wasn't sent, before what was added meanwhile
+func (o *outbox) putBack(kept []queued) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.queued = append(kept, o.queued...)
+}
+
+// FlushOutbox is used by the client to send again the messages its peers couldn't store yet and the events they
+// couldn't receive, in the order they were sent. It returns how many were delivered
+func (i *clientFrontInteractor) FlushOutbox(ctx context.Context, now time.Time) (int, error) {
+	span, ctx := tracing.Start(ctx, "uc:flush_outbox")
+	defer span.End()
+
+	emitter := i.account.Login()
+	if emitter == "" {
+		return 0, domain.ErrUnauthorized{}
+	}
+	if err := i.unlocked(); err != nil {
+		return 0, err
+	}
+	ctx = logging.WithLogin(ctx, emitter)
+
+	kept := []queued{}
+	// a conversation waits for its oldest message to be delivered
+	waiting := map[string]bool{}
+	delivered := 0
+	for _, q := range i.outbox.take() {
+		switch {
+		case waiting[q.with]:
+			kept = append(kept, q)
+		case now.Sub(q.at) >= domain.DedupeWindow:
+			i.giveUp(ctx, q)
+		default:
+			done, sent := i.resend(ctx, emitter, q)
+			if sent {
+				delivered++
+			}
+			if !done {
+				waiting[q.with] = true
+				kept = append(kept, q)
+			}
+		}
+	}
+	i.outbox.putBack(kept)
+
+	if delivered > 0 {
+		span.SetAttribute("delivered", delivered)
+		logging.Info(ctx, "outbox sent again", "delivered", delivered)
+	}
+	return delivered, nil
+}
+
+// resend tells if the message or the event is done with, and if it was delivered: a message may have been deleted or
+// sent again by the user meanwhile
+func (i *clientFrontInteractor) resend(ctx context.Context, emitter string, q queued) (done bool, sent bool) {
+	if q.e.Type != domain.EventMessage {
+		err := i.deliverEvent(ctx, emitter, q.with, q.e)
+		return err == nil, err == nil
+	}
+
+	m, ok := i.cm.GetMessage(ctx, q.with, q.e.Ref)
+	if !ok {
+		return false, false
+	}
+	if m == nil || m.Deleted || m.Status != domain.MessagePending {
+		return true, false
+	}
+
+	sessions, err := i.peerSessions(ctx, emitter, q.with)
+	if err != nil {
+		return false, false
+	}
+	if status := i.deliver(ctx, sessions, *m, emitter); status != domain.MessageDelivered {
+		return false, false
+	}
+	i.setStatus(ctx, q.with, m.Ref, domain.MessageDelivered)
+	return true, true
+}
+
+// giveUp fails the message still pending past the dedupe window, an event is dropped
+func (i *clientFrontInteractor) giveUp(ctx context.Context, q queued) {
+	tracing.FromContext(ctx).Error(errors.Errorf("%s %s not delivered to %s in time", q.e.Type, q.e.Ref, q.with))
+	if q.e.Type != domain.EventMessage {
+		return
+	}
+
+	m, ok := i.cm.GetMessage(ctx, q.with, q.e.Ref)
+	if ok && m != nil && m.Status == domain.MessagePending {
+		i.setStatus(ctx, q.with, m.Ref, domain.MessageFailed)
+	}
+}
+
+// FlushOutboxEvery sends the outbox again at every interval until the context is done
+func FlushOutboxEvery(ctx context.Context, logic ClientFrontLogic, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			_, err := logic.FlushOutbox(ctx, now)
+			switch err.(type) {
+			// nothing can be sent before logging in, nor read while the history is locked
+			case nil, domain.ErrUnauthorized, domain.ErrLocked:
+			default:
+				logging.Warn(ctx, "outbox not flushed", "err", err)
+			}
+		}
+	}
+}
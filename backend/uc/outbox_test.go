@@ -0,0 +1,148 @@
+package uc_test
+
+import (
+	"context"
+	"gop2p/domain"
+	"gop2p/uc"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	conversationManager "gop2p/driven/inMem.conversationManager"
+)
+
+func TestFlushOutbox(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("given 2 messages sent while the history of bob is locked", t, func() {
+		cm := conversationManager.NewFailable()
+		peer := &peerStub{locked: true}
+		logic := uc.NewClientFrontLogic(cm, peer, peer, nil, nil, uc.NewAccount(""))
+		So(logic.NewSessionRegistered(ctx, "me"), ShouldBeNil)
+		So(logic.SendMessageToOtherClient(ctx, "bob", "hi", nil), ShouldBeNil)
+		So(logic.SendMessageToOtherClient(ctx, "bob", "are you there ?", nil), ShouldBeNil)
+
+		messages := func() []domain.Message {
+			messages, ok := cm.GetConversationWith(ctx, "bob", domain.Page{})
+			So(ok, ShouldBeTrue)
+			So(messages, ShouldHaveLength, 2)
+			return messages
+		}
+		sent := messages()
+
+		Convey("they stay pending while it's locked", func() {
+			delivered, err := logic.FlushOutbox(ctx, time.Now())
+			So(err, ShouldBeNil)
+			So(delivered, ShouldEqual, 0)
+			So(messages()[0].Status, ShouldEqual, domain.MessagePending)
+		})
+
+		Convey("once it's unlocked, they're delivered in order with their ref", func() {
+			peer.locked = false
+			delivered, err := logic.FlushOutbox(ctx, time.Now())
+			So(err, ShouldBeNil)
+			So(delivered, ShouldEqual, 2)
+			So(peer.refs, ShouldResemble, []string{sent[0].Ref, sent[1].Ref})
+			So(messages()[0].Status, ShouldEqual, domain.MessageDelivered)
+			So(messages()[1].Status, ShouldEqual, domain.MessageDelivered)
+
+			Convey("and aren't sent again", func() {
+				delivered, err := logic.FlushOutbox(ctx, time.Now())
+				So(err, ShouldBeNil)
+				So(delivered, ShouldEqual, 0)
+				So(peer.refs, ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("a message deleted meanwhile isn't sent again", func() {
+			So(cm.DeleteMessage(ctx, "bob", sent[0].Ref), ShouldBeTrue)
+			peer.locked = false
+			delivered, err := logic.FlushOutbox(ctx, time.Now())
+			So(err, ShouldBeNil)
+			So(delivered, ShouldEqual, 1)
+			So(peer.refs, ShouldResemble, []string{sent[1].Ref})
+		})
+
+		Convey("they fail once bob could have forgotten their refs", func() {
+			peer.locked = false
+			delivered, err := logic.FlushOutbox(ctx, time.Now().Add(domain.DedupeWindow))
+			So(err, ShouldBeNil)
+			So(delivered, ShouldEqual, 0)
+			So(peer.refs, ShouldBeEmpty)
+			So(messages()[0].Status, ShouldEqual, domain.MessageFailed)
+			So(messages()[1].Status, ShouldEqual, domain.MessageFailed)
+		})
+
+		Convey("when the history can't be read, they're kept for the next time", func() {
+			peer.locked = false
+			cm.InjectErrorAt(conversationManager.GetMessage)
+			delivered, err := logic.FlushOutbox(ctx, time.Now())
+			So(err, ShouldBeNil)
+			So(delivered, ShouldEqual, 0)
+			So(peer.refs, ShouldBeEmpty)
+			So(messages()[0].Status, ShouldEqual, domain.MessagePending)
+		})
+	})
+
+	Convey("nothing is sent before logging in", t, func() {
+		_, err := uc.NewClientFrontLogic(conversationManager.New(), nil, nil, nil, nil, uc.NewAccount("")).FlushOutbox(ctx, time.Now())
+		unauthorizedErrIsReturned(err)
+	})
+}
+
+func TestResendMessage(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("given a message of each user", t, func() {
+		cm := conversationManager.New()
+		So(cm.AppendToConversationWith(ctx, "bob", domain.Message{Ref: "failed", Author: "me", Content: "hi", Date: time.Now(), Status: domain.MessageFailed}), ShouldBeTrue)
+		So(cm.AppendToConversationWith(ctx, "bob", domain.Message{Ref: "theirs", Author: "bob", Content: "hello", Date: time.Now()}), ShouldBeTrue)
+		peer := &peerStub{}
+		logic := uc.NewClientFrontLogic(cm, peer, peer, nil, nil, uc.NewAccount(""))
+		So(logic.NewSessionRegistered(ctx, "me"), ShouldBeNil)
+
+		status := func(ref string) string {
+			m, ok := cm.GetMessage(ctx, "bob", ref)
+			So(ok, ShouldBeTrue)
+			So(m, ShouldNotBeNil)
+			return m.Status
+		}
+
+		Convey("the failed message is delivered with its ref", func() {
+			So(logic.ResendMessage(ctx, "bob", "failed"), ShouldBeNil)
+			So(peer.refs, ShouldResemble, []string{"failed"})
+			So(status("failed"), ShouldEqual, domain.MessageDelivered)
+
+			Convey("then it can't be sent again", func() {
+				So(logic.ResendMessage(ctx, "bob", "failed"), ShouldHaveSameTypeAs, domain.ErrMalformed{})
+			})
+		})
+
+		Convey("when the history of bob is locked, it's pending and sent again later", func() {
+			peer.locked = true
+			So(logic.ResendMessage(ctx, "bob", "failed"), ShouldBeNil)
+			So(status("failed"), ShouldEqual, domain.MessagePending)
+
+			peer.locked = false
+			delivered, err := logic.FlushOutbox(ctx, time.Now())
+			So(err, ShouldBeNil)
+			So(delivered, ShouldEqual, 1)
+			So(status("failed"), ShouldEqual, domain.MessageDelivered)
+		})
+
+		Convey("when bob can't be reached, it fails again", func() {
+			peer.unreachable = true
+			techErrIsReturned(logic.ResendMessage(ctx, "bob", "failed"))
+			So(status("failed"), ShouldEqual, domain.MessageFailed)
+		})
+
+		Convey("the message of bob can't be sent", func() {
+			unauthorizedErrIsReturned(logic.ResendMessage(ctx, "bob", "theirs"))
+			So(peer.refs, ShouldBeEmpty)
+		})
+
+		Convey("an unknown message isn't found", func() {
+			resourceNotFoundErrIsReturned(logic.ResendMessage(ctx, "bob", "unknown"))
+		})
+	})
+}
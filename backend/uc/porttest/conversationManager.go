@@ -0,0 +1,559 @@
+package porttest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gop2p/domain"
+	"gop2p/uc"
+)
+
+// RunConversationManagerSuite checks the whole uc.ConversationManager contract, newManager must return an empty
+// manager each time
+func RunConversationManagerSuite(t *testing.T, newManager func() uc.ConversationManager) {
+	ctx := context.Background()
+	photo := domain.Attachment{Hash: "a1b2", Name: "photo.jpg", Size: 42}
+
+	Convey("given a conversation manager with a conversation with bob", t, func() {
+		manager := newManager()
+		So(manager.AppendToConversationWith(ctx, "bob", domain.Message{Ref: "r1", Author: "bob", Content: "Hello there"}), ShouldBeTrue)
+		So(manager.AppendToConversationWith(ctx, "bob", domain.Message{Ref: "r2", Author: "me", Content: "hi bob", Attachments: []domain.Attachment{photo}}), ShouldBeTrue)
+		So(manager.AppendToConversationWith(ctx, "bob", domain.Message{Ref: "r3", Author: "bob", Content: "how are you?"}), ShouldBeTrue)
+
+		conversation := func(with string) []domain.Message {
+			messages, ok := manager.GetConversationWith(ctx, with, domain.Page{})
+			So(ok, ShouldBeTrue)
+			return messages
+		}
+		message := func(ref string) *domain.Message {
+			m, ok := manager.GetMessage(ctx, "bob", ref)
+			So(ok, ShouldBeTrue)
+			return m
+		}
+
+		Convey("the messages are returned oldest first, with increasing IDs", func() {
+			messages := conversation("bob")
+			So(refs(messages), ShouldResemble, []string{"r1", "r2", "r3"})
+			So(messages[0].ID, ShouldBeGreaterThan, 0)
+			So(messages[1].ID, ShouldBeGreaterThan, messages[0].ID)
+			So(messages[2].ID, ShouldBeGreaterThan, messages[1].ID)
+			So(messages[1].Attachments, ShouldResemble, []domain.Attachment{photo})
+		})
+
+		Convey("an unknown conversation is empty, without failing", func() {
+			So(conversation("unknown"), ShouldBeEmpty)
+		})
+
+		Convey("the IDs increase across the conversations", func() {
+			So(manager.AppendToConversationWith(ctx, "carol", domain.Message{Ref: "c1", Author: "carol", Content: "yo"}), ShouldBeTrue)
+			So(conversation("carol")[0].ID, ShouldBeGreaterThan, conversation("bob")[2].ID)
+		})
+
+		Convey("the conversation is paginated", func() {
+			messages := conversation("bob")
+
+			latest, ok := manager.GetConversationWith(ctx, "bob", domain.Page{Limit: 2})
+			So(ok, ShouldBeTrue)
+			So(refs(latest), ShouldResemble, []string{"r2", "r3"})
+
+			before, ok := manager.GetConversationWith(ctx, "bob", domain.Page{Before: messages[2].ID, Limit: 1})
+			So(ok, ShouldBeTrue)
+			So(refs(before), ShouldResemble, []string{"r2"})
+
+			after, ok := manager.GetConversationWith(ctx, "bob", domain.Page{After: messages[0].ID, Limit: 1})
+			So(ok, ShouldBeTrue)
+			So(refs(after), ShouldResemble, []string{"r2"})
+
+			none, ok := manager.GetConversationWith(ctx, "bob", domain.Page{After: messages[2].ID})
+			So(ok, ShouldBeTrue)
+			So(none, ShouldBeEmpty)
+		})
+
+		Convey("a message is found by its ref in its conversation only", func() {
+			So(message("r1").Content, ShouldEqual, "Hello there")
+			So(message("unknown"), ShouldBeNil)
+
+			m, ok := manager.GetMessage(ctx, "carol", "r1")
+			So(ok, ShouldBeTrue)
+			So(m, ShouldBeNil)
+		})
+
+		Convey("when a message is edited", func() {
+			So(manager.EditMessage(ctx, "bob", "r1", "Hello you", 2), ShouldBeTrue)
+
+			Convey("its content and version change", func() {
+				So(message("r1").Content, ShouldEqual, "Hello you")
+				So(message("r1").Edits, ShouldEqual, 2)
+			})
+
+			Convey("an older or the same version is ignored", func() {
+				So(manager.EditMessage(ctx, "bob", "r1", "Hello again", 1), ShouldBeTrue)
+				So(manager.EditMessage(ctx, "bob", "r1", "Hello again", 2), ShouldBeTrue)
+				So(message("r1").Content, ShouldEqual, "Hello you")
+			})
+
+			Convey("it's searched by its new content", func() {
+				So(searched(manager, "you"), ShouldContain, "r1")
+				So(searched(manager, "there"), ShouldBeEmpty)
+			})
+		})
+
+		Convey("when a message is deleted", func() {
+			So(manager.SetReaction(ctx, "bob", "r2", "bob", "👍", true), ShouldBeTrue)
+			So(manager.DeleteMessage(ctx, "bob", "r2"), ShouldBeTrue)
+
+			Convey("a tombstone is left, without content, reactions nor attachments", func() {
+				m := message("r2")
+				So(m.Deleted, ShouldBeTrue)
+				So(m.Content, ShouldBeEmpty)
+				So(m.Reactions, ShouldBeEmpty)
+				So(m.Attachments, ShouldBeEmpty)
+				So(conversation("bob"), ShouldHaveLength, 3)
+			})
+
+			Convey("it can't be edited nor reacted to anymore", func() {
+				So(manager.EditMessage(ctx, "bob", "r2", "back", 1), ShouldBeTrue)
+				So(manager.SetReaction(ctx, "bob", "r2", "me", "👍", true), ShouldBeTrue)
+				So(message("r2").Content, ShouldBeEmpty)
+				So(message("r2").Reactions, ShouldBeEmpty)
+			})
+
+			Convey("its attachments and words aren't found anymore", func() {
+				a, ok := manager.FindAttachment(ctx, "bob", photo.Hash)
+				So(ok, ShouldBeTrue)
+				So(a, ShouldBeNil)
+				So(searched(manager, "hi"), ShouldBeEmpty)
+			})
+		})
+
+		Convey("when both users react", func() {
+			So(manager.SetReaction(ctx, "bob", "r1", "me", "👍", true), ShouldBeTrue)
+			So(manager.SetReaction(ctx, "bob", "r1", "bob", "👍", true), ShouldBeTrue)
+			So(manager.SetReaction(ctx, "bob", "r1", "bob", "👍", true), ShouldBeTrue)
+
+			Convey("each user is counted once, sorted", func() {
+				So(message("r1").Reactions, ShouldResemble, map[string][]string{"👍": {"bob", "me"}})
+			})
+
+			Convey("and both take it back, the emoji is gone", func() {
+				So(manager.SetReaction(ctx, "bob", "r1", "me", "👍", false), ShouldBeTrue)
+				So(manager.SetReaction(ctx, "bob", "r1", "bob", "👍", false), ShouldBeTrue)
+				So(message("r1").Reactions, ShouldBeEmpty)
+			})
+		})
+
+		Convey("the operations on an unknown message do nothing, without failing", func() {
+			So(manager.EditMessage(ctx, "bob", "unknown", "x", 1), ShouldBeTrue)
+			So(manager.DeleteMessage(ctx, "bob", "unknown"), ShouldBeTrue)
+			So(manager.SetReaction(ctx, "carol", "r1", "me", "👍", true), ShouldBeTrue)
+			So(conversation("carol"), ShouldBeEmpty)
+		})
+
+		Convey("an attachment is found in its conversation only", func() {
+			a, ok := manager.FindAttachment(ctx, "bob", photo.Hash)
+			So(ok, ShouldBeTrue)
+			So(a, ShouldResemble, &photo)
+
+			a, ok = manager.FindAttachment(ctx, "carol", photo.Hash)
+			So(ok, ShouldBeTrue)
+			So(a, ShouldBeNil)
+		})
+
+		Convey("the conversations are listed, the most recent first, with their unread messages", func() {
+			So(manager.AppendToConversationWith(ctx, "carol", domain.Message{Ref: "c1", Author: "carol", Content: "yo"}), ShouldBeTrue)
+
+			conversations, ok := manager.ListConversations(ctx)
+			So(ok, ShouldBeTrue)
+			So(conversations, ShouldHaveLength, 2)
+			So(conversations[0].With, ShouldEqual, "carol")
+			So(conversations[0].LastMessage.Ref, ShouldEqual, "c1")
+			So(conversations[0].Unread, ShouldEqual, 1)
+			So(conversations[1].With, ShouldEqual, "bob")
+			So(conversations[1].Unread, ShouldEqual, 2)
+		})
+
+		Convey("when the conversation is read up to a message", func() {
+			messages := conversation("bob")
+			So(manager.MarkConversationRead(ctx, "bob", messages[1].ID), ShouldBeTrue)
+			unread := func() int {
+				conversations, ok := manager.ListConversations(ctx)
+				So(ok, ShouldBeTrue)
+				return conversations[0].Unread
+			}
+
+			Convey("only the messages of the other user after it are unread", func() {
+				So(unread(), ShouldEqual, 1)
+			})
+
+			Convey("it never goes back", func() {
+				So(manager.MarkConversationRead(ctx, "bob", messages[0].ID), ShouldBeTrue)
+				So(unread(), ShouldEqual, 1)
+			})
+		})
+
+		Convey("the messages are searched by all their words, whatever the case, the most recent first", func() {
+			So(manager.AppendToConversationWith(ctx, "carol", domain.Message{Ref: "c1", Author: "carol", Content: "HELLO bob"}), ShouldBeTrue)
+
+			So(searched(manager, "hello"), ShouldResemble, []string{"c1", "r1"})
+			So(searched(manager, "hello there"), ShouldResemble, []string{"r1"})
+			So(searched(manager, "hello nobody"), ShouldBeEmpty)
+			So(searched(manager, "  "), ShouldBeEmpty)
+
+			results, ok := manager.SearchMessages(ctx, "hello", 1)
+			So(ok, ShouldBeTrue)
+			So(results, ShouldHaveLength, 1)
+			So(results[0].With, ShouldEqual, "carol")
+		})
+
+		Convey("the messages returned aren't the stored ones", func() {
+			So(manager.SetReaction(ctx, "bob", "r1", "me", "👍", true), ShouldBeTrue)
+			m := message("r1")
+			m.Reactions["👍"][0] = "changed"
+			m.Reactions["🎉"] = []string{"changed"}
+			conversation("bob")[1].Attachments[0].Name = "changed"
+
+			So(message("r1").Reactions, ShouldResemble, map[string][]string{"👍": {"me"}})
+			So(message("r2").Attachments[0].Name, ShouldEqual, photo.Name)
+		})
+
+		Convey("when many messages are appended at once", func() {
+			concurrently(func(i int) {
+				manager.AppendToConversationWith(ctx, "bob", domain.Message{Ref: fmt.Sprintf("c%02d", i), Author: "bob", Content: "hey"})
+				manager.GetConversationWith(ctx, "bob", domain.Page{Limit: 2})
+				manager.SearchMessages(ctx, "hey", 0)
+			})
+
+			Convey("none is lost and each has its own ID", func() {
+				messages := conversation("bob")
+				So(messages, ShouldHaveLength, concurrency+3)
+				So(sort.SliceIsSorted(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID }), ShouldBeTrue)
+				for i := 1; i < len(messages); i++ {
+					So(messages[i].ID, ShouldNotEqual, messages[i-1].ID)
+				}
+			})
+		})
+
+		Convey("the status of a message is changed", func() {
+			So(manager.SetMessageStatus(ctx, "bob", "r2", domain.MessageDelivered), ShouldBeTrue)
+			So(message("r2").Status, ShouldEqual, domain.MessageDelivered)
+			So(message("r2").Content, ShouldEqual, "hi bob")
+
+			Convey("it's kept once the message is deleted", func() {
+				So(manager.DeleteMessage(ctx, "bob", "r2"), ShouldBeTrue)
+				So(message("r2").Status, ShouldEqual, domain.MessageDelivered)
+			})
+
+			Convey("nothing is changed for an unknown message, without failing", func() {
+				So(manager.SetMessageStatus(ctx, "bob", "unknown", domain.MessageFailed), ShouldBeTrue)
+				So(message("unknown"), ShouldBeNil)
+			})
+		})
+
+		Convey("when messages are appended while the status of others change, no update is lost", func() {
+			concurrently(func(i int) {
+				ref := fmt.Sprintf("p%02d", i)
+				manager.AppendToConversationWith(ctx, "bob", domain.Message{Ref: ref, Author: "me", Content: "hey", Status: domain.MessagePending})
+				manager.SetMessageStatus(ctx, "bob", ref, domain.MessageDelivered)
+			})
+
+			messages := conversation("bob")
+			So(messages, ShouldHaveLength, 3+concurrency)
+			for _, m := range messages[3:] {
+				So(m.Status, ShouldEqual, domain.MessageDelivered)
+			}
+		})
+
+		Convey("a message is appended once by ref", func() {
+			stored, ok := manager.AppendOnce(ctx, "bob", domain.Message{Ref: "r4", Author: "bob", Content: "once"})
+			So(ok, ShouldBeTrue)
+			So(stored, ShouldBeTrue)
+
+			stored, ok = manager.AppendOnce(ctx, "bob", domain.Message{Ref: "r4", Author: "bob", Content: "once"})
+			So(ok, ShouldBeTrue)
+			So(stored, ShouldBeFalse)
+			So(refs(conversation("bob")), ShouldResemble, []string{"r1", "r2", "r3", "r4"})
+
+			Convey("in its conversation only", func() {
+				stored, ok := manager.AppendOnce(ctx, "carol", domain.Message{Ref: "r4", Author: "carol", Content: "once"})
+				So(ok, ShouldBeTrue)
+				So(stored, ShouldBeTrue)
+			})
+
+			Convey("even once deleted", func() {
+				So(manager.DeleteMessage(ctx, "bob", "r4"), ShouldBeTrue)
+				stored, ok := manager.AppendOnce(ctx, "bob", domain.Message{Ref: "r4", Author: "bob", Content: "once"})
+				So(ok, ShouldBeTrue)
+				So(stored, ShouldBeFalse)
+			})
+
+			Convey("even once purged", func() {
+				_, ok := manager.PurgeMessages(ctx, "bob", time.Time{}, 1)
+				So(ok, ShouldBeTrue)
+				So(refs(conversation("bob")), ShouldResemble, []string{"r4"})
+
+				for _, ref := range []string{"r1", "r4"} {
+					stored, ok := manager.AppendOnce(ctx, "bob", domain.Message{Ref: ref, Author: "bob", Content: "again"})
+					So(ok, ShouldBeTrue)
+					So(stored, ShouldBeFalse)
+				}
+			})
+		})
+
+		Convey("the messages are merged by date once by ref, read, and the IDs keep increasing", func() {
+			now := time.Now()
+			So(manager.AppendToConversationWith(ctx, "dave", domain.Message{Ref: "d2", Author: "dave", Content: "unread", Date: now.Add(-2 * time.Hour)}), ShouldBeTrue)
+			So(manager.AppendToConversationWith(ctx, "dave", domain.Message{Ref: "d4", Author: "me", Content: "hey", Date: now}), ShouldBeTrue)
+
+			stored, ok := manager.MergeOnce(ctx, "dave", []domain.Message{
+				{Ref: "d3", Author: "dave", Content: "later", Date: now.Add(-time.Hour)},
+				{Ref: "d1", Author: "dave", Content: "first", Date: now.Add(-3 * time.Hour)},
+				{Ref: "d2", Author: "dave", Content: "unread", Date: now.Add(-2 * time.Hour)},
+				{Content: "no ref"},
+			})
+			So(ok, ShouldBeTrue)
+			So(stored, ShouldEqual, 2)
+
+			messages := conversation("dave")
+			So(refs(messages), ShouldResemble, []string{"d1", "d2", "d3", "d4"})
+			for i := 1; i < len(messages); i++ {
+				So(messages[i].ID, ShouldBeGreaterThan, messages[i-1].ID)
+			}
+			So(message("r1").Content, ShouldEqual, "Hello there")
+
+			conversations, ok := manager.ListConversations(ctx)
+			So(ok, ShouldBeTrue)
+			So(conversations[0].With, ShouldEqual, "dave")
+			So(conversations[0].LastMessage.Ref, ShouldEqual, "d4")
+			So(conversations[0].Unread, ShouldEqual, 1)
+
+			Convey("found by their words", func() {
+				results, ok := manager.SearchMessages(ctx, "later", 0)
+				So(ok, ShouldBeTrue)
+				So(results, ShouldHaveLength, 1)
+				So(results[0].Message.ID, ShouldEqual, messages[2].ID)
+			})
+
+			Convey("and merged again, nothing changes", func() {
+				stored, ok := manager.MergeOnce(ctx, "dave", []domain.Message{{Ref: "d1", Author: "dave", Content: "first"}})
+				So(ok, ShouldBeTrue)
+				So(stored, ShouldEqual, 0)
+				So(conversation("dave"), ShouldResemble, messages)
+			})
+		})
+
+		Convey("when the same message is appended many times at once, it's stored once", func() {
+			stored := make(chan bool, concurrency)
+			concurrently(func(int) {
+				s, _ := manager.AppendOnce(ctx, "bob", domain.Message{Ref: "redelivered", Author: "bob", Content: "hey"})
+				stored <- s
+			})
+			close(stored)
+
+			times := 0
+			for s := range stored {
+				if s {
+					times++
+				}
+			}
+			So(times, ShouldEqual, 1)
+			So(refs(conversation("bob")), ShouldResemble, []string{"r1", "r2", "r3", "redelivered"})
+		})
+
+		Convey("the retention of a conversation is kept until changed", func() {
+			r, ok := manager.GetRetention(ctx, "bob")
+			So(ok, ShouldBeTrue)
+			So(r, ShouldResemble, domain.Retention{})
+
+			So(manager.SetRetention(ctx, "bob", domain.Retention{Days: 7, TTL: 60}), ShouldBeTrue)
+			r, ok = manager.GetRetention(ctx, "bob")
+			So(ok, ShouldBeTrue)
+			So(r, ShouldResemble, domain.Retention{Days: 7, TTL: 60})
+
+			r, ok = manager.GetRetention(ctx, "carol")
+			So(ok, ShouldBeTrue)
+			So(r, ShouldResemble, domain.Retention{})
+		})
+
+		Convey("given messages stored at different dates", func() {
+			now := time.Now()
+			So(manager.AppendToConversationWith(ctx, "bob", domain.Message{Ref: "old", Author: "bob", Content: "ancient words", Date: now.Add(-48 * time.Hour)}), ShouldBeTrue)
+			So(manager.AppendToConversationWith(ctx, "bob", domain.Message{Ref: "new", Author: "me", Content: "fresh words", Date: now}), ShouldBeTrue)
+
+			Convey("the ones stored before the date are purged, not the ones without date", func() {
+				purged, ok := manager.PurgeMessages(ctx, "bob", now.Add(-time.Hour), 0)
+				So(ok, ShouldBeTrue)
+				So(purged, ShouldEqual, 1)
+				So(refs(conversation("bob")), ShouldResemble, []string{"r1", "r2", "r3", "new"})
+
+				Convey("they're not found nor searched anymore, the others still are", func() {
+					So(message("old"), ShouldBeNil)
+					So(searched(manager, "words"), ShouldResemble, []string{"new"})
+					So(message("r2").Content, ShouldEqual, "hi bob")
+					So(manager.EditMessage(ctx, "bob", "new", "edited", 1), ShouldBeTrue)
+					So(message("new").Content, ShouldEqual, "edited")
+				})
+			})
+
+			Convey("only the latest ones are kept", func() {
+				purged, ok := manager.PurgeMessages(ctx, "bob", time.Time{}, 2)
+				So(ok, ShouldBeTrue)
+				So(purged, ShouldEqual, 3)
+				So(refs(conversation("bob")), ShouldResemble, []string{"old", "new"})
+				So(searched(manager, "hello"), ShouldBeEmpty)
+			})
+
+			Convey("once every message is purged, the conversation is gone but not its retention", func() {
+				So(manager.SetRetention(ctx, "bob", domain.Retention{Messages: 1}), ShouldBeTrue)
+				purged, ok := manager.PurgeMessages(ctx, "bob", now.Add(time.Hour), 1)
+				So(ok, ShouldBeTrue)
+				So(purged, ShouldEqual, 5)
+				So(conversation("bob"), ShouldBeEmpty)
+
+				conversations, ok := manager.ListConversations(ctx)
+				So(ok, ShouldBeTrue)
+				So(conversations, ShouldBeEmpty)
+
+				r, ok := manager.GetRetention(ctx, "bob")
+				So(ok, ShouldBeTrue)
+				So(r.Messages, ShouldEqual, 1)
+
+				So(manager.AppendToConversationWith(ctx, "bob", domain.Message{Ref: "again", Author: "bob", Content: "back"}), ShouldBeTrue)
+				So(refs(conversation("bob")), ShouldResemble, []string{"again"})
+			})
+
+			Convey("nothing is purged from an unknown conversation, without failing", func() {
+				purged, ok := manager.PurgeMessages(ctx, "unknown", now, 1)
+				So(ok, ShouldBeTrue)
+				So(purged, ShouldEqual, 0)
+			})
+		})
+
+		threeMessages := func() {
+			So(refs(conversation("bob")), ShouldResemble, []string{"r1", "r2", "r3"})
+		}
+		r1IsUnchanged := func() {
+			m := message("r1")
+			So(m.Content, ShouldEqual, "Hello there")
+			So(m.Deleted, ShouldBeFalse)
+			So(m.Reactions, ShouldBeEmpty)
+		}
+		itFails(manager, []failure{
+			{method: "getConversationWith", call: func() bool {
+				messages, ok := manager.GetConversationWith(ctx, "bob", domain.Page{})
+				So(messages, ShouldBeEmpty)
+				return ok
+			}},
+			{method: "appendToConversationWith", call: func() bool {
+				return manager.AppendToConversationWith(ctx, "bob", domain.Message{Ref: "r4", Author: "me", Content: "lost"})
+			}, unchanged: threeMessages},
+			{method: "setMessageStatus", call: func() bool {
+				return manager.SetMessageStatus(ctx, "bob", "r2", domain.MessageFailed)
+			}, unchanged: threeMessages},
+			{method: "appendOnce", call: func() bool {
+				_, ok := manager.AppendOnce(ctx, "bob", domain.Message{Ref: "r4", Author: "me", Content: "lost"})
+				return ok
+			}, unchanged: threeMessages},
+			{method: "mergeOnce", call: func() bool {
+				_, ok := manager.MergeOnce(ctx, "bob", []domain.Message{{Ref: "r4", Author: "me", Content: "lost"}})
+				return ok
+			}, unchanged: threeMessages},
+			{method: "listConversations", call: func() bool {
+				conversations, ok := manager.ListConversations(ctx)
+				So(conversations, ShouldBeEmpty)
+				return ok
+			}},
+			{method: "markConversationRead", call: func() bool {
+				return manager.MarkConversationRead(ctx, "bob", conversation("bob")[2].ID)
+			}},
+			{method: "searchMessages", call: func() bool {
+				results, ok := manager.SearchMessages(ctx, "hello", 0)
+				So(results, ShouldBeEmpty)
+				return ok
+			}},
+			{method: "getMessage", call: func() bool {
+				m, ok := manager.GetMessage(ctx, "bob", "r1")
+				So(m, ShouldBeNil)
+				return ok
+			}},
+			{method: "editMessage", call: func() bool { return manager.EditMessage(ctx, "bob", "r1", "edited", 1) }, unchanged: r1IsUnchanged},
+			{method: "deleteMessage", call: func() bool { return manager.DeleteMessage(ctx, "bob", "r1") }, unchanged: r1IsUnchanged},
+			{method: "setReaction", call: func() bool { return manager.SetReaction(ctx, "bob", "r1", "me", "👍", true) }, unchanged: r1IsUnchanged},
+			{method: "findAttachment", call: func() bool {
+				a, ok := manager.FindAttachment(ctx, "bob", photo.Hash)
+				So(a, ShouldBeNil)
+				return ok
+			}},
+			{method: "getRetention", call: func() bool {
+				_, ok := manager.GetRetention(ctx, "bob")
+				return ok
+			}},
+			{method: "setRetention", call: func() bool { return manager.SetRetention(ctx, "bob", domain.Retention{Days: 1}) }, unchanged: func() {
+				r, ok := manager.GetRetention(ctx, "bob")
+				So(ok, ShouldBeTrue)
+				So(r, ShouldResemble, domain.Retention{})
+			}},
+			{method: "purgeMessages", call: func() bool {
+				_, ok := manager.PurgeMessages(ctx, "bob", time.Time{}, 1)
+				return ok
+			}, unchanged: threeMessages},
+		})
+	})
+}
+
+func refs(messages []domain.Message) []string {
+	r := []string{}
+	for _, m := range messages {
+		r = append(r, m.Ref)
+	}
+	return r
+}
+
+// searched returns the refs of the messages found, the most recent first
+func searched(manager uc.ConversationManager, query string) []string {
+	results, ok := manager.SearchMessages(context.Background(), query, 0)
+	So(ok, ShouldBeTrue)
+
+	r := []string{}
+	for _, res := range results {
+		r = append(r, res.Message.Ref)
+	}
+	return r
+}
+
+// RunConversationManagerBenchmarks measures the calls made by every message sent or received and every page read,
+// on a conversation of many messages
+func RunConversationManagerBenchmarks(b *testing.B, newManager func() uc.ConversationManager) {
+	ctx := context.Background()
+	manager := newManager()
+	for i := 0; i < population; i++ {
+		manager.AppendToConversationWith(ctx, "bob", domain.Message{Ref: fmt.Sprintf("r%d", i), Author: "bob", Content: "Hello there"})
+	}
+
+	b.Run("AppendToConversationWith", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			manager.AppendToConversationWith(ctx, "carol", domain.Message{Ref: fmt.Sprintf("r%d", i), Author: "carol", Content: "Hello there"})
+		}
+	})
+
+	b.Run("GetMessage", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			manager.GetMessage(ctx, "bob", fmt.Sprintf("r%d", i%population))
+		}
+	})
+
+	b.Run("GetConversationWith", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			manager.GetConversationWith(ctx, "bob", domain.Page{Limit: 50})
+		}
+	})
+
+	b.Run("SearchMessages", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			manager.SearchMessages(ctx, "there", 20)
+		}
+	})
+}
@@ -0,0 +1,64 @@
+// Package porttest holds the conformance suites of the side effects of the usecases: every implementation of a port
+// runs the same suite from its own tests, so that a new backend proves it behaves like the others
+package porttest
+
+import (
+	"sync"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gop2p/fault"
+)
+
+// concurrency is how many goroutines call a store at once in the suites
+const concurrency = 20
+
+// population is how many users or messages a store holds before it's benchmarked
+const population = 1000
+
+// Failable stores can be made to fail a method, named after the method of the port in lower camel case
+// (eg. "insertUser"). The failure cases are skipped for the stores which can't
+type Failable interface {
+	InjectErrorAt(method fault.Method)
+}
+
+// failure is a call which must fail once its method is injected
+type failure struct {
+	method fault.Method
+	// call returns the ok of the method, after checking the other results are zero
+	call func() bool
+	// unchanged checks with the other methods that nothing was written, if set
+	unchanged func()
+}
+
+// itFails checks each failure on the store, they're each run on a new one
+func itFails(store interface{}, failures []failure) {
+	failable, ok := store.(Failable)
+	if !ok {
+		return
+	}
+
+	for _, f := range failures {
+		f := f
+		Convey("when "+string(f.method)+" fails, it says so", func() {
+			failable.InjectErrorAt(f.method)
+			So(f.call(), ShouldBeFalse)
+
+			if f.unchanged != nil {
+				Convey("and nothing is written", f.unchanged)
+			}
+		})
+	}
+}
+
+// concurrently runs f in as many goroutines as the concurrency, and waits for them
+func concurrently(f func(i int)) {
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,184 @@
+package porttest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gop2p/domain"
+	"gop2p/uc"
+)
+
+// devicesPerGoroutine makes the concurrent inserts of the sessions of a user overlap
+const devicesPerGoroutine = 10
+
+// RunSessionManagerSuite checks the whole uc.SessionManager contract, newManager must return an empty manager each time
+func RunSessionManagerSuite(t *testing.T, newManager func() uc.SessionManager) {
+	ctx := context.Background()
+	caps := domain.Capabilities{Versions: []int{1, 0}, Features: []string{domain.FeatureGzip}}
+
+	Convey("given a session manager where alice is connected from her laptop", t, func() {
+		manager := newManager()
+		So(manager.InsertSession(ctx, "alice", "laptop", "alice-laptop:4000", caps), ShouldBeTrue)
+
+		Convey("her session is found", func() {
+			sessions, ok := manager.GetSessions(ctx, "alice")
+			So(ok, ShouldBeTrue)
+			So(sessions, ShouldResemble, []domain.Session{
+				{Online: true, Device: "laptop", Address: "alice-laptop:4000", Capabilities: caps},
+			})
+		})
+
+		Convey("an unknown user has no session, without failing", func() {
+			sessions, ok := manager.GetSessions(ctx, "unknown")
+			So(ok, ShouldBeTrue)
+			So(sessions, ShouldBeEmpty)
+		})
+
+		Convey("when she connects from her phone too", func() {
+			So(manager.InsertSession(ctx, "alice", "phone", "alice-phone:4000", caps), ShouldBeTrue)
+
+			Convey("she has a session per device, the latest last", func() {
+				sessions, ok := manager.GetSessions(ctx, "alice")
+				So(ok, ShouldBeTrue)
+				So(devices(sessions), ShouldResemble, []string{"laptop", "phone"})
+			})
+
+			Convey("and reconnects from her laptop", func() {
+				So(manager.InsertSession(ctx, "alice", "laptop", "alice-laptop:5000", caps), ShouldBeTrue)
+
+				Convey("its session is replaced and becomes the latest", func() {
+					sessions, ok := manager.GetSessions(ctx, "alice")
+					So(ok, ShouldBeTrue)
+					So(devices(sessions), ShouldResemble, []string{"phone", "laptop"})
+					So(sessions[1].Address, ShouldEqual, "alice-laptop:5000")
+				})
+			})
+
+			Convey("and a client restarts at the address of her phone as another device", func() {
+				So(manager.InsertSession(ctx, "alice", "tablet", "alice-phone:4000", caps), ShouldBeTrue)
+
+				Convey("it replaces the phone", func() {
+					sessions, ok := manager.GetSessions(ctx, "alice")
+					So(ok, ShouldBeTrue)
+					So(devices(sessions), ShouldResemble, []string{"laptop", "tablet"})
+				})
+			})
+
+			Convey("and her sessions are deleted", func() {
+				So(manager.DeleteSession(ctx, "alice"), ShouldBeTrue)
+
+				Convey("every device is disconnected", func() {
+					sessions, ok := manager.GetSessions(ctx, "alice")
+					So(ok, ShouldBeTrue)
+					So(sessions, ShouldBeEmpty)
+				})
+			})
+		})
+
+		Convey("the sessions are listed by user", func() {
+			So(manager.InsertSession(ctx, "bob", "", "bob:4000", domain.Capabilities{}), ShouldBeTrue)
+
+			sessions, ok := manager.ListSessions(ctx)
+			So(ok, ShouldBeTrue)
+			So(sessions, ShouldHaveLength, 2)
+			So(sessions["alice"], ShouldHaveLength, 1)
+			So(sessions["bob"], ShouldResemble, []domain.Session{{Online: true, Address: "bob:4000"}})
+		})
+
+		Convey("deleting the sessions of an unknown user does nothing, without failing", func() {
+			So(manager.DeleteSession(ctx, "unknown"), ShouldBeTrue)
+
+			sessions, ok := manager.ListSessions(ctx)
+			So(ok, ShouldBeTrue)
+			So(sessions, ShouldHaveLength, 1)
+		})
+
+		Convey("the sessions returned aren't the stored ones", func() {
+			sessions, _ := manager.GetSessions(ctx, "alice")
+			sessions[0].Address = "changed"
+			listed, _ := manager.ListSessions(ctx)
+			listed["alice"][0].Address = "changed"
+
+			sessions, ok := manager.GetSessions(ctx, "alice")
+			So(ok, ShouldBeTrue)
+			So(sessions[0].Address, ShouldEqual, "alice-laptop:4000")
+		})
+
+		Convey("when she connects from many devices at once", func() {
+			concurrently(func(i int) {
+				for j := 0; j < devicesPerGoroutine; j++ {
+					device := fmt.Sprintf("device-%02d-%02d", i, j)
+					manager.InsertSession(ctx, "alice", device, device+":4000", caps)
+					manager.GetSessions(ctx, "alice")
+				}
+			})
+
+			Convey("none is lost", func() {
+				sessions, ok := manager.GetSessions(ctx, "alice")
+				So(ok, ShouldBeTrue)
+				So(sessions, ShouldHaveLength, concurrency*devicesPerGoroutine+1)
+			})
+		})
+
+		aliceIsConnected := func() {
+			sessions, ok := manager.GetSessions(ctx, "alice")
+			So(ok, ShouldBeTrue)
+			So(devices(sessions), ShouldResemble, []string{"laptop"})
+		}
+		itFails(manager, []failure{
+			{method: "insertSession", call: func() bool {
+				return manager.InsertSession(ctx, "alice", "phone", "alice-phone:4000", caps)
+			}, unchanged: aliceIsConnected},
+			{method: "getSessions", call: func() bool {
+				sessions, ok := manager.GetSessions(ctx, "alice")
+				So(sessions, ShouldBeEmpty)
+				return ok
+			}},
+			{method: "listSessions", call: func() bool {
+				sessions, ok := manager.ListSessions(ctx)
+				So(sessions, ShouldBeEmpty)
+				return ok
+			}},
+			{method: "deleteSession", call: func() bool { return manager.DeleteSession(ctx, "alice") }, unchanged: aliceIsConnected},
+		})
+	})
+}
+
+func devices(sessions []domain.Session) []string {
+	d := []string{}
+	for _, s := range sessions {
+		d = append(d, s.Device)
+	}
+	return d
+}
+
+// RunSessionManagerBenchmarks measures the calls made by every login and every message sent, on a manager of many
+// users called from every CPU
+func RunSessionManagerBenchmarks(b *testing.B, newManager func() uc.SessionManager) {
+	ctx := context.Background()
+	caps := domain.Supported()
+	manager := newManager()
+	for i := 0; i < population; i++ {
+		login := fmt.Sprintf("user-%04d", i)
+		manager.InsertSession(ctx, login, "laptop", login+":4000", caps)
+	}
+
+	b.Run("InsertSession", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				login := fmt.Sprintf("user-%04d", i%population)
+				manager.InsertSession(ctx, login, "laptop", login+":4000", caps)
+			}
+		})
+	})
+
+	b.Run("GetSessions", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				manager.GetSessions(ctx, fmt.Sprintf("user-%04d", i%population))
+			}
+		})
+	})
+}
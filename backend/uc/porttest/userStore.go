@@ -0,0 +1,208 @@
+package porttest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gop2p/domain"
+	"gop2p/uc"
+)
+
+// RunUserStoreSuite checks the whole uc.UserStore contract, newStore must return an empty store each time
+func RunUserStoreSuite(t *testing.T, newStore func() uc.UserStore) {
+	ctx := context.Background()
+
+	Convey("given a user store with alice", t, func() {
+		store := newStore()
+		So(store.InsertUser(ctx, "alice", "pass"), ShouldBeTrue)
+
+		Convey("she's found by her login", func() {
+			u, ok := store.GetUserByLogin(ctx, "alice")
+			So(ok, ShouldBeTrue)
+			So(u, ShouldResemble, &domain.User{Login: "alice", Password: "pass"})
+		})
+
+		Convey("she's found by her login and password", func() {
+			u, ok := store.GetUserByLoginPassword(ctx, "alice", "pass")
+			So(ok, ShouldBeTrue)
+			So(u, ShouldNotBeNil)
+			So(u.Login, ShouldEqual, "alice")
+		})
+
+		Convey("she isn't found with another password", func() {
+			u, ok := store.GetUserByLoginPassword(ctx, "alice", "wrong")
+			So(ok, ShouldBeTrue)
+			So(u, ShouldBeNil)
+		})
+
+		Convey("an unknown user isn't found, without failing", func() {
+			u, ok := store.GetUserByLogin(ctx, "unknown")
+			So(ok, ShouldBeTrue)
+			So(u, ShouldBeNil)
+
+			u, ok = store.GetUserByLoginPassword(ctx, "unknown", "pass")
+			So(ok, ShouldBeTrue)
+			So(u, ShouldBeNil)
+		})
+
+		Convey("the users are listed by login", func() {
+			So(store.InsertUser(ctx, "carol", "pass"), ShouldBeTrue)
+			So(store.InsertUser(ctx, "bob", "pass"), ShouldBeTrue)
+
+			users, ok := store.ListUsers(ctx)
+			So(ok, ShouldBeTrue)
+			So(logins(users), ShouldResemble, []string{"alice", "bob", "carol"})
+		})
+
+		Convey("when she's inserted again, she's replaced", func() {
+			So(store.InsertUser(ctx, "alice", "other"), ShouldBeTrue)
+
+			users, ok := store.ListUsers(ctx)
+			So(ok, ShouldBeTrue)
+			So(users, ShouldResemble, []domain.User{{Login: "alice", Password: "other"}})
+		})
+
+		Convey("when her password is updated", func() {
+			So(store.UpdatePassword(ctx, "alice", "new"), ShouldBeTrue)
+
+			Convey("only the new one matches", func() {
+				u, ok := store.GetUserByLoginPassword(ctx, "alice", "new")
+				So(ok, ShouldBeTrue)
+				So(u, ShouldNotBeNil)
+
+				u, ok = store.GetUserByLoginPassword(ctx, "alice", "pass")
+				So(ok, ShouldBeTrue)
+				So(u, ShouldBeNil)
+			})
+		})
+
+		Convey("when she's disabled, then enabled again", func() {
+			So(store.SetUserDisabled(ctx, "alice", true), ShouldBeTrue)
+			u, ok := store.GetUserByLogin(ctx, "alice")
+			So(ok, ShouldBeTrue)
+			So(u.Disabled, ShouldBeTrue)
+
+			So(store.SetUserDisabled(ctx, "alice", false), ShouldBeTrue)
+			u, ok = store.GetUserByLogin(ctx, "alice")
+			So(ok, ShouldBeTrue)
+			So(u.Disabled, ShouldBeFalse)
+		})
+
+		Convey("when she's deleted", func() {
+			So(store.DeleteUser(ctx, "alice"), ShouldBeTrue)
+
+			Convey("she's not found anymore", func() {
+				u, ok := store.GetUserByLogin(ctx, "alice")
+				So(ok, ShouldBeTrue)
+				So(u, ShouldBeNil)
+
+				users, ok := store.ListUsers(ctx)
+				So(ok, ShouldBeTrue)
+				So(users, ShouldBeEmpty)
+			})
+		})
+
+		Convey("updating or deleting an unknown user does nothing, without failing", func() {
+			So(store.UpdatePassword(ctx, "unknown", "pass"), ShouldBeTrue)
+			So(store.SetUserDisabled(ctx, "unknown", true), ShouldBeTrue)
+			So(store.DeleteUser(ctx, "unknown"), ShouldBeTrue)
+
+			u, ok := store.GetUserByLogin(ctx, "unknown")
+			So(ok, ShouldBeTrue)
+			So(u, ShouldBeNil)
+		})
+
+		Convey("the users returned aren't the stored ones", func() {
+			u, _ := store.GetUserByLogin(ctx, "alice")
+			u.Password = "changed"
+
+			u, ok := store.GetUserByLoginPassword(ctx, "alice", "pass")
+			So(ok, ShouldBeTrue)
+			So(u, ShouldNotBeNil)
+		})
+
+		Convey("when many users are inserted and updated at once", func() {
+			concurrently(func(i int) {
+				login := fmt.Sprintf("user-%02d", i)
+				store.InsertUser(ctx, login, "pass")
+				store.UpdatePassword(ctx, login, "new")
+				store.GetUserByLogin(ctx, "alice")
+			})
+
+			Convey("none is lost", func() {
+				users, ok := store.ListUsers(ctx)
+				So(ok, ShouldBeTrue)
+				So(users, ShouldHaveLength, concurrency+1)
+				for _, u := range users[1:] {
+					So(u.Password, ShouldEqual, "new")
+				}
+			})
+		})
+
+		aliceIsUnchanged := func() {
+			u, ok := store.GetUserByLogin(ctx, "alice")
+			So(ok, ShouldBeTrue)
+			So(u, ShouldResemble, &domain.User{Login: "alice", Password: "pass"})
+		}
+		itFails(store, []failure{
+			{method: "insertUser", call: func() bool { return store.InsertUser(ctx, "bob", "pass") }, unchanged: func() {
+				u, ok := store.GetUserByLogin(ctx, "bob")
+				So(ok, ShouldBeTrue)
+				So(u, ShouldBeNil)
+			}},
+			{method: "getUserByLogin", call: func() bool {
+				u, ok := store.GetUserByLogin(ctx, "alice")
+				So(u, ShouldBeNil)
+				return ok
+			}},
+			{method: "getUserByLoginPassword", call: func() bool {
+				u, ok := store.GetUserByLoginPassword(ctx, "alice", "pass")
+				So(u, ShouldBeNil)
+				return ok
+			}},
+			{method: "listUsers", call: func() bool {
+				users, ok := store.ListUsers(ctx)
+				So(users, ShouldBeEmpty)
+				return ok
+			}},
+			{method: "updatePassword", call: func() bool { return store.UpdatePassword(ctx, "alice", "new") }, unchanged: aliceIsUnchanged},
+			{method: "setUserDisabled", call: func() bool { return store.SetUserDisabled(ctx, "alice", true) }, unchanged: aliceIsUnchanged},
+			{method: "deleteUser", call: func() bool { return store.DeleteUser(ctx, "alice") }, unchanged: aliceIsUnchanged},
+		})
+	})
+}
+
+func logins(users []domain.User) []string {
+	l := []string{}
+	for _, u := range users {
+		l = append(l, u.Login)
+	}
+	return l
+}
+
+// RunUserStoreBenchmarks measures the calls made by every login, on a store of many users called from every CPU
+func RunUserStoreBenchmarks(b *testing.B, newStore func() uc.UserStore) {
+	ctx := context.Background()
+	store := newStore()
+	for i := 0; i < population; i++ {
+		store.InsertUser(ctx, fmt.Sprintf("user-%04d", i), "pass")
+	}
+
+	b.Run("GetUserByLoginPassword", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				store.GetUserByLoginPassword(ctx, fmt.Sprintf("user-%04d", i%population), "pass")
+			}
+		})
+	})
+
+	b.Run("GetUserByLogin", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				store.GetUserByLogin(ctx, fmt.Sprintf("user-%04d", i%population))
+			}
+		})
+	})
+}
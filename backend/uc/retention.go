@@ -0,0 +1,163 @@
+package uc
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"gop2p/domain"
+	"gop2p/logging"
+	"gop2p/tracing"
+	"time"
+)
+
+// GetRetention is used by the client to get the retention of a conversation, the default one applies to what's
+// not set
+func (i clientFrontInteractor) GetRetention(ctx context.Context, with string) (domain.Retention, error) {
+	span, ctx := tracing.Start(ctx, "uc:get_retention")
+	defer span.End()
+
+	if err := i.unlocked(); err != nil {
+		return domain.Retention{}, err
+	}
+
+	r, ok := i.cm.GetRetention(ctx, with)
+	if !ok {
+		return domain.Retention{}, domain.ErrTechnical{}
+	}
+	return r, nil
+}
+
+// SetRetention is used by the client to change the retention of a conversation. How long its messages are kept
+// (Days and Messages) is up to each user, the TTL is sent to the other user so that the messages disappear for both:
+// it's only stored once the other user has it
+func (i clientFrontInteractor) SetRetention(ctx context.Context, with string, r domain.Retention) error {
+	span, ctx := tracing.Start(ctx, "uc:set_retention")
+	defer span.End()
+
+	if !r.Valid() {
+		return domain.ErrMalformed{Details: []string{"the retention can't be negative"}}
+	}
+	if err := i.unlocked(); err != nil {
+		return err
+	}
+
+	current, ok := i.cm.GetRetention(ctx, with)
+	if !ok {
+		return domain.ErrTechnical{}
+	}
+	// the TTL can only be agreed once logged in
+	if r.TTL != current.TTL && i.account.Login() == "" {
+		span.Error(errors.New("missing current user session"))
+		return domain.ErrUnauthorized{}
+	}
+	if r.TTL == current.TTL {
+		if ok := i.cm.SetRetention(ctx, with, r); !ok {
+			return domain.ErrTechnical{}
+		}
+		return nil
+	}
+
+	emitter := i.account.Login()
+	ctx = logging.WithLogin(ctx, emitter)
+	e := domain.Event{Type: domain.EventRetention, TTL: r.TTL}
+	if err := i.deliverEvent(ctx, emitter, with, e); err != nil {
+		return err
+	}
+	if ok := i.cm.SetRetention(ctx, with, r); !ok {
+		// the other user is sent back the TTL kept
+		if err := i.deliverEvent(ctx, emitter, with, domain.Event{Type: domain.EventRetention, TTL: current.TTL}); err != nil {
+			span.Error(errors.New("the ttl sent can't be rolled back"))
+		}
+		return domain.ErrTechnical{}
+	}
+	i.syncDevices(ctx, emitter, with, e)
+
+	return nil
+}
+
+// applyRetention sets the TTL the other user (or another device of the user) chose for the conversation, the last
+// one set wins
+func (i clientp2pInteractor) applyRetention(ctx context.Context, with string, ttl int64) error {
+	if ttl < 0 {
+		return domain.ErrMalformed{Details: []string{"the ttl can't be negative"}}
+	}
+
+	r, ok := i.cm.GetRetention(ctx, with)
+	if !ok {
+		return domain.ErrTechnical{}
+	}
+	r.TTL = ttl
+	if ok := i.cm.SetRetention(ctx, with, r); !ok {
+		return domain.ErrTechnical{}
+	}
+	return nil
+}
+
+// RetentionSweeper purges the messages of the conversations once they're past their retention
+type RetentionSweeper struct {
+	cm ConversationManager
+	// defaults apply to what the conversations don't set
+	defaults domain.Retention
+}
+
+func NewRetentionSweeper(cm ConversationManager, defaults domain.Retention) *RetentionSweeper {
+	return &RetentionSweeper{cm: cm, defaults: defaults}
+}
+
+// Sweep purges what's past the retention at the given time, it returns how many messages were purged. It waits for
+// an encrypted history to be unlocked since the conversations can't be listed meanwhile
+func (s *RetentionSweeper) Sweep(ctx context.Context, now time.Time) (int, error) {
+	span, ctx := tracing.Start(ctx, "uc:sweep_retention")
+	defer span.End()
+
+	if v, ok := s.cm.(Vault); ok && v.Locked() {
+		return 0, domain.ErrLocked{}
+	}
+
+	conversations, ok := s.cm.ListConversations(ctx)
+	if !ok {
+		return 0, domain.ErrTechnical{}
+	}
+
+	purged := 0
+	for _, c := range conversations {
+		r, ok := s.cm.GetRetention(ctx, c.With)
+		if !ok {
+			return purged, domain.ErrTechnical{}
+		}
+		r = r.Over(s.defaults)
+
+		cutoff := r.Cutoff(now)
+		if cutoff.IsZero() && r.Messages == 0 {
+			continue
+		}
+		n, ok := s.cm.PurgeMessages(ctx, c.With, cutoff, r.Messages)
+		if !ok {
+			return purged, domain.ErrTechnical{}
+		}
+		purged += n
+	}
+
+	if purged > 0 {
+		span.SetAttribute("messages_purged", purged)
+		logging.Info(ctx, "messages past their retention purged", "messages", purged)
+	}
+	return purged, nil
+}
+
+// Run sweeps at every interval until the context is done
+func (s *RetentionSweeper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			_, err := s.Sweep(ctx, now)
+			if _, locked := err.(domain.ErrLocked); err != nil && !locked {
+				logging.Warn(ctx, "retention not applied", "err", err)
+			}
+		}
+	}
+}
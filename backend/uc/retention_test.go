@@ -0,0 +1,179 @@
+package uc_test
+
+import (
+	"context"
+	"gop2p/domain"
+	"gop2p/uc"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	conversationManager "gop2p/driven/inMem.conversationManager"
+	signalbroker "gop2p/driven/inMem.signalBroker"
+)
+
+func TestSetRetention(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("given a client with a conversation with bob", t, func() {
+		cm := conversationManager.NewFailable()
+		peer := &peerStub{}
+		logic := uc.NewClientFrontLogic(cm, peer, peer, nil, nil, uc.NewAccount(""))
+		So(logic.NewSessionRegistered(ctx, "me"), ShouldBeNil)
+
+		Convey("when the user keeps the messages 30 days", func() {
+			So(logic.SetRetention(ctx, "bob", domain.Retention{Days: 30}), ShouldBeNil)
+
+			Convey("it's only applied by this user, bob isn't told", func() {
+				r, err := logic.GetRetention(ctx, "bob")
+				So(err, ShouldBeNil)
+				So(r, ShouldResemble, domain.Retention{Days: 30})
+				So(peer.events, ShouldBeEmpty)
+			})
+		})
+
+		Convey("when the user makes the messages disappear after an hour", func() {
+			So(logic.SetRetention(ctx, "bob", domain.Retention{TTL: 3600}), ShouldBeNil)
+
+			Convey("bob is sent the TTL", func() {
+				So(peer.events, ShouldResemble, []domain.Event{{Type: domain.EventRetention, TTL: 3600}})
+			})
+
+			Convey("and set again with the same TTL, bob isn't sent it again", func() {
+				So(logic.SetRetention(ctx, "bob", domain.Retention{Days: 1, TTL: 3600}), ShouldBeNil)
+				So(peer.events, ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("a negative retention is refused", func() {
+			malformedErrIsReturned(logic.SetRetention(ctx, "bob", domain.Retention{Messages: -1}))
+		})
+
+		Convey("when bob can't receive the TTL, it's not stored", func() {
+			peer.locked = true
+			techErrIsReturned(logic.SetRetention(ctx, "bob", domain.Retention{Days: 30, TTL: 60}))
+			r, err := logic.GetRetention(ctx, "bob")
+			So(err, ShouldBeNil)
+			So(r, ShouldResemble, domain.Retention{})
+		})
+
+		Convey("when the retention can't be stored, it fails and bob is sent back the previous TTL", func() {
+			cm.InjectErrorAt(conversationManager.SetRetention)
+			techErrIsReturned(logic.SetRetention(ctx, "bob", domain.Retention{TTL: 60}))
+			So(peer.events, ShouldResemble, []domain.Event{{Type: domain.EventRetention, TTL: 60}, {Type: domain.EventRetention}})
+		})
+	})
+
+	Convey("without session", t, func() {
+		logic := uc.NewClientFrontLogic(conversationManager.New(), nil, nil, nil, nil, uc.NewAccount(""))
+
+		Convey("the messages can be kept less long", func() {
+			So(logic.SetRetention(ctx, "bob", domain.Retention{Days: 7}), ShouldBeNil)
+		})
+
+		Convey("but the TTL can't be agreed", func() {
+			unauthorizedErrIsReturned(logic.SetRetention(ctx, "bob", domain.Retention{TTL: 60}))
+		})
+	})
+}
+
+func TestRetentionReceived(t *testing.T) {
+	ctx := context.Background()
+	bob := domain.User{Login: "bob"}
+
+	Convey("given a client keeping its conversation with bob 30 days", t, func() {
+		cm := conversationManager.New()
+		So(cm.SetRetention(ctx, "bob", domain.Retention{Days: 30}), ShouldBeTrue)
+		logic := uc.NewClientP2pLogic(cm, nil, signalbroker.New(), uc.NewAccount(""))
+
+		Convey("when bob makes the messages disappear after a minute", func() {
+			So(logic.HandleMessageReceived(ctx, domain.Event{Type: domain.EventRetention, TTL: 60}, bob), ShouldBeNil)
+
+			Convey("the TTL is set, the days are kept", func() {
+				r, ok := cm.GetRetention(ctx, "bob")
+				So(ok, ShouldBeTrue)
+				So(r, ShouldResemble, domain.Retention{Days: 30, TTL: 60})
+			})
+
+			Convey("and stops it, the messages don't disappear anymore", func() {
+				So(logic.HandleMessageReceived(ctx, domain.Event{Type: domain.EventRetention}, bob), ShouldBeNil)
+				r, ok := cm.GetRetention(ctx, "bob")
+				So(ok, ShouldBeTrue)
+				So(r, ShouldResemble, domain.Retention{Days: 30})
+			})
+		})
+
+		Convey("a negative TTL is refused", func() {
+			malformedErrIsReturned(logic.HandleMessageReceived(ctx, domain.Event{Type: domain.EventRetention, TTL: -1}, bob))
+		})
+	})
+}
+
+func TestRetentionSweeper(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	Convey("given conversations with messages of different ages", t, func() {
+		cm := conversationManager.NewFailable()
+		for _, with := range []string{"bob", "carol"} {
+			So(cm.AppendToConversationWith(ctx, with, domain.Message{Ref: "legacy", Author: with, Content: "no date"}), ShouldBeTrue)
+			So(cm.AppendToConversationWith(ctx, with, domain.Message{Ref: "month", Author: with, Content: "a month ago", Date: now.AddDate(0, -1, 0)}), ShouldBeTrue)
+			So(cm.AppendToConversationWith(ctx, with, domain.Message{Ref: "hour", Author: with, Content: "an hour ago", Date: now.Add(-time.Hour)}), ShouldBeTrue)
+			So(cm.AppendToConversationWith(ctx, with, domain.Message{Ref: "now", Author: with, Content: "now", Date: now}), ShouldBeTrue)
+		}
+		refs := func(with string) []string {
+			messages, ok := cm.GetConversationWith(ctx, with, domain.Page{})
+			So(ok, ShouldBeTrue)
+			r := []string{}
+			for _, m := range messages {
+				r = append(r, m.Ref)
+			}
+			return r
+		}
+
+		Convey("without retention, nothing is purged", func() {
+			purged, err := uc.NewRetentionSweeper(cm, domain.Retention{}).Sweep(ctx, now)
+			So(err, ShouldBeNil)
+			So(purged, ShouldEqual, 0)
+		})
+
+		Convey("with a default of 7 days", func() {
+			sweeper := uc.NewRetentionSweeper(cm, domain.Retention{Days: 7})
+
+			Convey("the messages older than it are purged everywhere, not the ones without date", func() {
+				purged, err := sweeper.Sweep(ctx, now)
+				So(err, ShouldBeNil)
+				So(purged, ShouldEqual, 2)
+				So(refs("bob"), ShouldResemble, []string{"legacy", "hour", "now"})
+				So(refs("carol"), ShouldResemble, []string{"legacy", "hour", "now"})
+			})
+
+			Convey("a conversation keeping its last 2 messages and disappearing after 10 minutes purges more", func() {
+				So(cm.SetRetention(ctx, "bob", domain.Retention{Messages: 2, TTL: 600}), ShouldBeTrue)
+				_, err := sweeper.Sweep(ctx, now)
+				So(err, ShouldBeNil)
+				So(refs("bob"), ShouldResemble, []string{"now"})
+				So(refs("carol"), ShouldResemble, []string{"legacy", "hour", "now"})
+			})
+
+			Convey("a conversation keeping them 60 days purges less", func() {
+				So(cm.SetRetention(ctx, "bob", domain.Retention{Days: 60}), ShouldBeTrue)
+				_, err := sweeper.Sweep(ctx, now)
+				So(err, ShouldBeNil)
+				So(refs("bob"), ShouldResemble, []string{"legacy", "month", "hour", "now"})
+			})
+
+			Convey("when the messages can't be purged, it fails", func() {
+				cm.InjectErrorAt(conversationManager.PurgeMessages)
+				_, err := sweeper.Sweep(ctx, now)
+				techErrIsReturned(err)
+			})
+		})
+
+		Convey("when the conversations can't be listed, it fails", func() {
+			cm.InjectErrorAt(conversationManager.ListConversations)
+			_, err := uc.NewRetentionSweeper(cm, domain.Retention{Days: 7}).Sweep(ctx, now)
+			techErrIsReturned(err)
+		})
+	})
+}
@@ -0,0 +1,151 @@
+package uc
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"gop2p/domain"
+	"gop2p/tracing"
+	"net"
+	"sync"
+	"time"
+)
+
+// how long a sender stays verified (or rejected) from a host, so that the server isn't asked on every message, nor
+// for every request of a caller pretending to be someone else
+const (
+	verifiedFor = time.Minute
+	rejectedFor = 10 * time.Second
+)
+
+// SenderVerifier checks that the peers calling the p2p API are who they claim to be
+type SenderVerifier interface {
+	// VerifySender fails if login has no session registered from the host of the caller address (host:port)
+	VerifySender(ctx context.Context, login, address string) error
+}
+
+type senderVerifier struct {
+	account *Account
+	sg      ServerGateway
+	// lookupHost resolves the hosts of the sessions which aren't IPs
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+
+	mu     sync.Mutex
+	now    func() time.Time
+	checks map[string]check
+}
+
+// check is the last answer for a login from a host
+type check struct {
+	at       time.Time
+	verified bool
+}
+
+func (c check) expired(now time.Time) bool {
+	if c.verified {
+		return now.Sub(c.at) >= verifiedFor
+	}
+	return now.Sub(c.at) >= rejectedFor
+}
+
+// NewSenderVerifier asks the sessions of the senders to the server (or the DHT) as the user of the account
+func NewSenderVerifier(sg ServerGateway, a *Account) SenderVerifier {
+	return newSenderVerifier(sg, a, net.DefaultResolver.LookupHost, time.Now)
+}
+
+func newSenderVerifier(sg ServerGateway, a *Account, lookupHost func(context.Context, string) ([]string, error), now func() time.Time) *senderVerifier {
+	return &senderVerifier{account: a, sg: sg, lookupHost: lookupHost, now: now, checks: map[string]check{}}
+}
+
+// VerifySender only knows the address of the caller, so the devices of a user behind the same host can't be told
+// apart from each other, nor from another user on this host. A rejected caller is rejected again without asking the
+// server for a few seconds
+func (v *senderVerifier) VerifySender(ctx context.Context, login, address string) error {
+	span, ctx := tracing.Start(ctx, "uc:verify_sender")
+	defer span.End()
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		span.Error(err)
+		return domain.ErrUnauthorized{}
+	}
+	key := login + "@" + host
+	if c, ok := v.known(key); ok {
+		if !c.verified {
+			span.Error(fmt.Errorf("%s was rejected from %s lately", login, host))
+			return domain.ErrUnauthorized{}
+		}
+		return nil
+	}
+
+	me := v.account.Login()
+	if me == "" {
+		span.Error(errors.New("the server can't be asked without session"))
+		return domain.ErrUnauthorized{}
+	}
+	sessions, ok := v.sg.AskSessionsToServer(ctx, me, login)
+	if !ok {
+		return domain.ErrTechnical{}
+	}
+
+	for _, s := range sessions {
+		if v.sameHost(ctx, s.Address, host) {
+			v.remember(key, true)
+			return nil
+		}
+	}
+	v.remember(key, false)
+	span.Error(fmt.Errorf("%s has no session from %s", login, host))
+	return domain.ErrUnauthorized{}
+}
+
+func (v *senderVerifier) known(key string) (check, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	c, ok := v.checks[key]
+	if !ok || c.expired(v.now()) {
+		return check{}, false
+	}
+	return c, true
+}
+
+func (v *senderVerifier) remember(key string, verified bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := v.now()
+	for k, c := range v.checks {
+		if c.expired(now) {
+			delete(v.checks, k)
+		}
+	}
+	v.checks[key] = check{at: now, verified: verified}
+}
+
+// sameHost tells if the session address (host:port) is on the host, its name is resolved if it's not an IP
+func (v *senderVerifier) sameHost(ctx context.Context, address, host string) bool {
+	sessionHost, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return sessionHost == host
+	}
+	if sessionIP := net.ParseIP(sessionHost); sessionIP != nil {
+		return sessionIP.Equal(ip)
+	}
+
+	ips, err := v.lookupHost(ctx, sessionHost)
+	if err != nil {
+		tracing.FromContext(ctx).Error(err)
+		return false
+	}
+	for _, resolved := range ips {
+		if net.ParseIP(resolved).Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
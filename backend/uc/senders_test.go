@@ -0,0 +1,101 @@
+package uc_test
+
+import (
+	"context"
+	"errors"
+	"gop2p/domain"
+	"gop2p/uc"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// serverStub knows the sessions of every user, it fails if down
+type serverStub struct {
+	sessions map[string][]domain.Session
+	down     bool
+	asked    int
+}
+
+func (s *serverStub) AskSessionsToServer(_ context.Context, _, to string) ([]domain.Session, bool) {
+	s.asked++
+	if s.down {
+		return nil, false
+	}
+	return s.sessions[to], true
+}
+
+// lookupHost only knows the phone of bob
+func lookupHost(_ context.Context, host string) ([]string, error) {
+	if host == "bob-phone.lan" {
+		return []string{"192.168.1.12"}, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func TestVerifySender(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("given a client knowing bob has a session on two devices", t, func() {
+		server := &serverStub{sessions: map[string][]domain.Session{
+			"bob": {{Address: "10.0.0.2:4000", Device: "laptop"}, {Address: "bob-phone.lan:4001", Device: "phone"}},
+		}}
+		account := uc.NewAccount("")
+		now := time.Now()
+		verifier := uc.NewSenderVerifierWith(server, account, lookupHost, func() time.Time { return now })
+		So(uc.NewClientFrontLogic(nil, server, nil, nil, nil, account).NewSessionRegistered(ctx, "me"), ShouldBeNil)
+		// the other devices of the user were asked when logging in
+		server.asked = 0
+
+		Convey("bob is verified from the host of any of his sessions, whatever the port", func() {
+			So(verifier.VerifySender(ctx, "bob", "10.0.0.2:52100"), ShouldBeNil)
+			So(verifier.VerifySender(ctx, "bob", "192.168.1.12:52101"), ShouldBeNil)
+
+			Convey("and the server isn't asked again for a while", func() {
+				So(verifier.VerifySender(ctx, "bob", "10.0.0.2:52102"), ShouldBeNil)
+				So(server.asked, ShouldEqual, 2)
+
+				now = now.Add(time.Minute)
+				So(verifier.VerifySender(ctx, "bob", "10.0.0.2:52103"), ShouldBeNil)
+				So(server.asked, ShouldEqual, 3)
+			})
+		})
+
+		Convey("a caller from another host can't be bob", func() {
+			So(verifier.VerifySender(ctx, "bob", "10.0.0.3:52100"), ShouldHaveSameTypeAs, domain.ErrUnauthorized{})
+
+			Convey("and is rejected again without asking the server for a few seconds", func() {
+				So(verifier.VerifySender(ctx, "bob", "10.0.0.3:52101"), ShouldHaveSameTypeAs, domain.ErrUnauthorized{})
+				So(server.asked, ShouldEqual, 1)
+
+				now = now.Add(10 * time.Second)
+				So(verifier.VerifySender(ctx, "bob", "10.0.0.3:52102"), ShouldHaveSameTypeAs, domain.ErrUnauthorized{})
+				So(server.asked, ShouldEqual, 2)
+			})
+		})
+
+		Convey("a user without session can't be verified", func() {
+			unauthorizedErrIsReturned(verifier.VerifySender(ctx, "mallory", "10.0.0.2:52100"))
+		})
+
+		Convey("a caller without a valid address can't be verified", func() {
+			So(verifier.VerifySender(ctx, "bob", "10.0.0.2"), ShouldHaveSameTypeAs, domain.ErrUnauthorized{})
+		})
+
+		Convey("when the server is down, the sender isn't verified until it's back", func() {
+			server.down = true
+			So(verifier.VerifySender(ctx, "bob", "10.0.0.2:52100"), ShouldHaveSameTypeAs, domain.ErrTechnical{})
+
+			server.down = false
+			So(verifier.VerifySender(ctx, "bob", "10.0.0.2:52100"), ShouldBeNil)
+		})
+	})
+
+	Convey("a client without session can't ask the server", t, func() {
+		server := &serverStub{}
+		err := uc.NewSenderVerifier(server, uc.NewAccount("")).VerifySender(ctx, "bob", "10.0.0.2:52100")
+		unauthorizedErrIsReturned(err)
+		So(server.asked, ShouldEqual, 0)
+	})
+}
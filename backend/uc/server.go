@@ -2,8 +2,9 @@ package uc
 
 import (
 	"context"
-	"github.com/opentracing/opentracing-go"
 	"gop2p/domain"
+	"gop2p/logging"
+	"gop2p/tracing"
 	"strconv"
 	"strings"
 )
@@ -11,8 +12,8 @@ import (
 // ServerLogic handles the logic of the central server, we use a struct in order to be able to easily change
 // implementations in tests and because having several implementation is not very likely
 type ServerLogic struct {
-	StartSession       func(ctx context.Context, login, password, address string) error
-	ProvideUserSession func(ctx context.Context, srcLogin, dstLogin string) (*domain.Session, error)
+	StartSession       func(ctx context.Context, login, password, device, address string, caps domain.Capabilities) error
+	ProvideUserSession func(ctx context.Context, srcLogin, dstLogin string) ([]domain.Session, error)
 }
 
 type serverInteractor struct {
@@ -31,11 +32,11 @@ func NewServerLogic(uS UserStore, sM SessionManager) ServerLogic {
 	}
 }
 
-// StartSessionInit registers the address where the client can be reached
-// returns nil if everything is OK
-func (i serverInteractor) StartSession(ctx context.Context, login, password, clientAddress string) error {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "uc:start_new_session")
-	defer span.Finish()
+// StartSessionInit registers the address where the device of the client can be reached and what it supports,
+// the other devices of the user keep their session. Returns nil if everything is OK
+func (i serverInteractor) StartSession(ctx context.Context, login, password, device, clientAddress string, caps domain.Capabilities) error {
+	span, ctx := tracing.Start(ctx, "uc:start_new_session")
+	defer span.End()
 
 	if !validAddress(clientAddress) {
 		return domain.ErrMalformed{Details: []string{"the address provided is invalid"}}
@@ -48,36 +49,41 @@ func (i serverInteractor) StartSession(ctx context.Context, login, password, cli
 	if user == nil {
 		return domain.ErrResourceNotFound{}
 	}
+	if user.Disabled {
+		return domain.ErrUnauthorized{}
+	}
 
-	if ok := i.sM.InsertSession(ctx, login, clientAddress); !ok {
+	if ok := i.sM.InsertSession(ctx, login, device, clientAddress, caps); !ok {
 		return domain.ErrTechnical{}
 	}
+
+	logging.Info(logging.WithLogin(ctx, login), "session started", "device", device, "address", clientAddress, "versions", caps.Versions)
 	return nil
 }
 
-// ProvideUserSessionInit allows a client to get the session details of another one
-func (i serverInteractor) ProvideUserSession(ctx context.Context, srcLogin, dstLogin string) (*domain.Session, error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "uc:provide_user_session")
-	defer span.Finish()
+// ProvideUserSessionInit allows a client to get the session details of every device of another user (or its own)
+func (i serverInteractor) ProvideUserSession(ctx context.Context, srcLogin, dstLogin string) ([]domain.Session, error) {
+	span, ctx := tracing.Start(ctx, "uc:provide_user_session")
+	defer span.End()
 
 	// only users with a session can ask for another user session
 	u, ok := i.uS.GetUserByLogin(ctx, srcLogin)
 	if !ok {
 		return nil, domain.ErrTechnical{}
 	}
-	if u == nil {
+	if u == nil || u.Disabled {
 		return nil, domain.ErrUnauthorized{}
 	}
 
-	s, ok := i.sM.GetSession(ctx, dstLogin)
+	sessions, ok := i.sM.GetSessions(ctx, dstLogin)
 	if !ok {
 		return nil, domain.ErrTechnical{}
 	}
-	if s == nil {
+	if len(sessions) == 0 {
 		return nil, domain.ErrResourceNotFound{}
 	}
 
-	return s, nil
+	return sessions, nil
 }
 
 func validAddress(address string) bool {
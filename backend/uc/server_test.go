@@ -29,24 +29,60 @@ func TestStartSession(t *testing.T) {
 		So(uS.InsertUser(ctx, uName, uPswd), ShouldBeTrue)
 
 		Convey("when he attempts to create a new session with valid creds & address", func() {
-			ucRet := sI.StartSession(ctx, uName, uPswd, address)
+			ucRet := sI.StartSession(ctx, uName, uPswd, "laptop", address, domain.Capabilities{})
 			aNewSessionIsCreated(sM, uName)
 			noErrorReturned(ucRet)
 		})
 
+		Convey("when his client advertises its capabilities", func() {
+			So(sI.StartSession(ctx, uName, uPswd, "laptop", address, domain.Supported()), ShouldBeNil)
+
+			Convey("they're in his session", func() {
+				sessions, ok := sM.GetSessions(ctx, uName)
+				So(ok, ShouldBeTrue)
+				So(sessions, ShouldHaveLength, 1)
+				So(sessions[0].Capabilities, ShouldResemble, domain.Supported())
+			})
+		})
+
+		Convey("when he logs in from another device", func() {
+			So(sI.StartSession(ctx, uName, uPswd, "laptop", address, domain.Capabilities{}), ShouldBeNil)
+			So(sI.StartSession(ctx, uName, uPswd, "phone", "alice-phone:1234", domain.Capabilities{}), ShouldBeNil)
+
+			Convey("both devices have a session, the latest last", func() {
+				sessions, ok := sM.GetSessions(ctx, uName)
+				So(ok, ShouldBeTrue)
+				So(sessions, ShouldHaveLength, 2)
+				So(sessions[0].Device, ShouldEqual, "laptop")
+				So(sessions[1].Device, ShouldEqual, "phone")
+			})
+
+			Convey("and logs in again from the first one", func() {
+				So(sI.StartSession(ctx, uName, uPswd, "laptop", "alice-machine:5678", domain.Capabilities{}), ShouldBeNil)
+
+				Convey("its session is replaced", func() {
+					sessions, ok := sM.GetSessions(ctx, uName)
+					So(ok, ShouldBeTrue)
+					So(sessions, ShouldHaveLength, 2)
+					So(sessions[1].Device, ShouldEqual, "laptop")
+					So(sessions[1].Address, ShouldEqual, "alice-machine:5678")
+				})
+			})
+		})
+
 		Convey("same happy case but with invalid address", func() {
 			Convey("must have 2 part like host:port", func() {
-				ucRet := sI.StartSession(ctx, uName, uPswd, "anywhere")
+				ucRet := sI.StartSession(ctx, uName, uPswd, "laptop", "anywhere", domain.Capabilities{})
 				noSessionIsCreated(sM, uName)
 				errorReturned(ucRet)
 			})
 			Convey("port must be an int", func() {
-				ucRet := sI.StartSession(ctx, uName, uPswd, "anywhere:abc")
+				ucRet := sI.StartSession(ctx, uName, uPswd, "laptop", "anywhere:abc", domain.Capabilities{})
 				noSessionIsCreated(sM, uName)
 				errorReturned(ucRet)
 			})
 			Convey("port must be larger than 0", func() {
-				ucRet := sI.StartSession(ctx, uName, uPswd, "anywhere:0")
+				ucRet := sI.StartSession(ctx, uName, uPswd, "laptop", "anywhere:0", domain.Capabilities{})
 				noSessionIsCreated(sM, uName)
 				errorReturned(ucRet)
 			})
@@ -54,14 +90,14 @@ func TestStartSession(t *testing.T) {
 
 		Convey("when another, unknown, user attempts to login", func() {
 			unknownUsername := "unknownUsername"
-			ucRet := sI.StartSession(ctx, unknownUsername, uPswd, address)
+			ucRet := sI.StartSession(ctx, unknownUsername, uPswd, "laptop", address, domain.Capabilities{})
 			noSessionIsCreated(sM, unknownUsername)
 			resourceNotFoundErrIsReturned(ucRet)
 		})
 
 		Convey("when the same user, with the wrong password attempts to login", func() {
 			wrongPassword := "wrongPass"
-			ucRet := sI.StartSession(ctx, uName, wrongPassword, address)
+			ucRet := sI.StartSession(ctx, uName, wrongPassword, "laptop", address, domain.Capabilities{})
 			noSessionIsCreated(sM, uName)
 			resourceNotFoundErrIsReturned(ucRet)
 		})
@@ -73,19 +109,19 @@ func TestStartSession(t *testing.T) {
 		So(us.InsertUser(ctx, uName, uPswd), ShouldBeTrue)
 
 		Convey("if a tech error happens with the uS", func() {
-			us.InjectErrorAt("getUserByLogicPassword")
+			us.InjectErrorAt(userStore.GetUserByLoginPassword)
 			ucRet := uc.NewServerLogic(us, sessionManager.New()).
-				StartSession(ctx, uName, uPswd, address)
+				StartSession(ctx, uName, uPswd, "laptop", address, domain.Capabilities{})
 
 			noSessionIsCreated(sm, uName)
 			techErrIsReturned(ucRet)
 		})
 
 		Convey("if a tech error happens with the sessionStore", func() {
-			sm.InjectErrorAt("insertSession")
+			sm.InjectErrorAt(sessionManager.InsertSession)
 
 			ucRet := uc.NewServerLogic(us, sm).
-				StartSession(ctx, uName, uPswd, address)
+				StartSession(ctx, uName, uPswd, "laptop", address, domain.Capabilities{})
 
 			noSessionIsCreated(sm, uName)
 			techErrIsReturned(ucRet)
@@ -104,19 +140,19 @@ func TestGetUserSession(t *testing.T) {
 		userStore, sessionManager, sI := cleanServerLogic()
 		So(userStore.InsertUser(ctx, bobName, "pass"), ShouldBeTrue)
 		So(userStore.InsertUser(ctx, aliceName, "pass"), ShouldBeTrue)
-		So(sessionManager.InsertSession(ctx, bobName, bobAddr), ShouldBeTrue)
-		So(sessionManager.InsertSession(ctx, aliceName, aliceAddr), ShouldBeTrue)
+		So(sessionManager.InsertSession(ctx, bobName, "laptop", bobAddr, domain.Capabilities{}), ShouldBeTrue)
+		So(sessionManager.InsertSession(ctx, aliceName, "laptop", aliceAddr, domain.Capabilities{}), ShouldBeTrue)
 
 		Convey("they are able to get each other's session", func() {
-			aliceSession, err := sI.ProvideUserSession(ctx, bobName, aliceName)
+			aliceSessions, err := sI.ProvideUserSession(ctx, bobName, aliceName)
 			So(err, ShouldBeNil)
-			So(aliceSession, ShouldNotBeNil)
-			So(aliceSession.Address, ShouldEqual, aliceAddr)
+			So(aliceSessions, ShouldHaveLength, 1)
+			So(aliceSessions[0].Address, ShouldEqual, aliceAddr)
 
-			bobSess, err := sI.ProvideUserSession(ctx, aliceName, bobName)
+			bobSessions, err := sI.ProvideUserSession(ctx, aliceName, bobName)
 			So(err, ShouldBeNil)
-			So(bobSess, ShouldNotBeNil)
-			So(bobSess.Address, ShouldEqual, bobAddr)
+			So(bobSessions, ShouldHaveLength, 1)
+			So(bobSessions[0].Address, ShouldEqual, bobAddr)
 
 		})
 
@@ -143,21 +179,50 @@ func TestGetUserSession(t *testing.T) {
 		sm := sessionManager.NewFailable()
 		So(us.InsertUser(ctx, bobName, "pass"), ShouldBeTrue)
 		So(us.InsertUser(ctx, aliceName, "pass"), ShouldBeTrue)
-		So(sm.InsertSession(ctx, bobName, bobAddr), ShouldBeTrue)
-		So(sm.InsertSession(ctx, aliceName, aliceAddr), ShouldBeTrue)
+		So(sm.InsertSession(ctx, bobName, "laptop", bobAddr, domain.Capabilities{}), ShouldBeTrue)
+		So(sm.InsertSession(ctx, aliceName, "laptop", aliceAddr, domain.Capabilities{}), ShouldBeTrue)
 
 		Convey("but a tech error happens when attempting to getUserByLogin", func() {
-			us.InjectErrorAt("getUserByLogin")
+			us.InjectErrorAt(userStore.GetUserByLogin)
 			s, err := uc.NewServerLogic(us, sm).ProvideUserSession(ctx, aliceName, bobName)
 			techErrIsReturned(err)
 			So(s, ShouldBeNil)
 		})
 
-		Convey("but a tech error happens when attempting to getSession", func() {
-			sm.InjectErrorAt("getSession")
+		Convey("but a tech error happens when attempting to getSessions", func() {
+			sm.InjectErrorAt(sessionManager.GetSessions)
 			s, err := uc.NewServerLogic(us, sm).ProvideUserSession(ctx, aliceName, bobName)
 			techErrIsReturned(err)
 			So(s, ShouldBeNil)
 		})
 	})
 }
+
+func BenchmarkStartSession(b *testing.B) {
+	ctx := context.Background()
+	quiet(b)
+	us, _, logic := cleanServerLogic()
+	us.InsertUser(ctx, "alice", "pass")
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := logic.StartSession(ctx, "alice", "pass", "laptop", "alice-laptop:4000", domain.Supported()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProvideUserSession(b *testing.B) {
+	ctx := context.Background()
+	quiet(b)
+	us, sm, logic := cleanServerLogic()
+	us.InsertUser(ctx, "alice", "pass")
+	sm.InsertSession(ctx, "bob", "laptop", "bob-laptop:4000", domain.Supported())
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := logic.ProvideUserSession(ctx, "alice", "bob"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -3,24 +3,28 @@ package uc_test
 import (
 	"context"
 	"gop2p/domain"
+	"gop2p/logging"
 	"gop2p/uc"
+	"io"
+	"os"
+	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
 
 func noSessionIsCreated(sm uc.SessionManager, uName string) {
 	Convey("no new session is created", func() {
-		session, ok := sm.GetSession(context.Background(), uName)
+		sessions, ok := sm.GetSessions(context.Background(), uName)
 		So(ok, ShouldBeTrue)
-		So(session, ShouldBeNil)
+		So(sessions, ShouldBeEmpty)
 	})
 }
 
 func aNewSessionIsCreated(sm uc.SessionManager, uName string) {
 	Convey("a new session is created", func() {
-		session, ok := sm.GetSession(context.Background(), uName)
+		sessions, ok := sm.GetSessions(context.Background(), uName)
 		So(ok, ShouldBeTrue)
-		So(session, ShouldNotBeNil)
+		So(sessions, ShouldNotBeEmpty)
 	})
 }
 
@@ -48,3 +52,21 @@ func techErrIsReturned(err error) {
 		So(err, ShouldHaveSameTypeAs, domain.ErrTechnical{})
 	})
 }
+
+func malformedErrIsReturned(err error) {
+	Convey("a malformed error is returned", func() {
+		So(err, ShouldHaveSameTypeAs, domain.ErrMalformed{})
+	})
+}
+
+func unauthorizedErrIsReturned(err error) {
+	Convey("an unauthorized error is returned", func() {
+		So(err, ShouldHaveSameTypeAs, domain.ErrUnauthorized{})
+	})
+}
+
+// quiet drops the logs during a benchmark, a line is written by most usecases
+func quiet(b *testing.B) {
+	_ = logging.Setup(io.Discard, logging.FormatText)
+	b.Cleanup(func() { _ = logging.Setup(os.Stderr, logging.FormatText) })
+}
@@ -3,6 +3,7 @@ package uc
 import (
 	"context"
 	"gop2p/domain"
+	"time"
 )
 
 // NB : side effects return bool instead of error because we don't want their lower level
@@ -13,26 +14,115 @@ type UserStore interface {
 	InsertUser(ctx context.Context, login, password string) bool
 	GetUserByLoginPassword(ctx context.Context, login, password string) (*domain.User, bool)
 	GetUserByLogin(ctx context.Context, login string) (*domain.User, bool)
+	ListUsers(ctx context.Context) ([]domain.User, bool)
+	UpdatePassword(ctx context.Context, login, password string) bool
+	SetUserDisabled(ctx context.Context, login string, disabled bool) bool
+	DeleteUser(ctx context.Context, login string) bool
 }
 
-// SessionManager is a struct holding the function types allowing to manage the sessions
+// SessionManager is a struct holding the function types allowing to manage the sessions, a user has a session per
+// device (see domain.WithDevice)
 type SessionManager interface {
-	InsertSession(ctx context.Context, login, address string) bool
-	GetSession(ctx context.Context, login string) (*domain.Session, bool)
+	InsertSession(ctx context.Context, login, device, address string, caps domain.Capabilities) bool
+	// GetSessions returns the sessions of every device of the user, the latest started last
+	GetSessions(ctx context.Context, login string) ([]domain.Session, bool)
+	ListSessions(ctx context.Context) (map[string][]domain.Session, bool)
+	// DeleteSession ends the sessions of every device of the user
+	DeleteSession(ctx context.Context, login string) bool
 }
 
 // ConversationManager is used by client to store their conversations with other users
 type ConversationManager interface {
-	GetConversationWith(ctx context.Context, authorName string) ([]domain.Message, bool)
-	AppendToConversationWith(ctx context.Context, userName, msgAuthor, msgContent string) bool
+	GetConversationWith(ctx context.Context, authorName string, page domain.Page) ([]domain.Message, bool)
+	AppendToConversationWith(ctx context.Context, userName string, msg domain.Message) bool
+	// AppendOnce appends the message unless a message with the same ref is in the conversation, or was purged from it
+	// within domain.DedupeWindow. It's atomic, stored is false for a redelivery
+	AppendOnce(ctx context.Context, with string, msg domain.Message) (stored bool, ok bool)
+	// MergeOnce inserts the messages AppendOnce would store in date order among the conversation, its IDs then keep
+	// increasing in this order (they change). The messages inserted are read. It's atomic, it returns how many were
+	// stored
+	MergeOnce(ctx context.Context, with string, msgs []domain.Message) (stored int, ok bool)
+	ListConversations(ctx context.Context) ([]domain.Conversation, bool)
+	MarkConversationRead(ctx context.Context, with string, upTo int64) bool
+	SearchMessages(ctx context.Context, query string, limit int) ([]domain.SearchResult, bool)
+
+	// the operations on a message (found by ref) do nothing if it's unknown
+	GetMessage(ctx context.Context, with, ref string) (*domain.Message, bool)
+	EditMessage(ctx context.Context, with, ref, content string, edits int) bool
+	DeleteMessage(ctx context.Context, with, ref string) bool
+	SetReaction(ctx context.Context, with, ref, by, emoji string, on bool) bool
+	SetMessageStatus(ctx context.Context, with, ref, status string) bool
+
+	// FindAttachment returns nil if no message of the conversation has this attachment
+	FindAttachment(ctx context.Context, with, hash string) (*domain.Attachment, bool)
+
+	// the retention of a conversation is kept even when all its messages are purged
+	GetRetention(ctx context.Context, with string) (domain.Retention, bool)
+	SetRetention(ctx context.Context, with string, r domain.Retention) bool
+	// PurgeMessages removes the messages stored before the date (unless it's zero) and all but the latest keep
+	// (unless it's 0), the conversation is dropped once empty. It returns how many were removed
+	PurgeMessages(ctx context.Context, with string, before time.Time, keep int) (int, bool)
+}
+
+// Vault is implemented by the conversation managers keeping the history encrypted at rest, the history can't be
+// read nor written while it's locked. The secret is a passphrase or the content of a keyfile
+type Vault interface {
+	Locked() bool
+	// Unlock fails (valid is false) if the secret doesn't open the vault
+	Unlock(ctx context.Context, secret string) (valid bool, ok bool)
+	Lock(ctx context.Context) bool
+	// ChangeSecret encrypts the keys with a new secret once the current one checked, the vault is then unlocked
+	ChangeSecret(ctx context.Context, current, secret string) (valid bool, ok bool)
+	// RotateKey encrypts the next messages with a new key, the previous ones stay readable
+	RotateKey(ctx context.Context) bool
+}
+
+// BlobStore keeps the content of the attachments by hash, the ones downloaded from a peer are written chunk by chunk
+// so that a transfer can be resumed
+type BlobStore interface {
+	// Stat returns nil if there's nothing of this blob
+	Stat(ctx context.Context, hash string) (*domain.Blob, bool)
+	// Put writes a complete blob, its hash is already checked
+	Put(ctx context.Context, hash string, content []byte) bool
+	// Append writes a chunk at the end of an incomplete blob, it fails if offset isn't the size written so far
+	Append(ctx context.Context, hash string, offset int64, chunk []byte) bool
+	// Seal completes a blob if its content matches the hash, it's dropped otherwise (valid is false)
+	Seal(ctx context.Context, hash string) (valid bool, ok bool)
+	// ReadChunk reads at most length bytes of a complete blob from offset
+	ReadChunk(ctx context.Context, hash string, offset int64, length int) ([]byte, bool)
 }
 
 // ServerGateway provides client -> server communication
 type ServerGateway interface {
-	AskSessionToServer(ctx context.Context, from string, to string) (*domain.Session, bool)
+	// AskSessionsToServer returns the sessions of every device of "to", none if it's not connected
+	AskSessionsToServer(ctx context.Context, from string, to string) ([]domain.Session, bool)
+}
+
+// SessionPublisher is implemented by decentralized directories where the clients
+// announce their own session instead of registering it to the central server
+type SessionPublisher interface {
+	PublishSession(ctx context.Context, login, device, address string, caps domain.Capabilities) bool
 }
 
-// ClientGateway provides client -> client communication
+// ClientGateway provides client -> client communication,
+// it picks an encoding the peer supports from the capabilities of its session
 type ClientGateway interface {
-	SendMsg(ctx context.Context, addr string, msg domain.Message, from string) bool
+	// SendMsg returns the status of the message: delivered, failed, or pending when the peer can't store it for now
+	// (its history is locked) and it must be sent again later
+	SendMsg(ctx context.Context, to domain.Session, msg domain.Message, from string) (status string)
+	// SendEvent fails if the peer doesn't support the events (see domain.FeatureEvents)
+	SendEvent(ctx context.Context, to domain.Session, e domain.Event, from string) bool
+	// FetchChunk fails if the peer doesn't support the attachments (see domain.FeatureAttachments)
+	FetchChunk(ctx context.Context, to domain.Session, hash string, offset int64, from string) ([]byte, bool)
+	// SendSignal fails if the peer doesn't support the signals (see domain.FeatureSignals)
+	SendSignal(ctx context.Context, to domain.Session, s domain.Signal, from string) bool
+	// FetchHistory fails if the peer, another device of the user, doesn't sync (see domain.FeatureDevices)
+	FetchHistory(ctx context.Context, to domain.Session, from string) ([]domain.History, bool)
+}
+
+// SignalBroker delivers the signals to the front ends listening, nothing is kept for later
+type SignalBroker interface {
+	Publish(ctx context.Context, s domain.Signal) bool
+	// Subscribe receives the signals until ctx is done, the channel is then closed
+	Subscribe(ctx context.Context) (<-chan domain.Signal, bool)
 }
@@ -0,0 +1,99 @@
+package uc
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"gop2p/domain"
+	"gop2p/logging"
+	"gop2p/tracing"
+	"sync"
+	"time"
+)
+
+// throttle tells if something can be done again for a key, at most once per interval
+type throttle struct {
+	interval time.Duration
+	now      func() time.Time
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newThrottle(interval time.Duration) *throttle {
+	return &throttle{interval: interval, now: time.Now, last: map[string]time.Time{}}
+}
+
+func (t *throttle) allow(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if last, ok := t.last[key]; ok && now.Sub(last) < t.interval {
+		return false
+	}
+	t.last[key] = now
+	return true
+}
+
+// SendSignal is used by the client to tell the other user it's typing or has seen the conversation, nothing is
+// stored. Typing is only sent once per domain.TypingInterval, the front end can call it on every key stroke
+func (i clientFrontInteractor) SendSignal(ctx context.Context, with, signalType, ref string) error {
+	span, ctx := tracing.Start(ctx, "uc:send_signal")
+	defer span.End()
+
+	emitter := i.account.Login()
+	if emitter == "" {
+		span.Error(errors.New("missing current user session"))
+		return domain.ErrUnauthorized{}
+	}
+	if signalType != domain.SignalTyping && signalType != domain.SignalSeen {
+		return domain.ErrMalformed{Details: []string{"unknown signal type"}}
+	}
+	if signalType == domain.SignalTyping && !i.typing.allow(with) {
+		return nil
+	}
+	ctx = logging.WithLogin(ctx, emitter)
+
+	sessions, err := i.peerSessions(ctx, emitter, with)
+	if err != nil {
+		return err
+	}
+
+	delivered := fanOut(ctx, sessions, func(s domain.Session) bool {
+		return i.cg.SendSignal(ctx, s, domain.Signal{Type: signalType, With: with, Ref: ref}, emitter)
+	})
+	if !delivered {
+		return domain.ErrTechnical{}
+	}
+	return nil
+}
+
+// Signals is used by the front end to be told as soon as the other users type, see the conversations or change them,
+// the stream ends with the context
+func (i clientFrontInteractor) Signals(ctx context.Context) (<-chan domain.Signal, error) {
+	span, ctx := tracing.Start(ctx, "uc:signals")
+	defer span.End()
+
+	signals, ok := i.sb.Subscribe(ctx)
+	if !ok {
+		return nil, domain.ErrTechnical{}
+	}
+	return signals, nil
+}
+
+// HandleSignalReceived is used by the client to pass the signal of a peer to the front ends
+func (i clientp2pInteractor) HandleSignalReceived(ctx context.Context, s domain.Signal, emitter domain.User) error {
+	span, ctx := tracing.Start(ctx, "uc:handle_signal_received")
+	defer span.End()
+
+	if s.Type != domain.SignalTyping && s.Type != domain.SignalSeen {
+		return domain.ErrMalformed{Details: []string{"unknown signal type"}}
+	}
+
+	// for the front end, the conversation is with the emitter
+	s.With = emitter.Login
+	if ok := i.sb.Publish(ctx, s); !ok {
+		return domain.ErrTechnical{}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package uc_test
+
+import (
+	"context"
+	"gop2p/domain"
+	"gop2p/uc"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	conversationManager "gop2p/driven/inMem.conversationManager"
+	signalbroker "gop2p/driven/inMem.signalBroker"
+)
+
+func TestSendSignal(t *testing.T) {
+	ctx := context.Background()
+
+	Convey("given a connected user", t, func() {
+		peer := &peerStub{}
+		logic := uc.NewClientFrontLogic(conversationManager.New(), peer, peer, nil, signalbroker.New(), uc.NewAccount(""))
+		So(logic.NewSessionRegistered(ctx, "me"), ShouldBeNil)
+
+		Convey("when it types several times in a row", func() {
+			So(logic.SendSignal(ctx, "bob", domain.SignalTyping, ""), ShouldBeNil)
+			So(logic.SendSignal(ctx, "bob", domain.SignalTyping, ""), ShouldBeNil)
+			So(logic.SendSignal(ctx, "alice", domain.SignalTyping, ""), ShouldBeNil)
+
+			Convey("each peer is only told once", func() {
+				So(peer.signals, ShouldResemble, []domain.Signal{
+					{Type: domain.SignalTyping, With: "bob"},
+					{Type: domain.SignalTyping, With: "alice"},
+				})
+			})
+		})
+
+		Convey("when it sees the conversation twice", func() {
+			So(logic.SendSignal(ctx, "bob", domain.SignalSeen, "r1"), ShouldBeNil)
+			So(logic.SendSignal(ctx, "bob", domain.SignalSeen, "r2"), ShouldBeNil)
+
+			Convey("the peer is told each time", func() {
+				So(peer.signals, ShouldHaveLength, 2)
+				So(peer.signals[1].Ref, ShouldEqual, "r2")
+			})
+		})
+
+		Convey("when the signal is unknown", func() {
+			err := logic.SendSignal(ctx, "bob", domain.SignalUpdated, "")
+
+			malformedErrIsReturned(err)
+			Convey("nothing is sent", func() {
+				So(peer.signals, ShouldBeEmpty)
+			})
+		})
+	})
+
+	Convey("given no user connected", t, func() {
+		peer := &peerStub{}
+		err := uc.NewClientFrontLogic(conversationManager.New(), peer, peer, nil, signalbroker.New(), uc.NewAccount("")).SendSignal(ctx, "bob", domain.SignalTyping, "")
+
+		unauthorizedErrIsReturned(err)
+	})
+}
+
+func TestHandleSignalReceived(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bob := domain.User{Login: "bob"}
+
+	Convey("given a front end listening to the signals", t, func() {
+		sb := signalbroker.New()
+		front := uc.NewClientFrontLogic(conversationManager.New(), nil, nil, nil, sb, uc.NewAccount(""))
+		logic := uc.NewClientP2pLogic(conversationManager.New(), nil, sb, uc.NewAccount(""))
+		signals, err := front.Signals(ctx)
+		So(err, ShouldBeNil)
+
+		Convey("when bob is typing", func() {
+			So(logic.HandleSignalReceived(ctx, domain.Signal{Type: domain.SignalTyping, With: "someone"}, bob), ShouldBeNil)
+
+			Convey("the front end is told, in the conversation with bob", func() {
+				So(<-signals, ShouldResemble, domain.Signal{Type: domain.SignalTyping, With: "bob"})
+			})
+		})
+
+		Convey("when bob sends a message", func() {
+			So(logic.HandleMessageReceived(ctx, domain.Event{Type: domain.EventMessage, Ref: "r1", Content: "hi"}, bob), ShouldBeNil)
+
+			Convey("the front end is told the conversation changed", func() {
+				So(<-signals, ShouldResemble, domain.Signal{Type: domain.SignalUpdated, With: "bob", Ref: "r1"})
+			})
+		})
+
+		Convey("when bob sends an unknown signal", func() {
+			err := logic.HandleSignalReceived(ctx, domain.Signal{Type: domain.SignalUpdated}, bob)
+
+			malformedErrIsReturned(err)
+		})
+	})
+}